@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
@@ -82,9 +84,66 @@ func ScrapeTarget(target string, config *config.Module) ([]gosnmp.SnmpPDU, error
 		}
 		result = append(result, pdus...)
 	}
+	toGet := append([]string{}, config.Get...)
+	for _, filter := range config.StaticFilters {
+		for _, index := range filter.Indices {
+			for _, target := range filter.Targets {
+				toGet = append(toGet, target+"."+index)
+			}
+		}
+	}
+	for _, filter := range config.Filters {
+		for _, index := range filteredIndexes(result, filter) {
+			for _, target := range filter.Targets {
+				toGet = append(toGet, target+index)
+			}
+		}
+	}
+	// Get scalars and filtered rows, as many at a time as the agent allows.
+	for i := 0; i < len(toGet); i += snmp.MaxOids {
+		end := i + snmp.MaxOids
+		if end > len(toGet) {
+			end = len(toGet)
+		}
+		log.Debugf("Getting target %q oids %v", snmp.Target, toGet[i:end])
+		packet, err := snmp.Get(toGet[i:end])
+		if err != nil {
+			return nil, fmt.Errorf("Error getting target %s: %s", snmp.Target, err)
+		}
+		if packet.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("Error getting target %s: error status %d", snmp.Target, packet.Error)
+		}
+		for _, pdu := range packet.Variables {
+			// The agent may not have all the objects.
+			if pdu.Type == gosnmp.NoSuchObject || pdu.Type == gosnmp.NoSuchInstance {
+				continue
+			}
+			result = append(result, pdu)
+		}
+	}
 	return result, nil
 }
 
+// The indexes, with a leading period, of the rows of the filter's column
+// whose value is one of the filter's values.
+func filteredIndexes(pdus []gosnmp.SnmpPDU, filter config.DynamicFilter) []string {
+	prefix := "." + strings.TrimPrefix(filter.Oid, ".") + "."
+	indexes := []string{}
+	for _, pdu := range pdus {
+		if !strings.HasPrefix(pdu.Name, prefix) {
+			continue
+		}
+		value := pduValueAsString(&pdu, "DisplayString")
+		for _, v := range filter.Values {
+			if v == value {
+				indexes = append(indexes, pdu.Name[len(prefix)-1:])
+				break
+			}
+		}
+	}
+	return indexes
+}
+
 type MetricNode struct {
 	metric *config.Metric
 
@@ -194,12 +253,49 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 	switch metric.Type {
 	case "counter":
 		t = prometheus.CounterValue
+		value = scaleValue(value, metric)
 	case "gauge":
 		t = prometheus.GaugeValue
+		value = scaleValue(value, metric)
+	case "EnumAsStateSet":
+		return enumAsStateSet(metric, int(value), labelnames, labelvalues)
+	case "Bits":
+		return bits(metric, pdu.Value, labelnames, labelvalues)
+	case "Bool":
+		// A TruthValue, which is 1 for true and 2 for false.
+		t = prometheus.GaugeValue
+		switch value {
+		case 1:
+		case 2:
+			value = 0
+		default:
+			log.Debugf("Invalid TruthValue %v for metric %s", value, metric.Name)
+			return []prometheus.Metric{}
+		}
+	case "Auto":
+		return autoSamples(indexOids, pdu, metric, labelnames, labelvalues)
+	case "EnumAsInfo":
+		// The name of the value becomes a label, falling back to the number.
+		t = prometheus.GaugeValue
+		name, ok := metric.EnumValues[int(value)]
+		if !ok {
+			name = pduValueAsString(pdu, metric.Type)
+		}
+		value = 1.0
+		if _, ok := labels[metric.Name]; !ok {
+			labelnames = append(labelnames, metric.Name)
+			labelvalues = append(labelvalues, name)
+		}
 	default:
 		// It's some form of string.
 		t = prometheus.GaugeValue
 		value = 1.0
+		if b, ok := pdu.Value.([]byte); ok && metric.MaxSize > 0 && len(b) > metric.MaxSize {
+			// Longer than the MIB allows, so cap it rather than risk huge labels.
+			capped := *pdu
+			capped.Value = b[:metric.MaxSize]
+			pdu = &capped
+		}
 		if len(metric.RegexpExtracts) > 0 {
 			return applyRegexExtracts(metric, pduValueAsString(pdu, metric.Type), labelnames, labelvalues)
 		}
@@ -215,9 +311,97 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 		t, value, labelvalues...)}
 }
 
+// Samples for an object that isn't in any MIB, so its indexes and type are
+// unknown. The rest of its oid is the oid label. Numbers are untyped, and
+// anything else is a separate _info metric with the value as a label.
+func autoSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, labelnames, labelvalues []string) []prometheus.Metric {
+	oid := make([]string, len(indexOids))
+	for i, o := range indexOids {
+		oid[i] = strconv.Itoa(o)
+	}
+	labelnames = append(labelnames, "oid")
+	labelvalues = append(labelvalues, strings.Join(oid, "."))
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		return []prometheus.Metric{prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name, metric.Help, labelnames, nil),
+			prometheus.UntypedValue, getPduValue(pdu), labelvalues...)}
+	}
+	typ := "OctetString"
+	if b, ok := pdu.Value.([]byte); ok && isPrintable(b) {
+		typ = "DisplayString"
+	}
+	labelnames = append(labelnames, "value")
+	labelvalues = append(labelvalues, pduValueAsString(pdu, typ))
+	return []prometheus.Metric{prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+"_info", metric.Help, labelnames, nil),
+		prometheus.GaugeValue, 1, labelvalues...)}
+}
+
+// Whether bytes are UTF-8 text without control characters.
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply the metric's scale to a value, if it has one, then add its offset.
+func scaleValue(value float64, metric *config.Metric) float64 {
+	if metric.Scale != 0 {
+		value *= metric.Scale
+	}
+	return value + metric.Offset
+}
+
+// One sample per named bit, with the name as the bit label. Bit 0 is the most
+// significant bit of the first byte.
+func bits(metric *config.Metric, value interface{}, labelnames, labelvalues []string) []prometheus.Metric {
+	bytes, ok := value.([]byte)
+	if !ok {
+		log.Debugf("Expected bytes for BITS metric %s, got %v", metric.Name, value)
+		return []prometheus.Metric{}
+	}
+	labelnames = append(labelnames, "bit")
+	desc := prometheus.NewDesc(metric.Name, metric.Help, labelnames, nil)
+	results := []prometheus.Metric{}
+	for bit, name := range metric.EnumValues {
+		set := 0.0
+		if bit >= 0 && bit/8 < len(bytes) && bytes[bit/8]&(128>>uint(bit%8)) != 0 {
+			set = 1.0
+		}
+		results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, set, append(labelvalues, name)...))
+	}
+	return results
+}
+
+// One sample per state, with the state as a label. The current state has value
+// 1 and the others 0. An unknown current state is added by number.
+func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []string) []prometheus.Metric {
+	labelnames = append(labelnames, metric.Name)
+	desc := prometheus.NewDesc(metric.Name, metric.Help, labelnames, nil)
+	results := []prometheus.Metric{}
+	if _, ok := metric.EnumValues[value]; !ok {
+		results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1.0, append(labelvalues, strconv.Itoa(value))...))
+	}
+	for k, name := range metric.EnumValues {
+		state := 0.0
+		if k == value {
+			state = 1.0
+		}
+		results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, state, append(labelvalues, name)...))
+	}
+	return results
+}
+
 func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labelvalues []string) []prometheus.Metric {
 	results := []prometheus.Metric{}
 	for name, strMetricSlice := range metric.RegexpExtracts {
+		found := false
+		var v float64
 		for _, strMetric := range strMetricSlice {
 			indexes := strMetric.Regex.FindStringSubmatchIndex(pduValue)
 			if indexes == nil {
@@ -225,16 +409,30 @@ func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labe
 				continue
 			}
 			res := strMetric.Regex.ExpandString([]byte{}, strMetric.Value, pduValue, indexes)
-			v, err := strconv.ParseFloat(string(res), 64)
+			var err error
+			v, err = strconv.ParseFloat(string(res), 64)
 			if err != nil {
 				log.Debugf("Error parsing float64 from value: %v for metric: %v", res, metric.Name)
 				continue
 			}
-			newMetric := prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+name, metric.Help+" (regex extracted)", labelnames, nil),
-				prometheus.GaugeValue, v, labelvalues...)
-			results = append(results, newMetric)
+			found = true
 			break
 		}
+		if !found {
+			fallback, ok := metric.RegexpExtractFallbacks[name]
+			if !ok {
+				continue
+			}
+			var err error
+			v, err = strconv.ParseFloat(fallback, 64)
+			if err != nil {
+				log.Debugf("Error parsing float64 from fallback: %v for metric: %v", fallback, metric.Name+name)
+				continue
+			}
+		}
+		newMetric := prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+name, metric.Help+" (regex extracted)", labelnames, nil),
+			prometheus.GaugeValue, v, labelvalues...)
+		results = append(results, newMetric)
 	}
 	return results
 }
@@ -279,7 +477,7 @@ func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
 		for i, o := range pdu.Value.([]byte) {
 			parts[i] = int(o)
 		}
-		if typ == "OctetString" || typ == "DisplayString" {
+		if typ == "OctetString" || typ == "DisplayString" || typ == "DateAndTime" {
 			// Prepend the length, as it is explicit in an index.
 			parts = append([]int{len(pdu.Value.([]byte))}, parts...)
 		}
@@ -300,7 +498,7 @@ func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
 // Returns the string, the oids that were used and the oids left over.
 func indexOidsAsString(indexOids []int, typ string, fixedSize int) (string, []int, []int) {
 	switch typ {
-	case "Integer32", "Integer", "gauge", "counter":
+	case "Integer32", "Integer", "gauge", "counter", "Bool":
 		// Extract the oid for this index, and keep the remainder for the next index.
 		subOid, indexOids := splitOid(indexOids, 1)
 		return fmt.Sprintf("%d", subOid[0]), subOid, indexOids
@@ -371,6 +569,32 @@ func indexOidsAsString(indexOids []int, typ string, fixedSize int) (string, []in
 			}
 			return fmt.Sprintf("0x%X", string(parts)), subOid, indexOids
 		}
+	case "DateAndTime":
+		var subOid []int
+		length := fixedSize
+		if length == 0 {
+			subOid, indexOids = splitOid(indexOids, 1)
+			length = subOid[0]
+		}
+		content, indexOids := splitOid(indexOids, length)
+		subOid = append(subOid, content...)
+		// As rendered by the DISPLAY-HINT in SNMPv2-TC.
+		if length != 8 && length != 11 {
+			str, _, _ := indexOidsAsString(append([]int{length}, content...), "OctetString", 0)
+			return str, subOid, indexOids
+		}
+		str := fmt.Sprintf("%d-%d-%d,%d:%d:%d.%d", content[0]<<8|content[1], content[2], content[3], content[4], content[5], content[6], content[7])
+		if length == 11 {
+			str += fmt.Sprintf(",%c%d:%d", content[8], content[9], content[10])
+		}
+		return str, subOid, indexOids
+	case "InetAddressIPv6":
+		subOid, indexOids := splitOid(indexOids, 16)
+		ip := make(net.IP, 16)
+		for i, o := range subOid {
+			ip[i] = byte(o)
+		}
+		return ip.String(), subOid, indexOids
 	case "IpAddr":
 		subOid, indexOids := splitOid(indexOids, 4)
 		parts := make([]string, 4)
@@ -402,13 +626,85 @@ func indexOidsAsString(indexOids []int, typ string, fixedSize int) (string, []in
 	}
 }
 
+// Rewrite a looked up value with the first of the lookup's regex extracts that
+// matches it. Values that none match are unchanged.
+func rewriteLookupValue(lookup *config.Lookup, value string) string {
+	for _, extract := range lookup.RegexpExtracts {
+		indexes := extract.Regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			continue
+		}
+		return string(extract.Regex.ExpandString([]byte{}, extract.Value, value, indexes))
+	}
+	return value
+}
+
+// The oids of a value when used as an index, without any length, and whether
+// it is a string.
+func pduValueAsOids(pdu *gosnmp.SnmpPDU) ([]int, bool) {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		oids := []int{}
+		value, _ := pdu.Value.([]byte)
+		for _, b := range value {
+			oids = append(oids, int(b))
+		}
+		return oids, true
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		return []int{int(gosnmp.ToBigInt(pdu.Value).Int64())}, false
+	}
+	return nil, false
+}
+
+// Whether an index of a type starts with its length, unless it is fixed size
+// or IMPLIED.
+func indexHasLength(typ string) bool {
+	switch typ {
+	case "OctetString", "DisplayString", "DateAndTime":
+		return true
+	}
+	return false
+}
+
 func indexesToLabels(indexOids []int, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU) map[string]string {
 	labels := map[string]string{}
 	labelOids := map[string][]int{}
+	// Whether each label's oids omit or start with the length respectively.
+	impliedLabels := map[string]bool{}
+	lengthLabels := map[string]bool{}
+	// Whether each label is of a type that can have a length.
+	stringLabels := map[string]bool{}
 
 	// Covert indexes to useful strings.
+	var prevOid []int
 	for _, index := range metric.Indexes {
-		str, subOid, remainingOids := indexOidsAsString(indexOids, index.Type, index.FixedSize)
+		var str string
+		var subOid, remainingOids []int
+		fixedSize := index.FixedSize
+		stringLabels[index.Labelname] = indexHasLength(index.Type)
+		if index.Implied && fixedSize == 0 {
+			// An IMPLIED index has no length, and uses the rest of the oids.
+			fixedSize = len(indexOids)
+			impliedLabels[index.Labelname] = true
+		} else if fixedSize == 0 && indexHasLength(index.Type) {
+			lengthLabels[index.Labelname] = true
+		}
+		switch {
+		case index.Type == "TypedInetAddress" && len(prevOid) == 1 && impliedLabels[index.Labelname]:
+			// InetAddress expects the type and length to come first.
+			str, subOid, remainingOids = indexOidsAsString(append([]int{prevOid[0], len(indexOids)}, indexOids...), "InetAddress", 0)
+			subOid = subOid[2:]
+		case index.Type == "TypedInetAddress" && len(prevOid) == 1:
+			// The previous index is the InetAddressType, which InetAddress
+			// expects to come first.
+			str, subOid, remainingOids = indexOidsAsString(append([]int{prevOid[0]}, indexOids...), "InetAddress", index.FixedSize)
+			subOid = subOid[1:]
+		case index.Type == "TypedInetAddress":
+			str, subOid, remainingOids = indexOidsAsString(indexOids, "OctetString", fixedSize)
+		default:
+			str, subOid, remainingOids = indexOidsAsString(indexOids, index.Type, fixedSize)
+		}
+		prevOid = subOid
 		// The labelvalue is the text form of the index oids.
 		labels[index.Labelname] = str
 		// Save its oid in case we need it for lookups.
@@ -420,15 +716,44 @@ func indexesToLabels(indexOids []int, metric *config.Metric, oidToPdu map[string
 	// Perform lookups.
 	for _, lookup := range metric.Lookups {
 		oid := lookup.Oid
-		for _, label := range lookup.Labels {
-			for _, o := range labelOids[label] {
+		for i, label := range lookup.Labels {
+			subOid := labelOids[label]
+			var needsLength bool
+			if i < len(lookup.Indexes) {
+				// The lookup table's index says how it is encoded.
+				index := lookup.Indexes[i]
+				needsLength = !index.Implied && index.FixedSize == 0 && indexHasLength(index.Type)
+			} else {
+				// Assume it's encoded like the metric's index, other than
+				// being IMPLIED.
+				impliedLookup := lookup.Implied && i == len(lookup.Labels)-1
+				needsLength = (lengthLabels[label] || impliedLabels[label]) && !impliedLookup
+			}
+			if needsLength && !lengthLabels[label] && stringLabels[label] {
+				subOid = append([]int{len(subOid)}, subOid...)
+			} else if !needsLength && lengthLabels[label] && len(subOid) > 0 {
+				subOid = subOid[1:]
+			}
+			for _, o := range subOid {
 				oid = fmt.Sprintf("%s.%d", oid, o)
 			}
 		}
 		if pdu, ok := oidToPdu[oid]; ok {
-			labels[lookup.Labelname] = pduValueAsString(&pdu, lookup.Type)
+			labels[lookup.Labelname] = rewriteLookupValue(lookup, pduValueAsString(&pdu, lookup.Type))
+			// A later lookup may use the value as its index.
+			labelOids[lookup.Labelname], stringLabels[lookup.Labelname] = pduValueAsOids(&pdu)
+			impliedLabels[lookup.Labelname] = stringLabels[lookup.Labelname]
+			lengthLabels[lookup.Labelname] = false
 		} else {
 			labels[lookup.Labelname] = ""
+			labelOids[lookup.Labelname] = nil
+		}
+		if lookup.DropSourceIndexes {
+			for _, label := range lookup.Labels {
+				if label != lookup.Labelname {
+					delete(labels, label)
+				}
+			}
 		}
 	}
 
@@ -69,6 +69,33 @@ func TestPduToSample(t *testing.T) {
 			},
 			expectedMetrics: map[string]string{},
 		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Value: "SomeStringValue",
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "TestMetricName",
+				Oid:  "1.1.1.1.1",
+				Help: "HelpText",
+				RegexpExtracts: map[string][]config.RegexpExtract{
+					"Extension": []config.RegexpExtract{
+						{
+							Regex: config.Regexp{
+								regexp.MustCompile("Other.*"),
+							},
+							Value: "5",
+						},
+					},
+				},
+				RegexpExtractFallbacks: map[string]string{"Extension": "-1"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`gauge:<value:-1 > `: `Desc{fqName: "TestMetricNameExtension", help: "HelpText (regex extracted)", constLabels: {}, variableLabels: []}`,
+			},
+		},
 		{
 			pdu: &gosnmp.SnmpPDU{
 				Name:  "1.1.1.1.1",
@@ -228,6 +255,259 @@ func TestPduToSample(t *testing.T) {
 			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
 			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"-2" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`},
 		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 6,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsInfo",
+				Help:       "Help string",
+				EnumValues: map[int]string{1: "other", 6: "ethernetCsmacd"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"ethernetCsmacd" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.TimeTicks,
+				Value: uint32(12345),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:  "test_metric_seconds",
+				Oid:   "1.1.1.1.1",
+				Type:  "gauge",
+				Help:  "Help string",
+				Scale: 0.01,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:123.45 > `: `Desc{fqName: "test_metric_seconds", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2731,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:   "test_metric_celsius",
+				Oid:    "1.1.1.1.1",
+				Type:   "gauge",
+				Help:   "Help string",
+				Scale:  0.1,
+				Offset: -273.1,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:0 > `: `Desc{fqName: "test_metric_celsius", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.OctetString,
+				Value: []byte("too long for the MIB"),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "DisplayString",
+				Help:    "Help string",
+				MaxSize: 8,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"too long" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 1,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Bool",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Bool",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 3,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Bool",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 7,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsInfo",
+				Help:       "Help string",
+				EnumValues: map[int]string{1: "other", 6: "ethernetCsmacd"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"7" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsStateSet",
+				Help:       "Help string",
+				EnumValues: map[int]string{1: "up", 2: "down", 3: "testing"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"test_metric" value:"up" > gauge:<value:0 > `:      `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"down" > gauge:<value:1 > `:    `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"testing" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 5,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsStateSet",
+				Help:       "Help string",
+				EnumValues: map[int]string{1: "up", 2: "down"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"test_metric" value:"5" > gauge:<value:1 > `:    `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"up" > gauge:<value:0 > `:   `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"down" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.OctetString,
+				Value: []byte{0x40, 0x80},
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "Bits",
+				Help:       "Help string",
+				EnumValues: map[int]string{0: "a", 1: "b", 8: "c", 9: "d", 20: "e"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"bit" value:"a" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [bit]}`,
+				`label:<name:"bit" value:"b" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [bit]}`,
+				`label:<name:"bit" value:"c" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [bit]}`,
+				`label:<name:"bit" value:"d" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [bit]}`,
+				`label:<name:"bit" value:"e" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [bit]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.2.3",
+				Type:  gosnmp.Gauge32,
+				Value: uint(7),
+			},
+			indexOids: []int{2, 3},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Auto",
+				Help: "Help string",
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"oid" value:"2.3" > untyped:<value:7 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [oid]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.4",
+				Type:  gosnmp.OctetString,
+				Value: []byte("eth0"),
+			},
+			indexOids: []int{4},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Auto",
+				Help: "Help string",
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"oid" value:"4" > label:<name:"value" value:"eth0" > gauge:<value:1 > `: `Desc{fqName: "test_metric_info", help: "Help string", constLabels: {}, variableLabels: [oid value]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.5",
+				Type:  gosnmp.OctetString,
+				Value: []byte{0, 255},
+			},
+			indexOids: []int{5},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Auto",
+				Help: "Help string",
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"oid" value:"5" > label:<name:"value" value:"0x00FF" > gauge:<value:1 > `: `Desc{fqName: "test_metric_info", help: "Help string", constLabels: {}, variableLabels: [oid value]}`,
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -318,6 +598,40 @@ func TestSplitOid(t *testing.T) {
 	}
 }
 
+func TestFilteredIndexes(t *testing.T) {
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Value: 6},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.2", Value: 24},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.3", Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.30.4", Value: 6},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.5", Value: []byte("6")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.6", Value: []byte("eth0")},
+	}
+	cases := []struct {
+		filter config.DynamicFilter
+		result []string
+	}{
+		{
+			filter: config.DynamicFilter{Oid: "1.3.6.1.2.1.2.2.1.3", Values: []string{"6", "24"}},
+			result: []string{".1", ".2"},
+		},
+		{
+			filter: config.DynamicFilter{Oid: "1.3.6.1.2.1.31.1.1.1.1", Values: []string{"eth0"}},
+			result: []string{".6"},
+		},
+		{
+			filter: config.DynamicFilter{Oid: "1.3.6.1.2.1.2.2.1.3", Values: []string{"7"}},
+			result: []string{},
+		},
+	}
+	for _, c := range cases {
+		got := filteredIndexes(pdus, c.filter)
+		if !reflect.DeepEqual(got, c.result) {
+			t.Errorf("filteredIndexes(%+v): got %v, want %v", c.filter, got, c.result)
+		}
+	}
+}
+
 func TestPduValueAsString(t *testing.T) {
 	cases := []struct {
 		pdu    *gosnmp.SnmpPDU
@@ -348,6 +662,26 @@ func TestPduValueAsString(t *testing.T) {
 			pdu:    &gosnmp.SnmpPDU{Value: []byte{}},
 			result: "",
 		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{0x07, 0xe2, 10, 16, 13, 30, 15, 0, '+', 1, 0}},
+			typ:    "DateAndTime",
+			result: "2018-10-16,13:30:15.0,+1:0",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{0x07, 0xe2, 10, 16, 13, 30, 15, 0}},
+			typ:    "DateAndTime",
+			result: "2018-10-16,13:30:15.0",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{0x07, 0xe2}},
+			typ:    "DateAndTime",
+			result: "0x07E2",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+			typ:    "InetAddressIPv6",
+			result: "fe80::1",
+		},
 		{
 			pdu:    &gosnmp.SnmpPDU{Value: []byte{65, 66}},
 			typ:    "DisplayString",
@@ -444,6 +778,126 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{},
 			result:   map[string]string{"l": ""},
 		},
+		{
+			oid: []int{5, 104, 101, 108, 108, 111},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "a", Type: "gauge"}, {Labelname: "l", Type: "DisplayString", Implied: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"a": "5", "l": "hello"},
+		},
+		{
+			oid: []int{104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "OctetString", Implied: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "0x6869"},
+		},
+		{
+			// Implied index, looked up in a table where it isn't implied.
+			oid: []int{104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "DisplayString", Implied: true}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.2.104.105": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "hi", "n": "eth0"},
+		},
+		{
+			// Implied index, looked up in a table where it is also implied.
+			oid: []int{104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "DisplayString", Implied: true}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", Implied: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.104.105": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "hi", "n": "eth0"},
+		},
+		{
+			// Index with a length, looked up in a table where it is implied.
+			oid: []int{2, 104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "DisplayString"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", Implied: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.104.105": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "hi", "n": "eth0"},
+		},
+		{
+			// Index with a length, looked up in a table where it is fixed size.
+			oid: []int{2, 104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "OctetString"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", Indexes: []*config.Index{{Labelname: "m", Type: "OctetString", FixedSize: 2}}}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.104.105": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "0x6869", "n": "eth0"},
+		},
+		{
+			// Fixed size index, looked up in a table where it has a length.
+			oid: []int{104, 105},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "OctetString", FixedSize: 2}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", Indexes: []*config.Index{{Labelname: "m", Type: "OctetString"}}}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.2.104.105": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "0x6869", "n": "eth0"},
+		},
+		{
+			// Chained lookups, using the value of the first as the index of
+			// the second.
+			oid: []int{7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{Labels: []string{"l"}, Labelname: "m", Oid: "1.2.3", Type: "gauge"},
+					{Labels: []string{"m"}, Labelname: "n", Oid: "1.2.4", Type: "OctetString"},
+					{Labels: []string{"n"}, Labelname: "o", Oid: "1.2.5", Type: "DisplayString"},
+				},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{
+				"1.2.3.7":         gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: 9},
+				"1.2.4.9":         gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte("hi")},
+				"1.2.5.2.104.105": gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte("eth0")},
+			},
+			result: map[string]string{"l": "7", "m": "9", "n": "0x6869", "o": "eth0"},
+		},
+		{
+			// The looked up value is rewritten by the first regex that matches.
+			oid: []int{7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "l", Oid: "1.2.3", Type: "DisplayString",
+					RegexpExtracts: []config.RegexpExtract{
+						{Regex: config.Regexp{regexp.MustCompile("^Loopback.*")}, Value: "lo"},
+						{Regex: config.Regexp{regexp.MustCompile("^(\\S+) - .*")}, Value: "$1"},
+						{Regex: config.Regexp{regexp.MustCompile("^Gig.*")}, Value: "unused"},
+					}}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.7": gosnmp.SnmpPDU{Value: "GigabitEthernet0/0/1 - Uplink to core, do not touch"}},
+			result:   map[string]string{"l": "GigabitEthernet0/0/1"},
+		},
+		{
+			// The source index is dropped once looked up.
+			oid: []int{7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", DropSourceIndexes: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.7": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"n": "eth0"},
+		},
+		{
+			// Integer index, described by the lookup table.
+			oid: []int{7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "n", Oid: "1.2.3", Indexes: []*config.Index{{Labelname: "m", Type: "gauge"}}}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.3.7": gosnmp.SnmpPDU{Value: "eth0"}},
+			result:   map[string]string{"l": "7", "n": "eth0"},
+		},
 		{
 			oid:      []int{},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}}},
@@ -525,6 +979,40 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{"3.2.16.42.6.29.128.0.1.0.3.0.0.0.0.0.1.1.52": gosnmp.SnmpPDU{Value: "ipv6"}},
 			result:   map[string]string{"l": "ipv6", "b": "7"},
 		},
+		{
+			oid: []int{1, 4, 192, 168, 1, 2, 7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "TypedInetAddress"}, {Labelname: "b", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"t", "l"}, Labelname: "n", Oid: "3"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"3.1.4.192.168.1.2": gosnmp.SnmpPDU{Value: "name"}},
+			result:   map[string]string{"t": "ipv4", "l": "192.168.1.2", "b": "7", "n": "name"},
+		},
+		{
+			oid:      []int{2, 16, 42, 6, 29, 128, 0, 1, 0, 3, 0, 0, 0, 0, 0, 1, 1, 52},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "TypedInetAddress"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"t": "ipv6", "l": "2A06:1D80:0001:0003:0000:0000:0001:0134"},
+		},
+		{
+			// Without a preceding type, it's treated as an OctetString.
+			oid:      []int{2, 10, 11},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "TypedInetAddress"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "0x0A0B"},
+		},
+		{
+			oid:      []int{42, 6, 29, 128, 0, 1, 0, 3, 0, 0, 0, 0, 0, 1, 1, 52, 7},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "InetAddressIPv6", FixedSize: 16}, {Labelname: "b", Type: "gauge"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "2a06:1d80:1:3::1:134", "b": "7"},
+		},
+		{
+			oid:      []int{8, 7, 226, 10, 16, 13, 30, 15, 0, 7},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "DateAndTime"}, {Labelname: "b", Type: "gauge"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "2018-10-16,13:30:15.0", "b": "7"},
+		},
 		{
 			oid:      []int{192, 168, 1, 2},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "IpAddr"}}},
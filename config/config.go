@@ -24,6 +24,23 @@ func LoadFile(filename string) (*Config, error) {
 	return cfg, nil
 }
 
+// Marshal a config to YAML, including the secrets that are normally
+// hidden. The config is not modified, so this is safe to call concurrently
+// with other marshals.
+func MarshalWithSecrets(c Config) ([]byte, error) {
+	revealed := make(Config, len(c))
+	for name, module := range c {
+		if module == nil {
+			revealed[name] = nil
+			continue
+		}
+		m := *module
+		m.WalkParams.Auth.revealSecrets = true
+		revealed[name] = &m
+	}
+	return yaml.Marshal(revealed)
+}
+
 var (
 	DefaultAuth = Auth{
 		Community:     "public",
@@ -61,13 +78,59 @@ type WalkParams struct {
 
 type Module struct {
 	// A list of OIDs.
-	Walk       []string   `yaml:"walk"`
+	Walk []string `yaml:"walk"`
+	// A list of scalar instance OIDs to GET, rather than walk.
+	Get        []string   `yaml:"get,omitempty"`
 	Metrics    []*Metric  `yaml:"metrics"`
 	WalkParams WalkParams `yaml:",inline"`
+	// Restrictions on which rows of tables to get.
+	Filters       []DynamicFilter `yaml:"filters,omitempty"`
+	StaticFilters []StaticFilter  `yaml:"static_filters,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Only get the rows of the target columns whose index has one of the values
+// in the walked column Oid. The targets are got rather than walked.
+type DynamicFilter struct {
+	Oid     string   `yaml:"oid"`
+	Targets []string `yaml:"targets"`
+	Values  []string `yaml:"values"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Only get the rows of the target columns with these indices, rather than
+// walking them.
+type StaticFilter struct {
+	Targets []string `yaml:"targets"`
+	Indices []string `yaml:"indices"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+func (c *StaticFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain StaticFilter
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "filter"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *DynamicFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DynamicFilter
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "filter"); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *WalkParams) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultWalkParams
 	type plain WalkParams
@@ -168,6 +231,12 @@ type Metric struct {
 	Indexes        []*Index                   `yaml:"indexes,omitempty"`
 	Lookups        []*Lookup                  `yaml:"lookups,omitempty"`
 	RegexpExtracts map[string][]RegexpExtract `yaml:"regex_extracts,omitempty"`
+	// Values for regex extracts when none of their regexes give a number.
+	RegexpExtractFallbacks map[string]string `yaml:"regex_extract_fallbacks,omitempty"`
+	EnumValues             map[int]string    `yaml:"enum_values,omitempty"`
+	MaxSize                int               `yaml:"max_size,omitempty"`
+	Scale                  float64           `yaml:"scale,omitempty"`
+	Offset                 float64           `yaml:"offset,omitempty"`
 }
 
 func (c *Metric) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -182,6 +251,7 @@ type Index struct {
 	Labelname string `yaml:"labelname"`
 	Type      string `yaml:"type"`
 	FixedSize int    `yaml:"fixed_size,omitempty"`
+	Implied   bool   `yaml:"implied,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
@@ -202,6 +272,15 @@ type Lookup struct {
 	Labelname string   `yaml:"labelname"`
 	Oid       string   `yaml:"oid"`
 	Type      string   `yaml:"type"`
+	Implied   bool     `yaml:"implied,omitempty"`
+	// The indexes of the table looked up in, which say how to encode the
+	// labels into its oids.
+	Indexes []*Index `yaml:"indexes,omitempty"`
+	// Remove the input labels once the lookup is done, other than the
+	// output label.
+	DropSourceIndexes bool `yaml:"drop_source_indexes,omitempty"`
+	// Rewrite the looked up value with the first of these that matches.
+	RegexpExtracts []RegexpExtract `yaml:"regex_extracts,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
@@ -220,23 +299,15 @@ func (c *Lookup) UnmarshalYAML(unmarshal func(interface{}) error) error {
 // Secret is a string that must not be revealed on marshaling.
 type Secret string
 
-// Hack for creating snmp.yml with the secret.
-var (
-	DoNotHideSecrets = false
-)
-
 // MarshalYAML implements the yaml.Marshaler interface.
 func (s Secret) MarshalYAML() (interface{}, error) {
-	if DoNotHideSecrets {
-		return string(s), nil
-	}
 	if s != "" {
 		return "<secret>", nil
 	}
 	return nil, nil
 }
 
-//UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets.
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets.
 func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain Secret
 	return unmarshal((*plain)(s))
@@ -253,6 +324,42 @@ type Auth struct {
 	ContextName   string `yaml:"context_name,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
+
+	// Marshal secrets as-is rather than hiding them.
+	revealSecrets bool
+}
+
+// Auth with the secrets as plain strings, used when revealing them.
+type revealedAuth struct {
+	Community     string `yaml:"community,omitempty"`
+	SecurityLevel string `yaml:"security_level,omitempty"`
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`
+	AuthProtocol  string `yaml:"auth_protocol,omitempty"`
+	PrivProtocol  string `yaml:"priv_protocol,omitempty"`
+	PrivPassword  string `yaml:"priv_password,omitempty"`
+	ContextName   string `yaml:"context_name,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (c Auth) MarshalYAML() (interface{}, error) {
+	if !c.revealSecrets {
+		type plain Auth
+		return plain(c), nil
+	}
+	return revealedAuth{
+		Community:     string(c.Community),
+		SecurityLevel: c.SecurityLevel,
+		Username:      c.Username,
+		Password:      string(c.Password),
+		AuthProtocol:  c.AuthProtocol,
+		PrivProtocol:  c.PrivProtocol,
+		PrivPassword:  string(c.PrivPassword),
+		ContextName:   c.ContextName,
+		XXX:           c.XXX,
+	}, nil
 }
 
 func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
 )
 
 func TestHideConfigSecrets(t *testing.T) {
@@ -39,3 +43,48 @@ func TestLoadConfigWithOverrides(t *testing.T) {
 		t.Errorf("Error marshalling config: %v", err)
 	}
 }
+
+func TestMarshalWithSecretsConcurrently(t *testing.T) {
+	sc := &SafeConfig{}
+	err := sc.ReloadConfig("testdata/snmp-auth.yml")
+	if err != nil {
+		t.Fatalf("Error loading config %v: %v", "testdata/snmp-auth.yml", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			c, err := config.MarshalWithSecrets(*sc.C)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !strings.Contains(string(c), "mysecret") {
+				errs <- fmt.Errorf("marshalling with secrets hid them:\n%s", c)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			c, err := yaml.Marshal(sc.C)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if strings.Contains(string(c), "mysecret") {
+				errs <- fmt.Errorf("marshalling without secrets revealed them:\n%s", c)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+// Bump when the cache format or the Node struct changes.
+const treeCacheVersion = 2
+
+// The MIB tree as NetSNMP parsed it, cached on disk.
+type treeCache struct {
+	Hash        string `json:"hash"`
+	ParseErrors string `json:"parse_errors"`
+	Tree        *Node  `json:"tree"`
+}
+
+// The directories NetSNMP will load MIBs from with the given options.
+func snmpMIBDirs(opts snmpOptions) []string {
+	dirs := []string{}
+	if !opts.NoSystemMIBs {
+		for _, dir := range strings.Split(mibDirectory(), ":") {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return append(dirs, opts.MIBDirs...)
+}
+
+// Hash the options and the contents of every file in the MIB directories, so
+// that the cache is invalidated when anything that affects parsing changes.
+// Directories that don't exist are skipped, as NetSNMP does.
+func mibHash(opts snmpOptions, dirs []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\nnetsnmp %s\nmibs %q\n", treeCacheVersion, netSnmpVersion(), opts.MIBs)
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Error reading MIB directory %s: %s", dir, err)
+		}
+		fmt.Fprintf(h, "dir %q\n", dir)
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			f, err := os.Open(path)
+			if err != nil {
+				return "", fmt.Errorf("Error reading MIB file %s: %s", path, err)
+			}
+			fmt.Fprintf(h, "file %q %d\n", fi.Name(), fi.Size())
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return "", fmt.Errorf("Error reading MIB file %s: %s", path, err)
+			}
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Read a tree cache, returning an error if it is unreadable, corrupt or
+// doesn't match hash.
+func readTreeCache(path, hash string) (*treeCache, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &treeCache{}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("Error parsing MIB tree cache %s: %s", path, err)
+	}
+	if c.Tree == nil {
+		return nil, fmt.Errorf("MIB tree cache %s has no tree", path)
+	}
+	if c.Hash != hash {
+		return nil, fmt.Errorf("MIB tree cache %s is out of date", path)
+	}
+	compactTree(c.Tree)
+	return c, nil
+}
+
+// Atomically write a tree cache to path.
+func writeTreeCache(path string, c *treeCache) error {
+	out, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("Error marshalling MIB tree cache: %s", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("Error opening MIB tree cache: %s", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(out)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing MIB tree cache: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Error writing MIB tree cache: %s", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("Error renaming MIB tree cache: %s", err)
+	}
+	return nil
+}
+
+// Return the tree from the cache at path if it matches hash, otherwise call
+// parse and update the cache. If useCache is false the cache is only
+// written.
+func cachedMIBTree(path, hash string, useCache bool, parse func() (*Node, string, error)) (*Node, string, error) {
+	if useCache {
+		c, err := readTreeCache(path, hash)
+		if err == nil {
+			log.Infof("Loaded MIB tree from cache %s", path)
+			return c.Tree, c.ParseErrors, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Warnf("Ignoring MIB tree cache: %s", err)
+		}
+	}
+	nodes, parseErrors, err := parse()
+	if err != nil {
+		return nil, "", err
+	}
+	// A broken cache only slows down the next run.
+	if err := writeTreeCache(path, &treeCache{Hash: hash, ParseErrors: parseErrors, Tree: nodes}); err != nil {
+		log.Warn(err)
+	}
+	return nodes, parseErrors, nil
+}
+
+// Load the unprepared MIB tree and NetSNMP's parse errors. If cachePath is
+// set the tree is read from there when the MIBs haven't changed, skipping
+// NetSNMP entirely.
+func loadMIBTree(opts snmpOptions, cachePath string, useCache bool) (*Node, string, error) {
+	parse := func() (*Node, string, error) {
+		parseErrors, err := initSNMP(opts)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error initializing NetSNMP: %s", err)
+		}
+		return getMIBTree(opts.RawDescriptions), parseErrors, nil
+	}
+	if cachePath == "" {
+		return parse()
+	}
+	if err := checkMIBDirs(opts.MIBDirs); err != nil {
+		return nil, "", err
+	}
+	hash, err := mibHash(opts, snmpMIBDirs(opts))
+	if err != nil {
+		return nil, "", err
+	}
+	return cachedMIBTree(cachePath, hash, useCache, parse)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMIBHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mibPath := filepath.Join(dir, "TEST-MIB.txt")
+	if err := ioutil.WriteFile(mibPath, []byte(testMIB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := snmpOptions{MIBDirs: []string{dir}, NoSystemMIBs: true}
+	hash := func(opts snmpOptions) string {
+		h, err := mibHash(opts, snmpMIBDirs(opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	first := hash(opts)
+	if hash(opts) != first {
+		t.Error("Hash changed without any changes")
+	}
+	if hash(snmpOptions{MIBDirs: []string{dir}, MIBs: []string{"TEST-MIB"}, NoSystemMIBs: true}) == first {
+		t.Error("Hash didn't change when MIB modules changed")
+	}
+	if hash(snmpOptions{MIBDirs: []string{dir, filepath.Join(dir, "missing")}, NoSystemMIBs: true}) != first {
+		t.Error("Hash changed for a missing directory")
+	}
+	if err := ioutil.WriteFile(mibPath, []byte(testMIB+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash(opts) == first {
+		t.Error("Hash didn't change when a MIB file changed")
+	}
+}
+
+func TestCachedMIBTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree.json")
+
+	parses := 0
+	parse := func() (*Node, string, error) {
+		parses++
+		return &Node{Oid: "1", Label: "iso"}, "parse errors", nil
+	}
+	load := func(hash string, useCache bool, expectedParses int) {
+		parses = 0
+		n, parseErrors, err := cachedMIBTree(path, hash, useCache, parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Label != "iso" || parseErrors != "parse errors" {
+			t.Errorf("Got tree %+v and parse errors %q", n, parseErrors)
+		}
+		if parses != expectedParses {
+			t.Errorf("Got %d parses, want %d", parses, expectedParses)
+		}
+	}
+
+	// No cache yet.
+	load("a", true, 1)
+	// Cache is up to date.
+	load("a", true, 0)
+	// MIBs have changed.
+	load("b", true, 1)
+	load("b", true, 0)
+	// --no-cache.
+	load("b", false, 1)
+
+	for _, corrupt := range []string{"", "{\"hash\": \"b\", \"tree\": ", "{\"hash\": \"b\"}"} {
+		if err := ioutil.WriteFile(path, []byte(corrupt), 0644); err != nil {
+			t.Fatal(err)
+		}
+		load("b", true, 1)
+		load("b", true, 0)
+	}
+}
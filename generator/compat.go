@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// The parts of snmp.yml that a version of the exporter understands.
+type exporterVersion struct {
+	// Field names by where they appear: module, metric, index or lookup.
+	fields map[string][]string
+	// Metric, index and lookup types.
+	types []string
+}
+
+// What the config of older exporter versions can contain, for --compat. The
+// current version understands everything the generator writes, so isn't
+// listed.
+var exporterVersions = map[string]exporterVersion{
+	"0.8.0": {
+		fields: map[string][]string{
+			"module": {"walk", "metrics", "version", "max_repetitions", "retries", "timeout", "auth"},
+			"metric": {"name", "oid", "type", "help", "indexes", "lookups", "regex_extracts"},
+			"index":  {"labelname", "type", "fixed_size"},
+			"lookup": {"labels", "labelname", "oid", "type"},
+		},
+		types: []string{"gauge", "counter", "OctetString", "DisplayString", "PhysAddress48", "IpAddr", "InetAddress", "InetAddressType", "Integer32", "Integer"},
+	},
+}
+
+// Metric fields that older exporters don't understand, but that can be left
+// out without changing what they export.
+var droppableFields = map[string]func(*config.Metric){
+	"enum_values": func(m *config.Metric) { m.EnumValues = nil },
+	"max_size":    func(m *config.Metric) { m.MaxSize = 0 },
+}
+
+// The exporter versions that --compat accepts, sorted.
+func exporterVersionNames() []string {
+	names := make([]string, 0, len(exporterVersions))
+	for name := range exporterVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The fields that are set in v, by marshalling it.
+func yamlFields(v interface{}) ([]string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &m); err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// Make an snmp_exporter config for the given exporter version, leaving out
+// droppable fields it doesn't understand. Returns a line for everything else
+// it doesn't understand, naming the module and metric.
+func compatConfig(cfg config.Config, version string) (config.Config, []string, error) {
+	v, ok := exporterVersions[version]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown exporter version %q, known versions are %s", version, strings.Join(exporterVersionNames(), ", "))
+	}
+	fields := map[string]bool{}
+	for where, names := range v.fields {
+		for _, name := range names {
+			fields[where+"."+name] = true
+		}
+	}
+	types := map[string]bool{}
+	for _, t := range v.types {
+		types[t] = true
+	}
+
+	problems := []string{}
+	check := func(prefix, where string, value interface{}, t string) error {
+		set, err := yamlFields(value)
+		if err != nil {
+			return err
+		}
+		for _, field := range set {
+			if !fields[where+"."+field] {
+				problems = append(problems, fmt.Sprintf("%s: field %s", prefix, field))
+			}
+		}
+		if t != "" && !types[t] {
+			problems = append(problems, fmt.Sprintf("%s: type %s", prefix, t))
+		}
+		return nil
+	}
+
+	out := config.Config{}
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		module := *cfg[name]
+		module.Metrics = nil
+		prefix := "module " + name
+		if err := check(prefix, "module", module, ""); err != nil {
+			return nil, nil, err
+		}
+		for _, m := range cfg[name].Metrics {
+			metric := *m
+			for field, drop := range droppableFields {
+				if !fields["metric."+field] {
+					drop(&metric)
+				}
+			}
+			prefix := fmt.Sprintf("module %s metric %s", name, metric.Name)
+			if err := check(prefix, "metric", metric, metric.Type); err != nil {
+				return nil, nil, err
+			}
+			for _, index := range metric.Indexes {
+				if err := check(prefix+" index "+index.Labelname, "index", index, index.Type); err != nil {
+					return nil, nil, err
+				}
+			}
+			for _, lookup := range metric.Lookups {
+				if err := check(prefix+" lookup "+lookup.Labelname, "lookup", lookup, lookup.Type); err != nil {
+					return nil, nil, err
+				}
+			}
+			module.Metrics = append(module.Metrics, &metric)
+		}
+		out[name] = &module
+	}
+	return out, problems, nil
+}
+
+// Make an snmp_exporter config for the given exporter version, returning an
+// error listing everything it needs that the version doesn't understand.
+func checkCompat(cfg config.Config, version string) (config.Config, error) {
+	out, problems, err := compatConfig(cfg, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) != 0 {
+		return nil, fmt.Errorf("Config can't be used with exporter %s, which doesn't support:\n  %s", version, strings.Join(problems, "\n  "))
+	}
+	return out, nil
+}
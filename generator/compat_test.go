@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestCompatConfig(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		cfg      *ModuleConfig
+		problems []string
+	}{
+		{
+			cfg:      &ModuleConfig{Walk: []string{"ifTable"}},
+			problems: []string{},
+		},
+		{
+			cfg: &ModuleConfig{
+				Walk:      []string{"ifTable"},
+				Overrides: map[string]MetricOverrides{"ifType": {Type: "EnumAsInfo"}},
+			},
+			problems: []string{"module m metric ifType: type EnumAsInfo"},
+		},
+		{
+			cfg: &ModuleConfig{
+				Walk: []string{"ifTable"},
+				Get:  []string{"1.3.6.1.2.1.2.1.0"},
+			},
+			problems: []string{"module m: field get"},
+		},
+	}
+	for i, c := range cases {
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("Case %d: %s", i, err)
+		}
+		cfg := config.Config{"m": result.Module}
+		ifType := -1
+		for j, m := range result.Module.Metrics {
+			if m.Name == "ifType" && len(m.EnumValues) != 0 {
+				ifType = j
+			}
+		}
+		if ifType == -1 {
+			t.Fatalf("Case %d: no ifType metric with enum_values in %+v", i, result.Module.Metrics)
+		}
+
+		out, problems, err := compatConfig(cfg, "0.8.0")
+		if err != nil {
+			t.Fatalf("Case %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(problems, c.problems) {
+			t.Errorf("Case %d: got problems %v, want %v", i, problems, c.problems)
+		}
+		// enum_values is left out for 0.8.0, without changing the config.
+		if len(out["m"].Metrics) != len(result.Module.Metrics) || len(out["m"].Metrics[ifType].EnumValues) != 0 || len(result.Module.Metrics[ifType].EnumValues) == 0 {
+			t.Errorf("Case %d: unexpected metrics for 0.8.0 %+v", i, out["m"].Metrics)
+		}
+	}
+
+	if _, _, err := compatConfig(config.Config{}, "0.1.0"); err == nil || !strings.Contains(err.Error(), "known versions are 0.8.0") {
+		t.Errorf("Unexpected error for unknown version: %v", err)
+	}
+}
+
+func TestCompatOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	configPath := filepath.Join(dir, "generator.yml")
+	outputPath := filepath.Join(dir, "snmp.yml")
+	content := "modules:\n  if_mib:\n    walk: [ifTable]\n    overrides:\n      ifType:\n        type: EnumAsStateSet\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compat := range []string{"", "0.8.0"} {
+		*compatVersion = compat
+		_, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{})
+		if compat == "" && err != nil {
+			t.Fatal(err)
+		}
+		if compat == "0.8.0" && (err == nil || !strings.Contains(err.Error(), "module if_mib metric ifType: type EnumAsStateSet")) {
+			t.Errorf("Unexpected error generating for 0.8.0: %v", err)
+		}
+	}
+	*compatVersion = ""
+
+	// The output of the failed run isn't written.
+	cfg, err := config.LoadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*cfg)["if_mib"].Metrics[2].Type != "EnumAsStateSet" {
+		t.Errorf("Unexpected output %+v", (*cfg)["if_mib"].Metrics[2])
+	}
+}
@@ -1,76 +1,382 @@
 package main
 
-import "github.com/prometheus/snmp_exporter/config"
+import (
+	"fmt"
+	"strings"
 
-// The generator config.
-type Config struct {
-	Modules map[string]*ModuleConfig `yaml:"modules"`
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
 
-	XXX map[string]interface{} `yaml:",inline"`
+// The generator config. Unknown fields are caught by parsing it with
+// yaml.UnmarshalStrict, which reports where in the file they are.
+type Config struct {
+	// Globs of further files with modules, relative to this file.
+	Include []string `yaml:"include,omitempty"`
+	// Settings merged into every module.
+	Defaults Defaults                 `yaml:"defaults,omitempty"`
+	Modules  map[string]*ModuleConfig `yaml:"modules"`
 }
 
-func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	type plain Config
-	if err := unmarshal((*plain)(c)); err != nil {
-		return err
-	}
-	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
-		return err
-	}
-	return nil
+// Walk params, lookups and overrides shared by all modules. Walk params a
+// module sets replace the defaults, the default lookups come before the
+// module's own, and overrides are merged by name with the module's winning.
+type Defaults struct {
+	Lookups    []*Lookup                  `yaml:"lookups,omitempty"`
+	WalkParams config.WalkParams          `yaml:",inline"`
+	Overrides  map[string]MetricOverrides `yaml:"overrides,omitempty"`
 }
 
 type MetricOverrides struct {
-	RegexpExtracts map[string][]config.RegexpExtract `yaml:"regex_extracts,omitempty"`
+	RegexpExtracts map[string][]RegexpExtract `yaml:"regex_extracts,omitempty"`
+	// Values for regex extracts, by name, to use when none of their regexes
+	// match the value or give a number. Must be numbers.
+	RegexpExtractFallbacks map[string]string `yaml:"regex_extract_fallbacks,omitempty"`
+	// Type to use for the metric instead of the one from the MIB. Integers
+	// can be gauge, counter, Bool, EnumAsInfo or EnumAsStateSet, and strings
+	// can be OctetString, DisplayString, PhysAddress48, IpAddr,
+	// InetAddressIPv6 or DateAndTime.
+	Type string `yaml:"type,omitempty"`
+	// Most states allowed for EnumAsStateSet. Defaults to 100.
+	MaxStates int `yaml:"max_states,omitempty"`
+	// Convert a TIMETICKS object to seconds, overriding the module's
+	// timeticks_as_seconds.
+	TimeticksAsSeconds *bool `yaml:"timeticks_as_seconds,omitempty"`
+	// Drop the metric, or every metric under the OID, and don't walk it
+	// unless a lookup needs it.
+	Ignore bool `yaml:"ignore,omitempty"`
+	// Name to use for the metric instead of the one from the MIB. No unit
+	// suffix is added, and the module's prefix is only added if
+	// NameAbsolute is false.
+	Name         string `yaml:"name,omitempty"`
+	NameAbsolute bool   `yaml:"name_absolute,omitempty"`
+	// Help to use for the metric instead of the description from the MIB.
+	// The OID is appended unless HelpIncludeOid is false.
+	Help           string `yaml:"help,omitempty"`
+	HelpIncludeOid *bool  `yaml:"help_include_oid,omitempty"`
+	// Multiply the value by Scale and then add Offset. Only for gauges and
+	// counters, and not with timeticks_as_seconds.
+	Scale  *float64 `yaml:"scale,omitempty"`
+	Offset float64  `yaml:"offset,omitempty"`
+}
+
+type ModuleConfig struct {
+	// Module to inherit walk, get, lookups, overrides and walk params from.
+	Extends string   `yaml:"extends,omitempty"`
+	Walk    []string `yaml:"walk"`
+	// Scalars to get with a GET rather than walk, such as sysUpTime or
+	// sysUpTime.0.
+	Get        []string          `yaml:"get,omitempty"`
+	Lookups    []*Lookup         `yaml:"lookups"`
+	WalkParams config.WalkParams `yaml:",inline"`
+	// Overrides by object name or OID, or by a regex on metric names and
+	// OIDs for keys starting with ~.
+	Overrides map[string]MetricOverrides `yaml:"overrides"`
+	// Most named bits a BITS object can have to become a Bits metric,
+	// rather than an OctetString. Defaults to 64.
+	MaxBits int `yaml:"max_bits,omitempty"`
+	// Append each object's units to its metric name.
+	AppendUnitSuffix bool `yaml:"append_unit_suffix,omitempty"`
+	// How much of each object's description to use as the metric's help:
+	// full, first_sentence or none. Defaults to --help-mode.
+	Help string `yaml:"help,omitempty"`
+	// Convert metric and label names from the MIBs to snake_case. Defaults
+	// to --snake-case.
+	SnakeCase bool `yaml:"snake_case,omitempty"`
+	// Most characters of help, which is cut short with an ellipsis keeping
+	// the OID at the end. Defaults to --help-max-length.
+	HelpMaxLength int `yaml:"help_max_length,omitempty"`
+	// Prefix for the names of the module's metrics, joined with an
+	// underscore.
+	Prefix string `yaml:"prefix,omitempty"`
+	// Prefix for metric and label names from the MIBs that start with a
+	// digit, which Prometheus doesn't allow. Defaults to _.
+	DigitPrefix string `yaml:"digit_prefix,omitempty"`
+	// Append the meanings of enumerated values to help. Defaults to true.
+	EnumValuesInHelp *bool `yaml:"enum_values_in_help,omitempty"`
+	// Most enumerated values to list in help. Defaults to 10.
+	MaxEnumValuesInHelp int `yaml:"max_enum_values_in_help,omitempty"`
+	// Append the MIB module defining each object to help, such as
+	// "[IF-MIB]".
+	MIBInHelp bool `yaml:"mib_in_help,omitempty"`
+	// Convert TIMETICKS objects, which are in hundredths of a second, to
+	// seconds with a _seconds suffix.
+	TimeticksAsSeconds bool `yaml:"timeticks_as_seconds,omitempty"`
+	// Exclude objects with a STATUS of deprecated. Defaults to false.
+	IgnoreDeprecated bool `yaml:"ignore_deprecated,omitempty"`
+	// Exclude objects with a STATUS of obsolete. Defaults to true.
+	IgnoreObsolete *bool `yaml:"ignore_obsolete,omitempty"`
+	// Skip OIDs to walk that aren't in the MIBs with a warning, rather than
+	// failing. Defaults to --skip-missing.
+	AllowMissing bool `yaml:"allow_missing,omitempty"`
+	// Walk numeric OIDs that aren't in the MIBs, exporting everything under
+	// them as a metric of type Auto.
+	AllowUnknownOids bool `yaml:"allow_unknown_oids,omitempty"`
+	// Require every regex extract in overrides to have a fallback.
+	StrictExtracts bool `yaml:"strict_extracts,omitempty"`
+	// Look up the ifName and ifDescr of metrics indexed by ifIndex, keeping
+	// ifIndex.
+	AutoInterfaceLookups bool `yaml:"auto_interface_lookups,omitempty"`
+	// Objects whose subtrees are left out of the module, and out of the
+	// walk where possible.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Regular expressions selecting which metrics to keep by name.
+	MetricFilters MetricFilters `yaml:"metric_filters,omitempty"`
+	// Restrictions on which rows of tables the exporter gets.
+	Filters Filters `yaml:"filters,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	// The keys of overrides in the order they were declared, which regex
+	// overrides are applied in.
+	overrideOrder []string
+	// The keys of overrides that came from the defaults rather than the
+	// module. Most modules don't walk most of what they override, so these
+	// aren't warned about when they match no metric.
+	defaultOverrides map[string]bool
+	// The walk params set in the config, even to zero or empty, by their
+	// YAML keys with auth params as e.g. auth.community. These replace the
+	// params inherited from extends and defaults.
+	walkParamsSet map[string]bool
 }
 
-func (c *MetricOverrides) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	type plain MetricOverrides
-	if err := unmarshal((*plain)(c)); err != nil {
-		return err
-	}
-	if err := config.CheckOverflow(c.XXX, "overrides"); err != nil {
-		return err
+type Filters struct {
+	Static  []StaticFilter  `yaml:"static,omitempty"`
+	Dynamic []DynamicFilter `yaml:"dynamic,omitempty"`
+}
+
+// Have the exporter get only the rows of the target tables or columns with
+// these indices, such as 3 or 4.1.2, rather than walking them.
+type StaticFilter struct {
+	Targets []string `yaml:"targets"`
+	Indices []string `yaml:"indices"`
+}
+
+// Have the exporter walk the Oid column, and only get the rows of the target
+// tables or columns where it has one of the values. The targets must have
+// the same indexes as Oid. Values of enumerated objects can be given by name.
+type DynamicFilter struct {
+	Oid     string   `yaml:"oid"`
+	Targets []string `yaml:"targets"`
+	Values  []string `yaml:"values"`
+}
+
+// Filters on the names of a module's metrics, before any renaming, prefix or
+// suffix. If there are includes a metric must match one of them, and then it
+// must match none of the excludes.
+type MetricFilters struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+type Lookup struct {
+	OldIndex string `yaml:"old_index"`
+	// Indexes to look up together, in the order of the new index's table's
+	// indexes. Used instead of OldIndex.
+	OldIndexes []string `yaml:"old_indexes,omitempty"`
+	NewIndex   string   `yaml:"new_index"`
+	// By default the old index's label is replaced by the looked up one.
+	// Have the exporter remove the old index's label once looked up.
+	DropSourceIndexes bool `yaml:"drop_source_indexes,omitempty"`
+	// Keep the old index's label, adding the looked up one.
+	KeepSourceIndexes bool `yaml:"keep_source_indexes,omitempty"`
+	// Type of a NewIndex that is a numeric OID not in the MIBs, such as
+	// DisplayString or gauge.
+	Type string `yaml:"type,omitempty"`
+	// Rewrite the looked up value with the first of these whose regex
+	// matches, replacing it with the value expanded with the regex's groups.
+	RegexpExtracts []RegexpExtract `yaml:"regex_extracts,omitempty"`
+
+	// Added by auto_interface_lookups, so not warned about if unused.
+	auto bool
+}
+
+// A regex and the value to replace what it matches with. The regex is only
+// compiled when generating, so that a bad one is reported with where it is.
+type RegexpExtract struct {
+	Regex string `yaml:"regex"`
+	Value string `yaml:"value"`
+}
+
+func (c *RegexpExtract) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.Value = config.DefaultRegexpExtract.Value
+	type plain RegexpExtract
+	return unmarshal((*plain)(c))
+}
+
+// The indexes a lookup looks up.
+func (l *Lookup) oldIndexes() []string {
+	if len(l.OldIndexes) != 0 {
+		return l.OldIndexes
 	}
-	return nil
+	return []string{l.OldIndex}
 }
 
-type ModuleConfig struct {
-	Walk       []string                   `yaml:"walk"`
-	Lookups    []*Lookup                  `yaml:"lookups"`
-	WalkParams config.WalkParams          `yaml:",inline"`
-	Overrides  map[string]MetricOverrides `yaml:"overrides"`
+// Record what unmarshalling the modules loses: the order each module's
+// overrides are declared in, and which walk params were set to zero values.
+func (c *Config) readDeclaredFields(content []byte) {
+	raw := struct {
+		Modules yaml.MapSlice `yaml:"modules"`
+	}{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return
+	}
+	for _, module := range raw.Modules {
+		name, _ := module.Key.(string)
+		m, ok := c.Modules[name]
+		if !ok || m == nil {
+			continue
+		}
+		fields, _ := module.Value.(yaml.MapSlice)
+		for _, field := range fields {
+			switch field.Key {
+			case "overrides":
+				overrides, _ := field.Value.(yaml.MapSlice)
+				for _, o := range overrides {
+					if key, ok := o.Key.(string); ok {
+						m.overrideOrder = append(m.overrideOrder, key)
+					}
+				}
+			case "version", "max_repetitions", "retries", "timeout":
+				m.setWalkParam(field.Key.(string))
+			case "auth":
+				auth, _ := field.Value.(yaml.MapSlice)
+				for _, a := range auth {
+					if key, ok := a.Key.(string); ok {
+						m.setWalkParam("auth." + key)
+					}
+				}
+			}
+		}
+	}
+}
 
-	XXX map[string]interface{} `yaml:",inline"`
+// Record that a walk param was set in the module's config.
+func (m *ModuleConfig) setWalkParam(key string) {
+	if m.walkParamsSet == nil {
+		m.walkParamsSet = map[string]bool{}
+	}
+	m.walkParamsSet[key] = true
 }
 
-func (c *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	type plain ModuleConfig
-	if err := unmarshal((*plain)(c)); err != nil {
-		return err
+// Merge each module that extends another with its parent. Walks, gets and
+// lookups are appended to the parent's, while overrides and walk params
+// replace the parent's by key.
+func (c *Config) resolveExtends() error {
+	resolved := map[string]bool{}
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		m := c.Modules[name]
+		if resolved[name] || m.Extends == "" {
+			return nil
+		}
+		for i, n := range chain {
+			if n == name {
+				return fmt.Errorf("Modules %s extend each other in a cycle", strings.Join(append(chain[i:], name), " -> "))
+			}
+		}
+		parent, ok := c.Modules[m.Extends]
+		if !ok {
+			return fmt.Errorf("Module %s extends %s, which doesn't exist", name, m.Extends)
+		}
+		if err := resolve(m.Extends, append(chain, name)); err != nil {
+			return err
+		}
+		m.Walk = append(append([]string{}, parent.Walk...), m.Walk...)
+		m.Get = append(append([]string{}, parent.Get...), m.Get...)
+		m.Lookups = append(append([]*Lookup{}, parent.Lookups...), m.Lookups...)
+		m.Overrides = mergeOverrides(parent.Overrides, m.Overrides)
+		m.overrideOrder = append(append([]string{}, parent.overrideOrder...), m.overrideOrder...)
+		m.WalkParams = mergeWalkParams(parent.WalkParams, m.WalkParams, m.walkParamsSet)
+		for key := range parent.walkParamsSet {
+			m.setWalkParam(key)
+		}
+		resolved[name] = true
+		if out, err := yaml.Marshal(m); err == nil {
+			log.Debugf("Module %s after extending %s:\n%s", name, m.Extends, out)
+		}
+		return nil
 	}
-	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
-		return err
+	for name := range c.Modules {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-type Lookup struct {
-	OldIndex string `yaml:"old_index"`
-	NewIndex string `yaml:"new_index"`
+// Merge the defaults into every module. This is done after extends is
+// resolved, so that modules extending another get the defaults only once.
+func (c *Config) applyDefaults() {
+	d := c.Defaults
+	for _, m := range c.Modules {
+		m.Lookups = append(append([]*Lookup{}, d.Lookups...), m.Lookups...)
+		for name := range d.Overrides {
+			if _, ok := m.Overrides[name]; !ok {
+				if m.defaultOverrides == nil {
+					m.defaultOverrides = map[string]bool{}
+				}
+				m.defaultOverrides[name] = true
+			}
+		}
+		m.Overrides = mergeOverrides(d.Overrides, m.Overrides)
+		m.WalkParams = mergeWalkParams(d.WalkParams, m.WalkParams, m.walkParamsSet)
+	}
+}
 
-	XXX map[string]interface{} `yaml:",inline"`
+// Overrides with those in child replacing those of parent for the same name.
+func mergeOverrides(parent, child map[string]MetricOverrides) map[string]MetricOverrides {
+	merged := map[string]MetricOverrides{}
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range child {
+		merged[k] = v
+	}
+	return merged
 }
 
-func (c *Lookup) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	type plain Lookup
-	if err := unmarshal((*plain)(c)); err != nil {
-		return err
+// Walk params with those set in child replacing those of parent. Params
+// are set if they're non-zero or their key is in set, so that a child can
+// clear a param to get the exporter's default.
+func mergeWalkParams(parent, child config.WalkParams, set map[string]bool) config.WalkParams {
+	merged := parent
+	if child.Version != 0 || set["version"] {
+		merged.Version = child.Version
 	}
-	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
-		return err
+	if child.MaxRepetitions != 0 || set["max_repetitions"] {
+		merged.MaxRepetitions = child.MaxRepetitions
 	}
-	return nil
+	if child.Retries != 0 || set["retries"] {
+		merged.Retries = child.Retries
+	}
+	if child.Timeout != 0 || set["timeout"] {
+		merged.Timeout = child.Timeout
+	}
+	auth, childAuth := &merged.Auth, child.Auth
+	if childAuth.Community != "" || set["auth.community"] {
+		auth.Community = childAuth.Community
+	}
+	if childAuth.SecurityLevel != "" || set["auth.security_level"] {
+		auth.SecurityLevel = childAuth.SecurityLevel
+	}
+	if childAuth.Username != "" || set["auth.username"] {
+		auth.Username = childAuth.Username
+	}
+	if childAuth.Password != "" || set["auth.password"] {
+		auth.Password = childAuth.Password
+	}
+	if childAuth.AuthProtocol != "" || set["auth.auth_protocol"] {
+		auth.AuthProtocol = childAuth.AuthProtocol
+	}
+	if childAuth.PrivProtocol != "" || set["auth.priv_protocol"] {
+		auth.PrivProtocol = childAuth.PrivProtocol
+	}
+	if childAuth.PrivPassword != "" || set["auth.priv_password"] {
+		auth.PrivPassword = childAuth.PrivPassword
+	}
+	if childAuth.ContextName != "" || set["auth.context_name"] {
+		auth.ContextName = childAuth.ContextName
+	}
+	return merged
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// Rough sizes in bytes of the parts of a response, for estimating how big
+// the responses to a scrape are.
+const (
+	responseOverheadBytes = 40
+	varbindOverheadBytes  = 4
+	numberValueBytes      = 6
+	stringValueBytes      = 32
+)
+
+// An estimate of how much work a scrape of a module is for a device.
+type scrapeCost struct {
+	WalkRoots int `json:"walk_roots"`
+	// Objects in the MIBs under the walked oids that have values, so not
+	// tables or entries.
+	WalkObjects int `json:"walk_objects"`
+	// Walked oids that aren't in the MIBs, so can't be estimated.
+	UnknownWalks int `json:"unknown_walks"`
+	// Values returned by walks, assuming every table has the given rows.
+	WalkVarbinds int `json:"walk_varbinds"`
+	WalkPDUs     int `json:"walk_pdus"`
+	// Oids got with GET, including the rows of filters.
+	GetOids int `json:"get_oids"`
+	GetPDUs int `json:"get_pdus"`
+	// The rough total size of the responses.
+	ResponseBytes  int `json:"response_bytes"`
+	MaxRepetitions int `json:"max_repetitions"`
+	RowsPerTable   int `json:"rows_per_table"`
+}
+
+// Estimate the cost of scraping a generated module, assuming every table has
+// rowsPerTable rows. Walks use GETBULK with the module's max_repetitions,
+// or GETNEXT with SNMP v1, and gets are sent as many oids at a time as the
+// exporter does.
+func estimateCost(module *config.Module, nameToNode map[string]*Node, rowsPerTable int) scrapeCost {
+	cost := scrapeCost{WalkRoots: len(module.Walk), RowsPerTable: rowsPerTable}
+	params := module.WalkParams
+	if params.Version == 0 {
+		params.Version = config.DefaultWalkParams.Version
+	}
+	if params.MaxRepetitions == 0 {
+		params.MaxRepetitions = config.DefaultWalkParams.MaxRepetitions
+	}
+	cost.MaxRepetitions = int(params.MaxRepetitions)
+
+	for _, oid := range module.Walk {
+		root, ok := nameToNode[oid]
+		if !ok {
+			cost.UnknownWalks++
+			continue
+		}
+		varbinds := 0
+		walkNode(root, func(n *Node) {
+			if len(n.Children) != 0 || !valueAccess(n.Access) {
+				return
+			}
+			rows := 1
+			if len(n.Indexes) != 0 {
+				rows = rowsPerTable
+			}
+			cost.WalkObjects++
+			varbinds += rows
+			cost.ResponseBytes += rows * varbindBytes(n)
+		})
+		cost.WalkVarbinds += varbinds
+		// The last request goes past the end of the subtree.
+		if params.Version == 1 {
+			cost.WalkPDUs += varbinds + 1
+		} else {
+			cost.WalkPDUs += (varbinds+cost.MaxRepetitions-1)/cost.MaxRepetitions + 1
+		}
+	}
+
+	// The size of the value of an oid to get, from the object it is an
+	// instance of if that's in the MIBs.
+	getBytes := func(oid string) int {
+		if n, ok := nameToNode[oid]; ok {
+			return varbindBytes(n)
+		}
+		return varbindOverheadBytes + len(strings.Split(oid, ".")) + 1 + numberValueBytes
+	}
+	for _, oid := range module.Get {
+		cost.GetOids++
+		cost.ResponseBytes += getBytes(strings.TrimSuffix(oid, ".0"))
+	}
+	for _, filter := range module.StaticFilters {
+		for _, target := range filter.Targets {
+			cost.GetOids += len(filter.Indices)
+			cost.ResponseBytes += len(filter.Indices) * getBytes(target)
+		}
+	}
+	for _, filter := range module.Filters {
+		for _, target := range filter.Targets {
+			cost.GetOids += rowsPerTable
+			cost.ResponseBytes += rowsPerTable * getBytes(target)
+		}
+	}
+	cost.GetPDUs = (cost.GetOids + gosnmp.MaxOids - 1) / gosnmp.MaxOids
+	cost.ResponseBytes += (cost.WalkPDUs + cost.GetPDUs) * responseOverheadBytes
+	return cost
+}
+
+// Whether an agent returns values for objects with an access.
+func valueAccess(a string) bool {
+	switch a {
+	case "ACCESS_READONLY", "ACCESS_READWRITE", "ACCESS_CREATE":
+		return true
+	}
+	return false
+}
+
+// The rough size of a value of a node in a response, with one oid for
+// each index.
+func varbindBytes(n *Node) int {
+	size := varbindOverheadBytes + len(strings.Split(n.Oid, ".")) + len(n.Indexes)
+	switch n.Type {
+	case "OCTETSTR", "BITSTRING", "DisplayString", "PhysAddress48", "InetAddressIPv6", "DateAndTime":
+		if max := maxSize(n); max != 0 && max < stringValueBytes {
+			return size + max
+		}
+		return size + stringValueBytes
+	}
+	return size + numberValueBytes
+}
+
+// Print the estimated cost of scraping a module.
+func writeCostReport(w io.Writer, module string, cost scrapeCost) {
+	fmt.Fprintf(w, "Module %s scrape cost, assuming %d rows per table:\n", module, cost.RowsPerTable)
+	fmt.Fprintf(w, "  walks:  %d roots with %d objects, %d values in %d PDUs with max_repetitions %d\n", cost.WalkRoots, cost.WalkObjects, cost.WalkVarbinds, cost.WalkPDUs, cost.MaxRepetitions)
+	if cost.UnknownWalks != 0 {
+		fmt.Fprintf(w, "          %d roots aren't in the MIBs, so aren't counted\n", cost.UnknownWalks)
+	}
+	fmt.Fprintf(w, "  gets:   %d oids in %d PDUs\n", cost.GetOids, cost.GetPDUs)
+	fmt.Fprintf(w, "  responses: about %d bytes\n", cost.ResponseBytes)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestEstimateCost(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"ifTable"}, Get: []string{"ifNumber"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := result.Module
+	// A walk of an oid that isn't in the MIBs.
+	module.Walk = append(module.Walk, "1.3.6.1.4.1.9")
+
+	cost := estimateCost(module, nameToNode, 10)
+	expected := scrapeCost{
+		WalkRoots:      2,
+		WalkObjects:    5,
+		UnknownWalks:   1,
+		WalkVarbinds:   50,
+		WalkPDUs:       3,
+		GetOids:        1,
+		GetPDUs:        1,
+		ResponseBytes:  cost.ResponseBytes,
+		MaxRepetitions: 25,
+		RowsPerTable:   10,
+	}
+	if cost != expected {
+		t.Errorf("got cost %+v, want %+v", cost, expected)
+	}
+
+	// More rows, SNMP v1 and filters getting rows cost more.
+	module.WalkParams.Version = 1
+	module.StaticFilters = []config.StaticFilter{{Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Indices: []string{"1", "2"}}}
+	module.Filters = []config.DynamicFilter{{Oid: "1.3.6.1.2.1.2.2.1.3", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"6"}}}
+	v1 := estimateCost(module, nameToNode, 100)
+	if v1.WalkVarbinds != 500 || v1.WalkPDUs != 501 || v1.GetOids != 103 || v1.GetPDUs != 2 || v1.ResponseBytes <= cost.ResponseBytes {
+		t.Errorf("Unexpected cost %+v", v1)
+	}
+
+	buf := &bytes.Buffer{}
+	writeCostReport(buf, "if_mib", cost)
+	for _, line := range []string{
+		"Module if_mib scrape cost, assuming 10 rows per table:\n",
+		"  walks:  2 roots with 5 objects, 50 values in 3 PDUs with max_repetitions 25\n",
+		"          1 roots aren't in the MIBs, so aren't counted\n",
+		"  gets:   1 oids in 1 PDUs\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Report is missing %q:\n%s", line, buf)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// Compare two snmp_exporter configs, returning a line describing each
+// difference. Metrics are matched up by OID, so a changed name is reported
+// as a rename.
+func diffConfigs(old, new config.Config) []string {
+	differences := []string{}
+	for _, name := range sortedModuleNames(old, new) {
+		oldModule, inOld := old[name]
+		newModule, inNew := new[name]
+		switch {
+		case !inOld:
+			differences = append(differences, fmt.Sprintf("+ module %s", name))
+		case !inNew:
+			differences = append(differences, fmt.Sprintf("- module %s", name))
+		default:
+			for _, d := range diffModules(oldModule, newModule) {
+				differences = append(differences, fmt.Sprintf("module %s: %s", name, d))
+			}
+		}
+	}
+	return differences
+}
+
+func diffModules(old, new *config.Module) []string {
+	differences := []string{}
+
+	oldWalk := map[string]struct{}{}
+	for _, oid := range old.Walk {
+		oldWalk[oid] = struct{}{}
+	}
+	newWalk := map[string]struct{}{}
+	for _, oid := range new.Walk {
+		newWalk[oid] = struct{}{}
+		if _, ok := oldWalk[oid]; !ok {
+			differences = append(differences, fmt.Sprintf("+ walk %s", oid))
+		}
+	}
+	for _, oid := range old.Walk {
+		if _, ok := newWalk[oid]; !ok {
+			differences = append(differences, fmt.Sprintf("- walk %s", oid))
+		}
+	}
+
+	oldMetrics := map[string]*config.Metric{}
+	for _, m := range old.Metrics {
+		oldMetrics[m.Oid] = m
+	}
+	newMetrics := map[string]*config.Metric{}
+	for _, m := range new.Metrics {
+		newMetrics[m.Oid] = m
+	}
+	oids := []string{}
+	for oid := range oldMetrics {
+		oids = append(oids, oid)
+	}
+	for oid := range newMetrics {
+		if _, ok := oldMetrics[oid]; !ok {
+			oids = append(oids, oid)
+		}
+	}
+	sort.Strings(oids)
+
+	for _, oid := range oids {
+		o, inOld := oldMetrics[oid]
+		n, inNew := newMetrics[oid]
+		switch {
+		case !inOld:
+			differences = append(differences, fmt.Sprintf("+ metric %s (%s)", n.Name, oid))
+		case !inNew:
+			differences = append(differences, fmt.Sprintf("- metric %s (%s)", o.Name, oid))
+		default:
+			if o.Name != n.Name {
+				differences = append(differences, fmt.Sprintf("~ metric %s (%s) renamed to %s", o.Name, oid, n.Name))
+			}
+			if o.Type != n.Type {
+				differences = append(differences, fmt.Sprintf("~ metric %s (%s) type changed from %s to %s", n.Name, oid, o.Type, n.Type))
+			}
+			if !reflect.DeepEqual(describeIndexes(o.Indexes), describeIndexes(n.Indexes)) {
+				differences = append(differences, fmt.Sprintf("~ metric %s (%s) indexes changed from [%s] to [%s]",
+					n.Name, oid, strings.Join(describeIndexes(o.Indexes), ", "), strings.Join(describeIndexes(n.Indexes), ", ")))
+			}
+			if !reflect.DeepEqual(describeLookups(o.Lookups), describeLookups(n.Lookups)) {
+				differences = append(differences, fmt.Sprintf("~ metric %s (%s) lookups changed from [%s] to [%s]",
+					n.Name, oid, strings.Join(describeLookups(o.Lookups), ", "), strings.Join(describeLookups(n.Lookups), ", ")))
+			}
+		}
+	}
+	return differences
+}
+
+func sortedModuleNames(configs ...config.Config) []string {
+	seen := map[string]struct{}{}
+	names := []string{}
+	for _, c := range configs {
+		for name := range c {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func describeIndexes(indexes []*config.Index) []string {
+	result := []string{}
+	for _, i := range indexes {
+		d := fmt.Sprintf("%s:%s", i.Labelname, i.Type)
+		if i.FixedSize != 0 {
+			d = fmt.Sprintf("%s(%d)", d, i.FixedSize)
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+func describeLookups(lookups []*config.Lookup) []string {
+	result := []string{}
+	for _, l := range lookups {
+		result = append(result, fmt.Sprintf("%s->%s:%s@%s", strings.Join(l.Labels, "+"), l.Labelname, l.Type, l.Oid))
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	old := config.Config{
+		"removed": &config.Module{},
+		"same": &config.Module{
+			Walk:    []string{"1.1"},
+			Metrics: []*config.Metric{{Name: "a", Oid: "1.1.1", Type: "gauge"}},
+		},
+		"changed": &config.Module{
+			Walk: []string{"1.1", "1.2"},
+			Metrics: []*config.Metric{
+				{Name: "removedMetric", Oid: "1.1.1", Type: "gauge"},
+				{Name: "oldName", Oid: "1.1.2", Type: "gauge"},
+				{Name: "retyped", Oid: "1.1.3", Type: "gauge"},
+				{Name: "lookedUp", Oid: "1.1.4", Type: "gauge",
+					Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+			},
+		},
+	}
+	new := config.Config{
+		"added": &config.Module{},
+		"same": &config.Module{
+			Walk:    []string{"1.1"},
+			Metrics: []*config.Metric{{Name: "a", Oid: "1.1.1", Type: "gauge"}},
+		},
+		"changed": &config.Module{
+			Walk: []string{"1.1", "1.3"},
+			Metrics: []*config.Metric{
+				{Name: "newName", Oid: "1.1.2", Type: "gauge"},
+				{Name: "retyped", Oid: "1.1.3", Type: "counter"},
+				{Name: "lookedUp", Oid: "1.1.4", Type: "gauge",
+					Indexes: []*config.Index{{Labelname: "ifDescr", Type: "gauge"}},
+					Lookups: []*config.Lookup{{Labels: []string{"ifDescr"}, Labelname: "ifDescr", Type: "DisplayString", Oid: "1.3.2"}}},
+				{Name: "addedMetric", Oid: "1.1.5", Type: "gauge"},
+			},
+		},
+	}
+	expected := []string{
+		"+ module added",
+		"module changed: + walk 1.3",
+		"module changed: - walk 1.2",
+		"module changed: - metric removedMetric (1.1.1)",
+		"module changed: ~ metric oldName (1.1.2) renamed to newName",
+		"module changed: ~ metric retyped (1.1.3) type changed from gauge to counter",
+		"module changed: ~ metric lookedUp (1.1.4) indexes changed from [ifIndex:gauge] to [ifDescr:gauge]",
+		"module changed: ~ metric lookedUp (1.1.4) lookups changed from [] to [ifDescr->ifDescr:DisplayString@1.3.2]",
+		"module changed: + metric addedMetric (1.1.5)",
+		"- module removed",
+	}
+	got := diffConfigs(old, new)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("diffConfigs: got\n%q\nwant\n%q", got, expected)
+	}
+
+	if d := diffConfigs(new, new); len(d) != 0 {
+		t.Errorf("diffConfigs: identical configs differ: %q", d)
+	}
+}
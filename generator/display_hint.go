@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// One part of an RFC 2579 DISPLAY-HINT for an OCTET STRING, such as "1x:".
+type DisplayHintSpec struct {
+	// Whether the first octet is a count of how many times to apply the spec.
+	Repeat bool
+	// How many octets to consume each time.
+	Length int
+	// How to render the octets: one of x, d, o, a or t.
+	Format byte
+	// Separator after each application, 0 if there is none.
+	Separator byte
+	// Terminator after all repetitions, 0 if there is none.
+	Terminator byte
+}
+
+// Kinds of DISPLAY-HINT, by how they render octets.
+const (
+	hintUnknown = "unknown"
+	hintString  = "string"
+	hintHex     = "hex"
+	hintDecimal = "decimal"
+	hintDate    = "date"
+)
+
+// The DISPLAY-HINT of DateAndTime in SNMPv2-TC.
+const dateAndTimeHint = "2d-1d-1d,1d:1d:1d.1d,1a1d:1d"
+
+// Parse an RFC 2579 DISPLAY-HINT for an OCTET STRING. Integer hints such as
+// "d-2" are not octet string hints, and return an error.
+func ParseDisplayHint(hint string) ([]DisplayHintSpec, error) {
+	specs := []DisplayHintSpec{}
+	isSeparator := func(i int) bool {
+		return i < len(hint) && hint[i] != '*' && (hint[i] < '0' || hint[i] > '9')
+	}
+	for i := 0; i < len(hint); {
+		spec := DisplayHintSpec{}
+		if hint[i] == '*' {
+			spec.Repeat = true
+			i++
+		}
+		start := i
+		for i < len(hint) && hint[i] >= '0' && hint[i] <= '9' {
+			spec.Length = spec.Length*10 + int(hint[i]-'0')
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("missing octet length at position %d of display hint %q", i, hint)
+		}
+		if i == len(hint) || !strings.ContainsRune("xdoat", rune(hint[i])) {
+			return nil, fmt.Errorf("missing format at position %d of display hint %q", i, hint)
+		}
+		spec.Format = hint[i]
+		i++
+		if isSeparator(i) {
+			spec.Separator = hint[i]
+			i++
+			if spec.Repeat && isSeparator(i) {
+				spec.Terminator = hint[i]
+				i++
+			}
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty display hint")
+	}
+	return specs, nil
+}
+
+// Classify a DISPLAY-HINT by how it renders octets. Hints that can't be
+// parsed, or mix formats other than in DateAndTime, are hintUnknown.
+func classifyDisplayHint(hint string) string {
+	specs, err := ParseDisplayHint(hint)
+	if err != nil {
+		return hintUnknown
+	}
+	if hint == dateAndTimeHint {
+		return hintDate
+	}
+	formats := map[byte]bool{}
+	for _, s := range specs {
+		formats[s.Format] = true
+	}
+	switch {
+	case len(formats) == 1 && formats['x']:
+		return hintHex
+	case len(formats) == 1 && formats['d']:
+		return hintDecimal
+	case !formats['x'] && !formats['d'] && !formats['o']:
+		return hintString
+	}
+	return hintUnknown
+}
+
+// The type to use for a node based on its DISPLAY-HINT and the kind of hint
+// it is, or "" if the hint doesn't determine one.
+func displayHintType(n *Node, kind string) string {
+	switch kind {
+	case hintString:
+		return "DisplayString"
+	case hintHex:
+		if n.Hint == "1x:" {
+			return "PhysAddress48"
+		}
+	case hintDecimal:
+		// Example: IpV4Address in some vendor MIBs.
+		if n.Hint == "1d.1d.1d.1d" && n.Type == "OCTETSTR" {
+			return "IpAddr"
+		}
+	case hintDate:
+		if n.Type == "OCTETSTR" {
+			return "DateAndTime"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDisplayHint(t *testing.T) {
+	cases := []struct {
+		hint  string
+		specs []DisplayHintSpec
+		kind  string
+	}{
+		// SNMPv2-TC DisplayString.
+		{hint: "255a", specs: []DisplayHintSpec{{Length: 255, Format: 'a'}}, kind: hintString},
+		// SNMP-FRAMEWORK-MIB SnmpAdminString.
+		{hint: "255t", specs: []DisplayHintSpec{{Length: 255, Format: 't'}}, kind: hintString},
+		// SNMPv2-TC PhysAddress and MacAddress.
+		{hint: "1x:", specs: []DisplayHintSpec{{Length: 1, Format: 'x', Separator: ':'}}, kind: hintHex},
+		// BRIDGE-MIB BridgeId style.
+		{hint: "1x-", specs: []DisplayHintSpec{{Length: 1, Format: 'x', Separator: '-'}}, kind: hintHex},
+		// INET-ADDRESS-MIB InetAddressIPv6.
+		{hint: "2x:2x:2x:2x:2x:2x:2x:2x", specs: []DisplayHintSpec{
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x'},
+		}, kind: hintHex},
+		// Fixed width hex.
+		{hint: "4x", specs: []DisplayHintSpec{{Length: 4, Format: 'x'}}, kind: hintHex},
+		// INET-ADDRESS-MIB InetAddressIPv4.
+		{hint: "1d.1d.1d.1d", specs: []DisplayHintSpec{
+			{Length: 1, Format: 'd', Separator: '.'}, {Length: 1, Format: 'd', Separator: '.'},
+			{Length: 1, Format: 'd', Separator: '.'}, {Length: 1, Format: 'd'},
+		}, kind: hintDecimal},
+		{hint: "1d.1d", specs: []DisplayHintSpec{{Length: 1, Format: 'd', Separator: '.'}, {Length: 1, Format: 'd'}}, kind: hintDecimal},
+		// RFC 2579 example with a repeat count and terminator.
+		{hint: "*1x:/1x:", specs: []DisplayHintSpec{{Repeat: true, Length: 1, Format: 'x', Separator: ':', Terminator: '/'}, {Length: 1, Format: 'x', Separator: ':'}}, kind: hintHex},
+		// INET-ADDRESS-MIB InetAddressIPv4z, mixing formats.
+		{hint: "1d.1d.1d.1d%4d", specs: []DisplayHintSpec{
+			{Length: 1, Format: 'd', Separator: '.'}, {Length: 1, Format: 'd', Separator: '.'},
+			{Length: 1, Format: 'd', Separator: '.'}, {Length: 1, Format: 'd', Separator: '%'},
+			{Length: 4, Format: 'd'},
+		}, kind: hintDecimal},
+		// INET-ADDRESS-MIB InetAddressIPv6z, mixing formats.
+		{hint: "2x:2x:2x:2x:2x:2x:2x:2x%4d", specs: []DisplayHintSpec{
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: ':'},
+			{Length: 2, Format: 'x', Separator: ':'}, {Length: 2, Format: 'x', Separator: '%'},
+			{Length: 4, Format: 'd'},
+		}, kind: hintUnknown},
+		// SNMPv2-TC DateAndTime.
+		{hint: "2d-1d-1d,1d:1d:1d.1d,1a1d:1d", specs: []DisplayHintSpec{
+			{Length: 2, Format: 'd', Separator: '-'}, {Length: 1, Format: 'd', Separator: '-'},
+			{Length: 1, Format: 'd', Separator: ','}, {Length: 1, Format: 'd', Separator: ':'},
+			{Length: 1, Format: 'd', Separator: ':'}, {Length: 1, Format: 'd', Separator: '.'},
+			{Length: 1, Format: 'd', Separator: ','}, {Length: 1, Format: 'a'},
+			{Length: 1, Format: 'd', Separator: ':'}, {Length: 1, Format: 'd'},
+		}, kind: hintDate},
+		// Octal.
+		{hint: "1o", specs: []DisplayHintSpec{{Length: 1, Format: 'o'}}, kind: hintUnknown},
+		// Integer hints aren't octet string hints.
+		{hint: "d-2", kind: hintUnknown},
+		{hint: "d", kind: hintUnknown},
+		{hint: "x", kind: hintUnknown},
+		// Malformed.
+		{hint: "", kind: hintUnknown},
+		{hint: "255", kind: hintUnknown},
+		{hint: "1q", kind: hintUnknown},
+		{hint: "*x", kind: hintUnknown},
+	}
+	for _, c := range cases {
+		specs, err := ParseDisplayHint(c.hint)
+		if c.specs == nil {
+			if err == nil {
+				t.Errorf("%q: expected error, got %+v", c.hint, specs)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error %s", c.hint, err)
+		} else if !reflect.DeepEqual(specs, c.specs) {
+			t.Errorf("%q: got %+v, want %+v", c.hint, specs, c.specs)
+		}
+		if got := classifyDisplayHint(c.hint); got != c.kind {
+			t.Errorf("%q: got kind %s, want %s", c.hint, got, c.kind)
+		}
+	}
+}
+
+func TestDisplayHintTypes(t *testing.T) {
+	cases := []struct {
+		node *Node
+		typ  string
+	}{
+		{node: &Node{Type: "OCTETSTR", Hint: "255a"}, typ: "DisplayString"},
+		{node: &Node{Type: "OCTETSTR", Hint: "255t"}, typ: "DisplayString"},
+		{node: &Node{Type: "OCTETSTR", Hint: "1x:"}, typ: "PhysAddress48"},
+		{node: &Node{Type: "OCTETSTR", Hint: "1x-"}, typ: "OCTETSTR"},
+		{node: &Node{Type: "OCTETSTR", Hint: "1d.1d.1d.1d"}, typ: "IpAddr"},
+		{node: &Node{Type: "OCTETSTR", Hint: "2d-1d-1d,1d:1d:1d.1d,1a1d:1d"}, typ: "DateAndTime"},
+		{node: &Node{Type: "INTEGER", Hint: "d-2"}, typ: "INTEGER"},
+		// Unknown hints fall back to looking for string formats.
+		{node: &Node{Type: "OCTETSTR", Hint: "1a?x"}, typ: "DisplayString"},
+		{node: &Node{Type: "OCTETSTR", Hint: "1o1a"}, typ: "DisplayString"},
+	}
+	for _, c := range cases {
+		c.node.Oid = "1"
+		prepareTree(c.node, generatorOptions{})
+		if c.node.Type != c.typ {
+			t.Errorf("%q: got type %s, want %s", c.node.Hint, c.node.Type, c.typ)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The JSON representation of a node in dump output.
+type dumpNode struct {
+	Oid               string   `json:"oid"`
+	Label             string   `json:"label"`
+	Module            string   `json:"module"`
+	Type              string   `json:"type"`
+	FixedSize         int      `json:"fixed_size"`
+	TextualConvention string   `json:"textual_convention"`
+	Hint              string   `json:"hint"`
+	Indexes           []string `json:"indexes"`
+	Description       string   `json:"description"`
+}
+
+// Restricts which nodes are dumped. The zero value matches every node.
+type dumpFilter struct {
+	LeavesOnly bool
+	Access     []string // Without the ACCESS_ prefix, e.g. readonly.
+	Types      []string
+}
+
+// Whether the filter restricts the output at all.
+func (f *dumpFilter) active() bool {
+	return f != nil && (f.LeavesOnly || len(f.Access) != 0 || len(f.Types) != 0)
+}
+
+// Whether the node passes all of the filters. Access levels and types are
+// compared case-insensitively.
+func (f *dumpFilter) match(n *Node) bool {
+	if !f.active() {
+		return true
+	}
+	if f.LeavesOnly && len(n.Children) != 0 {
+		return false
+	}
+	if len(f.Access) != 0 && !containsFold(f.Access, strings.TrimPrefix(n.Access, "ACCESS_")) {
+		return false
+	}
+	if len(f.Types) != 0 && !containsFold(f.Types, n.Type) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split comma separated and repeated flag values into a single list.
+func splitFlagValues(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// Write out every node in the given subtrees that matches filter in the
+// given format, which is one of text, json (a single JSON array), ndjson (one
+// JSON object per line) or dot (a Graphviz digraph). A nil filter matches
+// every node.
+func dumpNodes(w io.Writer, roots []*Node, format string, nameToNode map[string]*Node, filter *dumpFilter) error {
+	if format == "dot" {
+		if filter.active() {
+			return fmt.Errorf("Filters cannot be used with the dot format")
+		}
+		return dumpDot(w, roots, nameToNode)
+	}
+	var err error
+	first := true
+	if format == "json" {
+		_, err = io.WriteString(w, "[\n")
+	}
+	walkNodes(roots, func(n *Node) {
+		if err != nil || !filter.match(n) {
+			return
+		}
+		switch format {
+		case "text":
+			t := n.Type
+			if n.FixedSize != 0 {
+				t = fmt.Sprintf("%s(%d)", n.Type, n.FixedSize)
+			}
+			// The label is qualified by its MIB module, when known.
+			label := n.Label
+			if n.Module != "" {
+				label = n.Module + "::" + n.Label
+			}
+			_, err = fmt.Fprintf(w, "%s %s %s %q %q %s %s\n", n.Oid, label, t, n.TextualConvention, n.Hint, n.Indexes, n.Description)
+		case "json", "ndjson":
+			var b []byte
+			b, err = json.Marshal(dumpNode{
+				Oid:               n.Oid,
+				Label:             n.Label,
+				Module:            n.Module,
+				Type:              n.Type,
+				FixedSize:         n.FixedSize,
+				TextualConvention: n.TextualConvention,
+				Hint:              n.Hint,
+				Indexes:           n.Indexes,
+				Description:       n.Description,
+			})
+			if err != nil {
+				return
+			}
+			if format == "json" && !first {
+				b = append([]byte(",\n"), b...)
+			} else if format == "ndjson" {
+				b = append(b, '\n')
+			}
+			_, err = w.Write(b)
+		default:
+			err = fmt.Errorf("Unknown dump format %q", format)
+		}
+		first = false
+	})
+	if err == nil && format == "json" {
+		_, err = io.WriteString(w, "\n]\n")
+	}
+	return err
+}
+
+// Write out the given subtrees as a Graphviz digraph. Nodes that would become
+// metrics are colored green, and AUGMENTS relationships are drawn as dashed
+// edges to the augmented entry.
+func dumpDot(w io.Writer, roots []*Node, nameToNode map[string]*Node) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("digraph mib {\n")
+	printf("  node [shape=box, style=filled];\n")
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		color := "lightgrey"
+		if _, ok := metricType(n.Type); ok && metricAccess(n.Access) {
+			color = "palegreen"
+		}
+		printf("  %s [label=%s, fillcolor=%s];\n", dotQuote(n.Oid), dotQuote(n.Label+"\n"+n.Oid+"\n"+n.Type), color)
+		if n.Augments != "" {
+			if augmented, ok := nameToNode[n.Augments]; ok {
+				printf("  %s -> %s [style=dashed, color=blue, label=\"augments\"];\n", dotQuote(n.Oid), dotQuote(augmented.Oid))
+			}
+		}
+		for _, c := range n.Children {
+			printf("  %s -> %s;\n", dotQuote(n.Oid), dotQuote(c.Oid))
+			walk(c)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+	printf("}\n")
+	return err
+}
+
+// Quote a string as a DOT ID.
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
+
+// Walk each of the given subtrees in turn.
+func walkNodes(roots []*Node, f func(n *Node)) {
+	for _, root := range roots {
+		walkNode(root, f)
+	}
+}
+
+// Look up nodes by name or OID. Unknown names produce an error suggesting
+// similarly named nodes.
+func lookupNodes(names []string, nameToNode map[string]*Node) ([]*Node, error) {
+	nodes := []*Node{}
+	for _, name := range names {
+		n, ok := nameToNode[name]
+		if !ok {
+			matches := closeMatches(name, nameToNode, 5)
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("Cannot find oid '%s'", name)
+			}
+			return nil, fmt.Errorf("Cannot find oid '%s', did you mean: %s", name, strings.Join(matches, ", "))
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// Find up to max node names similar to name, closest first.
+func closeMatches(name string, nameToNode map[string]*Node, max int) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+	lower := strings.ToLower(name)
+	threshold := len(name)/3 + 1
+	matches := []match{}
+	for candidate := range nameToNode {
+		lowerCandidate := strings.ToLower(candidate)
+		d := levenshtein(lower, lowerCandidate)
+		if d <= threshold || (len(lower) > 2 && strings.Contains(lowerCandidate, lower)) {
+			matches = append(matches, match{name: candidate, distance: d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+	result := []string{}
+	for i := 0; i < len(matches) && i < max; i++ {
+		result = append(result, matches[i].name)
+	}
+	return result
+}
+
+// Edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Which node fields find searches.
+const (
+	findAll = iota
+	findLabel
+	findDescription
+)
+
+// A node matched by find, along with the table it is a column of, if any.
+type findResult struct {
+	node  *Node
+	table *Node
+}
+
+// Search node labels, descriptions and textual conventions for a regular
+// expression, returning at most limit results. A limit of 0 means no limit.
+func findNodes(root *Node, re *regexp.Regexp, fields int, limit int) []findResult {
+	results := []findResult{}
+	var walk func(n *Node, parents []*Node) bool
+	walk = func(n *Node, parents []*Node) bool {
+		if limit > 0 && len(results) >= limit {
+			return false
+		}
+		var match bool
+		switch fields {
+		case findLabel:
+			match = re.MatchString(n.Label)
+		case findDescription:
+			match = re.MatchString(n.Description)
+		default:
+			match = re.MatchString(n.Label) || re.MatchString(n.Description) || re.MatchString(n.TextualConvention)
+		}
+		if match {
+			results = append(results, findResult{node: n, table: parentTable(parents)})
+		}
+		parents = append(parents, n)
+		for _, c := range n.Children {
+			if !walk(c, parents) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(root, []*Node{})
+	return results
+}
+
+// Given the ancestors of a node, return the table it is a column of, if any.
+func parentTable(parents []*Node) *Node {
+	if len(parents) < 2 {
+		return nil
+	}
+	entry := parents[len(parents)-1]
+	if len(entry.Indexes) == 0 && entry.Augments == "" {
+		return nil
+	}
+	return parents[len(parents)-2]
+}
+
+// Everything the generator knows about a node, as output by describe.
+type nodeDescription struct {
+	Oid               string            `json:"oid"`
+	Label             string            `json:"label"`
+	Module            string            `json:"module"`
+	Type              string            `json:"type"`
+	Access            string            `json:"access"`
+	Hint              string            `json:"hint"`
+	TextualConvention string            `json:"textual_convention"`
+	FixedSize         int               `json:"fixed_size"`
+	Units             string            `json:"units"`
+	Indexes           []string          `json:"indexes"`
+	Augments          string            `json:"augments"`
+	EnumValues        map[string]string `json:"enum_values"`
+	MetricType        string            `json:"metric_type"`
+	Metric            bool              `json:"metric"`
+	Reason            string            `json:"reason"`
+	Description       string            `json:"description"`
+}
+
+// Describe a prepared node, including whether it would become a metric and
+// why not. The description is passed separately, as prepareTree removes its
+// whitespace.
+func describeNode(n *Node, description string, nameToNode map[string]*Node) nodeDescription {
+	d := nodeDescription{
+		Oid:               n.Oid,
+		Label:             n.Label,
+		Module:            n.Module,
+		Type:              n.Type,
+		Access:            n.Access,
+		Hint:              n.Hint,
+		TextualConvention: n.TextualConvention,
+		FixedSize:         n.FixedSize,
+		Units:             n.Units,
+		Indexes:           n.Indexes,
+		Augments:          n.Augments,
+		EnumValues:        map[string]string{},
+		Description:       description,
+	}
+	for k, v := range n.EnumValues {
+		d.EnumValues[strconv.Itoa(k)] = v
+	}
+
+	t, ok := metricType(n.Type)
+	if !ok {
+		d.Reason = fmt.Sprintf("unsupported type %s", n.Type)
+		return d
+	}
+	d.MetricType = t
+	if !metricAccess(n.Access) {
+		d.Reason = fmt.Sprintf("inaccessible access level %s", n.Access)
+		return d
+	}
+	if _, err := metricIndexes(n, nameToNode); err != nil {
+		d.Reason = err.Error()
+		return d
+	}
+	d.Metric = true
+	return d
+}
+
+// Write out a node description in the given format, text or json.
+func writeDescription(w io.Writer, d nodeDescription, format string) error {
+	switch format {
+	case "text":
+		enums := []string{}
+		for _, k := range sortedEnumKeys(d.EnumValues) {
+			enums = append(enums, fmt.Sprintf("%s=%s", k, d.EnumValues[k]))
+		}
+		lines := [][2]string{
+			{"oid", d.Oid},
+			{"label", d.Label},
+			{"module", d.Module},
+			{"type", d.Type},
+			{"access", d.Access},
+			{"hint", d.Hint},
+			{"textual_convention", d.TextualConvention},
+			{"fixed_size", strconv.Itoa(d.FixedSize)},
+			{"units", d.Units},
+			{"indexes", strings.Join(d.Indexes, " ")},
+			{"augments", d.Augments},
+			{"enum_values", strings.Join(enums, " ")},
+			{"metric_type", d.MetricType},
+			{"metric", strconv.FormatBool(d.Metric)},
+			{"reason", d.Reason},
+			{"description", strings.Join(strings.Fields(d.Description), " ")},
+		}
+		for _, l := range lines {
+			if _, err := fmt.Fprintf(w, "%s: %s\n", l[0], l[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	default:
+		return fmt.Errorf("Unknown describe format %q", format)
+	}
+}
+
+// Sort enum values numerically.
+func sortedEnumKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+	return keys
+}
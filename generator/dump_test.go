@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDumpNodesJSON(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"}},
+			{Oid: "1.2", Label: "ifPhysAddress", Type: "PhysAddress48", FixedSize: 6,
+				TextualConvention: "PhysAddress", Hint: "1x:", Description: `A "quoted" description`},
+		}}
+	expected := []dumpNode{
+		{Oid: "1", Label: "root"},
+		{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"}},
+		{Oid: "1.2", Label: "ifPhysAddress", Type: "PhysAddress48", FixedSize: 6,
+			TextualConvention: "PhysAddress", Hint: "1x:", Description: `A "quoted" description`},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := dumpNodes(buf, []*Node{node}, "json", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := []dumpNode{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Error parsing JSON dump: %s\n%s", err, buf)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("JSON dump: got %+v, want %+v", got, expected)
+	}
+
+	buf.Reset()
+	if err := dumpNodes(buf, []*Node{node}, "ndjson", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	got = []dumpNode{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		n := dumpNode{}
+		if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			t.Fatalf("Error parsing NDJSON line %q: %s", scanner.Text(), err)
+		}
+		got = append(got, n)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("NDJSON dump: got %+v, want %+v", got, expected)
+	}
+}
+
+func TestLookupNodes(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifTable",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifEntry"}}},
+			{Oid: "1.2", Label: "ifXTable", Module: "IF-MIB"},
+			{Oid: "1.3", Label: "sysDescr"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	nodes, err := lookupNodes([]string{"ifTable", "1.2"}, nameToNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := dumpNodes(buf, nodes, "text", nameToNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1.1 ifTable  \"\" \"\" [] \n1.1.1 ifEntry  \"\" \"\" [] \n1.2 IF-MIB::ifXTable  \"\" \"\" [] \n"
+	if buf.String() != expected {
+		t.Errorf("Subtree dump: got %q, want %q", buf.String(), expected)
+	}
+
+	_, err = lookupNodes([]string{"iftable"}, nameToNode)
+	if err == nil {
+		t.Fatal("Expected error for unknown name")
+	}
+	if !strings.Contains(err.Error(), "did you mean: ifTable") {
+		t.Errorf("Error %q does not suggest ifTable", err)
+	}
+}
+
+func TestFindNodes(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifTable",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Label: "ifIndex", Description: "A unique value"},
+							{Oid: "1.1.1.2", Label: "ifInOctets", Description: "The total number of octets received"},
+						}}}},
+			{Oid: "1.2", Label: "sysDescr", TextualConvention: "DisplayString", Description: "A textual description"},
+		}}
+
+	cases := []struct {
+		re     string
+		fields int
+		limit  int
+		oids   []string
+		tables []string
+	}{
+		{re: "(?i)octets", fields: findAll, oids: []string{"1.1.1.2"}, tables: []string{"ifTable"}},
+		{re: "(?i)DESCR", fields: findAll, oids: []string{"1.2"}, tables: []string{""}},
+		{re: "DESCR", fields: findAll, oids: []string{}, tables: []string{}},
+		{re: "displaystring", fields: findAll, oids: []string{}, tables: []string{}},
+		{re: "(?i)displaystring", fields: findLabel, oids: []string{}, tables: []string{}},
+		{re: "^if", fields: findLabel, oids: []string{"1.1", "1.1.1", "1.1.1.1", "1.1.1.2"}, tables: []string{"", "", "ifTable", "ifTable"}},
+		{re: "^if", fields: findLabel, limit: 2, oids: []string{"1.1", "1.1.1"}, tables: []string{"", ""}},
+		{re: "textual", fields: findDescription, oids: []string{"1.2"}, tables: []string{""}},
+		{re: "sys", fields: findDescription, oids: []string{}, tables: []string{}},
+	}
+	for i, c := range cases {
+		oids := []string{}
+		tables := []string{}
+		for _, r := range findNodes(node, regexp.MustCompile(c.re), c.fields, c.limit) {
+			oids = append(oids, r.node.Oid)
+			table := ""
+			if r.table != nil {
+				table = r.table.Label
+			}
+			tables = append(tables, table)
+		}
+		if !reflect.DeepEqual(oids, c.oids) || !reflect.DeepEqual(tables, c.tables) {
+			t.Errorf("findNodes: case %d got %v %v, want %v %v", i, oids, tables, c.oids, c.tables)
+		}
+	}
+}
+
+func TestDescribeNode(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifIndex", Access: "ACCESS_READONLY", Type: "INTEGER"},
+					{Oid: "1.1.2", Label: "ifOperStatus", Module: "IF-MIB", Access: "ACCESS_READONLY", Type: "INTEGER",
+						EnumValues: map[int]string{1: "up", 2: "down", 10: "other"}},
+					{Oid: "1.1.3", Label: "ifSpecific", Access: "ACCESS_READONLY", Type: "OBJID"},
+					{Oid: "1.1.4", Label: "ifNotify", Access: "ACCESS_NOTIFY", Type: "INTEGER"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	d := describeNode(nameToNode["ifOperStatus"], "The current\n   operational state. More detail.", nameToNode)
+	buf := &bytes.Buffer{}
+	if err := writeDescription(buf, d, "text"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `oid: 1.1.2
+label: ifOperStatus
+module: IF-MIB
+type: INTEGER
+access: ACCESS_READONLY
+hint: 
+textual_convention: 
+fixed_size: 0
+units: 
+indexes: ifIndex
+augments: 
+enum_values: 1=up 2=down 10=other
+metric_type: gauge
+metric: true
+reason: 
+description: The current operational state. More detail.
+`
+	if buf.String() != expected {
+		t.Errorf("Describe text: got %q, want %q", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := writeDescription(buf, d, "json"); err != nil {
+		t.Fatal(err)
+	}
+	got := nodeDescription{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Error parsing JSON description: %s", err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("Describe JSON: got %+v, want %+v", got, d)
+	}
+
+	reasons := map[string]string{
+		"ifSpecific": "unsupported type OBJID",
+		"ifNotify":   "inaccessible access level ACCESS_NOTIFY",
+	}
+	for name, reason := range reasons {
+		d := describeNode(nameToNode[name], "", nameToNode)
+		if d.Metric || d.Reason != reason {
+			t.Errorf("Describe %s: got metric %v reason %q, want reason %q", name, d.Metric, d.Reason, reason)
+		}
+	}
+}
+
+func TestDumpNodesDot(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
+				}},
+			{Oid: "1.2", Label: "augmentingEntry", Augments: "tableEntry",
+				Children: []*Node{
+					{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: `odd"label\`, Type: "OBJID"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	buf := &bytes.Buffer{}
+	if err := dumpNodes(buf, []*Node{node}, "dot", nameToNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "digraph mib {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Fatalf("Not a digraph: %s", out)
+	}
+	id := `"(?:[^"\\]|\\.)*"`
+	nodeRE := regexp.MustCompile(`^  (` + id + `) \[label=` + id + `, fillcolor=(\w+)\];$`)
+	edgeRE := regexp.MustCompile(`^  (` + id + `) -> (` + id + `)( \[style=dashed, color=blue, label="augments"\])?;$`)
+	colors := map[string]string{}
+	edges := []string{}
+	lines := strings.Split(strings.TrimSuffix(out, "}\n"), "\n")
+	for _, line := range lines[2 : len(lines)-1] {
+		if m := nodeRE.FindStringSubmatch(line); m != nil {
+			colors[m[1]] = m[2]
+		} else if m := edgeRE.FindStringSubmatch(line); m != nil {
+			edges = append(edges, m[1]+"->"+m[2]+m[3])
+		} else {
+			t.Errorf("Unparseable DOT line %q", line)
+		}
+	}
+	expectedColors := map[string]string{
+		`"1"`: "lightgrey", `"1.1"`: "lightgrey", `"1.1.1"`: "palegreen",
+		`"1.2"`: "lightgrey", `"1.2.1"`: "lightgrey",
+	}
+	if !reflect.DeepEqual(colors, expectedColors) {
+		t.Errorf("DOT nodes: got %v, want %v", colors, expectedColors)
+	}
+	expectedEdges := []string{
+		`"1"->"1.1"`, `"1.1"->"1.1.1"`, `"1"->"1.2"`,
+		`"1.2"->"1.1" [style=dashed, color=blue, label="augments"]`, `"1.2"->"1.2.1"`,
+	}
+	if !reflect.DeepEqual(edges, expectedEdges) {
+		t.Errorf("DOT edges: got %v, want %v", edges, expectedEdges)
+	}
+	if !strings.Contains(out, `"odd\"label\\\n1.2.1\nOBJID"`) {
+		t.Errorf("DOT label not escaped: %s", out)
+	}
+}
+
+func TestDumpFilter(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+					{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "ifInOctets", Type: "COUNTER"},
+					{Oid: "1.1.3", Access: "ACCESS_READWRITE", Label: "ifAdminStatus", Type: "INTEGER"},
+					{Oid: "1.1.4", Access: "ACCESS_READONLY", Label: "ifPhysAddress", Type: "OCTETSTR", Hint: "1x:"},
+				}},
+			{Oid: "1.2", Label: "ifGroup", Type: "OBJGROUP"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		filter *dumpFilter
+		labels []string
+	}{
+		{
+			filter: nil,
+			labels: []string{"root", "ifEntry", "ifIndex", "ifInOctets", "ifAdminStatus", "ifPhysAddress", "ifGroup"},
+		},
+		{
+			filter: &dumpFilter{LeavesOnly: true},
+			labels: []string{"ifIndex", "ifInOctets", "ifAdminStatus", "ifPhysAddress", "ifGroup"},
+		},
+		{
+			filter: &dumpFilter{Access: []string{"readwrite"}},
+			labels: []string{"ifAdminStatus"},
+		},
+		{
+			filter: &dumpFilter{Access: []string{"READONLY"}, Types: []string{"INTEGER", "counter"}},
+			labels: []string{"ifIndex", "ifInOctets"},
+		},
+		{
+			// Types are matched after preparation.
+			filter: &dumpFilter{Types: []string{"PhysAddress48"}},
+			labels: []string{"ifPhysAddress"},
+		},
+		{
+			filter: &dumpFilter{LeavesOnly: true, Types: []string{"OBJGROUP"}},
+			labels: []string{"ifGroup"},
+		},
+	}
+	for i, c := range cases {
+		buf := &bytes.Buffer{}
+		if err := dumpNodes(buf, []*Node{node}, "ndjson", nameToNode, c.filter); err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{}
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			n := dumpNode{}
+			if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+				t.Fatalf("Error parsing NDJSON line %q: %s", scanner.Text(), err)
+			}
+			labels = append(labels, n.Label)
+		}
+		if !reflect.DeepEqual(labels, c.labels) {
+			t.Errorf("%d: got %v, want %v", i, labels, c.labels)
+		}
+	}
+
+	if err := dumpNodes(&bytes.Buffer{}, []*Node{node}, "dot", nameToNode, &dumpFilter{LeavesOnly: true}); err == nil {
+		t.Error("Expected error using filters with dot format")
+	}
+}
+
+func TestSplitFlagValues(t *testing.T) {
+	got := splitFlagValues([]string{"readonly,readwrite", "create", " a , ,b"})
+	expected := []string{"readonly", "readwrite", "create", "a", "b"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got %v, want %v", got, expected)
+	}
+}
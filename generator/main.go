@@ -1,98 +1,1102 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
 
 	"github.com/prometheus/snmp_exporter/config"
 )
 
-// Generate a snmp_exporter config and write it out.
-func generateConfig(nodes *Node, nameToNode map[string]*Node) {
-	outputPath, err := filepath.Abs(*outputPath)
+// Read and parse a generator config, merging in the modules of the files it
+// includes. Relative paths are resolved against the current working
+// directory.
+func loadConfig(configPath string) (*Config, error) {
+	configPath, err := filepath.Abs(configPath)
 	if err != nil {
-		log.Fatal("Unable to determine absolute path for output")
+		return nil, fmt.Errorf("Unable to determine absolute path for config %s: %s", configPath, err)
 	}
+	cfg, err := readConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := includedFiles(configPath, cfg.Include)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing yml config %s: %s", configPath, err)
+	}
+	if cfg.Modules == nil {
+		cfg.Modules = map[string]*ModuleConfig{}
+	}
+	moduleFiles := map[string]string{}
+	for name := range cfg.Modules {
+		moduleFiles[name] = configPath
+	}
+	for _, file := range files {
+		fragment, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(fragment.Include) != 0 {
+			return nil, fmt.Errorf("Error parsing yml config %s: included files can't include other files", file)
+		}
+		if !reflect.DeepEqual(fragment.Defaults, Defaults{}) {
+			return nil, fmt.Errorf("Error parsing yml config %s: defaults can only be set in the main config", file)
+		}
+		for name, m := range fragment.Modules {
+			if other, ok := moduleFiles[name]; ok {
+				return nil, fmt.Errorf("Module %s is defined in both %s and %s", name, other, file)
+			}
+			moduleFiles[name] = file
+			cfg.Modules[name] = m
+		}
+	}
+	if err := cfg.resolveExtends(); err != nil {
+		return nil, fmt.Errorf("Error parsing yml config %s: %s", configPath, err)
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
 
-	content, err := ioutil.ReadFile("generator.yml")
+// Read and parse one generator config file, without its includes.
+func readConfigFile(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Error reading yml config: %s", err)
+		return nil, fmt.Errorf("Error reading yml config %s: %s", path, err)
 	}
+	cfg, err := unmarshalConfig(content, !*noStrict)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing yml config %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// The files matched by a config's include patterns, which are relative to
+// the config's directory, in order and without duplicates. A pattern that
+// matches nothing is an error, as it's most likely a typo.
+func includedFiles(configPath string, patterns []string) ([]string, error) {
+	files := []string{}
+	seen := map[string]bool{configPath: true}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configPath), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include %s: %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("Include %s matches no files", pattern)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Parse a generator config. In strict mode unknown fields are an error,
+// otherwise they are ignored. Includes need a config file to be relative to,
+// so aren't supported.
+func parseConfig(content []byte, strict bool) (*Config, error) {
+	cfg, err := unmarshalConfig(content, strict)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Include) != 0 {
+		return nil, fmt.Errorf("include is only supported when loading the config from a file")
+	}
+	if err := cfg.resolveExtends(); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+func unmarshalConfig(content []byte, strict bool) (*Config, error) {
 	cfg := &Config{}
-	err = yaml.Unmarshal(content, cfg)
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	if err := unmarshal(content, cfg); err != nil {
+		return nil, err
+	}
+	cfg.readDeclaredFields(content)
+	return cfg, nil
+}
+
+// Restrict a generator config to the named modules. An empty list of
+// modules leaves the config unchanged.
+func selectModules(cfg *Config, modules []string) error {
+	if len(modules) == 0 {
+		return nil
+	}
+	selected := map[string]*ModuleConfig{}
+	for _, name := range modules {
+		m, ok := cfg.Modules[name]
+		if !ok {
+			return fmt.Errorf("Module %s is not defined in the generator config", name)
+		}
+		selected[name] = m
+	}
+	cfg.Modules = selected
+	return nil
+}
+
+// Generate a snmp_exporter config and write it out. If outputDir is set,
+// each module is written to its own file in that directory instead of a
+// single combined file at outputPath. If modules are given only they are
+// generated, and other modules already in the output are kept. Returns the
+// warnings from generation.
+func generateConfig(ctx context.Context, nodes *Node, nameToNode map[string]*Node, configPath, outputPath, outputDir string, skipReport bool, modules []string, concurrency int, genOpts generatorOptions) ([]warning, error) {
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Error parsing yml config: %s", err)
+		return nil, err
+	}
+	defined := map[string]bool{}
+	for name := range cfg.Modules {
+		defined[name] = true
+	}
+	if err := selectModules(cfg, modules); err != nil {
+		return nil, err
 	}
 
+	results, err := generateModules(ctx, cfg, nodes, nameToNode, concurrency, genOpts)
+	if err != nil {
+		return nil, err
+	}
+	warnings := []warning{}
 	outputConfig := config.Config{}
-	for name, m := range cfg.Modules {
-		log.Infof("Generating config for module %s", name)
-		outputConfig[name] = generateConfigModule(m, nodes, nameToNode)
-		outputConfig[name].WalkParams = m.WalkParams
-		log.Infof("Generated %d metrics for module %s", len(outputConfig[name].Metrics), name)
+	costs := map[string]scrapeCost{}
+	for _, name := range sortedResultNames(results) {
+		result := results[name]
+		for _, w := range result.Warnings {
+			log.Warn(w)
+		}
+		warnings = append(warnings, result.Warnings...)
+		outputConfig[name] = result.Module
+		log.Infof("Generated %d metrics for module %s", len(result.Module.Metrics), name)
+		if result.StatusSkipped != 0 {
+			log.Infof("Skipped %d deprecated or obsolete objects for module %s", result.StatusSkipped, name)
+		}
+		if skipReport {
+			printSkipReport(name, result.Skipped, result.Ignored)
+		}
+		if *costReport != "" {
+			costs[name] = estimateCost(result.Module, nameToNode, *costRows)
+		}
+		if *costReport == "text" {
+			writeCostReport(os.Stdout, name, costs[name])
+		}
+	}
+	if *costReport == "json" {
+		out, err := json.MarshalIndent(costs, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(string(out))
+	}
+
+	opts, err := outputOpts(nameToNode, configPath)
+	if err != nil {
+		return nil, err
+	}
+	for name := range outputConfig {
+		opts.generated = append(opts.generated, name)
+	}
+	// Keep the modules we didn't regenerate. With outputDir they're
+	// separate files, so are left alone anyway.
+	if (len(modules) != 0 || *mergeOutput) && outputDir == "" {
+		kept, err := mergeExisting(outputConfig, outputPath, defined, *pruneOutput)
+		if err != nil {
+			return nil, err
+		}
+		opts.generated = append(opts.generated, kept...)
+	}
+	// Don't start writing if we've been cancelled since generating.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := writeOutput(outputConfig, outputPath, outputDir, opts); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// The comment at the start of a generated config listing the modules that
+// were generated, so that hand written modules can be told apart.
+const generatedModulesHeader = "# generated_modules: "
+
+// Add the modules of the existing config at outputPath that weren't just
+// generated to outputConfig, if it exists. Modules the existing config says
+// were generated, but which are no longer defined, are dropped if prune.
+// Returns the generated modules that were kept.
+func mergeExisting(outputConfig config.Config, outputPath string, defined map[string]bool, prune bool) ([]string, error) {
+	content, err := ioutil.ReadFile(outputPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error loading existing config %s: %s", outputPath, err)
+	}
+	existing, err := config.LoadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading existing config %s: %s", outputPath, err)
+	}
+	generated := map[string]bool{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, generatedModulesHeader) {
+			names := []string{}
+			if err := yaml.Unmarshal([]byte(strings.TrimPrefix(line, generatedModulesHeader)), &names); err != nil {
+				return nil, fmt.Errorf("Error loading existing config %s: bad list of generated modules: %s", outputPath, err)
+			}
+			for _, name := range names {
+				generated[name] = true
+			}
+			break
+		}
+	}
+	kept := []string{}
+	for name, m := range *existing {
+		if _, ok := outputConfig[name]; ok {
+			continue
+		}
+		if prune && generated[name] && !defined[name] {
+			log.Infof("Removing module %s, which is no longer in the generator config", name)
+			continue
+		}
+		outputConfig[name] = m
+		if generated[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept, nil
+}
+
+// Write out a generated config, either to outputPath or if outputDir is set
+// as one file per module in that directory.
+func writeOutput(outputConfig config.Config, outputPath, outputDir string, opts outputOptions) error {
+	if opts.compat != "" {
+		var err error
+		outputConfig, err = checkCompat(outputConfig, opts.compat)
+		if err != nil {
+			return err
+		}
+	}
+	if outputDir == "" {
+		return writeConfig(outputPath, outputConfig, opts)
+	}
+	opts.generated = nil
+
+	// Check all filenames up front, so we don't write a partial set of files.
+	paths := map[string]string{}
+	moduleForFile := map[string]string{}
+	for name := range outputConfig {
+		filename := moduleFilename(name)
+		if opts.format == formatJSON {
+			filename = strings.TrimSuffix(filename, ".yml") + ".json"
+		}
+		if other, ok := moduleForFile[filename]; ok {
+			return fmt.Errorf("Modules %s and %s would both be written to %s", other, name, filename)
+		}
+		moduleForFile[filename] = name
+		paths[name] = filepath.Join(outputDir, filename)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("Error creating output directory: %s", err)
+	}
+	for name, module := range outputConfig {
+		if err := writeConfig(paths[name], config.Config{name: module}, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generation options that apply to every module.
+type generatorOptions struct {
+	// Which object a name defined by more than one MIB module refers to,
+	// nameConflictFirst or the last otherwise.
+	onNameConflict string
+	// Skip OIDs to walk that aren't in the MIBs, as if every module set
+	// allow_missing.
+	skipMissing bool
+	// The help mode and maximum help length of modules that don't set them.
+	helpMode      string
+	helpMaxLength int
+	// Use snake_case names, as if every module set snake_case.
+	snakeCase bool
+	// Add suffixes to names that collide, rather than failing.
+	allowCollisions bool
+}
+
+// The generation options set by flags.
+func generatorOpts() generatorOptions {
+	return generatorOptions{
+		onNameConflict:  *onNameConflict,
+		skipMissing:     *skipMissing,
+		helpMode:        *defaultHelpMode,
+		helpMaxLength:   *helpMaxLength,
+		snakeCase:       *snakeCaseNames,
+		allowCollisions: *allowCollisions,
+	}
+}
+
+// Generate the snmp_exporter config for every module in a generator config.
+// Nothing is logged or written out, so this can be used with an in memory
+// MIB tree.
+func generate(ctx context.Context, cfg *Config, nodes *Node, nameToNode map[string]*Node, opts generatorOptions) (config.Config, error) {
+	results, err := generateModules(ctx, cfg, nodes, nameToNode, runtime.NumCPU(), opts)
+	if err != nil {
+		return nil, err
+	}
+	outputConfig := config.Config{}
+	for name, result := range results {
+		outputConfig[name] = result.Module
+	}
+	return outputConfig, nil
+}
+
+// Generate every module in a generator config using up to concurrency
+// goroutines, returning the full results including warnings and skipped
+// objects. The tree is only read, so can be shared between modules.
+func generateModules(ctx context.Context, cfg *Config, nodes *Node, nameToNode map[string]*Node, concurrency int, opts generatorOptions) (map[string]*moduleResult, error) {
+	names := []string{}
+	for name := range cfg.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*moduleResult, len(names))
+	errs := make([]error, len(names))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				results[j], errs[j] = generateConfigModule(ctx, cfg.Modules[names[j]], nodes, nameToNode, opts)
+			}
+		}()
+	}
+	for j := range names {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Report the first failing module in name order, so errors don't depend
+	// on scheduling.
+	byName := map[string]*moduleResult{}
+	for i, name := range names {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("Error generating module %s: %s", name, errs[i])
+		}
+		for j := range results[i].Warnings {
+			results[i].Warnings[j].Module = name
+		}
+		byName[name] = results[i]
+	}
+	return byName, nil
+}
+
+// The module names of generation results, sorted.
+func sortedResultNames(results map[string]*moduleResult) []string {
+	names := []string{}
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Print the objects that were not turned into metrics for a module, and the
+// metrics that overrides ignored.
+func printSkipReport(module string, skipped, ignored []skippedNode) {
+	fmt.Printf("Module %s skipped %d objects:\n", module, len(skipped))
+	for _, s := range skipped {
+		fmt.Printf("  %s %s: %s\n", s.Oid, s.Label, s.Reason)
+	}
+	if len(ignored) != 0 {
+		fmt.Printf("Module %s ignored %d metrics:\n", module, len(ignored))
+		for _, s := range ignored {
+			fmt.Printf("  %s %s: %s\n", s.Oid, s.Label, s.Reason)
+		}
+	}
+}
+
+// Run the test command, returning an error if it fails.
+func runTest(nameToNode map[string]*Node) error {
+	params := testParams{Version: *testSNMPVersion, Community: *testCommunity}
+	ok, err := testTarget(os.Stdout, *testConfigPath, *testModuleName, *testTargetAddress, *testWalkFile, nameToNode, params, *testMinCoverage)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Fewer than %g%% of metrics returned values", *testMinCoverage)
+	}
+	return nil
+}
+
+// Version information for the generator, including the NetSNMP library.
+func versionInfo() string {
+	return fmt.Sprintf("%s\n  netsnmp version:  %s", version.Print("generator"), netSnmpVersion())
+}
+
+// Check a generator config against the MIBs, printing all problems found.
+// Returns true if the config is valid.
+func validateConfig(nameToNode map[string]*Node, configPath string) bool {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	names := []string{}
+	for name := range cfg.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := 0
+	for _, name := range names {
+		m := cfg.Modules[name]
+		errs := append(validateModuleConfig(m, nameToNode), validateModuleIndexes(m, nameToNode)...)
+		for _, err := range errs {
+			fmt.Printf("%s: %s\n", name, err)
+		}
+		problems += len(errs)
+	}
+	if problems != 0 {
+		fmt.Printf("Found %d problems in %s\n", problems, configPath)
+		return false
+	}
+	return true
+}
+
+var (
+	invalidFilenameCharRE = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+// Convert a module name into a filename that is safe on all platforms.
+func moduleFilename(name string) string {
+	name = invalidFilenameCharRE.ReplaceAllString(name, "_")
+	// Avoid hidden files, and "." and "..".
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = "_" + name
 	}
+	return name + ".yml"
+}
 
-	config.DoNotHideSecrets = true
-	out, err := yaml.Marshal(outputConfig)
-	config.DoNotHideSecrets = false
+// Marshal a snmp_exporter config, including secrets, checking that the
+// result can be loaded by the exporter.
+func marshalConfig(outputConfig config.Config) ([]byte, error) {
+	out, err := config.MarshalWithSecrets(outputConfig)
 	if err != nil {
-		log.Fatalf("Error marshalling yml: %s", err)
+		return nil, fmt.Errorf("Error marshalling yml: %s", err)
 	}
 
 	// Check the generated config to catch auth/version issues.
 	err = yaml.Unmarshal(out, &config.Config{})
 	if err != nil {
-		log.Fatalf("Error parsing generated config: %s", err)
+		return nil, fmt.Errorf("Error parsing generated config: %s", err)
 	}
+	return out, nil
+}
+
+// Formats the generated config can be written in. The exporter only reads
+// YAML, JSON is for other tooling.
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
 
-	f, err := os.Create(outputPath)
+// How to write out a generated config.
+type outputOptions struct {
+	// Nodes to annotate the modules and metrics with comments from, or nil
+	// for no comments.
+	nameToNode map[string]*Node
+	// formatYAML or formatJSON. Empty means formatYAML.
+	format string
+	// The modules that were generated rather than written by hand, to list
+	// at the start of a single YAML file.
+	generated []string
+	// The SHA-256 of the generator config, for the comment at the start of
+	// YAML output saying how it was generated. Empty for no such comment.
+	configHash string
+	// The exporter version the output must work with, empty for the
+	// current version.
+	compat string
+}
+
+// The output options set by flags, for output generated from configPath.
+func outputOpts(nameToNode map[string]*Node, configPath string) (outputOptions, error) {
+	opts := outputOptions{format: *outputFormat, compat: *compatVersion}
+	if *annotate {
+		opts.nameToNode = nameToNode
+	}
+	if !*noHeader {
+		hash, err := configHash(configPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.configHash = hash
+	}
+	return opts, nil
+}
+
+// The SHA-256 of a generator config and the files it includes, in the order
+// they're read, so it changes when any of them do.
+func configHash(configPath string) (string, error) {
+	configPath, err := filepath.Abs(configPath)
 	if err != nil {
-		log.Fatalf("Error opening output file: %s", err)
+		return "", fmt.Errorf("Unable to determine absolute path for config %s: %s", configPath, err)
 	}
+	cfg, err := readConfigFile(configPath)
+	if err != nil {
+		return "", err
+	}
+	files, err := includedFiles(configPath, cfg.Include)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing yml config %s: %s", configPath, err)
+	}
+	hash := sha256.New()
+	for _, file := range append([]string{configPath}, files...) {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Error reading config %s: %s", file, err)
+		}
+		hash.Write(content)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// When the output was generated. SOURCE_DATE_EPOCH is used if set, so that
+// reproducible builds get the same output.
+func generationTime() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now().UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid SOURCE_DATE_EPOCH %q: %s", epoch, err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// The comment at the start of YAML output saying which generator produced it
+// when, from which generator config, and how big it is.
+func provenanceHeader(outputConfig config.Config, configHash string) ([]byte, error) {
+	generatedAt, err := generationTime()
+	if err != nil {
+		return nil, err
+	}
+	metrics := 0
+	for _, m := range outputConfig {
+		metrics += len(m.Metrics)
+	}
+	header := &bytes.Buffer{}
+	fmt.Fprintf(header, "# Generated by the snmp_exporter generator.\n")
+	fmt.Fprintf(header, "# generator_version: %s\n", version.Version)
+	fmt.Fprintf(header, "# generator_revision: %s\n", version.Revision)
+	fmt.Fprintf(header, "# generated_at: %s\n", generatedAt.Format(time.RFC3339))
+	fmt.Fprintf(header, "# config_sha256: %s\n", configHash)
+	fmt.Fprintf(header, "# modules: %d\n", len(outputConfig))
+	fmt.Fprintf(header, "# metrics: %d\n", metrics)
+	return header.Bytes(), nil
+}
+
+// Convert a marshalled config to JSON, with the same field names as the YAML,
+// checking that the result can still be loaded. As YAML is a superset of JSON
+// the YAML parser reads it.
+func convertToJSON(out []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(out, &v); err != nil {
+		return nil, fmt.Errorf("Error converting to json: %s", err)
+	}
+	out, err := json.MarshalIndent(jsonValue(v), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling json: %s", err)
+	}
+	out = append(out, '\n')
+	if err := yaml.Unmarshal(out, &config.Config{}); err != nil {
+		return nil, fmt.Errorf("Error parsing generated json config: %s", err)
+	}
+	return out, nil
+}
+
+// Replace the maps with interface{} keys that YAML decodes to with ones with
+// string keys that JSON can encode.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Add a comment before each module of a marshalled config saying how many
+// metrics it has from which MIBs, and before each metric saying which object
+// it is, such as "# IF-MIB::ifHCInOctets (1.3.6.1.2.1.31.1.1.1.6) COUNTER64".
+// yaml.v2 can't write comments, so the lines of each module and metric are
+// found in its output. The result is checked to still parse.
+func annotateConfig(out []byte, outputConfig config.Config, nameToNode map[string]*Node) ([]byte, error) {
+	annotated := &bytes.Buffer{}
+	var module *config.Module
+	metric := -1
+	for _, line := range strings.SplitAfter(string(out), "\n") {
+		switch {
+		case line != "" && line[0] != ' ' && strings.HasSuffix(line, ":\n"):
+			name := ""
+			if err := yaml.Unmarshal([]byte(strings.TrimSuffix(line, ":\n")), &name); err != nil {
+				return nil, fmt.Errorf("Error annotating module %q: %s", line, err)
+			}
+			module, metric = outputConfig[name], -1
+			if module != nil {
+				fmt.Fprintf(annotated, "# %s\n", moduleAnnotation(name, module, nameToNode))
+			}
+		case module != nil && line == "  metrics:\n":
+			metric = 0
+		case metric >= 0 && strings.HasPrefix(line, "  - name: "):
+			if metric < len(module.Metrics) {
+				fmt.Fprintf(annotated, "  # %s\n", metricAnnotation(module.Metrics[metric], nameToNode))
+			}
+			metric++
+		case !strings.HasPrefix(line, "  - ") && !strings.HasPrefix(line, "    "):
+			// The metrics are over.
+			metric = -1
+		}
+		annotated.WriteString(line)
+	}
+	if err := yaml.Unmarshal(annotated.Bytes(), &config.Config{}); err != nil {
+		return nil, fmt.Errorf("Error parsing annotated config: %s", err)
+	}
+	return annotated.Bytes(), nil
+}
+
+// The comment for a module, such as "if_mib: 10 metrics from IF-MIB".
+func moduleAnnotation(name string, module *config.Module, nameToNode map[string]*Node) string {
+	mibs := map[string]bool{}
+	for _, m := range module.Metrics {
+		if n, ok := nameToNode[m.Oid]; ok && n.Module != "" {
+			mibs[n.Module] = true
+		}
+	}
+	names := []string{}
+	for mib := range mibs {
+		names = append(names, mib)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Sprintf("%s: %d metrics", name, len(module.Metrics))
+	}
+	return fmt.Sprintf("%s: %d metrics from %s", name, len(module.Metrics), strings.Join(names, ", "))
+}
+
+// The comment for a metric, saying which object it is.
+func metricAnnotation(metric *config.Metric, nameToNode map[string]*Node) string {
+	n, ok := nameToNode[metric.Oid]
+	if !ok {
+		return fmt.Sprintf("%s isn't in the MIBs", metric.Oid)
+	}
+	label := n.Label
+	if n.Module != "" {
+		label = qualifiedName(n)
+	}
+	return fmt.Sprintf("%s (%s) %s", label, n.Oid, n.Type)
+}
+
+// Marshal a snmp_exporter config and atomically write it to outputPath.
+func writeConfig(outputPath string, outputConfig config.Config, opts outputOptions) error {
+	outputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return fmt.Errorf("Unable to determine absolute path for output %s: %s", outputPath, err)
+	}
+
+	out, err := marshalConfig(outputConfig)
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.format == formatJSON:
+		out, err = convertToJSON(out)
+	case opts.nameToNode != nil:
+		out, err = annotateConfig(out, outputConfig, opts.nameToNode)
+	}
+	if err != nil {
+		return err
+	}
+	if len(opts.generated) != 0 && opts.format != formatJSON {
+		sort.Strings(opts.generated)
+		// A flow sequence keeps the list on the one comment line.
+		header, err := yaml.Marshal(struct {
+			Modules []string `yaml:"generated_modules,flow"`
+		}{opts.generated})
+		if err != nil {
+			return err
+		}
+		out = append(append([]byte("# "), header...), out...)
+	}
+	if opts.configHash != "" && opts.format != formatJSON {
+		header, err := provenanceHeader(outputConfig, opts.configHash)
+		if err != nil {
+			return err
+		}
+		out = append(header, out...)
+	}
+
+	// Write to a temporary file in the same directory and rename it into
+	// place, so an existing file is never left half written.
+	f, err := ioutil.TempFile(filepath.Dir(outputPath), "."+filepath.Base(outputPath))
+	if err != nil {
+		return fmt.Errorf("Error opening output file: %s", err)
+	}
+	defer os.Remove(f.Name())
 	_, err = f.Write(out)
 	if err != nil {
-		log.Fatalf("Error writing to output file: %s", err)
+		f.Close()
+		return fmt.Errorf("Error writing to output file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Error writing to output file: %s", err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("Error setting output file permissions: %s", err)
+	}
+	if err := os.Rename(f.Name(), outputPath); err != nil {
+		return fmt.Errorf("Error renaming output file: %s", err)
 	}
 	log.Infof("Config written to %s", outputPath)
+	return nil
 }
 
 var (
+	mibDirs            = kingpin.Flag("mib-dir", "Directory to load MIBs from, in addition to NetSNMP's usual directories. Can be repeated").Strings()
+	mibs               = kingpin.Flag("mib", "MIB module to load, rather than loading all MIBs found. Can be repeated").Strings()
+	noSystemMIBs       = kingpin.Flag("no-system-mibs", "Only load MIBs from --mib-dir, not NetSNMP's usual directories").Bool()
+	treeCachePath      = kingpin.Flag("tree-cache", "File to cache the parsed MIB tree in, so NetSNMP only parses the MIBs again when they change").String()
+	noCache            = kingpin.Flag("no-cache", "Parse the MIBs even if --tree-cache is up to date").Bool()
+	noStrict           = kingpin.Flag("no-strict", "Ignore unknown fields in the generator config, rather than treating them as errors").Bool()
+	defaultHelpMode    = kingpin.Flag("help-mode", "How much of each object's description to use as metric help, for modules that don't set help: full, first_sentence or none").Default(helpFirstSentence).Enum(helpFull, helpFirstSentence, helpNone)
+	helpMaxLength      = kingpin.Flag("help-max-length", "Most characters of metric help, for modules that don't set help_max_length, 0 for no limit").Default("0").Int()
+	snakeCaseNames     = kingpin.Flag("snake-case", "Convert metric and label names from the MIBs to snake_case, as if every module set snake_case").Bool()
+	onNameConflict     = kingpin.Flag("on-name-conflict", "Which object a name defined by more than one MIB module refers to: first or last in the MIB tree, or error to exit").Default(nameConflictLast).Enum(nameConflictFirst, nameConflictLast, nameConflictError)
+	allowCollisions    = kingpin.Flag("allow-collisions", "Add a numeric suffix to metric and label names that collide after sanitization, rather than failing").Bool()
+	skipMissing        = kingpin.Flag("skip-missing", "Skip OIDs to walk that aren't in the MIBs with a warning, rather than failing, as if every module set allow_missing").Bool()
+	profileCPU         = kingpin.Flag("profile.cpu", "File to write a CPU profile of the command to").String()
+	profileMem         = kingpin.Flag("profile.mem", "File to write a heap profile to when the command finishes").String()
+	profileHTTP        = kingpin.Flag("profile.http", "Address to serve pprof profiles on while the command runs, such as :6060").String()
 	generateCommand    = kingpin.Command("generate", "Generate snmp.yml from generator.yml")
+	configPath         = generateCommand.Flag("config", "Path to the generator config file").Default("generator.yml").Short('c').String()
 	outputPath         = generateCommand.Flag("output-path", "Path to to write resulting config file").Default("snmp.yml").Short('o').String()
-	parseErrorsCommand = kingpin.Command("parse_errors", "Debug: Print the parse errors output by NetSNMP")
+	failOnParseErrors  = generateCommand.Flag("fail-on-parse-errors", "Exit with an error if NetSNMP reported any MIB parse errors").Bool()
+	maxParseErrors     = generateCommand.Flag("max-parse-errors", "Exit with an error if NetSNMP reported more than this many MIB parse errors, -1 for no limit").Default("-1").Int()
+	strict             = generateCommand.Flag("strict", "Exit with an error after writing the config if there were any warnings").Bool()
+	skipReport         = generateCommand.Flag("skip-report", "Print every object under the walked OIDs that did not become a metric, and why").Bool()
+	watch              = generateCommand.Flag("watch", "Keep running, and regenerate the config whenever the generator config or a file it includes changes").Bool()
+	moduleNames        = generateCommand.Flag("module", "Only generate this module, keeping other modules in the existing output. Can be repeated").Strings()
+	outputDir          = generateCommand.Flag("output-dir", "Directory to write one <module>.yml config file per module to, instead of --output-path").String()
+	annotate           = generateCommand.Flag("annotate", "Add comments to the output saying which MIB object each metric is").Bool()
+	outputFormat       = generateCommand.Flag("format", "Format of the output: yaml, or json for other tooling. The exporter only reads yaml").Default(formatYAML).Enum(formatYAML, formatJSON)
+	costReport         = generateCommand.Flag("cost-report", "Print an estimate of how much work scraping each module is for a device, as text or json").Enum("text", "json")
+	costRows           = generateCommand.Flag("cost-rows", "How many rows to assume each table has for --cost-report").Default("10").Int()
+	mergeOutput        = generateCommand.Flag("merge", "Keep the modules of the existing --output-path that aren't in the generator config, such as hand written ones").Bool()
+	pruneOutput        = generateCommand.Flag("prune", "With --merge, remove modules that were generated but are no longer in the generator config").Bool()
+	compatVersion      = generateCommand.Flag("compat", "Only write config that this older exporter version understands, failing if anything needs a newer one").PlaceHolder("VERSION").String()
+	noHeader           = generateCommand.Flag("no-header", "Don't start the output with a comment saying how it was generated, so it only changes when the config does").Bool()
+	concurrency        = generateCommand.Flag("concurrency", "Number of modules to generate in parallel").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	validateCommand    = kingpin.Command("validate", "Check generator.yml against the MIBs, reporting all problems found")
+	validateConfigPath = validateCommand.Flag("config", "Path to the generator config file").Default("generator.yml").Short('c').String()
+	diffCommand        = kingpin.Command("diff", "Compare the config that would be generated with an existing snmp.yml, exiting with 1 if they differ")
+	diffConfigPath     = diffCommand.Flag("config", "Path to the generator config file").Default("generator.yml").Short('c').String()
+	diffAgainst        = diffCommand.Flag("against", "Path to the existing snmp_exporter config file").Default("snmp.yml").String()
+	serveCommand       = kingpin.Command("serve", "Serve config generation and debugging over HTTP")
+	listenAddress      = serveCommand.Flag("web.listen-address", "Address to listen on for HTTP requests").Default(":9117").String()
+	versionCommand     = kingpin.Command("version", "Print version information")
+	testCommand        = kingpin.Command("test", "Walk a device with a generated module, reporting which metrics it returned values for")
+	testConfigPath     = testCommand.Flag("snmp-config", "The generated snmp_exporter config to take the module from").Default("snmp.yml").String()
+	testModuleName     = testCommand.Flag("module", "Module to walk the device with").Required().String()
+	testTargetAddress  = testCommand.Flag("target", "Device to walk, as host or host:port").String()
+	testWalkFile       = testCommand.Flag("walk-file", "Output of snmpwalk or snmpbulkwalk to use rather than walking a device, preferably with -On").ExistingFile()
+	testSNMPVersion    = testCommand.Flag("snmp-version", "SNMP version to use, rather than the module's").Int()
+	testCommunity      = testCommand.Flag("community", "SNMP community to use, rather than the module's").String()
+	testMinCoverage    = testCommand.Flag("min-coverage", "Exit non-zero if fewer than this percentage of the module's metrics returned values").Default("0").Float64()
+	parseErrorsCommand = kingpin.Command("parse_errors", "Debug: Print the parse errors output by NetSNMP, grouped by MIB")
+	parseErrorsRaw     = parseErrorsCommand.Flag("raw", "Print the raw NetSNMP output").Bool()
+	parseErrorsFilter  = parseErrorsCommand.Flag("filter", "Only print errors for MIBs matching this regular expression").String()
+	findCommand        = kingpin.Command("find", "Search the MIBs for objects whose label, description or textual convention matches a regular expression")
+	findRegexp         = findCommand.Arg("regexp", "Regular expression to search for").Required().String()
+	findLabelOnly      = findCommand.Flag("label-only", "Only search object labels").Bool()
+	findDescOnly       = findCommand.Flag("description-only", "Only search object descriptions").Bool()
+	findCaseSensitive  = findCommand.Flag("case-sensitive", "Match case sensitively").Bool()
+	findLimit          = findCommand.Flag("limit", "Maximum number of results to print, 0 for no limit").Default("0").Int()
+	describeCommand    = kingpin.Command("describe", "Debug: Print everything known about an object, and whether it would become a metric")
+	describeOid        = describeCommand.Arg("oid", "Object name or numeric OID").Required().String()
+	describeFormat     = describeCommand.Flag("format", "Output format: text or json").Default("text").Enum("text", "json")
 	dumpCommand        = kingpin.Command("dump", "Debug: Dump the parsed and prepared MIBs")
+	dumpOids           = dumpCommand.Flag("oid", "Only dump the subtree under this object name or numeric OID, can be repeated").Strings()
+	dumpFormat         = dumpCommand.Flag("format", "Output format: text, json (a JSON array), ndjson (one JSON object per line) or dot (a Graphviz digraph)").Default("text").Enum("text", "json", "ndjson", "dot")
+	dumpLeavesOnly     = dumpCommand.Flag("leaves-only", "Only dump nodes without children").Bool()
+	dumpAccess         = dumpCommand.Flag("access", "Only dump nodes with one of these comma separated access levels, e.g. readonly,readwrite").Strings()
+	dumpTypes          = dumpCommand.Flag("type", "Only dump nodes with one of these comma separated types as shown after preparation, e.g. COUNTER,GAUGE").Strings()
 )
 
 func main() {
 	log.AddFlags(kingpin.CommandLine)
+	kingpin.Version(versionInfo())
 	kingpin.HelpFlag.Short('h')
 	command := kingpin.Parse()
 
-	parseErrors := initSNMP()
-	log.Warnf("NetSNMP reported %d parse errors", len(strings.Split(parseErrors, "\n")))
+	if command == versionCommand.FullCommand() {
+		fmt.Println(versionInfo())
+		return
+	}
+	stopProfiling, err := startProfiling(*profileCPU, *profileMem, *profileHTTP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = run(command)
+	// Profiles are written before exiting, even if the command failed.
+	stopProfiling()
+	if err == errFailed {
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Returned by commands that have already said why they failed, to exit with
+// an error without logging anything more.
+var errFailed = errors.New("failed")
+
+// Run a command, returning why it failed.
+func run(command string) error {
+	if command == testCommand.FullCommand() && (*testTargetAddress == "") == (*testWalkFile == "") {
+		return errors.New("Exactly one of --target and --walk-file is needed")
+	}
+	// Testing a device doesn't need the MIBs, but the names in a walk file
+	// do.
+	if command == testCommand.FullCommand() && *testWalkFile == "" {
+		return runTest(nil)
+	}
+
+	opts := snmpOptions{MIBDirs: *mibDirs, MIBs: *mibs, NoSystemMIBs: *noSystemMIBs}
+	cachePath := *treeCachePath
+	if command == describeCommand.FullCommand() {
+		// The cache only has cleaned up descriptions.
+		opts.RawDescriptions = true
+		cachePath = ""
+	}
+	nodes, parseErrors, err := loadMIBTree(opts, cachePath, !*noCache)
+	if err != nil {
+		return err
+	}
+	parsedErrors := parseNetSNMPErrors(parseErrors)
+	log.Warnf("NetSNMP reported %d parse errors", len(parsedErrors))
+	if command == generateCommand.FullCommand() {
+		if err := checkParseErrors(parsedErrors, *failOnParseErrors, *maxParseErrors); err != nil {
+			writeParseErrors(os.Stderr, groupParseErrors(parsedErrors, nil))
+			return err
+		}
+	}
 
-	nodes := getMIBTree()
-	nameToNode := prepareTree(nodes)
+	// Keep the descriptions as written, as prepareTree removes whitespace.
+	descriptions := map[*Node]string{}
+	if command == describeCommand.FullCommand() {
+		walkNode(nodes, func(n *Node) {
+			descriptions[n] = n.Description
+		})
+	}
+	genOpts := generatorOpts()
+	nameToNode, warnings := prepareTree(nodes, genOpts)
+	conflicts := 0
+	for _, w := range warnings {
+		log.Warn(w)
+		if w.Category == warnNameConflict {
+			conflicts++
+		}
+	}
+	if genOpts.onNameConflict == nameConflictError && conflicts != 0 {
+		return fmt.Errorf("Exiting due to %d names defined by more than one MIB module", conflicts)
+	}
+	switch command {
+	case generateCommand.FullCommand(), validateCommand.FullCommand(), diffCommand.FullCommand(), testCommand.FullCommand():
+		// These only use descriptions for the help of metrics.
+		dropUnusedDescriptions(nodes)
+	}
 
 	switch command {
 	case generateCommand.FullCommand():
-		generateConfig(nodes, nameToNode)
+		if *annotate && *outputFormat == formatJSON {
+			return errors.New("--annotate can't be used with --format=json, as json has no comments")
+		}
+		if *pruneOutput && !*mergeOutput {
+			return errors.New("--prune can only be used with --merge")
+		}
+		if _, ok := exporterVersions[*compatVersion]; *compatVersion != "" && !ok {
+			return fmt.Errorf("Unknown exporter version %q for --compat, known versions are %s", *compatVersion, strings.Join(exporterVersionNames(), ", "))
+		}
+		if *watch {
+			// The MIB tree is reused between generations.
+			watchConfig(*configPath, time.Second, nil, func() error {
+				_, err := generateConfig(context.Background(), nodes, nameToNode, *configPath, *outputPath, *outputDir, *skipReport, *moduleNames, *concurrency, genOpts)
+				return err
+			})
+			return nil
+		}
+		generateWarnings, err := generateConfig(context.Background(), nodes, nameToNode, *configPath, *outputPath, *outputDir, *skipReport, *moduleNames, *concurrency, genOpts)
+		if err != nil {
+			return err
+		}
+		warnings = append(warnings, generateWarnings...)
+		if *strict && len(warnings) != 0 {
+			return fmt.Errorf("Exiting due to %d warnings in strict mode", len(warnings))
+		}
+	case validateCommand.FullCommand():
+		if !validateConfig(nameToNode, *validateConfigPath) {
+			return errFailed
+		}
+	case diffCommand.FullCommand():
+		cfg, err := loadConfig(*diffConfigPath)
+		if err != nil {
+			return err
+		}
+		generated, err := generate(context.Background(), cfg, nodes, nameToNode, genOpts)
+		if err != nil {
+			return err
+		}
+		existing, err := config.LoadFile(*diffAgainst)
+		if err != nil {
+			return fmt.Errorf("Error loading existing config %s: %s", *diffAgainst, err)
+		}
+		differences := diffConfigs(*existing, generated)
+		for _, d := range differences {
+			fmt.Println(d)
+		}
+		if len(differences) != 0 {
+			return errFailed
+		}
+	case testCommand.FullCommand():
+		return runTest(nameToNode)
+	case serveCommand.FullCommand():
+		s := &server{nodes: nodes, nameToNode: nameToNode, opts: genOpts, parseErrors: parsedErrors}
+		log.Infof("Listening on %s", *listenAddress)
+		if err := http.ListenAndServe(*listenAddress, s.handler()); err != nil {
+			return fmt.Errorf("Error starting HTTP server: %s", err)
+		}
 	case parseErrorsCommand.FullCommand():
-		fmt.Println(parseErrors)
+		if *parseErrorsRaw {
+			fmt.Println(parseErrors)
+			return nil
+		}
+		var filter *regexp.Regexp
+		if *parseErrorsFilter != "" {
+			var err error
+			filter, err = regexp.Compile("^(?:" + *parseErrorsFilter + ")$")
+			if err != nil {
+				return fmt.Errorf("Error parsing --filter regular expression: %s", err)
+			}
+		}
+		groups := groupParseErrors(parsedErrors, filter)
+		if err := writeParseErrors(os.Stdout, groups); err != nil {
+			return fmt.Errorf("Error writing parse errors: %s", err)
+		}
+	case findCommand.FullCommand():
+		if *findLabelOnly && *findDescOnly {
+			return errors.New("Only one of --label-only and --description-only can be used")
+		}
+		fields := findAll
+		if *findLabelOnly {
+			fields = findLabel
+		} else if *findDescOnly {
+			fields = findDescription
+		}
+		expr := *findRegexp
+		if !*findCaseSensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("Error parsing regular expression: %s", err)
+		}
+		for _, r := range findNodes(nodes, re, fields, *findLimit) {
+			table := "-"
+			if r.table != nil {
+				table = r.table.Label
+			}
+			fmt.Printf("%s %s %s %s\n", r.node.Oid, r.node.Label, r.node.Type, table)
+		}
+	case describeCommand.FullCommand():
+		found, err := lookupNodes([]string{*describeOid}, nameToNode)
+		if err != nil {
+			return err
+		}
+		d := describeNode(found[0], descriptions[found[0]], nameToNode)
+		if err := writeDescription(os.Stdout, d, *describeFormat); err != nil {
+			return fmt.Errorf("Error describing object: %s", err)
+		}
 	case dumpCommand.FullCommand():
-		walkNode(nodes, func(n *Node) {
-			t := n.Type
-			if n.FixedSize != 0 {
-				t = fmt.Sprintf("%s(%d)", n.Type, n.FixedSize)
+		roots := []*Node{nodes}
+		if len(*dumpOids) != 0 {
+			var err error
+			roots, err = lookupNodes(*dumpOids, nameToNode)
+			if err != nil {
+				return err
 			}
-			fmt.Printf("%s %s %s %q %q %s %s\n", n.Oid, n.Label, t, n.TextualConvention, n.Hint, n.Indexes, n.Description)
-		})
+		}
+		filter := &dumpFilter{
+			LeavesOnly: *dumpLeavesOnly,
+			Access:     splitFlagValues(*dumpAccess),
+			Types:      splitFlagValues(*dumpTypes),
+		}
+		if err := dumpNodes(os.Stdout, roots, *dumpFormat, nameToNode, filter); err != nil {
+			return fmt.Errorf("Error dumping MIBs: %s", err)
+		}
 	}
+	return nil
 }
@@ -0,0 +1,1150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestGenerateConfigFromPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "profiles", "test.yml")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "modules:\n  test:\n    walk: [root]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	outputPath := filepath.Join(dir, "snmp.yml")
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Error reading generated config: %s", err)
+	}
+	cfg := config.Config{}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		t.Fatalf("Error parsing generated config: %s", err)
+	}
+	module, ok := cfg["test"]
+	if !ok {
+		t.Fatalf("Module test missing from generated config: %s", out)
+	}
+	if len(module.Metrics) != 1 || module.Metrics[0].Name != "root" {
+		t.Errorf("Unexpected metrics in generated config: %s", out)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "missing.yml")
+	_, err = loadConfig(configPath)
+	if err == nil {
+		t.Fatal("Expected error loading missing config")
+	}
+	if !strings.Contains(err.Error(), configPath) {
+		t.Errorf("Error %q does not name the path %s", err, configPath)
+	}
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	configPath := write("generator.yml", "include: [modules/*.yml]\nmodules:\n  base:\n    walk: [a]\n")
+	write("modules/one.yml", "modules:\n  one:\n    extends: base\n    walk: [b]\n")
+	write("modules/two.yml", "modules:\n  two:\n    walk: [c]\n")
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Modules) != 3 || !reflect.DeepEqual(cfg.Modules["one"].Walk, []string{"a", "b"}) || !reflect.DeepEqual(cfg.Modules["two"].Walk, []string{"c"}) {
+		t.Errorf("Unexpected modules %v", cfg.Modules)
+	}
+
+	cases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "modules/dup.yml",
+			content: "modules:\n  one:\n    walk: [d]\n",
+			err:     "Module one is defined in both " + filepath.Join(dir, "modules", "dup.yml") + " and " + filepath.Join(dir, "modules", "one.yml"),
+		},
+		{
+			name:    "modules/nested.yml",
+			content: "include: [other.yml]\nmodules:\n  nested:\n    walk: [d]\n",
+			err:     "included files can't include other files",
+		},
+	}
+	for _, c := range cases {
+		path := write(c.name, c.content)
+		_, err := loadConfig(configPath)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Including %q: got error %v, want %q", c.content, err, c.err)
+		}
+		os.Remove(path)
+	}
+
+	write("generator.yml", "include: [fragments/*.yml]\n")
+	if _, err := loadConfig(configPath); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Errorf("Unexpected error for include matching nothing: %v", err)
+	}
+	if _, err := parseConfig([]byte("include: [modules/*.yml]\n"), true); err == nil {
+		t.Error("Expected error parsing include without a config file")
+	}
+}
+
+func TestGenerateConfigOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "generator.yml")
+	content := "modules:\n  first:\n    walk: [root]\n  ../second:\n    walk: [root]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(dir, "out")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Existing files are overwritten.
+	if err := ioutil.WriteFile(filepath.Join(outputDir, "first.yml"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, "", outputDir, false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, module := range map[string]string{"first.yml": "first", "_.._second.yml": "../second"} {
+		out, err := ioutil.ReadFile(filepath.Join(outputDir, filename))
+		if err != nil {
+			t.Fatalf("Error reading generated config: %s", err)
+		}
+		cfg := config.Config{}
+		if err := yaml.Unmarshal(out, &cfg); err != nil {
+			t.Fatalf("Error parsing generated config %s: %s", filename, err)
+		}
+		if _, ok := cfg[module]; !ok || len(cfg) != 1 {
+			t.Errorf("Expected only module %s in %s, got: %s", module, filename, out)
+		}
+	}
+	files, err := ioutil.ReadDir(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files in output dir, got %d", len(files))
+	}
+}
+
+func TestModuleFilename(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{in: "if_mib", out: "if_mib.yml"},
+		{in: "cisco/wlc", out: "cisco_wlc.yml"},
+		{in: "..", out: "_...yml"},
+		{in: ".hidden", out: "_.hidden.yml"},
+		{in: "", out: "_.yml"},
+	}
+	for _, c := range cases {
+		got := moduleFilename(c.in)
+		if got != c.out {
+			t.Errorf("moduleFilename(%q): got %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "first"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "second"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	configPath := filepath.Join(dir, "generator.yml")
+	outputPath := filepath.Join(dir, "snmp.yml")
+
+	// Wait for the output to contain the given metric.
+	waitFor := func(metric string) {
+		for i := 0; i < 500; i++ {
+			out, _ := ioutil.ReadFile(outputPath)
+			if strings.Contains(string(out), "name: "+metric) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %s to be generated", metric)
+	}
+
+	if err := ioutil.WriteFile(configPath, []byte("modules:\n  test:\n    walk: [first]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchConfig(configPath, 10*time.Millisecond, stop, func() error {
+			_, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{})
+			return err
+		})
+		close(done)
+	}()
+	waitFor("first")
+
+	// A broken config leaves the previous output in place.
+	if err := ioutil.WriteFile(configPath, []byte("modules:\n  test:\n    walk: [missing]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	waitFor("first")
+
+	if err := ioutil.WriteFile(configPath, []byte("modules:\n  test:\n    walk: [second]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("second")
+
+	// Changes to included files are noticed too.
+	fragmentPath := filepath.Join(dir, "fragment.yml")
+	if err := ioutil.WriteFile(fragmentPath, []byte("modules:\n  other:\n    walk: [second]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, []byte("include: [fragment.yml]\nmodules:\n  test:\n    walk: [second]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := ioutil.WriteFile(fragmentPath, []byte("modules:\n  other:\n    walk: [first]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("first")
+
+	close(stop)
+	<-done
+}
+
+func TestGenerateConfigSelectedModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "first"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "second"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	configPath := filepath.Join(dir, "generator.yml")
+	outputPath := filepath.Join(dir, "snmp.yml")
+
+	content := "modules:\n  a:\n    walk: [first]\n  b:\n    walk: [first]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Regenerating only b keeps a, and the hand written module c.
+	existing, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing = append(existing, []byte("c:\n  walk: [1.3]\n")...)
+	if err := ioutil.WriteFile(outputPath, existing, 0644); err != nil {
+		t.Fatal(err)
+	}
+	content = "modules:\n  a:\n    walk: [second]\n  b:\n    walk: [second]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, []string{"b"}, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"a": "first", "b": "second", "c": ""}
+	for name, metric := range expected {
+		m, ok := (*cfg)[name]
+		if !ok {
+			t.Errorf("Module %s missing from output", name)
+			continue
+		}
+		got := ""
+		if len(m.Metrics) != 0 {
+			got = m.Metrics[0].Name
+		}
+		if got != metric {
+			t.Errorf("Module %s: got metric %q, want %q", name, got, metric)
+		}
+	}
+
+	if err := selectModules(&Config{Modules: map[string]*ModuleConfig{}}, []string{"missing"}); err == nil {
+		t.Error("Expected error selecting undefined module")
+	}
+}
+
+func TestGenerateConfigMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*mergeOutput = true
+	defer func() { *mergeOutput = false }()
+
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "first"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "second"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	configPath := filepath.Join(dir, "generator.yml")
+	outputPath := filepath.Join(dir, "snmp.yml")
+
+	content := "modules:\n  a:\n    walk: [first]\n  b:\n    walk: [first]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	existing, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(existing, []byte("\n# generated_modules: [a, b]\n")) {
+		t.Fatalf("Output doesn't list the generated modules:\n%s", existing)
+	}
+	existing = append(existing, []byte("c:\n  walk: [1.3]\n")...)
+	if err := ioutil.WriteFile(outputPath, existing, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// b is no longer defined, but without --prune is kept.
+	content = "modules:\n  a:\n    walk: [second]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, prune := range []bool{false, true} {
+		*pruneOutput = prune
+		if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := config.LoadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := (*cfg)["a"]; !ok || len(m.Metrics) != 1 || m.Metrics[0].Name != "second" {
+			t.Errorf("prune=%v: module a wasn't regenerated: %v", prune, m)
+		}
+		if _, ok := (*cfg)["b"]; ok == prune {
+			t.Errorf("prune=%v: got module b %v", prune, ok)
+		}
+		if _, ok := (*cfg)["c"]; !ok {
+			t.Errorf("prune=%v: hand written module c missing from output", prune)
+		}
+	}
+	*pruneOutput = false
+}
+
+func TestProvenanceHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "first"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "second"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	configPath := filepath.Join(dir, "generator.yml")
+	outputPath := filepath.Join(dir, "snmp.yml")
+	content := "modules:\n  a:\n    walk: [first]\n  b:\n    walk: [root]\n"
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"# generated_at: 2017-07-14T02:40:00Z\n",
+		fmt.Sprintf("# config_sha256: %x\n", sha256.Sum256([]byte(content))),
+		"# modules: 2\n",
+		"# metrics: 3\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("Output is missing %q:\n%s", line, out)
+		}
+	}
+	cfg, err := config.LoadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*cfg) != 2 {
+		t.Errorf("Unexpected modules in output %v", *cfg)
+	}
+
+	// The hash covers the included files too.
+	include := "include: [modules.yml]\n"
+	fragment := "modules:\n  a:\n    walk: [first]\n"
+	if err := ioutil.WriteFile(configPath, []byte(include), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "modules.yml"), []byte(fragment), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := fmt.Sprintf("# config_sha256: %x\n", sha256.Sum256([]byte(include+fragment))); !strings.Contains(string(out), line) {
+		t.Errorf("Output is missing %q:\n%s", line, out)
+	}
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "SOURCE_DATE_EPOCH") {
+		t.Errorf("Expected error for invalid SOURCE_DATE_EPOCH, got %v", err)
+	}
+
+	*noHeader = true
+	defer func() { *noHeader = false }()
+	if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "generated_at") {
+		t.Errorf("Output has a header despite --no-header:\n%s", out)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	// Catches clashing flag definitions, which kingpin only reports on parse.
+	if _, err := kingpin.CommandLine.Parse([]string{"parse_errors", "--filter", "IF-MIB", "--mib", "IF-MIB"}); err != nil {
+		t.Fatalf("Error parsing command line: %s", err)
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	info := versionInfo()
+	for _, s := range []string{"generator, version", "go version:", "netsnmp version:  " + netSnmpVersion()} {
+		if !strings.Contains(info, s) {
+			t.Errorf("Version info %q does not contain %q", info, s)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "scalar"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	out, err := generate(context.Background(), &Config{Modules: map[string]*ModuleConfig{"good": {Walk: []string{"root"}}}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out["good"].Metrics) != 1 || out["good"].Metrics[0].Name != "scalar" {
+		t.Errorf("Unexpected metrics %+v", out["good"].Metrics)
+	}
+
+	cases := map[string]*ModuleConfig{
+		"Cannot find oid 'missing' to walk": {Walk: []string{"missing"}},
+		"Unknown index 'missingIndex'": {
+			Walk:    []string{"root"},
+			Lookups: []*Lookup{{OldIndex: "missingIndex", NewIndex: "scalar"}},
+		},
+	}
+	for expected, m := range cases {
+		cfg := &Config{Modules: map[string]*ModuleConfig{"good": {Walk: []string{"root"}}, "bad": m}}
+		_, err := generate(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err == nil {
+			t.Errorf("Expected error %q", expected)
+			continue
+		}
+		if !strings.Contains(err.Error(), "bad") || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Got error %q, want error for module bad containing %q", err, expected)
+		}
+	}
+}
+
+func TestParseConfigStrict(t *testing.T) {
+	cases := []struct {
+		content string
+		err     string
+	}{
+		{
+			content: "modulez:\n  a:\n    walk: [root]\n",
+			err:     "field modulez not found in struct main.Config",
+		},
+		{
+			content: "modules:\n  a:\n    walk: [root]\n    lookup:\n    - old_index: a\n      new_index: b\n",
+			err:     "field lookup not found in struct main.ModuleConfig",
+		},
+		{
+			content: "modules:\n  a:\n    walk: [root]\n    overides:\n      root:\n        regex_extracts: {}\n",
+			err:     "field overides not found in struct main.ModuleConfig",
+		},
+		{
+			content: "modules:\n  a:\n    walk: [root]\n    overrides:\n      root:\n        regex_extract: {}\n",
+			err:     "field regex_extract not found in struct main.MetricOverrides",
+		},
+		{
+			content: "modules:\n  a:\n    walk: [root]\n    max_repetition: 10\n",
+			err:     "field max_repetition not found in struct main.ModuleConfig",
+		},
+		{
+			content: "modules:\n  a:\n    walk: [root]\n    lookups:\n    - old_index: a\n      new_indx: b\n",
+			err:     "field new_indx not found in struct main.Lookup",
+		},
+	}
+	for _, c := range cases {
+		_, err := parseConfig([]byte(c.content), true)
+		if err == nil {
+			t.Errorf("Expected error parsing %q", c.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Parsing %q: got error %q, want %q", c.content, err, c.err)
+		}
+
+		if _, err := parseConfig([]byte(c.content), false); err != nil {
+			t.Errorf("Unexpected error parsing %q without strict: %s", c.content, err)
+		}
+	}
+
+	cfg, err := parseConfig([]byte("modules:\n  a:\n    walk: [root]\n    max_repetitions: 10\n    lookups:\n    - old_index: a\n      new_index: b\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cfg.Modules["a"]
+	if m.WalkParams.MaxRepetitions != 10 || len(m.Lookups) != 1 || m.Lookups[0].NewIndex != "b" {
+		t.Errorf("Unexpected module config %+v", m)
+	}
+}
+
+func TestParseConfigExtends(t *testing.T) {
+	content := `
+modules:
+  base:
+    walk: [a, b]
+    version: 2
+    retries: 3
+    auth:
+      community: secret
+    lookups:
+    - old_index: a
+      new_index: b
+    overrides:
+      a:
+        type: gauge
+      b:
+        type: counter
+  child:
+    extends: base
+    walk: [c]
+    retries: 5
+    lookups:
+    - old_index: c
+      new_index: d
+    overrides:
+      b:
+        ignore: true
+  grandchild:
+    extends: child
+    walk: [e]
+  reset:
+    extends: base
+    retries: 0
+    auth:
+      community: ""
+  resetchild:
+    extends: reset
+`
+	cfg, err := parseConfig([]byte(content), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cfg.Modules["grandchild"]
+	if !reflect.DeepEqual(m.Walk, []string{"a", "b", "c", "e"}) {
+		t.Errorf("Unexpected walk %v", m.Walk)
+	}
+	if len(m.Lookups) != 2 || m.Lookups[0].NewIndex != "b" || m.Lookups[1].NewIndex != "d" {
+		t.Errorf("Unexpected lookups %v", m.Lookups)
+	}
+	if m.Overrides["a"].Type != "gauge" || !m.Overrides["b"].Ignore || m.Overrides["b"].Type != "" {
+		t.Errorf("Unexpected overrides %v", m.Overrides)
+	}
+	if m.WalkParams.Version != 2 || m.WalkParams.Retries != 5 || m.WalkParams.Auth.Community != "secret" {
+		t.Errorf("Unexpected walk params %+v", m.WalkParams)
+	}
+	// Params set to zero values replace the parent's, for the exporter's defaults.
+	for _, name := range []string{"reset", "resetchild"} {
+		if p := cfg.Modules[name].WalkParams; p.Version != 2 || p.Retries != 0 || p.Auth.Community != "" {
+			t.Errorf("Unexpected walk params for %s %+v", name, p)
+		}
+	}
+	if base := cfg.Modules["base"]; !reflect.DeepEqual(base.Walk, []string{"a", "b"}) || base.Overrides["b"].Ignore {
+		t.Errorf("Base module was changed: %+v", base)
+	}
+
+	cases := []struct {
+		content string
+		err     string
+	}{
+		{
+			content: "modules:\n  a:\n    extends: b\n",
+			err:     "Module a extends b, which doesn't exist",
+		},
+		{
+			content: "modules:\n  a:\n    extends: a\n",
+			err:     "Modules a -> a extend each other in a cycle",
+		},
+		{
+			content: "modules:\n  a:\n    extends: b\n  b:\n    extends: c\n  c:\n    extends: b\n",
+			err:     "extend each other in a cycle",
+		},
+	}
+	for _, c := range cases {
+		_, err := parseConfig([]byte(c.content), true)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Parsing %q: got error %v, want %q", c.content, err, c.err)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+					{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR", TextualConvention: "DisplayString"},
+					{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "ifType", Type: "INTEGER", EnumValues: map[int]string{1: "other", 6: "ethernet"}},
+					{Oid: "1.1.4", Access: "ACCESS_READONLY", Label: "ifSpeed", Type: "GAUGE"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	withDefaults := `
+defaults:
+  version: 1
+  retries: 5
+  auth:
+    community: secret
+  lookups:
+  - old_index: ifIndex
+    new_index: ifDescr
+  overrides:
+    ifType:
+      type: EnumAsInfo
+    ifSpeed:
+      type: counter
+modules:
+  a:
+    walk: [ifEntry]
+  b:
+    walk: [ifEntry]
+    retries: 2
+    lookups:
+    - old_index: ifIndex
+      new_index: ifType
+    overrides:
+      ifSpeed:
+        ignore: true
+  c:
+    extends: a
+    timeout: 5s
+  d:
+    walk: [ifDescr]
+`
+	copied := `
+modules:
+  a:
+    walk: [ifEntry]
+    version: 1
+    retries: 5
+    auth:
+      community: secret
+    lookups:
+    - old_index: ifIndex
+      new_index: ifDescr
+    overrides:
+      ifType:
+        type: EnumAsInfo
+      ifSpeed:
+        type: counter
+  b:
+    walk: [ifEntry]
+    version: 1
+    retries: 2
+    auth:
+      community: secret
+    lookups:
+    - old_index: ifIndex
+      new_index: ifDescr
+    - old_index: ifIndex
+      new_index: ifType
+    overrides:
+      ifType:
+        type: EnumAsInfo
+      ifSpeed:
+        ignore: true
+  c:
+    walk: [ifEntry]
+    version: 1
+    retries: 5
+    timeout: 5s
+    auth:
+      community: secret
+    lookups:
+    - old_index: ifIndex
+      new_index: ifDescr
+    overrides:
+      ifType:
+        type: EnumAsInfo
+      ifSpeed:
+        type: counter
+  d:
+    walk: [ifDescr]
+    version: 1
+    retries: 5
+    auth:
+      community: secret
+    lookups:
+    - old_index: ifIndex
+      new_index: ifDescr
+`
+	outputs := []config.Config{}
+	for _, content := range []string{withDefaults, copied} {
+		cfg, err := parseConfig([]byte(content), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := generate(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out)
+	}
+	got, err := marshalConfig(outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := marshalConfig(outputs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Config with defaults generated:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(string(got), "labelname: ifDescr") || !strings.Contains(string(got), "retries: 2") {
+		t.Errorf("Defaults not merged into modules:\n%s", got)
+	}
+
+	// Default overrides of objects a module doesn't walk aren't warned about.
+	cfg, err := parseConfig([]byte(withDefaults), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := generateConfigModule(context.Background(), cfg.Modules["d"], node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Unexpected warnings for default overrides: %v", result.Warnings)
+	}
+}
+
+func TestGenerateModulesConcurrently(t *testing.T) {
+	// Run with -race to check the tree is only read.
+	node := &Node{Oid: "1", Label: "root"}
+	for i := 1; i <= 10; i++ {
+		entry := &Node{Oid: fmt.Sprintf("1.%d", i), Label: fmt.Sprintf("entry%d", i), Indexes: []string{fmt.Sprintf("index%d", i)}}
+		entry.Children = []*Node{
+			{Oid: entry.Oid + ".1", Access: "ACCESS_READONLY", Label: fmt.Sprintf("index%d", i), Type: "INTEGER"},
+			{Oid: entry.Oid + ".2", Access: "ACCESS_READONLY", Label: fmt.Sprintf("descr%d", i), Type: "OCTETSTR", TextualConvention: "DisplayString"},
+			{Oid: entry.Oid + ".3", Access: "ACCESS_READONLY", Label: fmt.Sprintf("octets%d", i), Type: "COUNTER"},
+		}
+		node.Children = append(node.Children, entry)
+	}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &Config{Modules: map[string]*ModuleConfig{}}
+	for i := 1; i <= 20; i++ {
+		cfg.Modules[fmt.Sprintf("module%d", i)] = &ModuleConfig{
+			Walk: []string{"root"},
+			Lookups: []*Lookup{
+				{OldIndex: fmt.Sprintf("index%d", i%10+1), NewIndex: fmt.Sprintf("descr%d", i%10+1)},
+			},
+		}
+	}
+
+	expected, err := generateModules(context.Background(), cfg, node, nameToNode, 1, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := generateModules(context.Background(), cfg, node, nameToNode, 8, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Error("Results differ when generating concurrently")
+	}
+
+	// Errors are reported for the first failing module by name.
+	cfg.Modules["module3"].Walk = []string{"missing"}
+	cfg.Modules["module7"].Walk = []string{"missing"}
+	for i := 0; i < 5; i++ {
+		_, err := generateModules(context.Background(), cfg, node, nameToNode, 8, generatorOptions{})
+		if err == nil || !strings.HasPrefix(err.Error(), "Error generating module module3:") {
+			t.Fatalf("Got error %v, want error for module3", err)
+		}
+	}
+}
+
+func TestGenerateCancellation(t *testing.T) {
+	// A wide, deep tree that takes a while to walk.
+	node := &Node{Oid: "1", Label: "root"}
+	for i := 0; i < 200; i++ {
+		entry := &Node{Oid: fmt.Sprintf("1.%d", i), Label: fmt.Sprintf("entry%d", i)}
+		for j := 0; j < 1000; j++ {
+			entry.Children = append(entry.Children, &Node{Oid: fmt.Sprintf("%s.%d", entry.Oid, j), Access: "ACCESS_READONLY", Label: fmt.Sprintf("scalar%d_%d", i, j), Type: "INTEGER"})
+		}
+		node.Children = append(node.Children, entry)
+	}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &Config{Modules: map[string]*ModuleConfig{}}
+	for i := 0; i < 50; i++ {
+		cfg.Modules[fmt.Sprintf("module%d", i)] = &ModuleConfig{Walk: []string{"root"}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := generate(ctx, cfg, node, nameToNode, generatorOptions{})
+	if err != context.Canceled {
+		t.Fatalf("Got error %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Took %s to return after cancellation", elapsed)
+	}
+
+	// Nothing is written once cancelled.
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "generator.yml")
+	if err := ioutil.WriteFile(configPath, []byte("modules:\n  test:\n    walk: [root]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(dir, "snmp.yml")
+	if _, err := generateConfig(ctx, node, nameToNode, configPath, outputPath, "", false, nil, 1, generatorOptions{}); err != context.Canceled {
+		t.Errorf("Got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Output written after cancellation: %v", err)
+	}
+}
+
+func TestGenerateConfigDeterministic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := &Node{Oid: "1", Label: "root"}
+	for i := 1; i <= 12; i++ {
+		entry := &Node{Oid: fmt.Sprintf("1.%d", i), Label: fmt.Sprintf("entry%d", i), Indexes: []string{fmt.Sprintf("index%d", i)}}
+		entry.Children = []*Node{
+			{Oid: entry.Oid + ".1", Access: "ACCESS_READONLY", Label: fmt.Sprintf("index%d", i), Type: "INTEGER"},
+			{Oid: entry.Oid + ".2", Access: "ACCESS_READONLY", Label: fmt.Sprintf("descr%d", i), Type: "OCTETSTR", TextualConvention: "DisplayString"},
+			{Oid: entry.Oid + ".10", Access: "ACCESS_READONLY", Label: fmt.Sprintf("octets%d", i), Type: "COUNTER"},
+			{Oid: entry.Oid + ".11", Access: "ACCESS_READONLY", Label: fmt.Sprintf("status%d", i), Type: "INTEGER", EnumValues: map[int]string{1: "up", 2: "down"}},
+		}
+		node.Children = append(node.Children, entry)
+	}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	configPath := filepath.Join(dir, "generator.yml")
+	content := `
+modules:
+  b:
+    walk: [entry10, entry2, entry1, entry11]
+    lookups:
+      - old_index: index2
+        new_index: descr2
+      - old_index: index1
+        new_index: descr1
+    overrides:
+      status1: {type: EnumAsStateSet}
+      octets2: {name: octets}
+      octets10: {ignore: true}
+      '~^status1[01]$': {type: EnumAsInfo}
+  a:
+    walk: [root]
+    overrides:
+      octets3: {ignore: true}
+      descr5: {ignore: true}
+      descr12: {ignore: true}
+`
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The header has the time, which may change between runs.
+	*noHeader = true
+	defer func() { *noHeader = false }()
+	var expected []byte
+	for i := 0; i < 5; i++ {
+		outputPath := filepath.Join(dir, fmt.Sprintf("snmp%d.yml", i))
+		if _, err := generateConfig(context.Background(), node, nameToNode, configPath, outputPath, "", false, nil, 4, generatorOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected == nil {
+			expected = out
+		} else if !bytes.Equal(out, expected) {
+			t.Fatalf("Output differs between runs:\n%s\n%s", expected, out)
+		}
+	}
+
+	cfg := config.Config{}
+	if err := yaml.Unmarshal(expected, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	walk := []string{"1.1", "1.2", "1.10.1", "1.10.2", "1.10.11", "1.11"}
+	if !reflect.DeepEqual(cfg["b"].Walk, walk) {
+		t.Errorf("Got walk %v, want %v", cfg["b"].Walk, walk)
+	}
+	for i, m := range cfg["a"].Metrics[1:] {
+		if !oidLess(cfg["a"].Metrics[i].Oid, m.Oid) {
+			t.Errorf("Metric %s is before %s", cfg["a"].Metrics[i].Oid, m.Oid)
+		}
+	}
+}
+
+func TestAnnotateConfig(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Module: "IF-MIB", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifIndex", Module: "IF-MIB", Access: "ACCESS_READONLY", Type: "INTEGER"},
+					{Oid: "1.1.2", Label: "ifDescr", Module: "IF-MIB", Access: "ACCESS_READONLY", Type: "OCTETSTR", TextualConvention: "DisplayString"},
+					{Oid: "1.1.6", Label: "ifHCInOctets", Module: "IF-MIB", Access: "ACCESS_READONLY", Type: "COUNTER64"},
+				}},
+			{Oid: "1.2", Label: "sysUpTime", Access: "ACCESS_READONLY", Type: "TIMETICKS"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &Config{Modules: map[string]*ModuleConfig{
+		"if-mib": {
+			Walk:    []string{"ifEntry", "1.3"},
+			Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+			// Long enough to be wrapped.
+			Overrides:        map[string]MetricOverrides{"ifDescr": {Help: strings.Repeat("Long help ", 20)}},
+			AllowUnknownOids: true,
+		},
+		"system": {Walk: []string{"sysUpTime"}},
+	}}
+	outputConfig, err := generate(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := marshalConfig(outputConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotated, err := annotateConfig(out, outputConfig, nameToNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{
+		"# if-mib: 4 metrics from IF-MIB\nif-mib:\n",
+		"  # IF-MIB::ifHCInOctets (1.1.6) COUNTER64\n  - name: ifHCInOctets\n",
+		"  # 1.3 isn't in the MIBs\n  - name: oid_1_3\n",
+		"# system: 1 metrics\nsystem:\n",
+		"  # sysUpTime (1.2) TIMETICKS\n  - name: sysUpTime\n",
+	} {
+		if !strings.Contains(string(annotated), comment) {
+			t.Errorf("Annotated config doesn't contain %q:\n%s", comment, annotated)
+		}
+	}
+	if strings.Count(string(annotated), "\n  # ") != 5 {
+		t.Errorf("Expected a comment per metric:\n%s", annotated)
+	}
+
+	got, want := config.Config{}, config.Config{}
+	if err := yaml.Unmarshal(annotated, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(out, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Annotated config parses differently:\n%s", annotated)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifIndex", Access: "ACCESS_READONLY", Type: "INTEGER"},
+					{Oid: "1.1.2", Label: "ifDescr", Access: "ACCESS_READONLY", Type: "OCTETSTR", TextualConvention: "DisplayString"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &Config{Modules: map[string]*ModuleConfig{
+		"a": {
+			Walk:       []string{"root"},
+			Lookups:    []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+			WalkParams: config.WalkParams{Auth: config.Auth{Community: "secret"}},
+		},
+		"b": {Walk: []string{"ifDescr"}},
+	}}
+	outputConfig, err := generate(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "snmp.json")
+	if err := writeOutput(outputConfig, outputPath, "", outputOptions{format: formatJSON}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Field names are the same as in YAML, and secrets are included.
+	parsed := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		t.Fatalf("Error parsing json: %s\n%s", err, out)
+	}
+	for _, field := range []string{"walk", "metrics", "auth"} {
+		if _, ok := parsed["a"][field]; !ok {
+			t.Errorf("No field %s in module a:\n%s", field, out)
+		}
+	}
+	if !strings.Contains(string(out), `"community": "secret"`) {
+		t.Errorf("Secret community missing:\n%s", out)
+	}
+	got := config.Config{}
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	yamlOut, err := marshalConfig(outputConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.Config{}
+	if err := yaml.Unmarshal(yamlOut, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON config differs from YAML config:\n%s\n%s", out, yamlOut)
+	}
+
+	outputDir := filepath.Join(dir, "modules")
+	if err := writeOutput(outputConfig, "", outputDir, outputOptions{format: formatJSON}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.json", "b.json"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
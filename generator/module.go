@@ -0,0 +1,934 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// The result of generating the config for a module.
+type moduleResult struct {
+	Module *config.Module
+	// Problems that caused metrics to be dropped.
+	Warnings []warning
+	// Every object under the walked OIDs that did not become a metric.
+	Skipped []skippedNode
+	// How many objects were skipped due to their STATUS.
+	StatusSkipped int
+	// Metrics dropped by an ignore override.
+	Ignored []skippedNode
+}
+
+// Metrics indexed by name, OID and index label, to find those an override
+// or lookup applies to without going through every metric. The metrics found
+// may not all match, so still need checking.
+type metricIndex struct {
+	position map[*config.Metric]int
+	byName   map[string][]*config.Metric
+	byOid    map[string][]*config.Metric
+	byLabel  map[string][]*config.Metric
+	// Sorted by OID, so those under an OID are together.
+	sorted []*config.Metric
+}
+
+func newMetricIndex(metrics []*config.Metric) *metricIndex {
+	m := &metricIndex{
+		position: make(map[*config.Metric]int, len(metrics)),
+		byName:   make(map[string][]*config.Metric, len(metrics)),
+		byOid:    make(map[string][]*config.Metric, len(metrics)),
+		byLabel:  map[string][]*config.Metric{},
+		sorted:   append([]*config.Metric{}, metrics...),
+	}
+	for i, metric := range metrics {
+		m.position[metric] = i
+		m.byName[metric.Name] = append(m.byName[metric.Name], metric)
+		m.byOid[metric.Oid] = append(m.byOid[metric.Oid], metric)
+		for _, index := range metric.Indexes {
+			m.addLabel(index.Labelname, metric)
+		}
+	}
+	sort.SliceStable(m.sorted, func(i, j int) bool {
+		return oidLess(m.sorted[i].Oid, m.sorted[j].Oid)
+	})
+	return m
+}
+
+// Record that a metric has an index or lookup label. Labels that are later
+// replaced aren't removed.
+func (m *metricIndex) addLabel(label string, metric *config.Metric) {
+	m.byLabel[label] = append(m.byLabel[label], metric)
+}
+
+// The metrics with an OID or under it.
+func (m *metricIndex) under(oid string) []*config.Metric {
+	prefix := oid + "."
+	metrics := []*config.Metric{}
+	i := sort.Search(len(m.sorted), func(i int) bool {
+		return !oidLess(m.sorted[i].Oid, oid)
+	})
+	for ; i < len(m.sorted) && strings.HasPrefix(m.sorted[i].Oid+".", prefix); i++ {
+		metrics = append(metrics, m.sorted[i])
+	}
+	return metrics
+}
+
+// The metrics in any of the sets, once each and in the order of the module.
+func (m *metricIndex) inOrder(sets ...[]*config.Metric) []*config.Metric {
+	seen := map[*config.Metric]bool{}
+	metrics := []*config.Metric{}
+	for _, set := range sets {
+		for _, metric := range set {
+			if !seen[metric] {
+				seen[metric] = true
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return m.position[metrics[i]] < m.position[metrics[j]]
+	})
+	return metrics
+}
+
+// Generate the config for a module. Returns an error if the module config
+// refers to objects that are not in the MIBs, or the context's error if it is
+// cancelled.
+func generateConfigModule(ctx context.Context, cfg *ModuleConfig, node *Node, nameToNode map[string]*Node, opts generatorOptions) (*moduleResult, error) {
+	out := &config.Module{WalkParams: cfg.WalkParams}
+	result := &moduleResult{Module: out, Warnings: []warning{}, Skipped: []skippedNode{}, Ignored: []skippedNode{}}
+	configuredWalk := cfg.Walk
+	cfg, mibWarnings := withMIBModuleWalks(cfg, nameToNode)
+	cfg = withInterfaceLookups(cfg, nameToNode)
+	result.Warnings = append(result.Warnings, mibWarnings...)
+
+	unknownOids := []string{}
+	isUnknown := map[string]bool{}
+	for _, oid := range cfg.Walk {
+		if isUnknownOid(cfg, oid, nameToNode) {
+			isUnknown[oid] = true
+			unknownOids = append(unknownOids, strings.TrimPrefix(oid, "."))
+		}
+	}
+
+	if cfg.AllowMissing || opts.skipMissing {
+		// Work on a copy, leaving the caller's config alone.
+		c := *cfg
+		c.Walk = []string{}
+		for _, oid := range cfg.Walk {
+			if _, ok := nameToNode[oid]; ok || isUnknownOid(cfg, oid, nameToNode) {
+				c.Walk = append(c.Walk, oid)
+				continue
+			}
+			result.Skipped = append(result.Skipped, skippedNode{Label: oid, Reason: "not found in the MIBs"})
+			result.Warnings = append(result.Warnings, warning{
+				Label:    oid,
+				Category: warnMissingOid,
+				Message:  fmt.Sprintf("Cannot find oid '%s' to walk, skipping it", oid),
+			})
+		}
+		cfg = &c
+	}
+
+	if errs := validateModuleConfig(cfg, nameToNode); len(errs) != 0 {
+		msgs := []string{}
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return nil, fmt.Errorf("Found %d errors in module config: %s", len(errs), strings.Join(msgs, "; "))
+	}
+	nameToNode = withLookupNodes(cfg, nameToNode)
+
+	result.Warnings = append(result.Warnings, ambiguousNameWarnings(cfg, nameToNode)...)
+
+	help := cfg.Help
+	if help == "" {
+		help = opts.helpMode
+	}
+	helpMax := cfg.HelpMaxLength
+	if helpMax == 0 {
+		helpMax = opts.helpMaxLength
+	}
+	maxBits := cfg.MaxBits
+	if maxBits == 0 {
+		maxBits = defaultMaxBits
+	}
+	maxEnumValuesInHelp := cfg.MaxEnumValuesInHelp
+	if maxEnumValuesInHelp == 0 {
+		maxEnumValuesInHelp = defaultMaxEnumValuesInHelp
+	}
+
+	g := &moduleGenerator{
+		cfg:                 cfg,
+		nameToNode:          nameToNode,
+		opts:                opts,
+		out:                 out,
+		result:              result,
+		help:                help,
+		helpMax:             helpMax,
+		maxBits:             maxBits,
+		maxEnumValuesInHelp: maxEnumValuesInHelp,
+		ignoreStatus: map[string]bool{
+			"STATUS_DEPRECATED": cfg.IgnoreDeprecated,
+			"STATUS_OBSOLETE":   cfg.IgnoreObsolete == nil || *cfg.IgnoreObsolete,
+		},
+		configuredWalk: configuredWalk,
+		unknownOids:    unknownOids,
+		isUnknown:      isUnknown,
+		needToWalk:     map[string]struct{}{},
+		toGet:          map[string]struct{}{},
+		metricOids:     map[string]struct{}{},
+		warnedTables:   map[string]struct{}{},
+		lookupOids:     map[string]struct{}{},
+		asSeconds:      map[*config.Metric]bool{},
+		ignored:        map[*config.Metric]string{},
+		renamed:        map[*config.Metric]MetricOverrides{},
+		scaled:         map[*config.Metric]bool{},
+	}
+	if err := g.findMetrics(ctx); err != nil {
+		return nil, err
+	}
+	if err := checkLookupChains(cfg.Lookups, nameToNode); err != nil {
+		return nil, err
+	}
+	g.metrics = newMetricIndex(out.Metrics)
+	// Lookups and overrides match the names from the MIBs, so are applied
+	// before anything is dropped or renamed.
+	g.applyLookups()
+	if err := g.applyOverrides(); err != nil {
+		return nil, err
+	}
+	g.dropIgnored()
+	g.applyFilters()
+	if err := g.nameMetrics(); err != nil {
+		return nil, err
+	}
+
+	// Sorted so the output only changes when the config or MIBs do, and
+	// before deduplicating so the same metric always gets the suffix.
+	sort.SliceStable(out.Metrics, func(i, j int) bool {
+		return oidLess(out.Metrics[i].Oid, out.Metrics[j].Oid)
+	})
+
+	// Done last, as overrides, suffixes and prefixes change names.
+	collisions := dedupeNames(out.Metrics)
+	if len(collisions) != 0 && !opts.allowCollisions {
+		msgs := []string{}
+		for _, w := range collisions {
+			msgs = append(msgs, w.Message)
+		}
+		return nil, fmt.Errorf("Found %d name collisions, use --allow-collisions to add a suffix instead: %s", len(collisions), strings.Join(msgs, "; "))
+	}
+	result.Warnings = append(result.Warnings, collisions...)
+
+	oids := []string{}
+	for k, _ := range g.needToWalk {
+		oids = append(oids, k)
+	}
+	// Remove redundant OIDs to be walked.
+	out.Walk = sortOids(minimizeOids(oids))
+	// Ignored scalars aren't got. The metrics are already in order.
+	for _, metric := range out.Metrics {
+		if _, ok := g.toGet[metric.Oid]; ok {
+			out.Get = append(out.Get, metric.Oid+".0")
+		}
+	}
+	return result, nil
+}
+
+// The state of generating a module, which passes through each stage in
+// turn.
+type moduleGenerator struct {
+	cfg        *ModuleConfig
+	nameToNode map[string]*Node
+	opts       generatorOptions
+	out        *config.Module
+	result     *moduleResult
+
+	// The module's settings, with defaults applied.
+	help                string
+	helpMax             int
+	maxBits             int
+	maxEnumValuesInHelp int
+	// Which STATUSes of objects are ignored.
+	ignoreStatus map[string]bool
+
+	// The walk as configured, before MIB module walks were added.
+	configuredWalk []string
+	// Numeric OIDs that aren't in the MIBs, to walk blind.
+	unknownOids []string
+	isUnknown   map[string]bool
+	// The known OIDs to walk, minimized.
+	toWalk []string
+	// OIDs the exporter needs to walk.
+	needToWalk map[string]struct{}
+	// Scalars to get rather than walk.
+	toGet map[string]struct{}
+	// OIDs that already have a metric, so each OID has at most one however
+	// many ways it's reached.
+	metricOids map[string]struct{}
+	// Tables already warned about, to only warn once per table.
+	warnedTables map[string]struct{}
+	// OIDs that lookups need walked, even if ignored.
+	lookupOids map[string]struct{}
+	// Metrics by their index labels, so lookups and overrides don't need to
+	// go through every metric.
+	metrics *metricIndex
+
+	// TIMETICKS to convert to seconds, which overrides can change.
+	asSeconds map[*config.Metric]bool
+	// Metrics to drop, and why.
+	ignored map[*config.Metric]string
+	// Metrics to rename, which is done after all overrides have matched
+	// the names from the MIB.
+	renamed map[*config.Metric]MetricOverrides
+	// Metrics with a scale or offset from an override.
+	scaled map[*config.Metric]bool
+}
+
+// The node of a metric, made up for those of unknown OIDs.
+func (g *moduleGenerator) metricNode(metric *config.Metric) *Node {
+	if n, ok := g.nameToNode[metric.Oid]; ok {
+		return n
+	}
+	return &Node{Oid: metric.Oid, Label: metric.Name, Type: "unknown"}
+}
+
+// Warn about an object explicitly asked for despite its status, which is kept
+// regardless.
+func (g *moduleGenerator) warnIgnoredStatus(n *Node, how string) {
+	if !g.ignoreStatus[n.Status] {
+		return
+	}
+	g.result.Warnings = append(g.result.Warnings, warning{
+		Oid:      n.Oid,
+		Label:    n.Label,
+		Category: warnIgnoredStatus,
+		Message:  fmt.Sprintf("%s is %s, but is used as it is explicitly %s", n.Label, statusName(n.Status), how),
+	})
+}
+
+// Add the metric for n, if it can be one, found by walking node.
+func (g *moduleGenerator) addMetric(node, n *Node) {
+	if _, ok := g.metricOids[n.Oid]; ok {
+		return
+	}
+	skip := func(reason string) {
+		// Tables and entries are structure rather than objects,
+		// so aren't worth reporting.
+		if len(n.Children) == 0 {
+			g.result.Skipped = append(g.result.Skipped, skippedNode{Oid: n.Oid, Label: n.Label, Reason: reason})
+		}
+	}
+	t, ok := metricType(n.Type)
+	if !ok {
+		if n.Type == "OPAQUE" && metricAccess(n.Access) {
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Oid:      n.Oid,
+				Label:    n.Label,
+				Category: warnUnknownOpaque,
+				Message:  fmt.Sprintf("Can't handle Opaque node %s with textual convention %q, only Float and Double are supported", n.Label, n.TextualConvention),
+			})
+		}
+		skip(fmt.Sprintf("unsupported type %s", n.Type))
+		return
+	}
+
+	if !metricAccess(n.Access) {
+		skip(fmt.Sprintf("inaccessible access level %s", n.Access))
+		return
+	}
+
+	if n != node && g.ignoreStatus[n.Status] && !g.ignoreStatus[node.Status] {
+		if len(n.Children) == 0 {
+			g.result.StatusSkipped++
+		}
+		skip(fmt.Sprintf("status %s", statusName(n.Status)))
+		return
+	}
+
+	indexes, err := metricIndexes(n, g.nameToNode)
+	if err != nil {
+		g.result.Warnings = append(g.result.Warnings, warning{
+			Oid:      n.Oid,
+			Label:    n.Label,
+			Category: err.(*indexError).category,
+			Message:  err.Error(),
+		})
+		skip(err.Error())
+		return
+	}
+	if index, ok := unpairedInetAddressIndex(n, g.nameToNode); ok {
+		table := tableLabel(n, g.nameToNode)
+		if _, ok := g.warnedTables[table]; !ok {
+			g.warnedTables[table] = struct{}{}
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Oid:      n.Oid,
+				Label:    n.Label,
+				Category: warnUnpairedInetAddress,
+				Message:  fmt.Sprintf("Index %s of table %s is an InetAddress without a preceding InetAddressType index, so its type is unknown", index, table),
+			})
+		}
+	}
+	metric := &config.Metric{
+		Name:    sanitizeLabelName(n.Label),
+		Oid:     n.Oid,
+		Type:    t,
+		Help:    metricHelp(n, g.help, g.helpMax, g.cfg.MIBInHelp),
+		Indexes: indexes,
+		Lookups: []*config.Lookup{},
+	}
+	// Let the exporter cap the length of strings.
+	if t == "OctetString" || t == "DisplayString" {
+		metric.MaxSize = maxSize(n)
+	}
+	// Keep the names of enumerated integers.
+	if t == "gauge" && len(n.EnumValues) != 0 {
+		metric.EnumValues = n.EnumValues
+		if g.cfg.EnumValuesInHelp == nil || *g.cfg.EnumValuesInHelp {
+			metric.Help += enumValuesHelp(n, g.maxEnumValuesInHelp)
+		}
+	}
+	// BITS with named bits become a series per bit.
+	if n.Type == "BITSTRING" && len(n.EnumValues) != 0 {
+		if len(n.EnumValues) > g.maxBits {
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Oid:      n.Oid,
+				Label:    n.Label,
+				Category: warnTooManyBits,
+				Message:  fmt.Sprintf("BITS node %s has %d named bits which is more than max_bits of %d, using OctetString", n.Label, len(n.EnumValues), g.maxBits),
+			})
+		} else {
+			metric.Type = "Bits"
+			metric.EnumValues = n.EnumValues
+		}
+	}
+	g.metricOids[n.Oid] = struct{}{}
+	g.out.Metrics = append(g.out.Metrics, metric)
+}
+
+// Add the metrics of the walked OIDs, including those not in the MIBs, and
+// of the scalars to get. Returns the context's error if it is cancelled.
+func (g *moduleGenerator) findMetrics(ctx context.Context) error {
+	// Remove redundant OIDs to be walked.
+	known := []string{}
+	for _, oid := range g.cfg.Walk {
+		// Unknown OIDs that a lookup made a node for are still walked blind.
+		if g.isUnknown[oid] || isUnknownOid(g.cfg, oid, g.nameToNode) {
+			continue
+		}
+		known = append(known, oid)
+		g.toWalk = append(g.toWalk, g.nameToNode[oid].Oid)
+	}
+	g.result.Warnings = append(g.result.Warnings, redundantWalkWarnings(known, g.configuredWalk, g.nameToNode)...)
+	g.toWalk = minimizeOids(g.toWalk)
+
+	// Find all the usable metrics.
+	for _, oid := range g.toWalk {
+		node := g.nameToNode[oid]
+		g.needToWalk[node.Oid] = struct{}{}
+		g.warnIgnoredStatus(node, "walked")
+		err := walkNodeContext(ctx, node, func(n *Node) { g.addMetric(node, n) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, oid := range g.unknownOids {
+		if _, ok := g.metricOids[oid]; ok {
+			continue
+		}
+		g.metricOids[oid] = struct{}{}
+		g.needToWalk[oid] = struct{}{}
+		g.out.Metrics = append(g.out.Metrics, &config.Metric{
+			Name:    unknownOidMetricName(oid),
+			Oid:     oid,
+			Type:    "Auto",
+			Help:    fmt.Sprintf("Objects under an oid that isn't in the MIBs - %s", oid),
+			Indexes: []*config.Index{},
+			Lookups: []*config.Lookup{},
+		})
+	}
+
+	for _, name := range g.cfg.Get {
+		n := scalarNode(name, g.nameToNode)
+		if _, ok := g.toGet[n.Oid]; ok {
+			continue
+		}
+		g.toGet[n.Oid] = struct{}{}
+		g.warnIgnoredStatus(n, "got")
+		g.addMetric(n, n)
+	}
+	return nil
+}
+
+// Add the lookups to the metrics with their old indexes.
+func (g *moduleGenerator) applyLookups() {
+	for _, lookup := range g.cfg.Lookups {
+		applied := false
+		indexNode := g.nameToNode[lookup.NewIndex]
+		typ, _ := metricType(indexNode.Type)
+		// The lookup table's indexes may be encoded differently to the
+		// metric's, such as being fixed size or IMPLIED.
+		lookupIndexes, _ := metricIndexes(indexNode, g.nameToNode)
+		for _, i := range lookupIndexes {
+			i.Labelname = sanitizeLabelName(i.Labelname)
+		}
+		addLookup := func(metric *config.Metric, sources ...string) {
+			if !applied {
+				g.warnIgnoredStatus(indexNode, "looked up")
+			}
+			applied = true
+			metric.Lookups = append(metric.Lookups, &config.Lookup{
+				Labels:            sources,
+				Labelname:         sanitizeLabelName(indexNode.Label),
+				Type:              typ,
+				Oid:               indexNode.Oid,
+				Implied:           indexNode.ImpliedIndex,
+				Indexes:           lookupIndexes,
+				DropSourceIndexes: lookup.DropSourceIndexes,
+				RegexpExtracts:    compileRegexpExtracts(lookup.RegexpExtracts),
+			})
+			// The looked up label may be the old index of a chained lookup.
+			g.metrics.addLabel(sanitizeLabelName(indexNode.Label), metric)
+			// Make sure we walk the lookup OID
+			g.needToWalk[indexNode.Oid] = struct{}{}
+			g.lookupOids[indexNode.Oid] = struct{}{}
+		}
+		if len(lookup.OldIndexes) != 0 {
+			// The looked up label is added, as no one index is replaced.
+			// Only metrics with the first of the old indexes can have all
+			// of them.
+			candidates := g.metrics.inOrder(g.metrics.byLabel[g.nameToNode[lookup.OldIndexes[0]].Label])
+		MetricLoop:
+			for _, metric := range candidates {
+				sources := []string{}
+				for _, old := range lookup.OldIndexes {
+					// The old index may be qualified by its MIB module.
+					label := g.nameToNode[old].Label
+					found := false
+					for _, index := range metric.Indexes {
+						if index.Labelname == label {
+							found = true
+						}
+					}
+					if !found {
+						log.Debugf("Not looking up %s to %s for %s, as it has no index %s", strings.Join(lookup.OldIndexes, ", "), lookup.NewIndex, metric.Name, old)
+						continue MetricLoop
+					}
+					sources = append(sources, label)
+				}
+				addLookup(metric, sources...)
+			}
+		} else {
+			// The old index may be qualified by its MIB module.
+			oldIndex := g.nameToNode[lookup.OldIndex].Label
+			candidates := g.metrics.inOrder(g.metrics.byLabel[oldIndex], g.metrics.byLabel[sanitizeLabelName(oldIndex)])
+			for _, metric := range candidates {
+				// A chained lookup uses the value from an earlier lookup, rather
+				// than an index.
+				var chained *config.Lookup
+				for _, l := range metric.Lookups {
+					if l.Labelname == sanitizeLabelName(oldIndex) {
+						chained = l
+					}
+				}
+				if chained != nil {
+					addLookup(metric, chained.Labelname)
+					continue
+				}
+				for _, index := range metric.Indexes {
+					if index.Labelname == oldIndex {
+						source := index.Labelname
+						if !lookup.DropSourceIndexes && !lookup.KeepSourceIndexes {
+							// Replace the old label, by having the index
+							// itself become the looked up label.
+							index.Labelname = sanitizeLabelName(indexNode.Label)
+							source = index.Labelname
+						}
+						addLookup(metric, source)
+						// Rows that differ only in the dropped index would
+						// become the same series.
+						if lookup.DropSourceIndexes && len(metric.Indexes) == 1 && !isIndex(indexNode) {
+							g.result.Warnings = append(g.result.Warnings, warning{
+								Oid:      metric.Oid,
+								Label:    metric.Name,
+								Category: warnNonUniqueLookup,
+								Message:  fmt.Sprintf("Dropping index %s of %s leaves only %s to tell rows apart, which isn't guaranteed to be unique", oldIndex, metric.Name, indexNode.Label),
+							})
+						}
+					}
+				}
+			}
+		}
+		if !applied && !lookup.auto {
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Label:    strings.Join(lookup.oldIndexes(), ", "),
+				Category: warnUnknownLookup,
+				Message:  fmt.Sprintf("Lookup of %s to %s doesn't match the index of any metric", strings.Join(lookup.oldIndexes(), ", "), lookup.NewIndex),
+			})
+		}
+	}
+}
+
+// Apply the overrides to the metrics they match, recording which metrics
+// they ignore, rename, scale or convert to seconds.
+func (g *moduleGenerator) applyOverrides() error {
+	for _, metric := range g.out.Metrics {
+		if g.metricNode(metric).Type == "TIMETICKS" {
+			g.asSeconds[metric] = g.cfg.TimeticksAsSeconds
+		}
+	}
+
+	// The regex override that last matched each metric.
+	regexpMatched := map[*config.Metric]string{}
+
+	// Apply module config overrides to their corresponding metrics.
+	for _, name := range overrideNames(g.cfg) {
+		params := g.cfg.Overrides[name]
+		var re *regexp.Regexp
+		if isRegexpOverride(name) {
+			re = regexp.MustCompile(name[1:])
+		}
+		qualified, ok := g.nameToNode[name]
+		if ok && !strings.Contains(name, "::") {
+			qualified = nil
+		}
+		// Ignoring an OID also ignores everything under it.
+		prefix := ""
+		if n, ok := g.nameToNode[name]; ok && params.Ignore {
+			prefix = n.Oid + "."
+		}
+		regexpExtracts := map[string][]config.RegexpExtract{}
+		for suffix, extracts := range params.RegexpExtracts {
+			regexpExtracts[suffix] = compileRegexpExtracts(extracts)
+		}
+		// Regex overrides can match any metric, others only those with
+		// the name or OID, or under the OID if ignored.
+		candidates := g.out.Metrics
+		if re == nil {
+			sets := [][]*config.Metric{g.metrics.byName[name], g.metrics.byOid[name]}
+			if qualified != nil {
+				sets = append(sets, g.metrics.byOid[qualified.Oid])
+			}
+			if prefix != "" {
+				sets = append(sets, g.metrics.under(strings.TrimSuffix(prefix, ".")))
+			}
+			candidates = g.metrics.inOrder(sets...)
+		}
+		matched := false
+		for _, metric := range candidates {
+			matches := name == metric.Name
+			if re != nil {
+				matches = re.MatchString(metric.Name) || re.MatchString(metric.Oid)
+				if earlier, ok := regexpMatched[metric]; ok && matches {
+					g.result.Warnings = append(g.result.Warnings, warning{
+						Oid:      metric.Oid,
+						Label:    metric.Name,
+						Category: warnOverlappingOverrides,
+						Message:  fmt.Sprintf("Overrides %s and %s both match %s, applying them in that order", earlier, name, metric.Name),
+					})
+				}
+				if matches {
+					regexpMatched[metric] = name
+				}
+			}
+			if params.Ignore && (matches || (prefix != "" && strings.HasPrefix(metric.Oid+".", prefix))) {
+				matched = true
+				g.ignored[metric] = fmt.Sprintf("ignored by override of %s", name)
+				continue
+			}
+			if matches || name == metric.Oid || (qualified != nil && qualified.Oid == metric.Oid) {
+				if len(regexpExtracts) != 0 {
+					metric.RegexpExtracts = regexpExtracts
+					if len(params.RegexpExtractFallbacks) != 0 {
+						metric.RegexpExtractFallbacks = params.RegexpExtractFallbacks
+					}
+				}
+				if params.TimeticksAsSeconds != nil {
+					if _, ok := g.asSeconds[metric]; !ok {
+						return fmt.Errorf("Cannot convert %s to seconds, as it isn't TIMETICKS", metric.Name)
+					}
+					g.asSeconds[metric] = *params.TimeticksAsSeconds
+				}
+				matched = true
+				if params.Name != "" {
+					g.renamed[metric] = params
+				}
+				if params.Help != "" {
+					// Help is a single line, even if the override isn't.
+					metric.Help = cleanDescription(params.Help)
+					if params.HelpIncludeOid == nil || *params.HelpIncludeOid {
+						metric.Help += " - " + metric.Oid
+					}
+				}
+				if params.Type != "" {
+					if err := overrideType(metric, g.metricNode(metric), params); err != nil {
+						return err
+					}
+				}
+				if params.Scale != nil || params.Offset != 0 {
+					if !scalableTypes[metric.Type] {
+						return fmt.Errorf("Cannot scale %s, as it is of type %s rather than gauge or counter", metric.Name, metric.Type)
+					}
+					if params.Scale != nil {
+						metric.Scale = *params.Scale
+					}
+					metric.Offset = params.Offset
+					g.scaled[metric] = true
+				}
+				// The value of a number is matched as its decimal digits,
+				// which is rarely what was meant.
+				if len(regexpExtracts) != 0 && numericTypes[metric.Type] {
+					g.result.Warnings = append(g.result.Warnings, warning{
+						Oid:      metric.Oid,
+						Label:    metric.Name,
+						Category: warnNumericRegexpExtract,
+						Message:  fmt.Sprintf("Override of %s has regex extracts for %s, which is a number of type %s rather than a string", name, metric.Name, metric.Type),
+					})
+				}
+			}
+		}
+		// The object may be in the MIBs but not walked by this module.
+		if !matched && g.cfg.defaultOverrides[name] {
+			log.Debugf("Default override of %s doesn't match any metric of the module", name)
+			continue
+		}
+		if !matched {
+			message := fmt.Sprintf("Override of %s doesn't match any metric, so isn't used", name)
+			if re != nil {
+				message = fmt.Sprintf("Regex override %s doesn't match the name or OID of any metric, so isn't used", name)
+			}
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Label:    name,
+				Category: warnUnusedOverride,
+				Message:  message,
+			})
+			continue
+		}
+		if params.Ignore && params.Help != "" {
+			g.result.Warnings = append(g.result.Warnings, warning{
+				Label:    name,
+				Category: warnUnusedOverride,
+				Message:  fmt.Sprintf("Help of override of %s isn't used, as it ignores the metrics it matches", name),
+			})
+		}
+	}
+	return nil
+}
+
+// Drop the metrics ignored by overrides, metric filters and excludes, and
+// walk around them.
+func (g *moduleGenerator) dropIgnored() {
+	// Done before renaming, as filters match the name from the MIB.
+	include := []*regexp.Regexp{}
+	for _, f := range g.cfg.MetricFilters.Include {
+		include = append(include, regexp.MustCompile(f))
+	}
+	exclude := []*regexp.Regexp{}
+	for _, f := range g.cfg.MetricFilters.Exclude {
+		exclude = append(exclude, regexp.MustCompile(f))
+	}
+	for _, metric := range g.out.Metrics {
+		if _, ok := g.ignored[metric]; ok {
+			continue
+		}
+		if reason := filterMetric(metric.Name, include, exclude); reason != "" {
+			g.ignored[metric] = reason
+		}
+	}
+
+	// Excluded subtrees are left out entirely, not just their metrics.
+	excludedOids := map[string]bool{}
+	for _, name := range g.cfg.Exclude {
+		prefix := g.nameToNode[name].Oid + "."
+		excludedOids[g.nameToNode[name].Oid] = true
+		for _, metric := range g.out.Metrics {
+			if _, ok := g.ignored[metric]; !ok && strings.HasPrefix(metric.Oid+".", prefix) {
+				g.ignored[metric] = fmt.Sprintf("excluded by %s", name)
+			}
+		}
+	}
+
+	if len(g.ignored) != 0 || len(excludedOids) != 0 {
+		ignoredOids := map[string]bool{}
+		for oid := range excludedOids {
+			ignoredOids[oid] = true
+		}
+		kept := []*config.Metric{}
+		for _, metric := range g.out.Metrics {
+			if reason, ok := g.ignored[metric]; ok {
+				ignoredOids[metric.Oid] = true
+				g.result.Ignored = append(g.result.Ignored, skippedNode{Oid: metric.Oid, Label: g.metricNode(metric).Label, Reason: reason})
+				continue
+			}
+			kept = append(kept, metric)
+		}
+		g.out.Metrics = kept
+		// Walk around the ignored metrics.
+		for _, oid := range g.toWalk {
+			if _, ok := g.lookupOids[oid]; ok {
+				continue
+			}
+			delete(g.needToWalk, oid)
+			for _, o := range walkWithout(g.nameToNode[oid], ignoredOids) {
+				g.needToWalk[o] = struct{}{}
+			}
+		}
+	}
+}
+
+// Turn the filters into the exporter's, and get the rows they keep rather
+// than walking them.
+func (g *moduleGenerator) applyFilters() {
+	// Get the rows the filters keep, rather than walking the targets. Lookups
+	// and dynamic filters still walk the columns they need.
+	kept := map[string]bool{}
+	for _, metric := range g.out.Metrics {
+		kept[metric.Oid] = true
+	}
+	filteredOids := map[string]bool{}
+	sources := map[string]bool{}
+	for _, f := range g.cfg.Filters.Dynamic {
+		sources[g.nameToNode[f.Oid].Oid] = true
+	}
+	filterColumns := func(names []string) []string {
+		oids := []string{}
+		for _, name := range names {
+			for _, column := range tableColumns(g.nameToNode[name]) {
+				_, isLookup := g.lookupOids[column.Oid]
+				if filteredOids[column.Oid] || sources[column.Oid] || isLookup || !kept[column.Oid] {
+					continue
+				}
+				filteredOids[column.Oid] = true
+				oids = append(oids, column.Oid)
+			}
+		}
+		return oids
+	}
+	for _, f := range g.cfg.Filters.Dynamic {
+		source := g.nameToNode[f.Oid]
+		values, _ := filterValues(source, f.Values)
+		filter := config.DynamicFilter{Oid: source.Oid, Targets: filterColumns(f.Targets), Values: values}
+		if len(filter.Targets) == 0 {
+			continue
+		}
+		g.out.Filters = append(g.out.Filters, filter)
+		g.needToWalk[source.Oid] = struct{}{}
+	}
+	for _, f := range g.cfg.Filters.Static {
+		filter := config.StaticFilter{Targets: filterColumns(f.Targets), Indices: f.Indices}
+		if len(filter.Targets) != 0 {
+			g.out.StaticFilters = append(g.out.StaticFilters, filter)
+		}
+	}
+	if len(filteredOids) != 0 {
+		walked := []string{}
+		for oid := range g.needToWalk {
+			walked = append(walked, oid)
+		}
+		for _, oid := range walked {
+			n, ok := g.nameToNode[oid]
+			if !ok {
+				continue
+			}
+			delete(g.needToWalk, oid)
+			for _, o := range walkWithout(n, filteredOids) {
+				g.needToWalk[o] = struct{}{}
+			}
+		}
+	}
+
+	// Lookups and filters may still need some of an excluded subtree, which
+	// the exporter walks and then discards what isn't needed.
+	for _, name := range g.cfg.Exclude {
+		n := g.nameToNode[name]
+		for oid := range g.needToWalk {
+			if strings.HasPrefix(n.Oid+".", oid+".") || strings.HasPrefix(oid+".", n.Oid+".") {
+				g.result.Warnings = append(g.result.Warnings, warning{
+					Oid:      n.Oid,
+					Label:    name,
+					Category: warnExcludedWalked,
+					Message:  fmt.Sprintf("Excluded %s can't be left out of the walk, as %s is needed, so the exporter walks and discards it", name, oid),
+				})
+				break
+			}
+		}
+	}
+}
+
+// Name the metrics as the exporter gets them: snake cased, renamed,
+// suffixed, sanitized and prefixed.
+func (g *moduleGenerator) nameMetrics() error {
+	// Done after overrides, as they match the names from the MIB. Renamed
+	// metrics have the name the user wants.
+	if g.cfg.SnakeCase || g.opts.snakeCase {
+		for _, metric := range g.out.Metrics {
+			_, ok := g.renamed[metric]
+			snakeCaseMetric(metric, !ok)
+		}
+	}
+
+	for metric, params := range g.renamed {
+		metric.Name = params.Name
+	}
+
+	// Done after overrides, as they match the name without the suffix.
+	for _, metric := range g.out.Metrics {
+		if g.asSeconds[metric] {
+			if g.scaled[metric] {
+				return fmt.Errorf("Cannot scale %s, as it is converted to seconds", g.metricNode(metric).Label)
+			}
+			// The MIB's units are ticks, so aren't used for the suffix.
+			metric.Scale = 0.01
+		}
+		// Renamed metrics have the name the user wants.
+		if _, ok := g.renamed[metric]; ok {
+			continue
+		}
+		if g.asSeconds[metric] {
+			if !strings.HasSuffix(metric.Name, "_seconds") {
+				metric.Name += "_seconds"
+			}
+		} else if g.cfg.AppendUnitSuffix {
+			metric.Name = appendUnitSuffix(metric.Name, g.metricNode(metric))
+		}
+	}
+
+	// Done after overrides, as they match the names from the MIBs, and after
+	// snake casing and suffixes, so it's what the exporter gets. Done before
+	// the prefix, which is already a valid metric name and may have colons.
+	// Metrics the user renamed have the name they want.
+	for _, metric := range g.out.Metrics {
+		if _, ok := g.renamed[metric]; !ok {
+			if !validName(sanitizeName(g.metricNode(metric).Label, g.cfg.DigitPrefix)) {
+				return fmt.Errorf("Cannot make a metric name from '%s' (%s), as it has no letters or digits. Use an override to name it", g.metricNode(metric).Label, metric.Oid)
+			}
+			if g.cfg.Prefix != "" {
+				// The prefix means the name doesn't start with a digit.
+				metric.Name = strings.TrimLeft(sanitizeName(metric.Name, "_"), "_")
+			} else {
+				metric.Name = sanitizeName(metric.Name, g.cfg.DigitPrefix)
+			}
+		}
+		if err := sanitizeMetricLabels(metric, g.cfg.DigitPrefix); err != nil {
+			return err
+		}
+	}
+
+	// Done after overrides, as they match the name without the prefix.
+	if g.cfg.Prefix != "" {
+		for _, metric := range g.out.Metrics {
+			if g.renamed[metric].NameAbsolute {
+				continue
+			}
+			metric.Name = g.cfg.Prefix + "_" + metric.Name
+		}
+	}
+	return nil
+}
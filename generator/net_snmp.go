@@ -5,6 +5,8 @@ package main
 #cgo CFLAGS: -I/usr/local/include
 #include <net-snmp/net-snmp-config.h>
 #include <net-snmp/mib_api.h>
+#include <net-snmp/version.h>
+#include <stdlib.h>
 #include <unistd.h>
 // From parse.c
 #define MAXTC   4096
@@ -32,6 +34,25 @@ int get_tc_fixed_size(int tc_index) {
   return ranges->low;
 }
 
+// Return the ranges of a textual convention, or NULL if there are none.
+struct range_list *get_tc_ranges(int tc_index) {
+	if (tc_index < 0 || tc_index >= MAXTC) {
+    return NULL;
+  }
+  return tclist[tc_index].ranges;
+}
+
+// Return the name of a MIB module, or "" if it is unknown.
+char *get_module_name(int modid) {
+  static char name[256];
+  module_name(modid, name);
+  // Unknown modules are named like "#-1".
+  if (name[0] == '#') {
+    return "";
+  }
+  return name;
+}
+
 */
 import "C"
 
@@ -39,25 +60,40 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"unsafe"
 
 	"github.com/prometheus/common/log"
 )
 
 // One entry in the tree of the MIB.
 type Node struct {
-	Oid               string
-	Label             string
-	Augments          string
-	Children          []*Node
-	Description       string
-	Type              string
-	Hint              string
-	TextualConvention string
-	FixedSize         int
-	Units             string
-	Access            string
-
-	Indexes []string
+	Oid               string         `json:"oid"`
+	Label             string         `json:"label"`
+	Augments          string         `json:"augments,omitempty"`
+	Children          []*Node        `json:"children,omitempty"`
+	Description       string         `json:"description,omitempty"`
+	Type              string         `json:"type,omitempty"`
+	Hint              string         `json:"hint,omitempty"`
+	TextualConvention string         `json:"textual_convention,omitempty"`
+	FixedSize         int            `json:"fixed_size,omitempty"`
+	Units             string         `json:"units,omitempty"`
+	Access            string         `json:"access,omitempty"`
+	Status            string         `json:"status,omitempty"`
+	Module            string         `json:"module,omitempty"`
+	EnumValues        map[int]string `json:"enum_values,omitempty"`
+	// SIZE constraints for strings, or value constraints for integers.
+	Ranges []Range `json:"ranges,omitempty"`
+
+	Indexes []string `json:"indexes,omitempty"`
+	// Whether the last index is IMPLIED, so has no length in the OID.
+	ImpliedIndex bool `json:"implied_index,omitempty"`
+}
+
+// A range of sizes or values that an object can have.
+type Range struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
 }
 
 // Adapted from parse.h.
@@ -97,16 +133,56 @@ var (
 		67: "ACCESS_NOTIFY",
 		48: "ACCESS_CREATE",
 	}
+	netSnmpstatusMap = map[int]string{
+		23: "STATUS_MANDATORY",
+		24: "STATUS_OPTIONAL",
+		25: "STATUS_OBSOLETE",
+		39: "STATUS_DEPRECATED",
+		57: "STATUS_CURRENT",
+	}
 )
 
+// Options controlling how NetSNMP loads MIBs. The zero value loads all
+// MIBs found in NetSNMP's usual directories.
+type snmpOptions struct {
+	// Directories to load MIBs from, in addition to NetSNMP's usual
+	// directories.
+	MIBDirs []string
+	// MIB modules to load. If empty, all MIBs found are loaded.
+	MIBs []string
+	// Don't load MIBs from NetSNMP's usual directories, only from MIBDirs.
+	NoSystemMIBs bool
+	// Keep descriptions as written, rather than cleaning them up as the
+	// tree is built.
+	RawDescriptions bool
+}
+
 // Initilise NetSNMP. Returns MIB parse errors.
 //
 // Warning: This function plays with the stderr file descriptor.
-func initSNMP() string {
-	// Load all the MIBs.
-	os.Setenv("MIBS", "ALL")
+func initSNMP(opts snmpOptions) (string, error) {
+	if err := checkMIBDirs(opts.MIBDirs); err != nil {
+		return "", err
+	}
+	if opts.NoSystemMIBs || len(opts.MIBDirs) != 0 {
+		path := strings.Join(opts.MIBDirs, ":")
+		if !opts.NoSystemMIBs {
+			// A leading + appends to the existing directories.
+			path = "+" + path
+		}
+		dirs := C.CString(path)
+		C.netsnmp_set_mib_directory(dirs)
+		C.free(unsafe.Pointer(dirs))
+	}
+
+	if len(opts.MIBs) != 0 {
+		os.Setenv("MIBS", strings.Join(opts.MIBs, ":"))
+	} else {
+		// Load all the MIBs.
+		os.Setenv("MIBS", "ALL")
+	}
 	// Help the user find their MIB directories.
-	log.Infof("Loading MIBs from %s", C.GoString(C.netsnmp_get_mib_directory()))
+	log.Infof("Loading MIBs from %s", mibDirectory())
 	// We want the descriptions.
 	C.snmp_set_save_descriptions(1)
 
@@ -115,7 +191,7 @@ func initSNMP() string {
 	// way to disable or redirect.
 	r, w, err := os.Pipe()
 	if err != nil {
-		log.Fatalf("Error creating pipe: %s", err)
+		return "", fmt.Errorf("Error creating pipe: %s", err)
 	}
 	defer r.Close()
 	defer w.Close()
@@ -139,11 +215,35 @@ func initSNMP() string {
 	C.close(2)
 	C.dup2(savedStderrFd, 2)
 	C.close(savedStderrFd)
-	return <-ch
+	return <-ch, nil
+}
+
+// Check that user provided MIB directories exist.
+func checkMIBDirs(dirs []string) error {
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("MIB directory %s does not exist: %s", dir, err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("MIB directory %s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// The directories NetSNMP loads MIBs from, separated by colons.
+func mibDirectory() string {
+	return C.GoString(C.netsnmp_get_mib_directory())
+}
+
+// The version of the NetSNMP library in use.
+func netSnmpVersion() string {
+	return C.GoString(C.netsnmp_get_version())
 }
 
 // Walk NetSNMP MIB tree, building a Go tree from it.
-func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
+func buildMIBTree(t *C.struct_tree, n *Node, oid string, strs stringInterner, rawDescriptions bool) {
 	if oid != "" {
 		n.Oid = fmt.Sprintf("%s.%d", oid, t.subid)
 	} else {
@@ -162,14 +262,47 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 		n.Access = "unknown"
 	}
 
+	if status, ok := netSnmpstatusMap[int(t.status)]; ok {
+		n.Status = status
+	} else {
+		n.Status = "unknown"
+	}
+
+	n.Module = C.GoString(C.get_module_name(t.modid))
 	n.Augments = C.GoString(t.augments)
 	n.Description = C.GoString(t.description)
 	n.Hint = C.GoString(t.hint)
 	n.TextualConvention = C.GoString(C.get_tc_descriptor(t.tc_index))
 	n.FixedSize = int(C.get_tc_fixed_size(t.tc_index))
 	n.Units = C.GoString(t.units)
+	// Descriptions are most of the memory of a large tree, so are cleaned
+	// up now rather than kept as written until prepareTree.
+	if !rawDescriptions {
+		n.Description = cleanDescription(n.Description)
+		n.Units = cleanDescription(n.Units)
+	}
+
+	enum := t.enums
+	if enum != nil {
+		n.EnumValues = map[int]string{}
+		for enum != nil {
+			n.EnumValues[int(enum.value)] = C.GoString(enum.label)
+			enum = enum.next
+		}
+	}
+
+	// Fall back to the textual convention's ranges.
+	ranges := t.ranges
+	if ranges == nil {
+		ranges = C.get_tc_ranges(t.tc_index)
+	}
+	for ranges != nil {
+		n.Ranges = append(n.Ranges, Range{Low: int(ranges.low), High: int(ranges.high)})
+		ranges = ranges.next
+	}
 
 	if t.child_list == nil {
+		internNode(n, strs)
 		return
 	}
 
@@ -177,11 +310,14 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 	n.Children = []*Node{}
 	for head != nil {
 		child := &Node{}
-		// Prepend, as nodes are backwards.
-		n.Children = append([]*Node{child}, n.Children...)
-		buildMIBTree(head, child, n.Oid)
+		n.Children = append(n.Children, child)
+		buildMIBTree(head, child, n.Oid, strs, rawDescriptions)
 		head = head.next_peer
 	}
+	// Reverse, as nodes are backwards.
+	for i, j := 0, len(n.Children)-1; i < j; i, j = i+1, j-1 {
+		n.Children[i], n.Children[j] = n.Children[j], n.Children[i]
+	}
 
 	// Set names of indexes on each child.
 	// In practice this means only the entry will have it.
@@ -189,16 +325,19 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 	indexes := []string{}
 	for index != nil {
 		indexes = append(indexes, C.GoString(index.ilabel))
+		// Only the last index can be IMPLIED.
+		n.ImpliedIndex = index.isimplied != 0
 		index = index.next
 	}
 	n.Indexes = indexes
+	internNode(n, strs)
 }
 
-// Convert the NetSNMP MIB tree to a Go data structure.
-func getMIBTree() *Node {
-
+// Convert the NetSNMP MIB tree to a Go data structure. Descriptions are
+// cleaned up unless rawDescriptions.
+func getMIBTree(rawDescriptions bool) *Node {
 	tree := C.get_tree_head()
 	head := &Node{}
-	buildMIBTree(tree, head, "")
+	buildMIBTree(tree, head, "", stringInterner{}, rawDescriptions)
 	return head
 }
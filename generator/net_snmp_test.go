@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMIB = `TEST-MIB DEFINITIONS ::= BEGIN
+
+testRoot OBJECT IDENTIFIER ::= { iso 99 }
+testChild OBJECT IDENTIFIER ::= { testRoot 1 }
+
+END
+`
+
+func TestInitSNMPMIBDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "TEST-MIB.txt"), []byte(testMIB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initSNMP(snmpOptions{MIBDirs: []string{filepath.Join(dir, "missing")}}); err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "missing")) {
+		t.Errorf("Expected error naming missing MIB directory, got %v", err)
+	}
+
+	if _, err := initSNMP(snmpOptions{MIBDirs: []string{dir}, MIBs: []string{"TEST-MIB"}}); err != nil {
+		t.Fatal(err)
+	}
+	nameToNode, _ := prepareTree(getMIBTree(false), generatorOptions{})
+	n, ok := nameToNode["testChild"]
+	if !ok {
+		t.Fatal("testChild not loaded from test MIB")
+	}
+	if n.Oid != "1.99.1" {
+		t.Errorf("testChild: got oid %s, want 1.99.1", n.Oid)
+	}
+}
+
+// A MAC address TC without a DISPLAY-HINT, as in some vendor MIBs.
+const testMacMIB = `TEST-MAC-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    OBJECT-TYPE FROM SNMPv2-SMI
+    TEXTUAL-CONVENTION FROM SNMPv2-TC;
+
+MacAddr ::= TEXTUAL-CONVENTION
+    STATUS      current
+    DESCRIPTION "A MAC address."
+    SYNTAX      OCTET STRING (SIZE (6))
+
+testMacRoot OBJECT IDENTIFIER ::= { iso 98 }
+
+testMac OBJECT-TYPE
+    SYNTAX      MacAddr
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The MAC address."
+    ::= { testMacRoot 1 }
+
+END
+`
+
+func TestMacAddressTC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "TEST-MAC-MIB.txt"), []byte(testMacMIB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initSNMP(snmpOptions{MIBDirs: []string{dir}, MIBs: []string{"TEST-MAC-MIB"}}); err != nil {
+		t.Fatal(err)
+	}
+	nameToNode, _ := prepareTree(getMIBTree(false), generatorOptions{})
+	n, ok := nameToNode["testMac"]
+	if !ok {
+		t.Fatal("testMac not loaded from test MIB")
+	}
+	if n.Hint != "" {
+		t.Errorf("testMac: got hint %q, want none", n.Hint)
+	}
+	if n.Type != "PhysAddress48" || n.FixedSize != 6 {
+		t.Errorf("testMac: got type %s with fixed size %d, want PhysAddress48 with 6", n.Type, n.FixedSize)
+	}
+}
+
+func TestInitSNMPNoSystemMIBs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "TEST-MIB.txt"), []byte(testMIB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initSNMP(snmpOptions{MIBDirs: []string{dir}, MIBs: []string{"TEST-MIB"}, NoSystemMIBs: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mibDirectory(); got != dir {
+		t.Errorf("MIB directory: got %q, want %q", got, dir)
+	}
+	nameToNode, _ := prepareTree(getMIBTree(false), generatorOptions{})
+	if _, ok := nameToNode["testChild"]; !ok {
+		t.Error("testChild not loaded from test MIB")
+	}
+}
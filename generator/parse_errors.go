@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single error reported by NetSNMP while parsing MIBs.
+type parseError struct {
+	Module  string `json:"module"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+var (
+	// "...: At line 73 in /usr/share/snmp/mibs/SNMPv2-PDU"
+	parseErrorAtLineRE = regexp.MustCompile(`^(.*): At line (\d+) in (.*)$`)
+	// "Undefined identifier: mib-2 near line 18 of /usr/share/snmp/mibs/IPATM-IPMC-MIB.txt"
+	parseErrorNearLineRE = regexp.MustCompile(`^(.*) near line (\d+) of (.*)$`)
+	// "Did not find 'ifIndex' in module #-1 (/usr/share/snmp/mibs/IF-MIB.txt)"
+	parseErrorInModuleRE = regexp.MustCompile(`^(.*) in module (\S+) \((.*)\)$`)
+	// "Unlinked OID in IPATM-IPMC-MIB: marsMIB ::= { mib-2 57 }"
+	parseErrorOidInRE = regexp.MustCompile(`^(?:Unlinked|Cannot adopt) OID in ([^:]+):`)
+	// "Cannot find module (IANAifType-MIB): At line 0 in (none)"
+	parseErrorFindModuleRE = regexp.MustCompile(`^Cannot find module \(([^)]+)\)`)
+)
+
+// Parse the error output of NetSNMP into structured errors.
+func parseNetSNMPErrors(output string) []parseError {
+	errs := []parseError{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		e := parseError{Message: line}
+		if m := parseErrorAtLineRE.FindStringSubmatch(line); m != nil {
+			e.Message, e.File = m[1], m[3]
+			e.Line, _ = strconv.Atoi(m[2])
+		} else if m := parseErrorNearLineRE.FindStringSubmatch(line); m != nil {
+			e.Message, e.File = m[1], m[3]
+			e.Line, _ = strconv.Atoi(m[2])
+		} else if m := parseErrorInModuleRE.FindStringSubmatch(line); m != nil {
+			e.Message, e.File = m[1], m[3]
+			if !strings.HasPrefix(m[2], "#") {
+				e.Module = m[2]
+			}
+		}
+		if e.File == "(none)" {
+			e.File = ""
+		}
+
+		if m := parseErrorOidInRE.FindStringSubmatch(line); m != nil {
+			e.Module = m[1]
+		} else if m := parseErrorFindModuleRE.FindStringSubmatch(line); m != nil {
+			e.Module = m[1]
+		}
+		if e.Module == "" && e.File != "" {
+			// MIB files are conventionally named after the module they define.
+			e.Module = strings.TrimSuffix(filepath.Base(e.File), filepath.Ext(e.File))
+		}
+		if e.Module == "" {
+			e.Module = "unknown"
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Group parse errors by MIB module, keeping only modules matching the
+// filter if it is not nil.
+func groupParseErrors(errs []parseError, filter *regexp.Regexp) map[string][]parseError {
+	groups := map[string][]parseError{}
+	for _, e := range errs {
+		if filter != nil && !filter.MatchString(e.Module) {
+			continue
+		}
+		groups[e.Module] = append(groups[e.Module], e)
+	}
+	return groups
+}
+
+// Write out grouped parse errors, ordered by module name.
+func writeParseErrors(w io.Writer, groups map[string][]parseError) error {
+	modules := []string{}
+	for module := range groups {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if _, err := fmt.Fprintf(w, "%s (%d errors)\n", module, len(groups[module])); err != nil {
+			return err
+		}
+		for _, e := range groups[module] {
+			location := e.File
+			if e.Line != 0 {
+				location = fmt.Sprintf("%s:%d", e.File, e.Line)
+			}
+			if location != "" {
+				location += ": "
+			}
+			if _, err := fmt.Fprintf(w, "  %s%s\n", location, e.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check whether the number of parse errors is acceptable. A max of less than
+// zero means there is no limit.
+func checkParseErrors(errs []parseError, failOnAny bool, max int) error {
+	if failOnAny && len(errs) > 0 {
+		return fmt.Errorf("NetSNMP reported %d parse errors", len(errs))
+	}
+	if max >= 0 && len(errs) > max {
+		return fmt.Errorf("NetSNMP reported %d parse errors, more than the maximum of %d", len(errs), max)
+	}
+	return nil
+}
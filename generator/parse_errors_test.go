@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// Captured from NetSNMP 5.7 with a typical MIB directory.
+const sampleParseErrors = `Cannot find module (IANAifType-MIB): At line 0 in (none)
+Did not find 'ifIndex' in module #-1 (/usr/share/snmp/mibs/IF-MIB.txt)
+Unlinked OID in IPATM-IPMC-MIB: marsMIB ::= { mib-2 57 }
+Undefined identifier: mib-2 near line 18 of /usr/share/snmp/mibs/IPATM-IPMC-MIB.txt
+Bad operator (INTEGER): At line 73 in /usr/share/mibs/ietf/SNMPv2-PDU
+Cannot adopt OID in CISCO-PROCESS-MIB: cpmCPUTotalEntry ::= { cpmCPUTotalTable 1 }
+Warning: Upper bound not handled correctly (0xffffffff != -1): At line 40 in /usr/share/snmp/mibs/CISCO-TC.my
+Something unexpected
+`
+
+func TestParseNetSNMPErrors(t *testing.T) {
+	expected := []parseError{
+		{Module: "IANAifType-MIB", Message: "Cannot find module (IANAifType-MIB)"},
+		{Module: "IF-MIB", File: "/usr/share/snmp/mibs/IF-MIB.txt", Message: "Did not find 'ifIndex'"},
+		{Module: "IPATM-IPMC-MIB", Message: "Unlinked OID in IPATM-IPMC-MIB: marsMIB ::= { mib-2 57 }"},
+		{Module: "IPATM-IPMC-MIB", File: "/usr/share/snmp/mibs/IPATM-IPMC-MIB.txt", Line: 18, Message: "Undefined identifier: mib-2"},
+		{Module: "SNMPv2-PDU", File: "/usr/share/mibs/ietf/SNMPv2-PDU", Line: 73, Message: "Bad operator (INTEGER)"},
+		{Module: "CISCO-PROCESS-MIB", Message: "Cannot adopt OID in CISCO-PROCESS-MIB: cpmCPUTotalEntry ::= { cpmCPUTotalTable 1 }"},
+		{Module: "CISCO-TC", File: "/usr/share/snmp/mibs/CISCO-TC.my", Line: 40, Message: "Warning: Upper bound not handled correctly (0xffffffff != -1)"},
+		{Module: "unknown", Message: "Something unexpected"},
+	}
+	got := parseNetSNMPErrors(sampleParseErrors)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseNetSNMPErrors: got\n%+v\nwant\n%+v", got, expected)
+	}
+}
+
+func TestWriteParseErrors(t *testing.T) {
+	groups := groupParseErrors(parseNetSNMPErrors(sampleParseErrors), regexp.MustCompile("^(?:IPATM-.*|IF-MIB)$"))
+	buf := &bytes.Buffer{}
+	if err := writeParseErrors(buf, groups); err != nil {
+		t.Fatal(err)
+	}
+	expected := `IF-MIB (1 errors)
+  /usr/share/snmp/mibs/IF-MIB.txt: Did not find 'ifIndex'
+IPATM-IPMC-MIB (2 errors)
+  Unlinked OID in IPATM-IPMC-MIB: marsMIB ::= { mib-2 57 }
+  /usr/share/snmp/mibs/IPATM-IPMC-MIB.txt:18: Undefined identifier: mib-2
+`
+	if buf.String() != expected {
+		t.Errorf("writeParseErrors: got\n%s\nwant\n%s", buf, expected)
+	}
+}
+
+func TestCheckParseErrors(t *testing.T) {
+	errs := parseNetSNMPErrors(sampleParseErrors)
+	cases := []struct {
+		errs      []parseError
+		failOnAny bool
+		max       int
+		fail      bool
+	}{
+		{errs: errs, failOnAny: false, max: -1, fail: false},
+		{errs: errs, failOnAny: true, max: -1, fail: true},
+		{errs: []parseError{}, failOnAny: true, max: -1, fail: false},
+		{errs: errs, failOnAny: false, max: 8, fail: false},
+		{errs: errs, failOnAny: false, max: 7, fail: true},
+		{errs: errs, failOnAny: false, max: 0, fail: true},
+	}
+	for i, c := range cases {
+		err := checkParseErrors(c.errs, c.failOnAny, c.max)
+		if (err != nil) != c.fail {
+			t.Errorf("checkParseErrors: case %d got error %v, wanted failure %v", i, err, c.fail)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// The SNMP requests the test command makes, so that it can be tested against
+// a simulated agent.
+type snmpClient interface {
+	// Get all the values under an oid.
+	walk(oid string) ([]gosnmp.SnmpPDU, error)
+	// Get the values of oids, which are noSuchObject or noSuchInstance if
+	// the agent doesn't have them.
+	get(oids []string) ([]gosnmp.SnmpPDU, error)
+	// How many requests have been sent, including retries.
+	requests() int
+}
+
+// A net.Conn counting the packets written to it.
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(b)
+}
+
+// An snmpClient talking to a device, configured as the exporter would be.
+type gosnmpClient struct {
+	snmp *gosnmp.GoSNMP
+	conn *countingConn
+}
+
+// Connect to a target, which is a host with an optional port, using the walk
+// parameters of a module.
+func dialTarget(target string, module *config.Module) (*gosnmpClient, error) {
+	snmp := &gosnmp.GoSNMP{}
+	snmp.MaxRepetitions = module.WalkParams.MaxRepetitions
+	// The timeout is for each retry, but gosnmp wants the total.
+	snmp.Retries = module.WalkParams.Retries
+	snmp.Timeout = module.WalkParams.Timeout * time.Duration(snmp.Retries)
+	snmp.Target = target
+	snmp.Port = 161
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		snmp.Target = host
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("Error converting port number to int for target %s: %s", target, err)
+		}
+		snmp.Port = uint16(p)
+	}
+	module.WalkParams.ConfigureSNMP(snmp)
+	if err := snmp.Connect(); err != nil {
+		return nil, fmt.Errorf("Error connecting to target %s: %s", target, err)
+	}
+	conn := &countingConn{Conn: snmp.Conn}
+	snmp.Conn = conn
+	return &gosnmpClient{snmp: snmp, conn: conn}, nil
+}
+
+func (c *gosnmpClient) walk(oid string) ([]gosnmp.SnmpPDU, error) {
+	if c.snmp.Version == gosnmp.Version1 {
+		return c.snmp.WalkAll(oid)
+	}
+	return c.snmp.BulkWalkAll(oid)
+}
+
+func (c *gosnmpClient) get(oids []string) ([]gosnmp.SnmpPDU, error) {
+	pdus := []gosnmp.SnmpPDU{}
+	for i := 0; i < len(oids); i += c.snmp.MaxOids {
+		end := i + c.snmp.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		packet, err := c.snmp.Get(oids[i:end])
+		if err != nil {
+			return nil, err
+		}
+		if packet.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("error status %d", packet.Error)
+		}
+		pdus = append(pdus, packet.Variables...)
+	}
+	return pdus, nil
+}
+
+func (c *gosnmpClient) requests() int {
+	return c.conn.writes
+}
+
+func (c *gosnmpClient) close() error {
+	return c.conn.Close()
+}
+
+// What a device returned for a metric.
+type metricCoverage struct {
+	Name string
+	Oid  string
+	// How many values were returned, one for each row of a table.
+	Values int
+	// noSuchObject or noSuchInstance, if a get returned that rather than a
+	// value.
+	Missing string
+	// How many values had indexes that didn't match the metric's, and why
+	// the first didn't.
+	BadIndexes int
+	IndexError string
+}
+
+// What a device returned for a module.
+type coverageReport struct {
+	Metrics []*metricCoverage
+	// The number of rows returned for each table, by the oid of its entry.
+	Tables   map[string]int
+	Requests int
+	Values   int
+	Duration time.Duration
+}
+
+// The percentage of metrics that the device returned values for.
+func (r *coverageReport) coverage() float64 {
+	if len(r.Metrics) == 0 {
+		return 100
+	}
+	covered := 0
+	for _, m := range r.Metrics {
+		if m.Values != 0 {
+			covered++
+		}
+	}
+	return 100 * float64(covered) / float64(len(r.Metrics))
+}
+
+// Do the walks and gets of a module as the exporter would, and report which
+// metrics got values. The targets of dynamic filters are walked in full, as
+// which rows they'd select doesn't change whether the device has them.
+func probeModule(client snmpClient, module *config.Module) (*coverageReport, error) {
+	start := time.Now()
+	pdus := []gosnmp.SnmpPDU{}
+	walk := append([]string{}, module.Walk...)
+	for _, filter := range module.Filters {
+		walk = append(walk, filter.Targets...)
+	}
+	for _, oid := range walk {
+		result, err := client.walk(oid)
+		if err != nil {
+			return nil, fmt.Errorf("Error walking %s: %s", oid, err)
+		}
+		pdus = append(pdus, result...)
+	}
+	get := append([]string{}, module.Get...)
+	for _, filter := range module.StaticFilters {
+		for _, index := range filter.Indices {
+			for _, target := range filter.Targets {
+				get = append(get, target+"."+index)
+			}
+		}
+	}
+	if len(get) != 0 {
+		result, err := client.get(get)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting %s: %s", strings.Join(get, ", "), err)
+		}
+		pdus = append(pdus, result...)
+	}
+
+	report := &coverageReport{Tables: map[string]int{}}
+	byOid := map[string]*metricCoverage{}
+	indexes := map[string][]*config.Index{}
+	for _, metric := range module.Metrics {
+		m := &metricCoverage{Name: metric.Name, Oid: metric.Oid}
+		report.Metrics = append(report.Metrics, m)
+		byOid[metric.Oid] = m
+		indexes[metric.Oid] = metric.Indexes
+	}
+	rows := map[string]map[string]bool{}
+	for _, pdu := range pdus {
+		oid := strings.TrimPrefix(pdu.Name, ".")
+		// The metric is the longest prefix of the oid that is one.
+		prefix := oid
+		for byOid[prefix] == nil && strings.Contains(prefix, ".") {
+			prefix = prefix[:strings.LastIndex(prefix, ".")]
+		}
+		m := byOid[prefix]
+		if m == nil {
+			continue
+		}
+		switch pdu.Type {
+		case gosnmp.NoSuchObject:
+			m.Missing = "noSuchObject"
+			continue
+		case gosnmp.NoSuchInstance:
+			m.Missing = "noSuchInstance"
+			continue
+		}
+		m.Values++
+		report.Values++
+		if len(indexes[prefix]) != 0 && prefix != oid {
+			index := oid[len(prefix)+1:]
+			if err := checkIndexes(indexes[prefix], oidToList(index)); err != nil {
+				if m.BadIndexes == 0 {
+					m.IndexError = fmt.Sprintf("%s: %s", index, err)
+				}
+				m.BadIndexes++
+			}
+			entry := prefix[:strings.LastIndex(prefix, ".")]
+			if rows[entry] == nil {
+				rows[entry] = map[string]bool{}
+			}
+			rows[entry][index] = true
+		}
+	}
+	for entry, indexes := range rows {
+		report.Tables[entry] = len(indexes)
+	}
+	report.Requests = client.requests()
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// Print a coverage report, listing each metric and table.
+func writeCoverageReport(w io.Writer, module string, report *coverageReport) {
+	covered := 0
+	for _, m := range report.Metrics {
+		if m.Values != 0 {
+			covered++
+		}
+	}
+	fmt.Fprintf(w, "Module %s: %d of %d metrics returned values (%.1f%%)\n", module, covered, len(report.Metrics), report.coverage())
+	for _, m := range report.Metrics {
+		switch {
+		case m.BadIndexes != 0:
+			fmt.Fprintf(w, "  bad      %s %s: %d of %d values have indexes that can't be decoded, such as %s\n", m.Name, m.Oid, m.BadIndexes, m.Values, m.IndexError)
+		case m.Values != 0:
+			fmt.Fprintf(w, "  ok       %s %s: %d values\n", m.Name, m.Oid, m.Values)
+		case m.Missing != "":
+			fmt.Fprintf(w, "  missing  %s %s: %s\n", m.Name, m.Oid, m.Missing)
+		default:
+			fmt.Fprintf(w, "  missing  %s %s: no values\n", m.Name, m.Oid)
+		}
+	}
+	if len(report.Tables) != 0 {
+		entries := make([]string, 0, len(report.Tables))
+		for entry := range report.Tables {
+			entries = append(entries, entry)
+		}
+		sortOids(entries)
+		fmt.Fprintln(w, "Tables:")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "  %s: %d rows\n", entry, report.Tables[entry])
+		}
+	}
+	fmt.Fprintf(w, "Sent %d requests and got %d values in %s\n", report.Requests, report.Values, report.Duration)
+}
+
+// Check that the oids of an index match the indexes of a metric, as the
+// exporter decodes them.
+func checkIndexes(indexes []*config.Index, oids []int) error {
+	// The previous index, for InetAddress types following an
+	// InetAddressType index.
+	var prev []int
+	for _, index := range indexes {
+		length := 0
+		switch {
+		case index.Implied && index.FixedSize == 0:
+			length = len(oids)
+		case index.FixedSize != 0:
+			length = index.FixedSize
+		case index.Type == "InetAddress":
+			if len(oids) < 2 {
+				return fmt.Errorf("index %s needs a type and a length", index.Labelname)
+			}
+			length = 2 + oids[1]
+		case index.Type == "TypedInetAddress" && len(prev) != 1:
+			return fmt.Errorf("index %s doesn't follow an InetAddressType index", index.Labelname)
+		case indexHasLength(index.Type) || index.Type == "TypedInetAddress":
+			if len(oids) < 1 {
+				return fmt.Errorf("index %s needs a length", index.Labelname)
+			}
+			length = 1 + oids[0]
+		case index.Type == "PhysAddress48":
+			length = 6
+		case index.Type == "IpAddr":
+			length = 4
+		case index.Type == "InetAddressIPv6":
+			length = 16
+		default:
+			length = 1
+		}
+		if length > len(oids) {
+			return fmt.Errorf("index %s needs %d oids, but only %d are left", index.Labelname, length, len(oids))
+		}
+		prev, oids = oids[:length], oids[length:]
+	}
+	if len(oids) != 0 {
+		return fmt.Errorf("%d oids are left over after the indexes", len(oids))
+	}
+	return nil
+}
+
+// Whether an index of a type starts with its length, unless it is fixed size
+// or IMPLIED.
+func indexHasLength(typ string) bool {
+	switch typ {
+	case "OctetString", "DisplayString", "DateAndTime":
+		return true
+	}
+	return false
+}
+
+// Convert a numeric oid to its arcs.
+func oidToList(oid string) []int {
+	result := []int{}
+	for _, x := range strings.Split(oid, ".") {
+		o, _ := strconv.Atoi(x)
+		result = append(result, o)
+	}
+	return result
+}
+
+// Walk a target, or the values captured from one in walkFile, with a module
+// of an snmp_exporter config, printing which metrics it has. Returns false if
+// fewer than minCoverage percent did. Names in walkFile are looked up in
+// nameToNode.
+func testTarget(w io.Writer, configPath, moduleName, target, walkFile string, nameToNode map[string]*Node, params testParams, minCoverage float64) (bool, error) {
+	cfg, err := config.LoadFile(configPath)
+	if err != nil {
+		return false, fmt.Errorf("Error loading config %s: %s", configPath, err)
+	}
+	module, ok := (*cfg)[moduleName]
+	if !ok {
+		names := make([]string, 0, len(*cfg))
+		for name := range *cfg {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return false, fmt.Errorf("Module %s is not in %s, which has: %s", moduleName, configPath, strings.Join(names, ", "))
+	}
+	if err := params.apply(&module.WalkParams); err != nil {
+		return false, err
+	}
+	var client snmpClient
+	if walkFile != "" {
+		f, err := os.Open(walkFile)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		pdus, err := ParseWalkFile(f, nameToNode)
+		if err != nil {
+			return false, fmt.Errorf("Error parsing walk file %s: %s", walkFile, err)
+		}
+		client = &capturedAgent{pdus: pdus}
+		target = walkFile
+	} else {
+		c, err := dialTarget(target, module)
+		if err != nil {
+			return false, err
+		}
+		defer c.close()
+		client = c
+	}
+	report, err := probeModule(client, module)
+	if err != nil {
+		return false, fmt.Errorf("Error testing target %s: %s", target, err)
+	}
+	writeCoverageReport(w, moduleName, report)
+	return report.coverage() >= minCoverage, nil
+}
+
+// Walk parameters given on the command line, overriding the module's.
+type testParams struct {
+	// 0 to use the module's.
+	Version int
+	// Empty to use the module's.
+	Community string
+}
+
+func (p testParams) apply(params *config.WalkParams) error {
+	if p.Version < 0 || p.Version > 3 {
+		return fmt.Errorf("SNMP version must be 1, 2 or 3, got %d", p.Version)
+	}
+	if p.Version != 0 {
+		params.Version = p.Version
+	}
+	if p.Community != "" {
+		params.Auth.Community = config.Secret(p.Community)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// A capturedAgent with integer values.
+func integerAgent(values map[string]int) *capturedAgent {
+	pdus := map[string]gosnmp.SnmpPDU{}
+	for oid, v := range values {
+		pdus[oid] = gosnmp.SnmpPDU{Name: "." + oid, Type: gosnmp.Integer, Value: v}
+	}
+	return &capturedAgent{pdus: pdus}
+}
+
+func TestProbeModule(t *testing.T) {
+	agent := integerAgent(map[string]int{
+		"1.1.0":     1,
+		"1.2.1.1.1": 1,
+		"1.2.1.1.2": 2,
+		"1.2.1.1.3": 3,
+		"1.2.1.2.1": 10,
+		"1.2.1.2.3": 30,
+		"1.3.1.1.5": 5,
+		"1.3.1.1.6": 6,
+	})
+	index := []*config.Index{{Labelname: "idx", Type: "gauge"}}
+	module := &config.Module{
+		Walk: []string{"1.2"},
+		Get:  []string{"1.1.0", "1.4.0"},
+		Metrics: []*config.Metric{
+			{Name: "scalar", Oid: "1.1", Type: "gauge"},
+			{Name: "column1", Oid: "1.2.1.1", Type: "gauge", Indexes: index},
+			{Name: "column2", Oid: "1.2.1.2", Type: "gauge", Indexes: index},
+			{Name: "column3", Oid: "1.2.1.3", Type: "gauge", Indexes: index},
+			{Name: "filtered", Oid: "1.3.1.1", Type: "gauge", Indexes: index},
+			{Name: "absent", Oid: "1.4", Type: "gauge"},
+		},
+		StaticFilters: []config.StaticFilter{{Targets: []string{"1.3.1.1"}, Indices: []string{"5", "7"}}},
+	}
+
+	report, err := probeModule(agent, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*metricCoverage{
+		{Name: "scalar", Oid: "1.1", Values: 1},
+		{Name: "column1", Oid: "1.2.1.1", Values: 3},
+		{Name: "column2", Oid: "1.2.1.2", Values: 2},
+		{Name: "column3", Oid: "1.2.1.3"},
+		{Name: "filtered", Oid: "1.3.1.1", Values: 1, Missing: "noSuchObject"},
+		{Name: "absent", Oid: "1.4", Missing: "noSuchObject"},
+	}
+	if !reflect.DeepEqual(report.Metrics, expected) {
+		for _, m := range report.Metrics {
+			t.Logf("%+v", m)
+		}
+		t.Errorf("Unexpected metric coverage")
+	}
+	if !reflect.DeepEqual(report.Tables, map[string]int{"1.2.1": 3, "1.3.1": 1}) {
+		t.Errorf("Unexpected table rows %v", report.Tables)
+	}
+	if report.Requests != 2 || report.Values != 7 {
+		t.Errorf("Unexpected totals: %d requests, %d values", report.Requests, report.Values)
+	}
+	if report.coverage() != 200.0/3 {
+		t.Errorf("Unexpected coverage %f", report.coverage())
+	}
+
+	buf := &bytes.Buffer{}
+	writeCoverageReport(buf, "test", report)
+	for _, line := range []string{
+		"Module test: 4 of 6 metrics returned values (66.7%)\n",
+		"  ok       column1 1.2.1.1: 3 values\n",
+		"  missing  column3 1.2.1.3: no values\n",
+		"  missing  absent 1.4: noSuchObject\n",
+		"  1.2.1: 3 rows\n",
+		"Sent 2 requests and got 7 values in ",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Report is missing %q:\n%s", line, buf)
+		}
+	}
+}
+
+func TestTestParams(t *testing.T) {
+	params := config.DefaultWalkParams
+	if err := (testParams{}).apply(&params); err != nil || !reflect.DeepEqual(params, config.DefaultWalkParams) {
+		t.Errorf("Empty params changed walk params: %v %+v", err, params)
+	}
+	if err := (testParams{Version: 1, Community: "private"}).apply(&params); err != nil {
+		t.Fatal(err)
+	}
+	if params.Version != 1 || params.Auth.Community != "private" {
+		t.Errorf("Params not applied: %+v", params)
+	}
+	if err := (testParams{Version: 4}).apply(&params); err == nil {
+		t.Error("Expected error for SNMP version 4")
+	}
+}
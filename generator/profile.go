@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/common/log"
+)
+
+// Start the profiling asked for, returning a function that writes the
+// profiles out. The function is safe to call more than once, and is also
+// called if the generator is interrupted, so commands that run until they're
+// stopped such as generate --watch and serve still write their profiles.
+func startProfiling(cpuPath, memPath, httpAddr string) (func(), error) {
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", httppprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+		log.Infof("Serving profiles on %s", httpAddr)
+		go func() {
+			if err := http.ListenAndServe(httpAddr, mux); err != nil {
+				log.Errorf("Error serving profiles: %s", err)
+			}
+		}()
+	}
+
+	var cpuFile *os.File
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating CPU profile: %s", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error starting CPU profile: %s", err)
+		}
+		cpuFile = f
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(interrupted)
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				if err := cpuFile.Close(); err != nil {
+					log.Errorf("Error writing CPU profile: %s", err)
+				}
+			}
+			if memPath != "" {
+				if err := writeHeapProfile(memPath); err != nil {
+					log.Errorf("Error writing heap profile: %s", err)
+				}
+			}
+		})
+	}
+
+	if cpuPath != "" || memPath != "" {
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupted
+			stop()
+			os.Exit(1)
+		}()
+	}
+	return stop, nil
+}
+
+// Write a heap profile of what's still in use to a file.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Only count what's still reachable.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpuPath := filepath.Join(dir, "cpu.pprof")
+	memPath := filepath.Join(dir, "mem.pprof")
+
+	stop, err := startProfiling(cpuPath, memPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A failing command still has its profiles written.
+	if err := run(testCommand.FullCommand()); err == nil {
+		t.Fatal("Expected an error from the test command without --target or --walk-file")
+	}
+	stop()
+	stop()
+	for _, path := range []string{cpuPath, memPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("Profile %s is empty", path)
+		}
+	}
+
+	if _, err := startProfiling(filepath.Join(dir, "missing", "cpu.pprof"), "", ""); err == nil {
+		t.Error("Expected an error creating a CPU profile in a missing directory")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/common/log"
+)
+
+// Serves config generation over HTTP, using a MIB tree that is loaded once.
+type server struct {
+	nodes       *Node
+	nameToNode  map[string]*Node
+	opts        generatorOptions
+	parseErrors []parseError
+
+	// NetSNMP and config marshalling rely on global state, so only one
+	// request may use them at a time.
+	mtx sync.Mutex
+}
+
+// An error returned to HTTP clients.
+type httpError struct {
+	Error   string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate", s.generate)
+	mux.HandleFunc("/dump", s.dump)
+	mux.HandleFunc("/parse_errors", s.parseErrorsHandler)
+	return mux
+}
+
+func writeError(w http.ResponseWriter, code int, err string, details []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(httpError{Error: err, Details: details})
+}
+
+// Generate a snmp.yml from a generator.yml in the request body.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST is supported", nil)
+		return
+	}
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error reading request body: %s", err), nil)
+		return
+	}
+	cfg, err := parseConfig(content, !*noStrict)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error parsing yml config: %s", err), nil)
+		return
+	}
+	problems := []string{}
+	for name, m := range cfg.Modules {
+		for _, err := range validateModuleConfig(m, s.nameToNode) {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(problems) != 0 {
+		writeError(w, http.StatusBadRequest, "Invalid generator config", problems)
+		return
+	}
+
+	s.mtx.Lock()
+	outputConfig, err := generate(r.Context(), cfg, s.nodes, s.nameToNode, s.opts)
+	if err != nil {
+		s.mtx.Unlock()
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	out, err := marshalConfig(outputConfig)
+	s.mtx.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(out)
+}
+
+// Dump the prepared MIB tree, optionally restricted to the given oids.
+func (s *server) dump(w http.ResponseWriter, r *http.Request) {
+	roots := []*Node{s.nodes}
+	if oids := r.URL.Query()["oid"]; len(oids) != 0 {
+		var err error
+		roots, err = lookupNodes(oids, s.nameToNode)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" && format != "ndjson" && format != "dot" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown dump format %q", format), nil)
+		return
+	}
+	// Buffer the output so errors can still be reported properly.
+	buf := &bytes.Buffer{}
+	if err := dumpNodes(buf, roots, format, s.nameToNode, nil); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(buf.Bytes())
+}
+
+// Return the MIB parse errors reported by NetSNMP on startup.
+func (s *server) parseErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.parseErrors); err != nil {
+		log.Errorf("Error writing parse errors: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestServer(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "first"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	s := &server{nodes: node, nameToNode: nameToNode, parseErrors: parseNetSNMPErrors(sampleParseErrors)}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/generate", "application/x-yaml", strings.NewReader("modules:\n  test:\n    walk: [first]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Generate: got status %d: %s", resp.StatusCode, body)
+	}
+	cfg := config.Config{}
+	if err := yaml.Unmarshal(body, &cfg); err != nil {
+		t.Fatalf("Error parsing generated config: %s", err)
+	}
+	if len(cfg["test"].Metrics) != 1 {
+		t.Errorf("Generate: unexpected config %s", body)
+	}
+
+	errorCases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		error  string
+	}{
+		{method: "GET", path: "/generate", code: http.StatusMethodNotAllowed, error: "Only POST is supported"},
+		{method: "POST", path: "/generate", body: "modules: [", code: http.StatusBadRequest, error: "Error parsing yml config"},
+		{method: "POST", path: "/generate", body: "modules:\n  test:\n    walk: [missing]\n", code: http.StatusBadRequest, error: "Invalid generator config"},
+		{method: "GET", path: "/dump?oid=missing", code: http.StatusBadRequest, error: "Cannot find oid 'missing'"},
+		{method: "GET", path: "/dump?format=xml", code: http.StatusBadRequest, error: "Unknown dump format"},
+	}
+	for _, c := range errorCases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := httpError{}
+		err = json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s %s: error decoding JSON error: %s", c.method, c.path, err)
+		}
+		if resp.StatusCode != c.code || !strings.HasPrefix(e.Error, c.error) {
+			t.Errorf("%s %s: got %d %q, want %d %q", c.method, c.path, resp.StatusCode, e.Error, c.code, c.error)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/dump?oid=first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(body), "1.1 first INTEGER") {
+		t.Errorf("Dump: unexpected output %q", body)
+	}
+
+	resp, err = http.Get(ts.URL + "/parse_errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := []parseError{}
+	err = json.NewDecoder(resp.Body).Decode(&errs)
+	resp.Body.Close()
+	if err != nil || len(errs) != 8 {
+		t.Errorf("Parse errors: got %d errors, err %v", len(errs), err)
+	}
+}
@@ -1,101 +1,575 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/prometheus/common/log"
 
 	"github.com/prometheus/snmp_exporter/config"
 )
 
+// Walk MIB nodes in pre-order, passing each node and its parent to f. The
+// root's parent is nil. Walking stops if f returns false. This doesn't
+// recurse, so very deep trees can't exhaust the stack.
+func walkTree(n *Node, f func(parent, n *Node) bool) {
+	type item struct {
+		parent, node *Node
+	}
+	stack := []item{{nil, n}}
+	for len(stack) != 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !f(i.parent, i.node) {
+			return
+		}
+		// Push in reverse so children are visited in order.
+		for j := len(i.node.Children) - 1; j >= 0; j-- {
+			stack = append(stack, item{i.node, i.node.Children[j]})
+		}
+	}
+}
+
 // Helper to walk MIB nodes.
 func walkNode(n *Node, f func(n *Node)) {
-	f(n)
-	for _, c := range n.Children {
-		walkNode(c, f)
+	walkTree(n, func(_, n *Node) bool {
+		f(n)
+		return true
+	})
+}
+
+// Helper to walk MIB nodes, also passing the parent of each node.
+func walkNodeWithParent(n *Node, f func(parent, n *Node)) {
+	walkTree(n, func(parent, n *Node) bool {
+		f(parent, n)
+		return true
+	})
+}
+
+// How many nodes walkNodeContext visits between checks for cancellation.
+const walkCheckInterval = 1000
+
+// Like walkNode, but stops walking and returns the context's error once it
+// is cancelled.
+func walkNodeContext(ctx context.Context, n *Node, f func(n *Node)) error {
+	var err error
+	visited := 0
+	walkTree(n, func(_, n *Node) bool {
+		visited++
+		if visited%walkCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		f(n)
+		return true
+	})
+	return err
+}
+
+// Categories of warnings.
+const (
+	warnMissingAugment       = "missing-augment"
+	warnAugmentCycle         = "augment-cycle"
+	warnUnknownOpaque        = "unknown-opaque"
+	warnTooManyBits          = "too-many-bits"
+	warnUnpairedInetAddress  = "unpaired-inet-address"
+	warnMissingIndex         = "missing-index"
+	warnUnsupportedIndexType = "unsupported-index-type"
+	warnUnknownLookup        = "unknown-lookup"
+	warnIgnoredStatus        = "ignored-status"
+	warnNameCollision        = "name-collision"
+	warnAmbiguousName        = "ambiguous-name"
+	warnNameConflict         = "name-conflict"
+	warnUnusedOverride       = "unused-override"
+	warnNonUniqueLookup      = "non-unique-lookup"
+	warnMissingOid           = "missing-oid"
+	warnNumericRegexpExtract = "numeric-regex-extract"
+	warnExcludedWalked       = "excluded-walked"
+	warnOverlappingOverrides = "overlapping-overrides"
+	warnRedundantWalk        = "redundant-walk"
+	warnDuplicateWalk        = "duplicate-walk"
+)
+
+// A problem found while preparing the tree or generating a module that did
+// not stop generation.
+type warning struct {
+	Module   string `json:"module,omitempty"`
+	Oid      string `json:"oid,omitempty"`
+	Label    string `json:"label,omitempty"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+func (w warning) String() string {
+	if w.Module != "" {
+		return fmt.Sprintf("Module %s: %s", w.Module, w.Message)
 	}
+	return w.Message
 }
 
-// Transform the tree
-func prepareTree(nodes *Node) map[string]*Node {
-	// Build a map from names and oids to nodes.
-	nameToNode := map[string]*Node{}
+// Transform the tree. Returns a map from names, MIB qualified names such as
+// IF-MIB::ifIndex and oids to nodes, and any warnings about problems found.
+// Other than counting the nodes, the tree is walked twice: once to build the
+// map and fix up each node on its own, and once to copy indexes, which needs
+// the map and the parents' final indexes.
+func prepareTree(nodes *Node, opts generatorOptions) (map[string]*Node, []warning) {
+	warnings := []warning{}
+	// Build a map from names and oids to nodes. Which node a name defined
+	// by more than one MIB module maps to depends on opts.onNameConflict.
+	// Each node has an oid, a name and usually a qualified name. Sizing the
+	// map up front saves growing it many times over.
+	count := 0
 	walkNode(nodes, func(n *Node) {
-		nameToNode[n.Oid] = n
-		nameToNode[n.Label] = n
+		count++
 	})
-
-	// Trim down description to first sentance, removing extra whitespace.
+	nameToNode := make(map[string]*Node, 3*count)
+	conflicts := map[string][]*Node{}
+	hints := hintKinds{}
 	walkNode(nodes, func(n *Node) {
-		s := strings.Join(strings.Fields(n.Description), " ")
-		n.Description = strings.Split(s, ". ")[0]
+		nameToNode[n.Oid] = n
+		if n.Module != "" {
+			nameToNode[qualifiedName(n)] = n
+		}
+		if existing, ok := nameToNode[n.Label]; ok && existing.Oid != n.Oid {
+			if len(conflicts[n.Label]) == 0 {
+				conflicts[n.Label] = []*Node{existing}
+			}
+			conflicts[n.Label] = append(conflicts[n.Label], n)
+			if opts.onNameConflict != nameConflictFirst {
+				nameToNode[n.Label] = n
+			}
+		} else {
+			nameToNode[n.Label] = n
+		}
+
+		// Clean up descriptions and units, as they end up in help.
+		n.Description = cleanDescription(n.Description)
+		n.Units = cleanDescription(n.Units)
+
+		fixIndexes(n)
+		fixType(n, hints)
 	})
+	warnings = append(warnings, nameConflictWarnings(conflicts, nameToNode)...)
 
-	// Fix indexes to "INTEGER" rather than an object name.
-	// Example: snSlotsEntry in LANOPTICS-HUB-MIB
-	walkNode(nodes, func(n *Node) {
-		indexes := []string{}
-		for _, i := range n.Indexes {
-			if i == "INTEGER" {
-				// Use the TableEntry name.
-				indexes = append(indexes, n.Label)
+	// Copy over indexes based on augments, following chains of augments,
+	// and from table entries down to their columns. Parents are visited
+	// first, so already have their final indexes.
+	walkNodeWithParent(nodes, func(parent, n *Node) {
+		if n.Augments != "" {
+			entry, w := augmentedEntry(n, nameToNode)
+			if w == nil {
+				n.Indexes = entry.Indexes
+				n.ImpliedIndex = entry.ImpliedIndex
 			} else {
-				indexes = append(indexes, i)
+				warnings = append(warnings, *w)
 			}
 		}
-		n.Indexes = indexes
+		if parent != nil && len(parent.Indexes) != 0 {
+			n.Indexes = parent.Indexes
+			n.ImpliedIndex = parent.ImpliedIndex
+		}
 	})
 
-	// Copy over indexes based on augments.
-	walkNode(nodes, func(n *Node) {
-		if n.Augments == "" {
-			return
+	return nameToNode, warnings
+}
+
+// Fix indexes to "INTEGER" rather than an object name.
+// Example: snSlotsEntry in LANOPTICS-HUB-MIB
+func fixIndexes(n *Node) {
+	indexes := []string{}
+	for _, i := range n.Indexes {
+		if i == "INTEGER" {
+			// Use the TableEntry name.
+			indexes = append(indexes, n.Label)
+		} else {
+			indexes = append(indexes, i)
 		}
-		augmented, ok := nameToNode[n.Augments]
-		if !ok {
-			log.Warnf("Can't find augmenting oid %s for %s", n.Augments, n.Label)
-			return
+	}
+	n.Indexes = indexes
+}
+
+// The kinds of display hints already classified. Most nodes with a hint
+// share one of a few, so each is only parsed once.
+type hintKinds map[string]string
+
+func (h hintKinds) classify(hint string) string {
+	kind, ok := h[hint]
+	if !ok {
+		kind = classifyDisplayHint(hint)
+		h[hint] = kind
+	}
+	return kind
+}
+
+// Include both ASCII and UTF-8 in DisplayString, even though DisplayString
+// is technically only ASCII.
+var displayStringRe = regexp.MustCompile(`\d+[at]`)
+
+// Set type on MAC addresses, strings, dates, IP addresses, floats and
+// booleans.
+func fixType(n *Node, hints hintKinds) {
+	// A single SIZE means a fixed size, even if NetSNMP didn't find
+	// it from the textual convention.
+	if n.Type == "OCTETSTR" && n.FixedSize == 0 && len(n.Ranges) == 1 && n.Ranges[0].Low == n.Ranges[0].High {
+		n.FixedSize = n.Ranges[0].Low
+	}
+
+	// RFC 2579. Most nodes have no hint, so don't parse one for them.
+	if n.Hint != "" {
+		if kind := hints.classify(n.Hint); kind != hintUnknown {
+			if t := displayHintType(n, kind); t != "" {
+				n.Type = t
+			}
+		} else {
+			// Hints that can't be parsed are checked heuristically.
+			switch n.Hint {
+			case "1x:":
+				n.Type = "PhysAddress48"
+			}
+			if displayStringRe.MatchString(n.Hint) {
+				n.Type = "DisplayString"
+			}
 		}
-		for _, c := range n.Children {
-			c.Indexes = augmented.Indexes
+	}
+
+	// Some MIBs refer to RFC1213 for this, which is too
+	// old to have the right hint set.
+	if n.TextualConvention == "DisplayString" {
+		n.Type = "DisplayString"
+	}
+
+	// Some MIBs define their own MAC address TC without a hint, or
+	// with one NetSNMP doesn't pass on.
+	switch n.TextualConvention {
+	case "MacAddress", "MacAddr", "MACAddress", "MacAddressType":
+		if n.Type == "OCTETSTR" {
+			n.Type = "PhysAddress48"
 		}
-		n.Indexes = augmented.Indexes
-	})
+	}
 
-	// Copy indexes from table entries down to the entries.
-	walkNode(nodes, func(n *Node) {
-		if len(n.Indexes) != 0 {
-			for _, c := range n.Children {
-				c.Indexes = n.Indexes
+	// IPv6 addresses are plain strings as far as SMI is concerned.
+	switch n.TextualConvention {
+	case "InetAddressIPv6", "Ipv6Address":
+		n.Type = "InetAddressIPv6"
+		n.FixedSize = 16
+	}
+
+	// Floats are Opaque wrapped, as SNMP has no float type.
+	// Example: Float in UCD-SNMP-MIB
+	if n.Type == "OPAQUE" {
+		switch n.TextualConvention {
+		case "Float":
+			n.Type = "FLOAT"
+		case "Double":
+			n.Type = "DOUBLE"
+		}
+	}
+
+	// TruthValue is 1 for true and 2 for false.
+	if n.TextualConvention == "TruthValue" && n.Type == "INTEGER" {
+		n.Type = "TruthValue"
+	}
+}
+
+// How much of an object's description is used as the help of its metric.
+const (
+	helpFull          = "full"
+	helpFirstSentence = "first_sentence"
+	helpNone          = "none"
+)
+
+var (
+	// Words that end with a full stop without ending the sentence.
+	abbreviations = map[string]bool{
+		"e.g": true,
+		"i.e": true,
+		"no":  true,
+		"etc": true,
+		"vs":  true,
+	}
+)
+
+// Descriptions with no sentence boundary are cut to about this many bytes.
+const maxSentenceLength = 200
+
+// The first sentence of a description with normalised whitespace. Full stops
+// after common abbreviations and inside parentheses don't end the sentence.
+func firstSentence(s string) string {
+	depth := 0
+	wordStart := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ' ':
+			wordStart = i + 1
+		case '.':
+			if depth != 0 || i+1 == len(s) || s[i+1] != ' ' {
+				continue
 			}
+			if abbreviations[strings.ToLower(s[wordStart:i])] {
+				continue
+			}
+			return s[:i]
 		}
-	})
+	}
 
-	// Include both ASCII and UTF-8 in DisplayString, even though DisplayString
-	// is technically only ASCII.
-	displayStringRe := regexp.MustCompile(`\d+[at]`)
+	if len(s) <= maxSentenceLength {
+		return s
+	}
+	// Cut at a word boundary, or failing that a character boundary.
+	cut := strings.LastIndex(s[:maxSentenceLength], " ")
+	if cut <= 0 {
+		cut = maxSentenceLength
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+	}
+	return s[:cut] + "..."
+}
 
-	// Set type on MAC addresses and strings.
-	walkNode(nodes, func(n *Node) {
-		// RFC 2579
-		switch n.Hint {
-		case "1x:":
-			n.Type = "PhysAddress48"
+// The help text for the metric of a node, including its units, and its MIB
+// module if withMIB. An empty mode means the first sentence. Help longer than
+// maxLength characters is cut short, always keeping the MIB module and OID,
+// unless maxLength is 0.
+func metricHelp(n *Node, mode string, maxLength int, withMIB bool) string {
+	var help string
+	switch mode {
+	case helpFull:
+		help = n.Description
+	case helpNone:
+		return n.Oid
+	default:
+		help = firstSentence(n.Description)
+	}
+	if n.Units != "" {
+		help += " (unit: " + n.Units + ")"
+	}
+	suffix := " - " + n.Oid
+	if withMIB && n.Module != "" {
+		suffix = " [" + n.Module + "]" + suffix
+	}
+	if maxLength == 0 {
+		return help + suffix
+	}
+	maxLength -= utf8.RuneCountInString(suffix)
+	if utf8.RuneCountInString(help) <= maxLength {
+		return help + suffix
+	}
+	if maxLength <= len("...") {
+		return n.Oid
+	}
+	// Count in runes, so as not to split a character.
+	return string([]rune(help)[:maxLength-len("...")]) + "..." + suffix
+}
+
+// The meanings of a node's enumerated values for help, such as
+// ": 1=up 2=down", listing at most max of them.
+func enumValuesHelp(n *Node, max int) string {
+	values := make([]int, 0, len(n.EnumValues))
+	for v := range n.EnumValues {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	parts := []string{}
+	for i, v := range values {
+		if i == max {
+			parts = append(parts, "...")
+			break
 		}
-		if displayStringRe.MatchString(n.Hint) {
-			n.Type = "DisplayString"
+		parts = append(parts, fmt.Sprintf("%d=%s", v, n.EnumValues[v]))
+	}
+	return ": " + strings.Join(parts, " ")
+}
+
+// Append a node's units to a metric name, unless the name already ends with
+// them.
+func appendUnitSuffix(name string, n *Node) string {
+	suffix := strings.Trim(sanitizeLabelName(n.Units), "_")
+	if suffix == "" || strings.HasSuffix(strings.ToLower(name), strings.ToLower(suffix)) {
+		return name
+	}
+	return name + "_" + suffix
+}
+
+// Convert a camelCase name to snake_case, keeping runs of capitals such as
+// acronyms together, so ifHCInOctets becomes if_hc_in_octets.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	out := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && runes[i-1] != '_' {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				out = append(out, '_')
+			}
 		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return string(out)
+}
 
-		// Some MIBs refer to RFC1213 for this, which is too
-		// old to have the right hint set.
-		if n.TextualConvention == "DisplayString" {
-			n.Type = "DisplayString"
+// Convert the label names of a metric and its lookups to snake_case, and its
+// name unless withName is false. The suffixes of regex extracts follow the
+// name, so that Temp becomes _temp.
+func snakeCaseMetric(metric *config.Metric, withName bool) {
+	for _, index := range metric.Indexes {
+		index.Labelname = snakeCase(index.Labelname)
+	}
+	for _, lookup := range metric.Lookups {
+		lookup.Labelname = snakeCase(lookup.Labelname)
+		for i, label := range lookup.Labels {
+			lookup.Labels[i] = snakeCase(label)
 		}
-	})
+		for _, index := range lookup.Indexes {
+			index.Labelname = snakeCase(index.Labelname)
+		}
+	}
+	if !withName {
+		return
+	}
+	name := snakeCase(metric.Name)
+	suffix := func(s string) string {
+		if full := snakeCase(metric.Name + s); strings.HasPrefix(full, name) {
+			return full[len(name):]
+		}
+		return s
+	}
+	if len(metric.RegexpExtracts) != 0 {
+		extracts := map[string][]config.RegexpExtract{}
+		for s, e := range metric.RegexpExtracts {
+			extracts[suffix(s)] = e
+		}
+		metric.RegexpExtracts = extracts
+	}
+	if len(metric.RegexpExtractFallbacks) != 0 {
+		fallbacks := map[string]string{}
+		for s, f := range metric.RegexpExtractFallbacks {
+			fallbacks[suffix(s)] = f
+		}
+		metric.RegexpExtractFallbacks = fallbacks
+	}
+	metric.Name = name
+}
+
+// The types an override can set, by the kind of value the exporter gets for
+// them. A metric can only be overridden to a type of the same kind.
+var overrideTypeKinds = map[string]string{
+	"gauge":           "integer",
+	"counter":         "integer",
+	"Bool":            "integer",
+	"EnumAsInfo":      "integer",
+	"EnumAsStateSet":  "integer",
+	"OctetString":     "string",
+	"DisplayString":   "string",
+	"PhysAddress48":   "string",
+	"IpAddr":          "string",
+	"InetAddressIPv6": "string",
+	"DateAndTime":     "string",
+}
+
+// Metric types the exporter applies scale and offset to.
+var scalableTypes = map[string]bool{
+	"gauge":   true,
+	"counter": true,
+}
+
+// Metric types whose values are plain numbers.
+var numericTypes = map[string]bool{
+	"gauge":   true,
+	"counter": true,
+	"Float":   true,
+	"Double":  true,
+}
+
+// Change the type of a metric as an override asks.
+func overrideType(metric *config.Metric, n *Node, params MetricOverrides) error {
+	kind, ok := overrideTypeKinds[metric.Type]
+	if !ok || kind != overrideTypeKinds[params.Type] {
+		return fmt.Errorf("Cannot override %s from %s to %s", n.Label, metric.Type, params.Type)
+	}
+	switch params.Type {
+	case "EnumAsInfo", "EnumAsStateSet":
+		if len(n.EnumValues) == 0 {
+			return fmt.Errorf("Cannot override %s to %s, as it has no enumerations", n.Label, params.Type)
+		}
+		if params.Type == "EnumAsStateSet" {
+			maxStates := params.MaxStates
+			if maxStates == 0 {
+				maxStates = defaultMaxStates
+			}
+			if len(n.EnumValues) > maxStates {
+				return fmt.Errorf("Cannot override %s to %s, as it has %d enumerations which is more than max_states of %d", n.Label, params.Type, len(n.EnumValues), maxStates)
+			}
+		}
+		metric.EnumValues = n.EnumValues
+	case "gauge":
+		// Keeps the names of enumerated integers, including TruthValues.
+		metric.EnumValues = n.EnumValues
+	case "OctetString", "DisplayString":
+		metric.EnumValues = nil
+		metric.MaxSize = maxSize(n)
+	default:
+		metric.EnumValues = nil
+		metric.MaxSize = 0
+	}
+	metric.Type = params.Type
+	return nil
+}
+
+// The most states an EnumAsStateSet metric can have by default, as each is
+// a time series.
+const defaultMaxStates = 100
 
-	return nameToNode
+// The most enumerated values listed in help by default.
+const defaultMaxEnumValuesInHelp = 10
+
+// The most named bits a Bits metric can have by default, as each is a time
+// series.
+const defaultMaxBits = 64
+
+// Follow a chain of augments from an entry to the entry it ultimately
+// augments, which has the indexes. Returns a warning if the chain is broken
+// or loops.
+func augmentedEntry(n *Node, nameToNode map[string]*Node) (*Node, *warning) {
+	chain := []string{n.Label}
+	seen := map[*Node]bool{n: true}
+	entry := n
+	for entry.Augments != "" {
+		augmented, ok := nameToNode[entry.Augments]
+		if !ok {
+			return nil, &warning{
+				Oid:      n.Oid,
+				Label:    n.Label,
+				Category: warnMissingAugment,
+				Message:  fmt.Sprintf("Can't find augmenting oid %s for %s", entry.Augments, entry.Label),
+			}
+		}
+		chain = append(chain, augmented.Label)
+		if seen[augmented] {
+			return nil, &warning{
+				Oid:      n.Oid,
+				Label:    n.Label,
+				Category: warnAugmentCycle,
+				Message:  fmt.Sprintf("Augments of %s form a cycle: %s", n.Label, strings.Join(chain, " -> ")),
+			}
+		}
+		seen[augmented] = true
+		entry = augmented
+	}
+	return entry, nil
 }
 
 func metricType(t string) (string, bool) {
@@ -111,7 +585,13 @@ func metricType(t string) (string, bool) {
 	case "NETADDR":
 		// TODO: Not sure about this one.
 		return "InetAddress", true
-	case "PhysAddress48", "DisplayString":
+	case "FLOAT":
+		return "Float", true
+	case "DOUBLE":
+		return "Double", true
+	case "TruthValue":
+		return "Bool", true
+	case "PhysAddress48", "DisplayString", "InetAddressIPv6", "DateAndTime":
 		return t, true
 	default:
 		// Unsupported type.
@@ -143,112 +623,1358 @@ func minimizeOids(oids []string) []string {
 	return minimized
 }
 
-func generateConfigModule(cfg *ModuleConfig, node *Node, nameToNode map[string]*Node) *config.Module {
-	out := &config.Module{}
-	needToWalk := map[string]struct{}{}
+// Warnings about entries of a module's walk that are ignored, as they're
+// listed more than once or are under another entry. The walk is after MIB
+// modules are expanded to their objects, and its entries must all be in the
+// MIBs. The walk as configured is used to name the MIB module entries that
+// objects came from.
+func redundantWalkWarnings(walk, configured []string, nameToNode map[string]*Node) []warning {
+	listed := map[string]bool{}
+	for _, entry := range configured {
+		listed[entry] = true
+	}
+	// The entry as configured, and whether it's a MIB module.
+	name := func(entry string) (string, bool) {
+		if listed[entry] {
+			return entry, false
+		}
+		for _, c := range configured {
+			if strings.TrimPrefix(c, mibModulePrefix) == nameToNode[entry].Module {
+				return c, true
+			}
+		}
+		return entry, false
+	}
+	// The entry as configured, or the object and its MIB module.
+	describe := func(entry string) string {
+		n, module := name(entry)
+		if module {
+			return fmt.Sprintf("%s of %s", nameToNode[entry].Label, n)
+		}
+		return n
+	}
 
-	// Remove redundant OIDs to be walked.
-	toWalk := []string{}
-	for _, oid := range cfg.Walk {
-		node, ok := nameToNode[oid]
+	warnings := []warning{}
+	reported := map[string]bool{}
+	add := func(w warning) {
+		if !reported[w.Message] {
+			reported[w.Message] = true
+			warnings = append(warnings, w)
+		}
+	}
+	// The first entry for each OID.
+	entries := map[string]string{}
+	oids := []string{}
+	for _, entry := range walk {
+		n := nameToNode[entry]
+		first, ok := entries[n.Oid]
 		if !ok {
-			log.Fatalf("Cannot find oid '%s' to walk", oid)
+			entries[n.Oid] = entry
+			oids = append(oids, n.Oid)
+			continue
 		}
-		toWalk = append(toWalk, node.Oid)
+		firstName, firstModule := name(first)
+		entryName, entryModule := name(entry)
+		if firstName == entryName {
+			add(warning{
+				Oid:      n.Oid,
+				Label:    entryName,
+				Category: warnDuplicateWalk,
+				Message:  fmt.Sprintf("%s is listed more than once in walk", entryName),
+			})
+			continue
+		}
+		if !firstModule && !entryModule {
+			add(warning{
+				Oid:      n.Oid,
+				Label:    entryName,
+				Category: warnDuplicateWalk,
+				Message:  fmt.Sprintf("%s and %s in walk are the same object, ignoring %s", firstName, entryName, entryName),
+			})
+			continue
+		}
+		// An object of a MIB module that's also walked.
+		ignored, covering := entry, firstName
+		if entryModule && !firstModule {
+			ignored, covering = first, entryName
+		}
+		add(warning{
+			Oid:      n.Oid,
+			Label:    nameToNode[ignored].Label,
+			Category: warnRedundantWalk,
+			Message:  fmt.Sprintf("%s is already walked as part of %s, ignoring it", describe(ignored), covering),
+		})
 	}
-	toWalk = minimizeOids(toWalk)
 
-	// Find all the usable metrics.
-	for _, oid := range toWalk {
-		node := nameToNode[oid]
-		needToWalk[node.Oid] = struct{}{}
-		walkNode(node, func(n *Node) {
-			t, ok := metricType(n.Type)
-			if !ok {
-				return // Unsupported type.
+	// minimizeOids sorts the OIDs it's given, and the warnings should be in
+	// the order of the walk.
+	kept := map[string]bool{}
+	for _, oid := range minimizeOids(append([]string{}, oids...)) {
+		kept[oid] = true
+	}
+	for _, oid := range oids {
+		if kept[oid] {
+			continue
+		}
+		for covering := range kept {
+			if !strings.HasPrefix(oid, covering+".") {
+				continue
 			}
-
-			if !metricAccess(n.Access) {
-				return // Inaccessible metrics.
+			entryName, _ := name(entries[oid])
+			coveringName, _ := name(entries[covering])
+			// Objects of a MIB module under each other are expected.
+			if entryName != coveringName {
+				add(warning{
+					Oid:      oid,
+					Label:    nameToNode[entries[oid]].Label,
+					Category: warnRedundantWalk,
+					Message:  fmt.Sprintf("%s is already walked as part of %s, ignoring it", describe(entries[oid]), coveringName),
+				})
 			}
+			break
+		}
+	}
+	return warnings
+}
 
-			metric := &config.Metric{
-				Name:    sanitizeLabelName(n.Label),
-				Oid:     n.Oid,
-				Type:    t,
-				Help:    n.Description + " - " + n.Oid,
-				Indexes: []*config.Index{},
-				Lookups: []*config.Lookup{},
-			}
-			for _, i := range n.Indexes {
-				index := &config.Index{Labelname: i}
-				indexNode, ok := nameToNode[i]
-				if !ok {
-					log.Warnf("Error, can't find index %s for node %s", i, n.Label)
-					return
-				}
-				index.Type, ok = metricType(indexNode.Type)
-				if !ok {
-					log.Warnf("Error, can't handle index type %s for node %s", indexNode.Type, n.Label)
-					return
-				}
-				index.FixedSize = indexNode.FixedSize
-				metric.Indexes = append(metric.Indexes, index)
-			}
-			out.Metrics = append(out.Metrics, metric)
-		})
+// Whether OID a comes before OID b, comparing sub-identifiers as numbers so
+// that 1.2 comes before 1.10.
+func oidLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		x, errX := strconv.ParseUint(as[i], 10, 64)
+		y, errY := strconv.ParseUint(bs[i], 10, 64)
+		if errX != nil || errY != nil {
+			return as[i] < bs[i]
+		}
+		return x < y
 	}
+	return len(as) < len(bs)
+}
 
-	// Apply lookups.
+// Sort OIDs numerically by sub-identifier, returning them.
+func sortOids(oids []string) []string {
+	sort.Slice(oids, func(i, j int) bool {
+		return oidLess(oids[i], oids[j])
+	})
+	return oids
+}
+
+// Check a module config against the MIB tree, returning every problem found
+// with the walks, lookups and overrides it references.
+func validateModuleConfig(cfg *ModuleConfig, nameToNode map[string]*Node) []error {
+	errs := []error{}
+	cfg, _ = withMIBModuleWalks(cfg, nameToNode)
+	mibNodes := nameToNode
+	nameToNode = withLookupNodes(cfg, nameToNode)
+	switch cfg.Help {
+	case "", helpFull, helpFirstSentence, helpNone:
+	default:
+		errs = append(errs, fmt.Errorf("Unknown help mode '%s', must be full, first_sentence or none", cfg.Help))
+	}
+	if cfg.HelpMaxLength < 0 {
+		errs = append(errs, fmt.Errorf("Invalid help_max_length %d, must not be negative", cfg.HelpMaxLength))
+	}
+	if cfg.Prefix != "" && !metricNameRE.MatchString(cfg.Prefix) {
+		errs = append(errs, fmt.Errorf("Invalid prefix '%s', must be a valid metric name", cfg.Prefix))
+	}
+	if cfg.DigitPrefix != "" && !labelNameRE.MatchString(cfg.DigitPrefix) {
+		errs = append(errs, fmt.Errorf("Invalid digit_prefix '%s', must be a valid label name", cfg.DigitPrefix))
+	}
+	for _, oid := range cfg.Walk {
+		n, ok := nameToNode[oid]
+		if isUnknownOid(cfg, oid, nameToNode) {
+			continue
+		}
+		if !ok && strings.HasPrefix(oid, mibModulePrefix) {
+			errs = append(errs, fmt.Errorf("Cannot find MIB module '%s' with objects to walk", strings.TrimPrefix(oid, mibModulePrefix)))
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to walk", oid))
+			continue
+		}
+		// Tables and entries are not-accessible too, but have children
+		// that can be walked.
+		if n.Access == "ACCESS_NOACCESS" && len(n.Children) == 0 {
+			errs = append(errs, fmt.Errorf("Cannot walk '%s' as it is not-accessible%s", oid, accessibleAlternatives(n, nameToNode)))
+		}
+	}
+	for _, name := range cfg.Get {
+		n := scalarNode(name, nameToNode)
+		if n == nil {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to get", name))
+			continue
+		}
+		if len(n.Children) != 0 || len(n.Indexes) != 0 {
+			errs = append(errs, fmt.Errorf("Cannot get '%s' as it is not a scalar, use walk instead", name))
+			continue
+		}
+		for _, oid := range cfg.Walk {
+			if w, ok := nameToNode[oid]; ok && strings.HasPrefix(n.Oid+".", w.Oid+".") {
+				errs = append(errs, fmt.Errorf("Cannot get '%s' as it is already walked by '%s'", name, oid))
+			}
+		}
+	}
 	for _, lookup := range cfg.Lookups {
-		for _, metric := range out.Metrics {
-			for _, index := range metric.Indexes {
-				if index.Labelname == lookup.OldIndex {
-					if _, ok := nameToNode[lookup.NewIndex]; !ok {
-						log.Fatalf("Unknown index '%s'", lookup.NewIndex)
-					}
-					indexNode := nameToNode[lookup.NewIndex]
-					// Avoid leaving the old labelname around.
-					index.Labelname = sanitizeLabelName(indexNode.Label)
-					typ, ok := metricType(indexNode.Type)
-					if !ok {
-						log.Fatalf("Unknown index type %s for %s", indexNode.Type, lookup.NewIndex)
-					}
-					metric.Lookups = append(metric.Lookups, &config.Lookup{
-						Labels:    []string{sanitizeLabelName(indexNode.Label)},
-						Labelname: sanitizeLabelName(indexNode.Label),
-						Type:      typ,
-						Oid:       indexNode.Oid,
-					})
-					// Make sure we walk the lookup OID
-					needToWalk[indexNode.Oid] = struct{}{}
-				}
+		if lookup.OldIndex != "" && len(lookup.OldIndexes) != 0 {
+			errs = append(errs, fmt.Errorf("Lookup to '%s' has both old_index and old_indexes", lookup.NewIndex))
+			continue
+		}
+		if _, ok := mibNodes[lookup.NewIndex]; ok && lookup.Type != "" {
+			errs = append(errs, fmt.Errorf("Lookup to '%s' has a type, which is only for numeric OIDs that aren't in the MIBs", lookup.NewIndex))
+		} else if !ok && numericOidRE.MatchString(lookup.NewIndex) {
+			if lookup.Type == "" {
+				errs = append(errs, fmt.Errorf("Cannot find oid '%s' to look up in the MIBs, so the lookup needs a type such as DisplayString or gauge", lookup.NewIndex))
+				continue
+			}
+			if _, ok := lookupNodeTypes[lookup.Type]; !ok {
+				errs = append(errs, fmt.Errorf("Unknown type '%s' for lookup to '%s', must be one of %s", lookup.Type, lookup.NewIndex, strings.Join(sortedKeys(lookupNodeTypes), ", ")))
+				continue
+			}
+		}
+		if lookup.DropSourceIndexes && lookup.KeepSourceIndexes {
+			errs = append(errs, fmt.Errorf("Lookup to '%s' has both drop_source_indexes and keep_source_indexes", lookup.NewIndex))
+		}
+		known := true
+		for _, old := range lookup.oldIndexes() {
+			if _, ok := nameToNode[old]; !ok {
+				errs = append(errs, fmt.Errorf("Unknown index '%s'", old))
+				known = false
+			}
+		}
+		indexNode, ok := nameToNode[lookup.NewIndex]
+		if !ok {
+			errs = append(errs, fmt.Errorf("Unknown index '%s'", lookup.NewIndex))
+			continue
+		}
+		for _, extract := range lookup.RegexpExtracts {
+			if _, err := compileRegexpExtract(extract); err != nil {
+				errs = append(errs, fmt.Errorf("Lookup to '%s' has a bad regex extract: %s", lookup.NewIndex, err))
 			}
 		}
+		// The labels are encoded in the order of the lookup table's indexes.
+		if known && len(lookup.OldIndexes) != 0 && !sameIndexes(lookup.OldIndexes, indexNode.Indexes, nameToNode) {
+			errs = append(errs, fmt.Errorf("Cannot look up '%s' to '%s' as its table's indexes are %s", strings.Join(lookup.OldIndexes, ", "), lookup.NewIndex, strings.Join(indexNode.Indexes, ", ")))
+		}
+		if _, ok := metricType(indexNode.Type); !ok {
+			errs = append(errs, fmt.Errorf("Unknown index type %s for %s", indexNode.Type, lookup.NewIndex))
+		}
+		// The agent won't return a not-accessible object, so it can't be
+		// walked to look up. When it's an index, its value is already in
+		// the label from the oid.
+		if indexNode.Access == "ACCESS_NOACCESS" {
+			errs = append(errs, fmt.Errorf("Cannot look up '%s' to '%s' as it is not-accessible%s", strings.Join(lookup.oldIndexes(), ", "), lookup.NewIndex, accessibleAlternatives(indexNode, nameToNode)))
+		}
 	}
-
-	// Apply module config overrides to their corresponding metrics.
-	for name, params := range cfg.Overrides {
-		for _, metric := range out.Metrics {
-			if name == metric.Name || name == metric.Oid {
-				metric.RegexpExtracts = params.RegexpExtracts
+	for name := range cfg.Overrides {
+		if isRegexpOverride(name) {
+			if _, err := regexp.Compile(name[1:]); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid regex in override '%s': %s", name, err))
+			}
+			continue
+		}
+		if _, ok := nameToNode[name]; ok {
+			continue
+		}
+		unknown := false
+		for _, oid := range cfg.Walk {
+			if isUnknownOid(cfg, oid, nameToNode) && (name == strings.TrimPrefix(oid, ".") || name == unknownOidMetricName(oid)) {
+				unknown = true
 			}
 		}
+		if unknown {
+			continue
+		}
+		// Overrides can also use the sanitized metric name.
+		found := false
+		for _, n := range nameToNode {
+			if sanitizeLabelName(n.Label) == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to override", name))
+		}
 	}
-
-	oids := []string{}
-	for k, _ := range needToWalk {
-		oids = append(oids, k)
+	for _, name := range cfg.Exclude {
+		if _, ok := nameToNode[name]; !ok {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to exclude", name))
+		}
 	}
-	// Remove redundant OIDs to be walked.
-	out.Walk = minimizeOids(oids)
-	return out
-}
-
-var (
-	invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
-)
-
-func sanitizeLabelName(name string) string {
-	return invalidLabelCharRE.ReplaceAllString(name, "_")
+	for _, filters := range [][]string{cfg.MetricFilters.Include, cfg.MetricFilters.Exclude} {
+		for _, f := range filters {
+			if _, err := regexp.Compile(f); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid metric filter '%s': %s", f, err))
+			}
+		}
+	}
+	// The filter of each filtered column, as a column can only be filtered once.
+	filtered := map[string]string{}
+	// The columns of a filter's target, or nil if it can't be filtered.
+	filterTarget := func(filter, name string) []*Node {
+		target, ok := nameToNode[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to filter", name))
+			return nil
+		}
+		columns := tableColumns(target)
+		if len(columns) == 0 {
+			errs = append(errs, fmt.Errorf("Cannot filter '%s' as it is not a table, entry or column", name))
+			return nil
+		}
+		walked := false
+		for _, oid := range cfg.Walk {
+			if w, ok := nameToNode[oid]; ok && strings.HasPrefix(target.Oid+".", w.Oid+".") {
+				walked = true
+			}
+		}
+		if !walked {
+			errs = append(errs, fmt.Errorf("Cannot filter '%s' as it is not walked", name))
+		}
+		for _, column := range columns {
+			if other, ok := filtered[column.Oid]; ok && other != filter {
+				errs = append(errs, fmt.Errorf("'%s' is filtered by both the %s and the %s", column.Label, other, filter))
+				break
+			}
+			filtered[column.Oid] = filter
+		}
+		return columns
+	}
+	for _, f := range cfg.Filters.Dynamic {
+		source, ok := nameToNode[f.Oid]
+		if !ok {
+			errs = append(errs, fmt.Errorf("Cannot find oid '%s' to filter on", f.Oid))
+			continue
+		}
+		if len(source.Indexes) == 0 || len(source.Children) != 0 || !metricAccess(source.Access) {
+			errs = append(errs, fmt.Errorf("Cannot filter on '%s' as it is not an accessible column of a table", f.Oid))
+			continue
+		}
+		if len(f.Values) == 0 {
+			errs = append(errs, fmt.Errorf("Dynamic filter on '%s' has no values", f.Oid))
+		}
+		if _, err := filterValues(source, f.Values); err != nil {
+			errs = append(errs, err)
+		}
+		if len(f.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("Dynamic filter on '%s' has no targets", f.Oid))
+		}
+		for _, name := range f.Targets {
+			columns := filterTarget(fmt.Sprintf("dynamic filter on '%s'", f.Oid), name)
+			// The index of a row of source must be that of the row of the target.
+			if columns != nil && !sameIndexes(source.Indexes, columns[0].Indexes, nameToNode) {
+				errs = append(errs, fmt.Errorf("Dynamic filter on '%s' can't apply to '%s', as its indexes are %s rather than %s", f.Oid, name, strings.Join(columns[0].Indexes, ", "), strings.Join(source.Indexes, ", ")))
+			}
+		}
+	}
+	for i, f := range cfg.Filters.Static {
+		if len(f.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("Static filter %d has no targets", i+1))
+		}
+		if len(f.Indices) == 0 {
+			errs = append(errs, fmt.Errorf("Static filter %d has no indices", i+1))
+		}
+		for _, index := range f.Indices {
+			if !indexOidRE.MatchString(index) {
+				errs = append(errs, fmt.Errorf("Invalid index '%s' in static filter %d, must be a sub-OID such as 3 or 4.1.2", index, i+1))
+			}
+		}
+		for _, name := range f.Targets {
+			filterTarget(fmt.Sprintf("static filter %d", i+1), name)
+		}
+	}
+	for name, params := range cfg.Overrides {
+		if _, ok := overrideTypeKinds[params.Type]; params.Type != "" && !ok {
+			errs = append(errs, fmt.Errorf("Unknown type '%s' in override of '%s'", params.Type, name))
+		}
+		if params.Name != "" && !metricNameRE.MatchString(params.Name) {
+			errs = append(errs, fmt.Errorf("Invalid name '%s' in override of '%s', must be a valid metric name", params.Name, name))
+		}
+		if isRegexpOverride(name) && params.Name != "" {
+			errs = append(errs, fmt.Errorf("Override of '%s' matches by regex, so can't set a name", name))
+		}
+		if params.NameAbsolute && params.Name == "" {
+			errs = append(errs, fmt.Errorf("Override of '%s' sets name_absolute without a name", name))
+		}
+		if params.HelpIncludeOid != nil && params.Help == "" {
+			errs = append(errs, fmt.Errorf("Override of '%s' sets help_include_oid without a help", name))
+		}
+		if params.Scale != nil && *params.Scale == 0 {
+			errs = append(errs, fmt.Errorf("Override of '%s' has a scale of 0", name))
+		}
+		if (params.Scale != nil || params.Offset != 0) && params.Type != "" && !scalableTypes[params.Type] {
+			errs = append(errs, fmt.Errorf("Override of '%s' sets a scale or offset with type %s, only gauge and counter can be scaled", name, params.Type))
+		}
+		for suffix, extracts := range params.RegexpExtracts {
+			// The exporter appends the name to the metric's name as is.
+			if invalidLabelCharRE.MatchString(suffix) {
+				errs = append(errs, fmt.Errorf("Override of '%s' has regex extracts named '%s', which can only have letters, digits and underscores", name, suffix))
+			}
+			for _, extract := range extracts {
+				if _, err := compileRegexpExtract(extract); err != nil {
+					errs = append(errs, fmt.Errorf("Override of '%s' has a bad regex extract for '%s': %s", name, suffix, err))
+				}
+			}
+			if _, ok := params.RegexpExtractFallbacks[suffix]; cfg.StrictExtracts && !ok {
+				errs = append(errs, fmt.Errorf("Override of '%s' has no fallback for regex extract '%s', which strict_extracts requires", name, suffix))
+			}
+		}
+		for suffix, fallback := range params.RegexpExtractFallbacks {
+			if _, ok := params.RegexpExtracts[suffix]; !ok {
+				errs = append(errs, fmt.Errorf("Override of '%s' has a fallback for regex extract '%s', which it doesn't have", name, suffix))
+			}
+			if _, err := strconv.ParseFloat(fallback, 64); err != nil {
+				errs = append(errs, fmt.Errorf("Override of '%s' has fallback '%s' for regex extract '%s', which isn't a number", name, fallback, suffix))
+			}
+		}
+	}
+	return errs
+}
+
+// Whether an override's key is a regex on metric names and OIDs, rather than
+// the name or OID of an object.
+func isRegexpOverride(name string) bool {
+	return strings.HasPrefix(name, "~")
+}
+
+// The keys of a module's overrides in the order they are applied: those by
+// name, then those by regex in the order they were declared. Regex overrides
+// with no declared order, such as from defaults, come first.
+func overrideNames(cfg *ModuleConfig) []string {
+	named, regexps := []string{}, []string{}
+	declared := map[string]bool{}
+	for _, name := range cfg.overrideOrder {
+		declared[name] = true
+	}
+	for name := range cfg.Overrides {
+		if !isRegexpOverride(name) {
+			named = append(named, name)
+		} else if !declared[name] {
+			regexps = append(regexps, name)
+		}
+	}
+	sort.Strings(named)
+	sort.Strings(regexps)
+	seen := map[string]bool{}
+	for _, name := range cfg.overrideOrder {
+		if _, ok := cfg.Overrides[name]; ok && isRegexpOverride(name) && !seen[name] {
+			seen[name] = true
+			regexps = append(regexps, name)
+		}
+	}
+	return append(named, regexps...)
+}
+
+// The columns of a table, entry or column, or nil if n isn't in a table.
+func tableColumns(n *Node) []*Node {
+	switch {
+	case len(n.Indexes) != 0 && len(n.Children) == 0:
+		return []*Node{n}
+	case len(n.Indexes) != 0:
+		return n.Children
+	case len(n.Children) == 1 && len(n.Children[0].Indexes) != 0:
+		return n.Children[0].Children
+	}
+	return nil
+}
+
+// The values for the exporter to compare a column's values to, with the
+// names of enumerated values replaced by their numbers.
+func filterValues(n *Node, values []string) ([]string, error) {
+	if len(n.EnumValues) == 0 {
+		return values, nil
+	}
+	out := []string{}
+	for _, v := range values {
+		if _, err := strconv.Atoi(v); err == nil {
+			out = append(out, v)
+			continue
+		}
+		found := false
+		for i, name := range n.EnumValues {
+			if name == v {
+				out = append(out, strconv.Itoa(i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown value '%s' to filter '%s' on, must be a number or one of its enumerated values", v, n.Label)
+		}
+	}
+	return out, nil
+}
+
+// Compile a regex extract for the exporter, anchoring the regex as the
+// exporter does and checking the value only uses groups the regex has.
+func compileRegexpExtract(e RegexpExtract) (config.RegexpExtract, error) {
+	if e.Regex == "" {
+		return config.RegexpExtract{}, fmt.Errorf("no regex")
+	}
+	re, err := regexp.Compile("^(?:" + e.Regex + ")$")
+	if err != nil {
+		return config.RegexpExtract{}, fmt.Errorf("invalid regex '%s': %s", e.Regex, err)
+	}
+	if err := checkExpandTemplate(re, e.Value); err != nil {
+		return config.RegexpExtract{}, fmt.Errorf("invalid value '%s': %s", e.Value, err)
+	}
+	return config.RegexpExtract{Regex: config.Regexp{Regexp: re}, Value: e.Value}, nil
+}
+
+// Compile validated regex extracts.
+func compileRegexpExtracts(extracts []RegexpExtract) []config.RegexpExtract {
+	if len(extracts) == 0 {
+		return nil
+	}
+	out := []config.RegexpExtract{}
+	for _, e := range extracts {
+		c, _ := compileRegexpExtract(e)
+		out = append(out, c)
+	}
+	return out
+}
+
+// The types a lookup of an OID that isn't in the MIBs can have, and the
+// MIB types of the nodes made up for them.
+var lookupNodeTypes = map[string]string{
+	"gauge":           "INTEGER",
+	"counter":         "COUNTER",
+	"OctetString":     "OCTETSTR",
+	"DisplayString":   "DisplayString",
+	"PhysAddress48":   "PhysAddress48",
+	"IpAddr":          "IPADDR",
+	"InetAddressIPv6": "InetAddressIPv6",
+	"DateAndTime":     "DateAndTime",
+}
+
+// nameToNode with the lookups' numeric OIDs added, with a leading period or
+// made up from the lookup's type when they aren't in the MIBs. It's only
+// copied if there are any.
+func withLookupNodes(cfg *ModuleConfig, nameToNode map[string]*Node) map[string]*Node {
+	added := map[string]*Node{}
+	for _, lookup := range cfg.Lookups {
+		for _, name := range append(lookup.oldIndexes(), lookup.NewIndex) {
+			if _, ok := nameToNode[name]; ok || !numericOidRE.MatchString(name) {
+				continue
+			}
+			oid := strings.TrimPrefix(name, ".")
+			if n, ok := nameToNode[oid]; ok {
+				added[name] = n
+			} else if typ, ok := lookupNodeTypes[lookup.Type]; ok && name == lookup.NewIndex {
+				added[name] = &Node{Oid: oid, Label: unknownOidMetricName(oid), Type: typ, Access: "ACCESS_READONLY"}
+				added[oid] = added[name]
+			}
+		}
+	}
+	if len(added) == 0 {
+		return nameToNode
+	}
+	m := make(map[string]*Node, len(nameToNode)+len(added))
+	for k, v := range nameToNode {
+		m[k] = v
+	}
+	for k, v := range added {
+		m[k] = v
+	}
+	return m
+}
+
+// The keys of a map, sorted.
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check that lookups whose old index is the new index of an earlier lookup
+// form chains that start at an index and don't loop. Lookups of several
+// indexes always start a chain.
+func checkLookupChains(lookups []*Lookup, nameToNode map[string]*Node) error {
+	// Longer chains are at the end, so start there to report a whole cycle.
+	for i := len(lookups) - 1; i >= 0; i-- {
+		if len(lookups[i].OldIndexes) != 0 {
+			continue
+		}
+		chain := []string{lookups[i].NewIndex, lookups[i].OldIndex}
+		seen := map[*Node]bool{nameToNode[lookups[i].NewIndex]: true}
+		for j := i; len(lookups[j].OldIndexes) == 0; {
+			old := nameToNode[lookups[j].OldIndex]
+			if seen[old] {
+				// Looking up an index to itself isn't a chain.
+				if len(chain) == 2 {
+					break
+				}
+				return fmt.Errorf("Lookup chain %s is a cycle", formatLookupChain(chain))
+			}
+			seen[old] = true
+			// The latest earlier lookup to produce the old index.
+			prev := -1
+			for k := j - 1; k >= 0; k-- {
+				if nameToNode[lookups[k].NewIndex] == old {
+					prev = k
+					break
+				}
+			}
+			if prev == -1 {
+				// Lookups are done in order, so a later one is too late.
+				later := false
+				for k := i + 1; k < len(lookups); k++ {
+					if nameToNode[lookups[k].NewIndex] == old {
+						later = true
+					}
+				}
+				if !isIndex(old) && (len(chain) > 2 || later) {
+					return fmt.Errorf("Lookup chain %s starts at %s, which is neither an index nor looked up by an earlier lookup", formatLookupChain(chain), lookups[j].OldIndex)
+				}
+				break
+			}
+			j = prev
+			chain = append(chain, lookups[j].OldIndex)
+		}
+	}
+	return nil
+}
+
+// Format a lookup chain built from its end, such as "a -> b -> c".
+func formatLookupChain(chain []string) string {
+	parts := []string{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		parts = append(parts, chain[i])
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// References to groups in a regexp.Expand template, such as $1 or ${name}.
+var templateRefRE = regexp.MustCompile(`\$(?:\$|\{(\w+)\}|(\w+))`)
+
+// Check that an expand template only refers to groups that the regex has.
+func checkExpandTemplate(re *regexp.Regexp, template string) error {
+	for _, m := range templateRefRE.FindAllStringSubmatch(template, -1) {
+		name := m[1] + m[2]
+		if name == "" {
+			// An escaped $.
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				return fmt.Errorf("regex '%s' has no group %d", re, n)
+			}
+			continue
+		}
+		found := false
+		for _, sub := range re.SubexpNames() {
+			if sub == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("regex '%s' has no group named %s", re, name)
+		}
+	}
+	return nil
+}
+
+// Whether an OID to walk is a numeric OID that isn't in the MIBs, which a
+// module allows.
+func isUnknownOid(cfg *ModuleConfig, oid string, nameToNode map[string]*Node) bool {
+	if _, ok := nameToNode[oid]; ok || !cfg.AllowUnknownOids {
+		return false
+	}
+	return numericOidRE.MatchString(oid)
+}
+
+// The name of the metric for everything under an unknown OID.
+func unknownOidMetricName(oid string) string {
+	return "oid_" + strings.Replace(strings.TrimPrefix(oid, "."), ".", "_", -1)
+}
+
+// The prefix of walk entries that are always MIB module names, even if an
+// object has the same name.
+const mibModulePrefix = "module:"
+
+// The OIDs of the top-level objects defined by a MIB module that have
+// something under them to walk, sorted.
+func mibModuleOids(module string, nameToNode map[string]*Node) []string {
+	oids := []string{}
+	for key, n := range nameToNode {
+		if key != n.Oid || n.Module != module {
+			continue
+		}
+		if i := strings.LastIndex(n.Oid, "."); i != -1 {
+			if parent, ok := nameToNode[n.Oid[:i]]; ok && parent.Module == module {
+				continue
+			}
+		}
+		walkable := false
+		walkTree(n, func(_, c *Node) bool {
+			walkable = metricAccess(c.Access)
+			return !walkable
+		})
+		if walkable {
+			oids = append(oids, n.Oid)
+		}
+	}
+	sort.Strings(oids)
+	return oids
+}
+
+// Replace walk entries that name a MIB module with the objects it defines,
+// returning a copy of the config if there were any. A name that is both an
+// object and a MIB module is the object, with a warning. Entries for unknown
+// MIB modules are left to fail validation.
+func withMIBModuleWalks(cfg *ModuleConfig, nameToNode map[string]*Node) (*ModuleConfig, []warning) {
+	candidates := false
+	for _, name := range cfg.Walk {
+		if strings.HasPrefix(name, mibModulePrefix) || mibModuleNameRE.MatchString(name) {
+			candidates = true
+		}
+	}
+	if !candidates {
+		return cfg, nil
+	}
+	modules := map[string]bool{}
+	for _, n := range nameToNode {
+		modules[n.Module] = true
+	}
+	delete(modules, "")
+
+	warnings := []warning{}
+	walk := []string{}
+	expanded := false
+	for _, name := range cfg.Walk {
+		module := strings.TrimPrefix(name, mibModulePrefix)
+		if n, ok := nameToNode[name]; ok && module == name {
+			if modules[name] {
+				warnings = append(warnings, warning{
+					Oid:      n.Oid,
+					Label:    name,
+					Category: warnAmbiguousName,
+					Message:  fmt.Sprintf("%s is both an object and a MIB module, walking the object. Use %s%s to walk the MIB module", name, mibModulePrefix, name),
+				})
+			}
+			walk = append(walk, name)
+			continue
+		}
+		oids := []string{}
+		if modules[module] {
+			oids = mibModuleOids(module, nameToNode)
+		}
+		if len(oids) == 0 {
+			walk = append(walk, name)
+			continue
+		}
+		log.Debugf("Walking %s as the objects of MIB module %s: %s", name, module, strings.Join(oids, ", "))
+		walk = append(walk, oids...)
+		expanded = true
+	}
+	if !expanded {
+		return cfg, warnings
+	}
+	c := *cfg
+	c.Walk = walk
+	return &c, warnings
+}
+
+// The objects auto_interface_lookups looks up from ifIndex, if they are in
+// the MIBs.
+var interfaceLookups = []string{"ifName", "ifDescr"}
+
+// Add lookups of ifIndex to interfaceLookups before the module's own, if it
+// sets auto_interface_lookups, returning a copy of the config if any were
+// added. Lookups the module already has aren't added again. ifDescr is
+// looked up as well as ifName, as ifName can be empty.
+func withInterfaceLookups(cfg *ModuleConfig, nameToNode map[string]*Node) *ModuleConfig {
+	ifIndex, ok := nameToNode["ifIndex"]
+	if !cfg.AutoInterfaceLookups || !ok {
+		return cfg
+	}
+	lookups := []*Lookup{}
+	for _, name := range interfaceLookups {
+		n, ok := nameToNode[name]
+		if !ok {
+			continue
+		}
+		declared := false
+		for _, l := range cfg.Lookups {
+			old := l.oldIndexes()
+			if len(old) == 1 && nameToNode[old[0]] == ifIndex && nameToNode[l.NewIndex] == n {
+				declared = true
+			}
+		}
+		if !declared {
+			lookups = append(lookups, &Lookup{OldIndex: "ifIndex", NewIndex: name, KeepSourceIndexes: true, auto: true})
+		}
+	}
+	if len(lookups) == 0 {
+		return cfg
+	}
+	c := *cfg
+	c.Lookups = append(lookups, cfg.Lookups...)
+	return &c
+}
+
+// Whether names refer to the same nodes as indexes, in the same order.
+func sameIndexes(names, indexes []string, nameToNode map[string]*Node) bool {
+	if len(names) != len(indexes) {
+		return false
+	}
+	for i, name := range names {
+		if nameToNode[name] != nameToNode[indexes[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a column is an index of its own table, so its values are unique.
+func isIndex(n *Node) bool {
+	for _, index := range n.Indexes {
+		if index == n.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// The node of an object to get, which may be named with its instance of .0.
+// Returns nil if there is none.
+func scalarNode(name string, nameToNode map[string]*Node) *Node {
+	if n, ok := nameToNode[name]; ok {
+		return n
+	}
+	if strings.HasSuffix(name, ".0") {
+		return nameToNode[strings.TrimSuffix(name, ".0")]
+	}
+	return nil
+}
+
+// Suggest accessible columns in the same table entry as a not-accessible
+// node, for use in an error message.
+func accessibleAlternatives(n *Node, nameToNode map[string]*Node) string {
+	i := strings.LastIndex(n.Oid, ".")
+	if i == -1 {
+		return ""
+	}
+	entry, ok := nameToNode[n.Oid[:i]]
+	if !ok || len(entry.Indexes) == 0 {
+		return ""
+	}
+	// Prefer columns of the same type.
+	same, other := []string{}, []string{}
+	for _, c := range entry.Children {
+		if c == n || c.Access == "ACCESS_NOACCESS" || !metricAccess(c.Access) {
+			continue
+		}
+		if _, ok := metricType(c.Type); !ok {
+			continue
+		}
+		if c.Type == n.Type {
+			same = append(same, c.Label)
+		} else {
+			other = append(other, c.Label)
+		}
+	}
+	alternatives := append(same, other...)
+	if len(alternatives) == 0 {
+		return fmt.Sprintf(", and %s has no accessible columns", entry.Label)
+	}
+	return fmt.Sprintf(", try an accessible column of %s such as %s", entry.Label, strings.Join(alternatives, ", "))
+}
+
+// Check the indexes of every metric that would be generated for a module,
+// returning every problem found.
+func validateModuleIndexes(cfg *ModuleConfig, nameToNode map[string]*Node) []error {
+	errs := []error{}
+	for _, oid := range cfg.Walk {
+		node, ok := nameToNode[oid]
+		if !ok {
+			continue
+		}
+		walkNode(node, func(n *Node) {
+			if _, ok := metricType(n.Type); !ok || !metricAccess(n.Access) {
+				return
+			}
+			if _, err := metricIndexes(n, nameToNode); err != nil {
+				errs = append(errs, err)
+			}
+		})
+	}
+	return errs
+}
+
+// A problem with the indexes of a node, with its warning category.
+type indexError struct {
+	category string
+	message  string
+}
+
+func (e *indexError) Error() string {
+	return e.message
+}
+
+// Build the config indexes for a node. Errors are of type *indexError.
+func metricIndexes(n *Node, nameToNode map[string]*Node) ([]*config.Index, error) {
+	indexes := []*config.Index{}
+	for _, i := range n.Indexes {
+		index := &config.Index{Labelname: i}
+		indexNode, ok := nameToNode[i]
+		if !ok {
+			return nil, &indexError{warnMissingIndex, fmt.Sprintf("Error, can't find index %s for node %s", i, n.Label)}
+		}
+		index.Type, ok = metricType(indexNode.Type)
+		if !ok {
+			return nil, &indexError{warnUnsupportedIndexType, fmt.Sprintf("Error, can't handle index type %s for node %s", indexNode.Type, n.Label)}
+		}
+		index.FixedSize = indexNode.FixedSize
+		if n.ImpliedIndex && len(indexes) == len(n.Indexes)-1 {
+			index.Implied = true
+		}
+		if isInetAddressPair(n, len(indexes), nameToNode) {
+			// The exporter decodes it using the type from the previous index.
+			index.Type = "TypedInetAddress"
+			indexes[len(indexes)-1].Type = "InetAddressType"
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// Whether the i-th index of a node is an InetAddress that follows an
+// InetAddressType index, as in ipAddressTable.
+func isInetAddressPair(n *Node, i int, nameToNode map[string]*Node) bool {
+	if i == 0 || nameToNode[n.Indexes[i]].TextualConvention != "InetAddress" {
+		return false
+	}
+	prev, ok := nameToNode[n.Indexes[i-1]]
+	return ok && prev.TextualConvention == "InetAddressType"
+}
+
+// The first InetAddress index of a node that doesn't follow an
+// InetAddressType index, and so can't be decoded based on the address type.
+func unpairedInetAddressIndex(n *Node, nameToNode map[string]*Node) (string, bool) {
+	for i, index := range n.Indexes {
+		indexNode, ok := nameToNode[index]
+		if ok && indexNode.TextualConvention == "InetAddress" && !isInetAddressPair(n, i, nameToNode) {
+			return index, true
+		}
+	}
+	return "", false
+}
+
+// The label of the table containing a column, or the column's OID if it
+// can't be found.
+func tableLabel(n *Node, nameToNode map[string]*Node) string {
+	oid := n.Oid
+	for i := 0; i < 2; i++ {
+		if j := strings.LastIndex(oid, "."); j != -1 {
+			oid = oid[:j]
+		}
+	}
+	if table, ok := nameToNode[oid]; ok {
+		return table.Label
+	}
+	return n.Oid
+}
+
+// Make text from a MIB safe to use as help. Bytes that aren't valid UTF-8
+// are taken to be Latin-1, other control characters are removed and all
+// whitespace is collapsed to single spaces.
+func cleanDescription(s string) string {
+	// Most units and many descriptions are empty, and trees from a cache
+	// are already clean.
+	if isCleanDescription(s) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			// Latin-1 maps directly to the first 256 code points, other
+			// than the C1 controls.
+			r = rune(s[i])
+			if r < 0xa0 {
+				r = utf8.RuneError
+			}
+		}
+		i += size
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case unicode.IsControl(r), r == '\ufeff':
+			// Dropped, including a stray byte order mark.
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Whether cleanDescription would leave a description as it is. Only
+// printable ASCII is checked for, so other text is always cleaned.
+func isCleanDescription(s string) bool {
+	if s == "" {
+		return true
+	}
+	if s[0] == ' ' || s[len(s)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' || (s[i] == ' ' && s[i+1] == ' ') {
+			return false
+		}
+	}
+	return true
+}
+
+// Strings many nodes share, such as types, MIB modules and textual
+// conventions, so that each is only kept in memory once.
+type stringInterner map[string]string
+
+func (s stringInterner) intern(v string) string {
+	if i, ok := s[v]; ok {
+		return i
+	}
+	s[v] = v
+	return v
+}
+
+// Intern the strings of a node that many nodes share.
+func internNode(n *Node, strs stringInterner) {
+	n.Type = strs.intern(n.Type)
+	n.Access = strs.intern(n.Access)
+	n.Status = strs.intern(n.Status)
+	n.Module = strs.intern(n.Module)
+	n.Hint = strs.intern(n.Hint)
+	n.TextualConvention = strs.intern(n.TextualConvention)
+	n.Units = strs.intern(n.Units)
+	for i, index := range n.Indexes {
+		n.Indexes[i] = strs.intern(index)
+	}
+	for v, label := range n.EnumValues {
+		n.EnumValues[v] = strs.intern(label)
+	}
+}
+
+// Reduce the memory a tree loaded from JSON takes, as buildMIBTree does for
+// a tree from NetSNMP: intern the strings many nodes share, and clean up
+// descriptions and units rather than keeping them as written.
+func compactTree(nodes *Node) {
+	strs := stringInterner{}
+	walkNode(nodes, func(n *Node) {
+		n.Description = cleanDescription(n.Description)
+		n.Units = cleanDescription(n.Units)
+		internNode(n, strs)
+	})
+}
+
+// Drop the descriptions of prepared nodes that can't become metrics, as
+// descriptions are only used for the help of metrics when generating. This
+// is most of the memory of a large tree.
+func dropUnusedDescriptions(nodes *Node) {
+	walkNode(nodes, func(n *Node) {
+		if _, ok := metricType(n.Type); !ok || !metricAccess(n.Access) {
+			n.Description = ""
+		}
+	})
+}
+
+// The largest SIZE a string node can have, or 0 if it is unconstrained.
+func maxSize(n *Node) int {
+	max := 0
+	for _, r := range n.Ranges {
+		if r.High > max {
+			max = r.High
+		}
+	}
+	return max
+}
+
+// The name of a node qualified by its MIB module, e.g. IF-MIB::ifIndex.
+func qualifiedName(n *Node) string {
+	return n.Module + "::" + n.Label
+}
+
+// How to resolve a name defined by more than one MIB module.
+const (
+	nameConflictFirst = "first"
+	nameConflictLast  = "last"
+	nameConflictError = "error"
+)
+
+// Warn about each node whose name was already used by another node with a
+// different oid, naming both oids and MIB modules.
+func nameConflictWarnings(conflicts map[string][]*Node, nameToNode map[string]*Node) []warning {
+	names := []string{}
+	for name := range conflicts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	module := func(n *Node) string {
+		if n.Module == "" {
+			return "an unknown MIB"
+		}
+		return n.Module
+	}
+	warnings := []warning{}
+	for _, name := range names {
+		chosen := nameToNode[name]
+		for _, n := range conflicts[name] {
+			if n == chosen {
+				continue
+			}
+			warnings = append(warnings, warning{
+				Oid:      n.Oid,
+				Label:    name,
+				Category: warnNameConflict,
+				Message:  fmt.Sprintf("Name %s is defined with oid %s by %s and oid %s by %s, using oid %s", name, chosen.Oid, module(chosen), n.Oid, module(n), chosen.Oid),
+			})
+		}
+	}
+	return warnings
+}
+
+// Warn about bare names used by a module config that more than one MIB
+// module defines, listing the candidates and which was chosen.
+func ambiguousNameWarnings(cfg *ModuleConfig, nameToNode map[string]*Node) []warning {
+	names := map[string]bool{}
+	add := func(name string) {
+		// Qualified names and oids are never ambiguous.
+		if !strings.Contains(name, "::") {
+			names[name] = true
+		}
+	}
+	for _, name := range cfg.Walk {
+		add(name)
+	}
+	for _, lookup := range cfg.Lookups {
+		for _, old := range lookup.oldIndexes() {
+			add(old)
+		}
+		add(lookup.NewIndex)
+	}
+	for name := range cfg.Overrides {
+		add(name)
+	}
+
+	candidates := map[string][]string{}
+	for key, n := range nameToNode {
+		if names[n.Label] && n.Module != "" && key == qualifiedName(n) {
+			candidates[n.Label] = append(candidates[n.Label], key)
+		}
+	}
+	ambiguous := []string{}
+	for name, c := range candidates {
+		if len(c) > 1 {
+			ambiguous = append(ambiguous, name)
+		}
+	}
+	sort.Strings(ambiguous)
+	warnings := []warning{}
+	for _, name := range ambiguous {
+		sort.Strings(candidates[name])
+		chosen := nameToNode[name]
+		warnings = append(warnings, warning{
+			Oid:      chosen.Oid,
+			Label:    name,
+			Category: warnAmbiguousName,
+			Message:  fmt.Sprintf("Name %s is defined by more than one MIB module (%s), using %s with oid %s. Use MODULE::name to choose", name, strings.Join(candidates[name], ", "), qualifiedName(chosen), chosen.Oid),
+		})
+	}
+	return warnings
+}
+
+// The name of a node's STATUS as in the MIB, e.g. "deprecated".
+func statusName(status string) string {
+	return strings.ToLower(strings.TrimPrefix(status, "STATUS_"))
+}
+
+// An object under a walked OID that did not become a metric.
+type skippedNode struct {
+	Oid    string
+	Label  string
+	Reason string
+}
+
+// Why a metric name is dropped by a module's metric filters, or "" if it is
+// kept.
+func filterMetric(name string, include, exclude []*regexp.Regexp) string {
+	if len(include) != 0 {
+		found := false
+		for _, re := range include {
+			if re.MatchString(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "not matched by an include metric filter"
+		}
+	}
+	for _, re := range exclude {
+		if re.MatchString(name) {
+			return fmt.Sprintf("excluded by metric filter %s", re)
+		}
+	}
+	return ""
+}
+
+// The OIDs to walk to get everything under n except the ignored OIDs. Subtrees
+// without anything ignored are walked whole.
+func walkWithout(n *Node, ignored map[string]bool) []string {
+	if ignored[n.Oid] {
+		return nil
+	}
+	contains := false
+	for oid := range ignored {
+		if strings.HasPrefix(oid, n.Oid+".") {
+			contains = true
+			break
+		}
+	}
+	if !contains {
+		return []string{n.Oid}
+	}
+	oids := []string{}
+	for _, child := range n.Children {
+		oids = append(oids, walkWithout(child, ignored)...)
+	}
+	return oids
+}
+
+// Give metrics, and index labels within a metric, whose names are the same
+// after sanitization a numeric suffix such as _2. Returns a warning for each
+// name changed.
+func dedupeNames(metrics []*config.Metric) []warning {
+	warnings := []warning{}
+	// Find a free name, as the suffixed name may also be taken.
+	unique := func(name string, taken map[string]bool) string {
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s_%d", name, i)
+			if !taken[candidate] {
+				taken[candidate] = true
+				return candidate
+			}
+		}
+	}
+	takenMetrics := map[string]bool{}
+	for _, metric := range metrics {
+		takenMetrics[metric.Name] = true
+	}
+	metricOids := map[string]string{}
+	for _, metric := range metrics {
+		if oid, ok := metricOids[metric.Name]; ok {
+			name := unique(metric.Name, takenMetrics)
+			warnings = append(warnings, warning{
+				Oid:      metric.Oid,
+				Label:    metric.Name,
+				Category: warnNameCollision,
+				Message:  fmt.Sprintf("Metric name %s is used by both %s and %s, renaming the latter to %s", metric.Name, oid, metric.Oid, name),
+			})
+			metric.Name = name
+		}
+		metricOids[metric.Name] = metric.Oid
+
+		takenLabels := map[string]bool{}
+		for _, index := range metric.Indexes {
+			takenLabels[sanitizeLabelName(index.Labelname)] = true
+		}
+		labels := map[string]bool{}
+		for _, index := range metric.Indexes {
+			if labels[sanitizeLabelName(index.Labelname)] {
+				name := unique(sanitizeLabelName(index.Labelname), takenLabels)
+				warnings = append(warnings, warning{
+					Oid:      metric.Oid,
+					Label:    metric.Name,
+					Category: warnNameCollision,
+					Message:  fmt.Sprintf("Label name %s is used by more than one index of metric %s (%s), renaming one to %s", index.Labelname, metric.Name, metric.Oid, name),
+				})
+				index.Labelname = name
+			}
+			labels[sanitizeLabelName(index.Labelname)] = true
+		}
+	}
+	return warnings
+}
+
+var (
+	invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	underscoresRE      = regexp.MustCompile(`__+`)
+	metricNameRE       = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE        = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	numericOidRE       = regexp.MustCompile(`^\.?[0-9]+(\.[0-9]+)*$`)
+	indexOidRE         = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+	// MIB module names start with an upper case letter, unlike objects.
+	mibModuleNameRE = regexp.MustCompile(`^[A-Z][a-zA-Z0-9-]*$`)
+)
+
+// Replace the characters of a name from the MIBs that aren't allowed in
+// metric and label names. Overrides and lookups match names like this, and
+// sanitizeName finishes them off once they have.
+func sanitizeLabelName(name string) string {
+	return invalidLabelCharRE.ReplaceAllString(name, "_")
+}
+
+// Make a name a valid metric or label name. Invalid characters become
+// underscores, runs of underscores are collapsed, and a name starting with a
+// digit gets the digit prefix, or _ if that's empty. A name with no letters
+// or digits is left as underscores, which validName catches.
+func sanitizeName(name, digitPrefix string) string {
+	name = sanitizeLabelName(name)
+	name = underscoresRE.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		if digitPrefix == "" {
+			digitPrefix = "_"
+		}
+		name = digitPrefix + name
+	}
+	return name
+}
+
+// Whether a sanitized name has something other than underscores.
+func validName(name string) bool {
+	return strings.Trim(name, "_") != ""
+}
+
+// Sanitize the index and lookup label names of a metric, which are the
+// names from the MIBs until lookups have been applied. Returns an error
+// naming the metric's OID if one can't be made valid.
+func sanitizeMetricLabels(metric *config.Metric, digitPrefix string) error {
+	sanitize := func(name string) (string, error) {
+		sanitized := sanitizeName(name, digitPrefix)
+		if !validName(sanitized) {
+			return "", fmt.Errorf("Cannot make a label name from '%s' for metric %s (%s), as it has no letters or digits", name, metric.Name, metric.Oid)
+		}
+		return sanitized, nil
+	}
+	var err error
+	for _, index := range metric.Indexes {
+		if index.Labelname, err = sanitize(index.Labelname); err != nil {
+			return err
+		}
+	}
+	for _, lookup := range metric.Lookups {
+		if lookup.Labelname, err = sanitize(lookup.Labelname); err != nil {
+			return err
+		}
+		for i, label := range lookup.Labels {
+			if lookup.Labels[i], err = sanitize(label); err != nil {
+				return err
+			}
+		}
+		for _, index := range lookup.Indexes {
+			if index.Labelname, err = sanitize(index.Labelname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
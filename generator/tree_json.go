@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Read a MIB tree previously written by dumpTree. This allows working with
+// MIBs without NetSNMP, for example in tests.
+func loadTree(r io.Reader) (*Node, error) {
+	n := &Node{}
+	if err := json.NewDecoder(r).Decode(n); err != nil {
+		return nil, fmt.Errorf("Error parsing MIB tree: %s", err)
+	}
+	var err error
+	walkNode(n, func(n *Node) {
+		if err == nil && (n.Oid == "" || n.Label == "") {
+			err = fmt.Errorf("Error parsing MIB tree: node %q %q is missing an oid or label", n.Oid, n.Label)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	compactTree(n)
+	return n, nil
+}
+
+// Write out a MIB tree, including all node fields, as JSON.
+func dumpTree(w io.Writer, n *Node) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(n)
+}
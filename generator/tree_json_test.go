@@ -0,0 +1,1788 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/prometheus/snmp_exporter/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Load a MIB tree fixture from testdata.
+func loadFixture(t *testing.T, name string) *Node {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := loadTree(f)
+	if err != nil {
+		t.Fatalf("Error loading %s: %s", name, err)
+	}
+	return n
+}
+
+func TestLoadTree(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	buf := &bytes.Buffer{}
+	if err := dumpTree(buf, node); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadTree(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(node, reloaded) {
+		t.Errorf("Tree changed after dumping and loading: got %+v, want %+v", reloaded, node)
+	}
+
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	ifType := nameToNode["ifType"]
+	expected := map[int]string{1: "other", 6: "ethernetCsmacd", 24: "softwareLoopback"}
+	if !reflect.DeepEqual(ifType.EnumValues, expected) {
+		t.Errorf("ifType enum values: got %v, want %v", ifType.EnumValues, expected)
+	}
+	if ifPhysAddress := nameToNode["ifPhysAddress"]; ifPhysAddress.Type != "PhysAddress48" {
+		t.Errorf("ifPhysAddress: got type %s, want PhysAddress48", ifPhysAddress.Type)
+	}
+	if ifInOctets := nameToNode["ifInOctets"]; ifInOctets.Units != "octets" || !reflect.DeepEqual(ifInOctets.Indexes, []string{"ifIndex"}) {
+		t.Errorf("ifInOctets: got %+v", ifInOctets)
+	}
+}
+
+func TestLoadTreeErrors(t *testing.T) {
+	cases := map[string]string{
+		`{"oid": "1", "label": `: "Error parsing MIB tree",
+		`{"oid": "1", "label": "root", "children": [{"oid": "1.1"}]}`: `node "1.1" "" is missing an oid or label`,
+	}
+	for content, expected := range cases {
+		_, err := loadTree(strings.NewReader(content))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Loading %q: got error %v, want %q", content, err, expected)
+		}
+	}
+}
+
+func TestLoadTreeCompacts(t *testing.T) {
+	content := `{"oid": "1", "label": "root", "children": [
+		{"oid": "1.1", "label": "a", "type": "INTEGER", "module": "A-MIB", "access": "ACCESS_READONLY",
+			"description": "\n\t  The first\n\t  object.  ", "units": " seconds", "enum_values": {"1": "true"}},
+		{"oid": "1.2", "label": "b", "type": "OTHER", "module": "A-MIB", "access": "ACCESS_READONLY",
+			"description": "The second object.", "units": "seconds", "enum_values": {"1": "true"}}]}`
+	node, err := loadTree(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := node.Children[0], node.Children[1]
+	if a.Description != "The first object." || a.Units != "seconds" {
+		t.Errorf("Description and units not cleaned: %q %q", a.Description, a.Units)
+	}
+	// The same strings share memory.
+	for _, s := range [][2]string{{a.Access, b.Access}, {a.Module, b.Module}, {a.Units, b.Units}, {a.EnumValues[1], b.EnumValues[1]}} {
+		if unsafe.StringData(s[0]) != unsafe.StringData(s[1]) {
+			t.Errorf("%q isn't interned", s[0])
+		}
+	}
+
+	prepareTree(node, generatorOptions{})
+	dropUnusedDescriptions(node)
+	if a.Description != "The first object." || b.Description != "" {
+		t.Errorf("Got descriptions %q and %q, want only the metric's kept", a.Description, b.Description)
+	}
+}
+
+// A tree of about n nodes that are like those of real MIBs: tables of
+// columns with descriptions as written in MIBs, many textual conventions and
+// MIB modules of 1000 or so nodes.
+func syntheticTree(n int) *Node {
+	root := &Node{Oid: "1", Label: "iso"}
+	types := []struct{ typ, tc, hint string }{
+		{"INTEGER", "", ""},
+		{"INTEGER", "TruthValue", ""},
+		{"OCTETSTR", "DisplayString", "255a"},
+		{"OCTETSTR", "SnmpAdminString", "255t"},
+		{"COUNTER64", "", ""},
+		{"GAUGE", "", ""},
+		{"TIMETICKS", "TimeStamp", ""},
+		{"OCTETSTR", "MacAddress", "1x:"},
+	}
+	description := "\n            The %s of the %s, as counted by the device since\n            it was last restarted. See the compliance statements\n            for when this is supported.\n           "
+	nodes := 1
+	for m := 0; nodes < n; m++ {
+		module := fmt.Sprintf("VENDOR%d-MIB", m)
+		mod := &Node{Oid: fmt.Sprintf("1.%d", m), Label: fmt.Sprintf("vendor%d", m), Module: module, Type: "OTHER",
+			Access: "ACCESS_NOACCESS", Status: "STATUS_CURRENT", Description: fmt.Sprintf(description, "root", module)}
+		root.Children = append(root.Children, mod)
+		nodes++
+		for tbl := 0; tbl < 50 && nodes < n; tbl++ {
+			label := fmt.Sprintf("v%dt%d", m, tbl)
+			table := &Node{Oid: fmt.Sprintf("%s.%d", mod.Oid, tbl+1), Label: label + "Table", Module: module, Type: "OTHER",
+				Access: "ACCESS_NOACCESS", Status: "STATUS_CURRENT", Description: fmt.Sprintf(description, "table", label)}
+			entry := &Node{Oid: table.Oid + ".1", Label: label + "Entry", Module: module, Type: "OTHER",
+				Access: "ACCESS_NOACCESS", Status: "STATUS_CURRENT", Description: fmt.Sprintf(description, "entry", label),
+				Indexes: []string{label + "Index"}}
+			table.Children = []*Node{entry}
+			mod.Children = append(mod.Children, table)
+			nodes += 2
+			for c := 0; c < 18 && nodes < n; c++ {
+				typ := types[c%len(types)]
+				columnLabel := fmt.Sprintf("%sColumn%d", label, c)
+				column := &Node{Oid: fmt.Sprintf("%s.%d", entry.Oid, c+1), Label: columnLabel,
+					Module: module, Type: typ.typ, TextualConvention: typ.tc, Hint: typ.hint,
+					Access: "ACCESS_READONLY", Status: "STATUS_CURRENT", Units: "packets",
+					Description: fmt.Sprintf(description, "value", columnLabel)}
+				if c == 0 {
+					column.Access = "ACCESS_NOACCESS"
+				}
+				if typ.tc == "TruthValue" {
+					column.EnumValues = map[int]string{1: "true", 2: "false"}
+				}
+				entry.Children = append(entry.Children, column)
+				nodes++
+			}
+		}
+	}
+	return root
+}
+
+// The most memory a 500k node tree may take once loaded, and once prepared
+// as for generating, including the map of names. Before descriptions were
+// cleaned up as trees were loaded it took 276 and 322 MiB.
+const (
+	maxLoadedTreeBytes   = 265 << 20
+	maxPreparedTreeBytes = 315 << 20
+)
+
+// The heap a tree loaded from content takes, and once prepared with unused
+// descriptions dropped as when generating.
+func treeBytes(b *testing.B, content []byte) (loaded, prepared int64) {
+	var before, afterLoad, afterPrepare runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	node, err := loadTree(bytes.NewReader(content))
+	if err != nil {
+		b.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&afterLoad)
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	dropUnusedDescriptions(node)
+	runtime.GC()
+	runtime.ReadMemStats(&afterPrepare)
+	runtime.KeepAlive(nameToNode)
+	return int64(afterLoad.HeapAlloc) - int64(before.HeapAlloc), int64(afterPrepare.HeapAlloc) - int64(before.HeapAlloc)
+}
+
+func BenchmarkLoadTreeMemory(b *testing.B) {
+	const nodes = 500000
+	// Kept until the end, so it isn't freed while measuring.
+	tree := syntheticTree(nodes)
+	buf := &bytes.Buffer{}
+	if err := dumpTree(buf, tree); err != nil {
+		b.Fatal(err)
+	}
+	content := buf.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loaded, prepared := treeBytes(b, content)
+		b.ReportMetric(float64(loaded)/nodes, "loaded-B/node")
+		b.ReportMetric(float64(prepared)/nodes, "prepared-B/node")
+		if loaded > maxLoadedTreeBytes {
+			b.Errorf("Loaded tree of %d nodes takes %d MiB, more than %d MiB", nodes, loaded>>20, maxLoadedTreeBytes>>20)
+		}
+		if prepared > maxPreparedTreeBytes {
+			b.Errorf("Prepared tree of %d nodes takes %d MiB, more than %d MiB", nodes, prepared>>20, maxPreparedTreeBytes>>20)
+		}
+	}
+	runtime.KeepAlive(tree)
+}
+
+func TestGenerateFromFixtures(t *testing.T) {
+	cases := []struct {
+		fixture string
+		cfg     *ModuleConfig
+		walk    []string
+		metrics map[string]string
+		indexes []string
+	}{
+		{
+			fixture: "iftable.json",
+			cfg: &ModuleConfig{
+				Walk:    []string{"interfaces"},
+				Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+			},
+			walk: []string{"1.3.6.1.2.1.2"},
+			metrics: map[string]string{
+				"ifNumber":      "gauge",
+				"ifIndex":       "gauge",
+				"ifDescr":       "DisplayString",
+				"ifType":        "gauge",
+				"ifPhysAddress": "PhysAddress48",
+				"ifInOctets":    "counter",
+			},
+			indexes: []string{"ifDescr"},
+		},
+		{
+			fixture: "augments.json",
+			cfg:     &ModuleConfig{Walk: []string{"ifXTable"}},
+			walk:    []string{"1.3.6.1.2.1.31.1.1"},
+			metrics: map[string]string{
+				"ifName":            "DisplayString",
+				"ifHCInOctets":      "counter",
+				"ifPromiscuousMode": "Bool",
+			},
+			indexes: []string{"ifIndex"},
+		},
+		{
+			// testChainEntry augments ifXEntry, which augments ifEntry.
+			fixture: "augments_chain.json",
+			cfg:     &ModuleConfig{Walk: []string{"testChainTable"}},
+			walk:    []string{"1.3.6.1.2.1.10.99"},
+			metrics: map[string]string{
+				"testChainErrors": "counter",
+			},
+			indexes: []string{"ifIndex"},
+		},
+		{
+			fixture: "ucd_la_table.json",
+			cfg:     &ModuleConfig{Walk: []string{"laTable"}},
+			walk:    []string{"1.3.6.1.4.1.2021.10"},
+			metrics: map[string]string{
+				"laIndex":     "gauge",
+				"laNames":     "DisplayString",
+				"laLoadInt":   "gauge",
+				"laLoadFloat": "Float",
+			},
+			indexes: []string{"laIndex"},
+		},
+		{
+			// snmpTargetAddrEntry has an IMPLIED index.
+			fixture: "target_addr_table.json",
+			cfg:     &ModuleConfig{Walk: []string{"snmpTargetAddrTable"}},
+			walk:    []string{"1.3.6.1.6.3.12.1.2"},
+			metrics: map[string]string{
+				"snmpTargetAddrName":       "DisplayString",
+				"snmpTargetAddrTimeout":    "gauge",
+				"snmpTargetAddrRetryCount": "gauge",
+				"snmpTargetAddrParams":     "DisplayString",
+			},
+			indexes: []string{"snmpTargetAddrName"},
+		},
+	}
+	for _, c := range cases {
+		node := loadFixture(t, c.fixture)
+		nameToNode, warnings := prepareTree(node, generatorOptions{})
+		if len(warnings) != 0 {
+			t.Errorf("%s: unexpected warnings %+v", c.fixture, warnings)
+		}
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", c.fixture, err)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, c.walk) {
+			t.Errorf("%s: got walk %v, want %v", c.fixture, result.Module.Walk, c.walk)
+		}
+		metrics := map[string]string{}
+		for _, m := range result.Module.Metrics {
+			metrics[m.Name] = m.Type
+			// Scalars have no indexes.
+			if m.Name == "ifNumber" {
+				continue
+			}
+			indexes := []string{}
+			for _, i := range m.Indexes {
+				indexes = append(indexes, i.Labelname)
+			}
+			if !reflect.DeepEqual(indexes, c.indexes) {
+				t.Errorf("%s: metric %s got indexes %v, want %v", c.fixture, m.Name, indexes, c.indexes)
+			}
+		}
+		if !reflect.DeepEqual(metrics, c.metrics) {
+			t.Errorf("%s: got metrics %v, want %v", c.fixture, metrics, c.metrics)
+		}
+	}
+}
+
+func TestImpliedIndexes(t *testing.T) {
+	node := loadFixture(t, "target_addr_table.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:    []string{"snmpTargetAddrTimeout", "snmpTargetAddrParams"},
+		Lookups: []*Lookup{{OldIndex: "snmpTargetAddrName", NewIndex: "snmpTargetAddrParams"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Module.Metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(result.Module.Metrics))
+	}
+	for _, m := range result.Module.Metrics {
+		if len(m.Indexes) != 1 || !m.Indexes[0].Implied || m.Indexes[0].Type != "DisplayString" {
+			t.Errorf("metric %s: got indexes %+v, want an implied DisplayString", m.Name, m.Indexes)
+		}
+		if len(m.Lookups) != 1 || !m.Lookups[0].Implied {
+			t.Errorf("metric %s: got lookups %+v, want an implied lookup", m.Name, m.Lookups)
+		}
+	}
+
+	out, err := yaml.Marshal(result.Module.Metrics[0].Indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "implied: true") {
+		t.Errorf("generated config doesn't mark the index as implied:\n%s", out)
+	}
+}
+
+func TestTruthValues(t *testing.T) {
+	node := loadFixture(t, "augments.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cases := []struct {
+		overrides map[string]MetricOverrides
+		typ       string
+		enums     map[int]string
+	}{
+		{typ: "Bool"},
+		// Opting out keeps the raw value, or uses the enumerations.
+		{overrides: map[string]MetricOverrides{"ifPromiscuousMode": {Type: "gauge"}}, typ: "gauge", enums: map[int]string{1: "true", 2: "false"}},
+		{overrides: map[string]MetricOverrides{"ifPromiscuousMode": {Type: "EnumAsInfo"}}, typ: "EnumAsInfo", enums: map[int]string{1: "true", 2: "false"}},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"ifPromiscuousMode"}, Overrides: c.overrides}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := result.Module.Metrics[0]
+		if m.Type != c.typ || !reflect.DeepEqual(m.EnumValues, c.enums) {
+			t.Errorf("%v: got type %s with enum values %v, want %s with %v", c.overrides, m.Type, m.EnumValues, c.typ, c.enums)
+		}
+		if len(m.Indexes) != 1 || m.Indexes[0].Labelname != "ifIndex" || m.Indexes[0].Type != "gauge" {
+			t.Errorf("%v: got indexes %+v, want ifIndex", c.overrides, m.Indexes)
+		}
+	}
+
+	// Strings can't be overridden to gauge.
+	cfg := &ModuleConfig{Walk: []string{"ifName"}, Overrides: map[string]MetricOverrides{"ifName": {Type: "gauge"}}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error overriding a DisplayString to gauge")
+	}
+}
+
+func TestOverrideType(t *testing.T) {
+	node := loadFixture(t, "augments.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		name     string
+		typ      string
+		expected string
+		err      bool
+	}{
+		{name: "ifHCInOctets", typ: "gauge", expected: "gauge"},
+		{name: "1.3.6.1.2.1.31.1.1.1.6", typ: "gauge", expected: "gauge"},
+		{name: "ifPromiscuousMode", typ: "counter", expected: "counter"},
+		{name: "ifHCInOctets", typ: "Bool", expected: "Bool"},
+		{name: "ifName", typ: "OctetString", expected: "OctetString"},
+		{name: "ifName", typ: "PhysAddress48", expected: "PhysAddress48"},
+		{name: "ifName", typ: "DateAndTime", expected: "DateAndTime"},
+		// Incompatible kinds of value.
+		{name: "ifName", typ: "counter", err: true},
+		{name: "ifHCInOctets", typ: "DisplayString", err: true},
+		// Needs enumerations.
+		{name: "ifHCInOctets", typ: "EnumAsInfo", err: true},
+		// Not in the MIBs.
+		{name: "noSuchObject", typ: "gauge", err: true},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{
+			Walk:      []string{"ifXTable"},
+			Overrides: map[string]MetricOverrides{c.name: {Type: c.typ}},
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if c.err {
+			if err == nil {
+				t.Errorf("%s to %s: expected error", c.name, c.typ)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s to %s: %s", c.name, c.typ, err)
+			continue
+		}
+		found := false
+		for _, m := range result.Module.Metrics {
+			if m.Name == c.name || m.Oid == c.name {
+				found = true
+				if m.Type != c.expected {
+					t.Errorf("%s to %s: got type %s, want %s", c.name, c.typ, m.Type, c.expected)
+				}
+				if m.Type != "OctetString" && m.Type != "DisplayString" && m.MaxSize != 0 {
+					t.Errorf("%s to %s: got max_size %d, want none", c.name, c.typ, m.MaxSize)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: no metric", c.name)
+		}
+	}
+
+	// An object the module doesn't walk is only warned about.
+	cfg := &ModuleConfig{
+		Walk:      []string{"ifXTable"},
+		Overrides: map[string]MetricOverrides{"ifIndex": {Type: "gauge"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnUnusedOverride || result.Warnings[0].Label != "ifIndex" {
+		t.Errorf("got warnings %v, want one %s for ifIndex", result.Warnings, warnUnusedOverride)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:      []string{"ifType", "ifInOctets"},
+		Lookups:   []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+		Overrides: map[string]MetricOverrides{"ifType": {Type: "EnumAsInfo"}},
+		Prefix:    "cisco_wlc",
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, m := range result.Module.Metrics {
+		types[m.Name] = m.Type
+		// Label names aren't prefixed.
+		if m.Indexes[0].Labelname != "ifDescr" || m.Lookups[0].Labelname != "ifDescr" {
+			t.Errorf("metric %s: got index %s and lookup %s, want ifDescr", m.Name, m.Indexes[0].Labelname, m.Lookups[0].Labelname)
+		}
+	}
+	expected := map[string]string{"cisco_wlc_ifType": "EnumAsInfo", "cisco_wlc_ifInOctets": "counter"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("got metrics %v, want %v", types, expected)
+	}
+
+	for _, prefix := range []string{"1cisco", "cisco-wlc", "cisco wlc"} {
+		cfg := &ModuleConfig{Walk: []string{"ifType"}, Prefix: prefix}
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+			t.Errorf("expected error for prefix %q", prefix)
+		}
+	}
+}
+
+func TestGenerateEnumValues(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"ifTable"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]string{1: "other", 6: "ethernetCsmacd", 24: "softwareLoopback"}
+	for _, m := range result.Module.Metrics {
+		switch m.Name {
+		case "ifType":
+			if !reflect.DeepEqual(m.EnumValues, expected) {
+				t.Errorf("ifType enum values: got %v, want %v", m.EnumValues, expected)
+			}
+		default:
+			if m.EnumValues != nil {
+				t.Errorf("%s: unexpected enum values %v", m.Name, m.EnumValues)
+			}
+		}
+	}
+
+	// The mapping survives writing out and loading the config.
+	out, err := marshalConfig(config.Config{"if_mib": result.Module})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := config.Config{}
+	if err := yaml.Unmarshal(out, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range loaded["if_mib"].Metrics {
+		if m.Name == "ifType" && !reflect.DeepEqual(m.EnumValues, expected) {
+			t.Errorf("ifType enum values after loading: got %v, want %v", m.EnumValues, expected)
+		}
+	}
+}
+
+func TestEnumAsInfoOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	expected := map[int]string{1: "other", 6: "ethernetCsmacd", 24: "softwareLoopback"}
+
+	// Overrides can be keyed by object name or OID.
+	for _, key := range []string{"ifType", "1.3.6.1.2.1.2.2.1.3"} {
+		cfg := &ModuleConfig{
+			Walk:      []string{"ifTable"},
+			Overrides: map[string]MetricOverrides{key: {Type: "EnumAsInfo"}},
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", key, err)
+		}
+		for _, m := range result.Module.Metrics {
+			if m.Name != "ifType" {
+				if m.Type == "EnumAsInfo" {
+					t.Errorf("%s: override applied to %s", key, m.Name)
+				}
+				continue
+			}
+			if m.Type != "EnumAsInfo" || !reflect.DeepEqual(m.EnumValues, expected) {
+				t.Errorf("%s: got type %s and enum values %v", key, m.Type, m.EnumValues)
+			}
+		}
+	}
+
+	// Objects without enumerations can't be overridden.
+	cfg := &ModuleConfig{
+		Walk:      []string{"ifTable"},
+		Overrides: map[string]MetricOverrides{"ifInOctets": {Type: "EnumAsInfo"}},
+	}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "no enumerations") {
+		t.Errorf("Expected error overriding ifInOctets, got %v", err)
+	}
+	cfg.Overrides = map[string]MetricOverrides{"ifType": {Type: "EnumAsFoo"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("Expected error for unknown override type")
+	}
+}
+
+func TestEnumAsStateSetOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{
+		Walk:      []string{"ifTable"},
+		Overrides: map[string]MetricOverrides{"ifType": {Type: "EnumAsStateSet"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]string{1: "other", 6: "ethernetCsmacd", 24: "softwareLoopback"}
+	for _, m := range result.Module.Metrics {
+		if m.Name == "ifType" && (m.Type != "EnumAsStateSet" || !reflect.DeepEqual(m.EnumValues, expected)) {
+			t.Errorf("Got type %s and enum values %v", m.Type, m.EnumValues)
+		}
+	}
+
+	// Too many states for the limit.
+	cfg.Overrides = map[string]MetricOverrides{"ifType": {Type: "EnumAsStateSet", MaxStates: 2}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "max_states of 2") {
+		t.Errorf("Expected error for too many states, got %v", err)
+	}
+
+	// The default limit.
+	many := map[int]string{}
+	for i := 0; i <= defaultMaxStates; i++ {
+		many[i] = fmt.Sprintf("state%d", i)
+	}
+	nameToNode["ifType"].EnumValues = many
+	cfg.Overrides = map[string]MetricOverrides{"ifType": {Type: "EnumAsStateSet"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("Expected error for more states than the default limit")
+	}
+	cfg.Overrides = map[string]MetricOverrides{"ifType": {Type: "EnumAsStateSet", MaxStates: 1000}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err != nil {
+		t.Errorf("Unexpected error with raised limit: %s", err)
+	}
+}
+
+func TestIgnoreOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		overrides map[string]MetricOverrides
+		metrics   []string
+		ignored   []string
+		walk      []string
+	}{
+		{
+			overrides: map[string]MetricOverrides{
+				"ifType":              {Ignore: true},
+				"1.3.6.1.2.1.2.2.1.6": {Ignore: true},
+				"ifDescr":             {Ignore: true},
+				"ifInOctets":          {Type: "gauge"},
+			},
+			metrics: []string{"ifIndex", "ifInOctets"},
+			ignored: []string{"ifDescr", "ifType", "ifPhysAddress"},
+			// ifDescr is still walked for the lookup.
+			walk: []string{"1.3.6.1.2.1.2.2.1.1", "1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		},
+		{
+			// A prefix drops everything under it.
+			overrides: map[string]MetricOverrides{"ifEntry": {Ignore: true}},
+			metrics:   []string{},
+			ignored:   []string{"ifIndex", "ifDescr", "ifType", "ifPhysAddress", "ifInOctets"},
+			walk:      []string{"1.3.6.1.2.1.2.2.1.2"},
+		},
+	}
+	for i, c := range cases {
+		cfg := &ModuleConfig{
+			Walk:      []string{"ifTable"},
+			Lookups:   []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+			Overrides: c.overrides,
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		metrics := []string{}
+		for _, m := range result.Module.Metrics {
+			metrics = append(metrics, m.Name)
+		}
+		if !reflect.DeepEqual(metrics, c.metrics) {
+			t.Errorf("%d: got metrics %v, want %v", i, metrics, c.metrics)
+		}
+		ignored := []string{}
+		for _, s := range result.Ignored {
+			ignored = append(ignored, s.Label)
+		}
+		if !reflect.DeepEqual(ignored, c.ignored) {
+			t.Errorf("%d: got ignored %v, want %v", i, ignored, c.ignored)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, c.walk) {
+			t.Errorf("%d: got walk %v, want %v", i, result.Module.Walk, c.walk)
+		}
+	}
+}
+
+func TestRenameOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{
+		Walk:    []string{"ifTable"},
+		Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+		Prefix:  "net",
+		Overrides: map[string]MetricOverrides{
+			"ifInOctets": {Name: "in_bytes"},
+			"ifType":     {Name: "interface_type", NameAbsolute: true},
+		},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]*config.Metric{}
+	for _, m := range result.Module.Metrics {
+		names[m.Name] = m
+	}
+	for _, name := range []string{"net_in_bytes", "interface_type", "net_ifDescr"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("no metric %s in %v", name, names)
+		}
+	}
+	// Indexes and lookups are kept.
+	if m, ok := names["net_in_bytes"]; ok {
+		if len(m.Indexes) != 1 || m.Indexes[0].Labelname != "ifDescr" || len(m.Lookups) != 1 || m.Lookups[0].Labelname != "ifDescr" {
+			t.Errorf("got indexes %v and lookups %v, want ifDescr", m.Indexes, m.Lookups)
+		}
+	}
+
+	// Names must be valid, and not collide.
+	cfg.Overrides = map[string]MetricOverrides{"ifInOctets": {Name: "in-bytes"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error for an invalid name")
+	}
+	cfg.Overrides = map[string]MetricOverrides{"ifInOctets": {Name: "ifDescr"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error for a colliding name")
+	}
+}
+
+func TestHelpOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	no := false
+	cfg := &ModuleConfig{
+		Walk: []string{"ifTable"},
+		Overrides: map[string]MetricOverrides{
+			"ifInOctets":          {Help: "Bytes received,\n  including\tframing."},
+			"1.3.6.1.2.1.2.2.1.3": {Help: "The type.", HelpIncludeOid: &no},
+		},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"ifInOctets": "Bytes received, including framing. - 1.3.6.1.2.1.2.2.1.10",
+		"ifType":     "The type.",
+	}
+	for _, m := range result.Module.Metrics {
+		if help, ok := expected[m.Name]; ok && m.Help != help {
+			t.Errorf("%s: got help %q, want %q", m.Name, m.Help, help)
+		}
+	}
+
+	// Help for ignored metrics is warned about.
+	cfg.Overrides = map[string]MetricOverrides{"ifInOctets": {Help: "Unused.", Ignore: true}}
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnUnusedOverride {
+		t.Errorf("got warnings %v, want one %s", result.Warnings, warnUnusedOverride)
+	}
+}
+
+func TestMetricFilters(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		filters MetricFilters
+		metrics []string
+		walk    []string
+	}{
+		{
+			filters: MetricFilters{Include: []string{"^if(In|Out)Octets$", "^ifType"}},
+			metrics: []string{"ifType", "ifInOctets"},
+			walk:    []string{"1.3.6.1.2.1.2.2.1.3", "1.3.6.1.2.1.2.2.1.10"},
+		},
+		{
+			filters: MetricFilters{Include: []string{"^if"}, Exclude: []string{"Octets$", "^ifIndex$"}},
+			metrics: []string{"ifDescr", "ifType", "ifPhysAddress"},
+			walk:    []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.3", "1.3.6.1.2.1.2.2.1.6"},
+		},
+		{
+			// Nothing filtered, so the table is walked whole.
+			filters: MetricFilters{Exclude: []string{"^sys"}},
+			metrics: []string{"ifIndex", "ifDescr", "ifType", "ifPhysAddress", "ifInOctets"},
+			walk:    []string{"1.3.6.1.2.1.2.2"},
+		},
+	}
+	for i, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"ifTable"}, MetricFilters: c.filters}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		metrics := []string{}
+		for _, m := range result.Module.Metrics {
+			metrics = append(metrics, m.Name)
+		}
+		if !reflect.DeepEqual(metrics, c.metrics) {
+			t.Errorf("%d: got metrics %v, want %v", i, metrics, c.metrics)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, c.walk) {
+			t.Errorf("%d: got walk %v, want %v", i, result.Module.Walk, c.walk)
+		}
+	}
+
+	cfg := &ModuleConfig{Walk: []string{"ifTable"}, MetricFilters: MetricFilters{Exclude: []string{"("}}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "'('") {
+		t.Errorf("got error %v, want one about the invalid filter", err)
+	}
+}
+
+func TestGetScalars(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	for _, get := range [][]string{{"ifNumber"}, {"1.3.6.1.2.1.2.1.0"}, {"ifNumber", "1.3.6.1.2.1.2.1"}} {
+		cfg := &ModuleConfig{Walk: []string{"ifTable"}, Get: get}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%v: %s", get, err)
+		}
+		if !reflect.DeepEqual(result.Module.Get, []string{"1.3.6.1.2.1.2.1.0"}) {
+			t.Errorf("%v: got get %v, want ifNumber.0", get, result.Module.Get)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, []string{"1.3.6.1.2.1.2.2"}) {
+			t.Errorf("%v: got walk %v, want ifTable", get, result.Module.Walk)
+		}
+		found := false
+		for _, m := range result.Module.Metrics {
+			if m.Name == "ifNumber" && m.Oid == "1.3.6.1.2.1.2.1" && len(m.Indexes) == 0 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%v: no ifNumber metric", get)
+		}
+	}
+
+	for _, cfg := range []*ModuleConfig{
+		{Get: []string{"ifTable"}},
+		{Get: []string{"ifDescr"}},
+		{Get: []string{"ifNumber.1"}},
+		{Get: []string{"ifNumber"}, Walk: []string{"interfaces"}},
+	} {
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+			t.Errorf("%v: expected error", cfg.Get)
+		}
+	}
+}
+
+func TestLookupIndexes(t *testing.T) {
+	node := loadFixture(t, "mac_lookup.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:    []string{"hostPackets"},
+		Lookups: []*Lookup{{OldIndex: "hostAddress", NewIndex: "hostName"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Module.Metrics) != 1 {
+		t.Fatalf("got %d metrics, want 1", len(result.Module.Metrics))
+	}
+	m := result.Module.Metrics[0]
+	// The metric's index has a length, but the lookup table's doesn't.
+	if !reflect.DeepEqual(m.Indexes, []*config.Index{{Labelname: "hostName", Type: "OctetString"}}) {
+		t.Errorf("got indexes %+v", m.Indexes[0])
+	}
+	if len(m.Lookups) != 1 {
+		t.Fatalf("got %d lookups, want 1", len(m.Lookups))
+	}
+	expected := []*config.Index{{Labelname: "hostNameAddress", Type: "OctetString", FixedSize: 6}}
+	if !reflect.DeepEqual(m.Lookups[0].Indexes, expected) {
+		t.Errorf("got lookup indexes %+v, want %+v", m.Lookups[0].Indexes[0], expected[0])
+	}
+}
+
+func TestDropSourceIndexes(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:    []string{"ifInOctets"},
+		Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr", DropSourceIndexes: true}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Module.Metrics[0]
+	// The exporter gets ifIndex, then drops it once ifDescr is looked up.
+	if m.Indexes[0].Labelname != "ifIndex" {
+		t.Errorf("got index %s, want ifIndex", m.Indexes[0].Labelname)
+	}
+	lookup := m.Lookups[0]
+	if !reflect.DeepEqual(lookup.Labels, []string{"ifIndex"}) || lookup.Labelname != "ifDescr" || !lookup.DropSourceIndexes {
+		t.Errorf("got lookup %+v, want ifIndex to ifDescr dropping ifIndex", lookup)
+	}
+	// ifDescr isn't guaranteed to be unique.
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnNonUniqueLookup {
+		t.Errorf("got warnings %v, want one %s", result.Warnings, warnNonUniqueLookup)
+	}
+}
+
+func TestKeepSourceIndexes(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		lookup  *Lookup
+		index   string
+		labels  []string
+		errText string
+	}{
+		// By default the index's label is replaced by the looked up one.
+		{lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "ifDescr"}, index: "ifDescr", labels: []string{"ifDescr"}},
+		// Keeping it looks up ifIndex into a label of its own.
+		{lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "ifDescr", KeepSourceIndexes: true}, index: "ifIndex", labels: []string{"ifIndex"}},
+		{
+			lookup:  &Lookup{OldIndex: "ifIndex", NewIndex: "ifDescr", KeepSourceIndexes: true, DropSourceIndexes: true},
+			errText: "Lookup to 'ifDescr' has both drop_source_indexes and keep_source_indexes",
+		},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"ifInOctets"}, Lookups: []*Lookup{c.lookup}}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if c.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), c.errText) {
+				t.Errorf("%+v: got error %v, want %q", c.lookup, err, c.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := result.Module.Metrics[0]
+		if m.Indexes[0].Labelname != c.index {
+			t.Errorf("%+v: got index %s, want %s", c.lookup, m.Indexes[0].Labelname, c.index)
+		}
+		lookup := m.Lookups[0]
+		if !reflect.DeepEqual(lookup.Labels, c.labels) || lookup.Labelname != "ifDescr" || lookup.DropSourceIndexes {
+			t.Errorf("%+v: got lookup %+v, want %v to ifDescr", c.lookup, lookup, c.labels)
+		}
+	}
+}
+
+func TestChainedLookups(t *testing.T) {
+	node := loadFixture(t, "chain_lookup.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk: []string{"portPackets"},
+		Lookups: []*Lookup{
+			{OldIndex: "portIndex", NewIndex: "portModule"},
+			{OldIndex: "portModule", NewIndex: "moduleName"},
+		},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Module.Metrics[0]
+	expected := []*config.Lookup{
+		{
+			Labels:    []string{"portModule"},
+			Labelname: "portModule",
+			Oid:       "1.3.6.1.4.1.99998.1.1.3",
+			Type:      "gauge",
+			Indexes:   []*config.Index{{Labelname: "portIndex", Type: "gauge"}},
+		},
+		{
+			// Looks up the value of portModule.
+			Labels:    []string{"portModule"},
+			Labelname: "moduleName",
+			Oid:       "1.3.6.1.4.1.99998.2.1.2",
+			Type:      "DisplayString",
+			Indexes:   []*config.Index{{Labelname: "moduleIndex", Type: "gauge"}},
+		},
+	}
+	if !reflect.DeepEqual(m.Lookups, expected) {
+		out, _ := yaml.Marshal(m.Lookups)
+		t.Errorf("got lookups %s", out)
+	}
+	walk := []string{"1.3.6.1.4.1.99998.1.1.2", "1.3.6.1.4.1.99998.1.1.3", "1.3.6.1.4.1.99998.2.1.2"}
+	if !reflect.DeepEqual(result.Module.Walk, walk) {
+		t.Errorf("got walk %v, want %v", result.Module.Walk, walk)
+	}
+
+	for _, c := range []struct {
+		lookups []*Lookup
+		err     string
+	}{
+		{
+			lookups: []*Lookup{
+				{OldIndex: "portModule", NewIndex: "moduleName"},
+				{OldIndex: "moduleName", NewIndex: "portModule"},
+			},
+			err: "portModule -> moduleName -> portModule is a cycle",
+		},
+		{
+			lookups: []*Lookup{
+				{OldIndex: "portModule", NewIndex: "moduleName"},
+				{OldIndex: "moduleName", NewIndex: "portPackets"},
+			},
+			err: "portModule -> moduleName -> portPackets starts at portModule",
+		},
+		{
+			// In the wrong order.
+			lookups: []*Lookup{
+				{OldIndex: "portModule", NewIndex: "moduleName"},
+				{OldIndex: "portIndex", NewIndex: "portModule"},
+			},
+			err: "portModule -> moduleName starts at portModule",
+		},
+	} {
+		cfg := &ModuleConfig{Walk: []string{"portPackets"}, Lookups: c.lookups}
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("got error %v, want %q", err, c.err)
+		}
+	}
+}
+
+func TestMultiIndexLookups(t *testing.T) {
+	node := loadFixture(t, "multi_lookup.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:    []string{"usageUsed", "storageSize"},
+		Lookups: []*Lookup{{OldIndexes: []string{"storageIndex", "instance"}, NewIndex: "descrName"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range result.Module.Metrics {
+		switch m.Name {
+		case "usageUsed":
+			if len(m.Lookups) != 1 || !reflect.DeepEqual(m.Lookups[0].Labels, []string{"storageIndex", "instance"}) || m.Lookups[0].Labelname != "descrName" {
+				out, _ := yaml.Marshal(m.Lookups)
+				t.Errorf("got lookups %s, want storageIndex and instance to descrName", out)
+			}
+			// The indexes are kept.
+			if len(m.Indexes) != 2 || m.Indexes[0].Labelname != "storageIndex" || m.Indexes[1].Labelname != "instance" {
+				t.Errorf("got indexes %v, want storageIndex and instance", m.Indexes)
+			}
+		case "storageSize":
+			// Doesn't have instance, so is left alone.
+			if len(m.Lookups) != 0 {
+				t.Errorf("got lookups %v for storageSize, want none", m.Lookups)
+			}
+		}
+	}
+
+	for _, lookup := range []*Lookup{
+		{OldIndexes: []string{"instance", "storageIndex"}, NewIndex: "descrName"},
+		{OldIndexes: []string{"storageIndex"}, NewIndex: "descrName"},
+		{OldIndex: "storageIndex", OldIndexes: []string{"storageIndex", "instance"}, NewIndex: "descrName"},
+		{OldIndexes: []string{"storageIndex", "noSuchIndex"}, NewIndex: "descrName"},
+	} {
+		cfg := &ModuleConfig{Walk: []string{"usageUsed"}, Lookups: []*Lookup{lookup}}
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+			t.Errorf("%v: expected error", lookup.OldIndexes)
+		}
+	}
+}
+
+func TestLookupRegexpExtracts(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		extracts string
+		err      string
+	}{
+		{extracts: `[{regex: '(\S+) - .*', value: '$1'}, {regex: '(?P<name>.*)', value: '${name}!$$'}]`},
+		{extracts: `[{regex: '(\S+) - .*', value: '$2'}]`, err: "no group 2"},
+		{extracts: `[{regex: '(\S+) - .*', value: '${name}'}]`, err: "no group named name"},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{}
+		in := "walk: [ifInOctets]\nlookups: [{old_index: ifIndex, new_index: ifDescr, regex_extracts: " + c.extracts + "}]"
+		if err := yaml.UnmarshalStrict([]byte(in), cfg); err != nil {
+			t.Fatal(err)
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got error %v, want %q", c.extracts, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.extracts, err)
+		}
+		lookup := result.Module.Metrics[0].Lookups[0]
+		if len(lookup.RegexpExtracts) != 2 || lookup.RegexpExtracts[0].Value != "$1" {
+			t.Errorf("%s: got regex extracts %v", c.extracts, lookup.RegexpExtracts)
+		}
+	}
+}
+
+func TestOverrideRegexpExtracts(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		overrides string
+		errs      []string
+		warning   bool
+	}{
+		{overrides: `{ifDescr: {regex_extracts: {Speed: [{regex: '(\d+)G'}, {regex: '.*', value: '0'}]}}}`},
+		{
+			overrides: `{ifDescr: {regex_extracts: {Speed: [{regex: '(\d+'}, {regex: '(\d+)', value: '$2'}], Bad-Name: [{regex: '.*', value: '1'}], Empty: [{value: '1'}]}}}`,
+			errs: []string{
+				`Override of 'ifDescr' has a bad regex extract for 'Speed': invalid regex '(\d+': error parsing regexp: missing closing )`,
+				`Override of 'ifDescr' has a bad regex extract for 'Speed': invalid value '$2': regex '^(?:(\d+))$' has no group 2`,
+				"Override of 'ifDescr' has regex extracts named 'Bad-Name', which can only have letters, digits and underscores",
+				"Override of 'ifDescr' has a bad regex extract for 'Empty': no regex",
+			},
+		},
+		{overrides: `{ifInOctets: {regex_extracts: {Big: [{regex: '\d{10,}', value: '1'}]}}}`, warning: true},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{}
+		in := "walk: [ifDescr, ifInOctets]\noverrides: " + c.overrides
+		if err := yaml.UnmarshalStrict([]byte(in), cfg); err != nil {
+			t.Fatal(err)
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if len(c.errs) != 0 {
+			if err == nil {
+				t.Errorf("%s: expected errors", c.overrides)
+				continue
+			}
+			for _, e := range c.errs {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("%s: got error %q, want it to contain %q", c.overrides, err, e)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.overrides, err)
+		}
+		warned := false
+		for _, w := range result.Warnings {
+			if w.Category == warnNumericRegexpExtract {
+				warned = true
+			}
+		}
+		if warned != c.warning {
+			t.Errorf("%s: got warnings %v, want numeric regex extract warning %t", c.overrides, result.Warnings, c.warning)
+		}
+		for _, metric := range result.Module.Metrics {
+			if metric.Name != "ifDescr" || c.warning {
+				continue
+			}
+			extracts := metric.RegexpExtracts["Speed"]
+			if len(extracts) != 2 || extracts[0].Value != "$1" || extracts[0].Regex.String() != `^(?:(\d+)G)$` {
+				t.Errorf("%s: got regex extracts %v", c.overrides, metric.RegexpExtracts)
+			}
+		}
+	}
+}
+
+func TestAllowMissing(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{Walk: []string{"ifInOctets", "noSuchObject"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error for a missing oid")
+	}
+
+	cfg.AllowMissing = true
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Module.Metrics) != 1 || result.Module.Metrics[0].Name != "ifInOctets" {
+		t.Errorf("got metrics %v, want ifInOctets", result.Module.Metrics)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnMissingOid {
+		t.Errorf("got warnings %v, want one %s", result.Warnings, warnMissingOid)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Label != "noSuchObject" {
+		t.Errorf("got skipped %v, want noSuchObject", result.Skipped)
+	}
+	// The config is left alone.
+	if len(cfg.Walk) != 2 {
+		t.Errorf("got walk %v, want it unchanged", cfg.Walk)
+	}
+
+	cfg.AllowMissing = false
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{skipMissing: true}); err != nil {
+		t.Errorf("got error %s with --skip-missing", err)
+	}
+}
+
+func TestAllowUnknownOids(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{Walk: []string{"ifInOctets", "1.3.6.1.4.1.9999.1"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error for an unknown oid")
+	}
+
+	cfg.AllowUnknownOids = true
+	cfg.AppendUnitSuffix = true
+	cfg.Overrides = map[string]MetricOverrides{"oid_1_3_6_1_4_1_9999_1": {Name: "vendor"}}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &config.Metric{
+		Name:    "vendor",
+		Oid:     "1.3.6.1.4.1.9999.1",
+		Type:    "Auto",
+		Help:    "Objects under an oid that isn't in the MIBs - 1.3.6.1.4.1.9999.1",
+		Indexes: []*config.Index{},
+		Lookups: []*config.Lookup{},
+	}
+	if len(result.Module.Metrics) != 2 || !reflect.DeepEqual(result.Module.Metrics[1], expected) {
+		out, _ := yaml.Marshal(result.Module.Metrics)
+		t.Errorf("got metrics %s", out)
+	}
+	walk := []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.4.1.9999.1"}
+	if !reflect.DeepEqual(result.Module.Walk, walk) {
+		t.Errorf("got walk %v, want %v", result.Module.Walk, walk)
+	}
+
+	// Names are still checked.
+	cfg.Walk = []string{"noSuchObject"}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("expected error for an unknown name")
+	}
+}
+
+func TestDynamicFilters(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{
+		Walk:    []string{"interfaces"},
+		Filters: Filters{Dynamic: []DynamicFilter{{Oid: "ifType", Targets: []string{"ifTable"}, Values: []string{"ethernetCsmacd", "24"}}}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := result.Module
+	wantFilters := []config.DynamicFilter{{
+		Oid:     "1.3.6.1.2.1.2.2.1.3",
+		Targets: []string{"1.3.6.1.2.1.2.2.1.1", "1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.6", "1.3.6.1.2.1.2.2.1.10"},
+		Values:  []string{"6", "24"},
+	}}
+	if !reflect.DeepEqual(out.Filters, wantFilters) {
+		t.Errorf("got filters %+v, want %+v", out.Filters, wantFilters)
+	}
+	// The targets are got by the exporter, so only the source is walked.
+	wantWalk := []string{"1.3.6.1.2.1.2.1", "1.3.6.1.2.1.2.2.1.3"}
+	if !reflect.DeepEqual(out.Walk, wantWalk) {
+		t.Errorf("got walk %v, want %v", out.Walk, wantWalk)
+	}
+
+	node = loadFixture(t, "multi_lookup.json")
+	nameToNode, _ = prepareTree(node, generatorOptions{})
+	cfg = &ModuleConfig{
+		Walk:    []string{"example"},
+		Filters: Filters{Dynamic: []DynamicFilter{{Oid: "descrName", Targets: []string{"usageTable"}, Values: []string{"root"}}}},
+	}
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Module.Filters) != 1 || len(result.Module.Filters[0].Targets) != 3 {
+		t.Errorf("got filters %+v, want the three columns of usageTable", result.Module.Filters)
+	}
+
+	cases := []struct {
+		filter DynamicFilter
+		err    string
+	}{
+		{
+			filter: DynamicFilter{Oid: "storageSize", Targets: []string{"usageTable"}, Values: []string{"1"}},
+			err:    "Dynamic filter on 'storageSize' can't apply to 'usageTable', as its indexes are storageIndex, instance rather than storageIndex",
+		},
+		{
+			filter: DynamicFilter{Oid: "usageTable", Targets: []string{"usageTable"}, Values: []string{"1"}},
+			err:    "Cannot filter on 'usageTable' as it is not an accessible column of a table",
+		},
+		{
+			filter: DynamicFilter{Oid: "descrName", Targets: []string{"example"}, Values: []string{"1"}},
+			err:    "Cannot filter 'example' as it is not a table, entry or column",
+		},
+		{
+			filter: DynamicFilter{Oid: "descrName", Targets: []string{"usageUsed"}},
+			err:    "Dynamic filter on 'descrName' has no values",
+		},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"example"}, Filters: Filters{Dynamic: []DynamicFilter{c.filter}}}
+		_, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("filter %+v: got error %v, want %q", c.filter, err, c.err)
+		}
+	}
+}
+
+func TestStaticFilters(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{
+		Walk: []string{"interfaces"},
+		Filters: Filters{
+			Static:  []StaticFilter{{Targets: []string{"ifInOctets", "ifDescr"}, Indices: []string{"1", "3", "17"}}},
+			Dynamic: []DynamicFilter{{Oid: "ifType", Targets: []string{"ifPhysAddress"}, Values: []string{"6"}}},
+		},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := result.Module
+	wantFilters := []config.StaticFilter{{
+		Targets: []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.2.1.2"},
+		Indices: []string{"1", "3", "17"},
+	}}
+	if !reflect.DeepEqual(out.StaticFilters, wantFilters) {
+		t.Errorf("got static filters %+v, want %+v", out.StaticFilters, wantFilters)
+	}
+	wantWalk := []string{"1.3.6.1.2.1.2.1", "1.3.6.1.2.1.2.2.1.1", "1.3.6.1.2.1.2.2.1.3"}
+	if !reflect.DeepEqual(out.Walk, wantWalk) {
+		t.Errorf("got walk %v, want %v", out.Walk, wantWalk)
+	}
+
+	cases := []struct {
+		filters Filters
+		err     string
+	}{
+		{
+			filters: Filters{Static: []StaticFilter{{Targets: []string{"ifDescr"}, Indices: []string{"1", ".2", "3.x"}}}},
+			err:     "Invalid index '.2' in static filter 1, must be a sub-OID such as 3 or 4.1.2; Invalid index '3.x'",
+		},
+		{
+			filters: Filters{Static: []StaticFilter{{Targets: []string{"ifNumber"}, Indices: []string{"1"}}}},
+			err:     "Cannot filter 'ifNumber' as it is not a table, entry or column",
+		},
+		{
+			filters: Filters{Static: []StaticFilter{{Targets: []string{"ifDescr"}, Indices: []string{"1"}}, {Targets: []string{"ifEntry"}, Indices: []string{"2"}}}},
+			err:     "'ifDescr' is filtered by both the static filter 1 and the static filter 2",
+		},
+		{
+			filters: Filters{Static: []StaticFilter{{Targets: []string{"ifDescr"}}}},
+			err:     "Static filter 1 has no indices",
+		},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"interfaces"}, Filters: c.filters}
+		_, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("filters %+v: got error %v, want %q", c.filters, err, c.err)
+		}
+	}
+}
+
+func TestScaleOverride(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{}
+	in := "walk: [ifInOctets, ifNumber]\noverrides: {ifInOctets: {scale: 8, name: ifInBits}, ifNumber: {offset: -1}}"
+	if err := yaml.UnmarshalStrict([]byte(in), cfg); err != nil {
+		t.Fatal(err)
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][2]float64{}
+	for _, metric := range result.Module.Metrics {
+		got[metric.Name] = [2]float64{metric.Scale, metric.Offset}
+	}
+	want := map[string][2]float64{"ifInBits": {8, 0}, "ifNumber": {0, -1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got scales and offsets %v, want %v", got, want)
+	}
+
+	// The exporter reads them back.
+	out, err := yaml.Marshal(config.Config{"test": result.Module})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporterConfig := config.Config{}
+	if err := yaml.UnmarshalStrict(out, &exporterConfig); err != nil {
+		t.Fatal(err)
+	}
+	got = map[string][2]float64{}
+	for _, metric := range exporterConfig["test"].Metrics {
+		got[metric.Name] = [2]float64{metric.Scale, metric.Offset}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got scales and offsets %v from %s, want %v", got, out, want)
+	}
+
+	cases := []struct {
+		overrides string
+		err       string
+	}{
+		{overrides: "{ifInOctets: {scale: 0}}", err: "Override of 'ifInOctets' has a scale of 0"},
+		{overrides: "{ifType: {type: EnumAsInfo, scale: 2}}", err: "Override of 'ifType' sets a scale or offset with type EnumAsInfo, only gauge and counter can be scaled"},
+		{overrides: "{ifDescr: {offset: 2}}", err: "Cannot scale ifDescr, as it is of type DisplayString rather than gauge or counter"},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{}
+		in := "walk: [ifEntry]\noverrides: " + c.overrides
+		if err := yaml.UnmarshalStrict([]byte(in), cfg); err != nil {
+			t.Fatal(err)
+		}
+		_, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want %q", c.overrides, err, c.err)
+		}
+	}
+}
+
+func TestRegexpExtractFallbacks(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		module string
+		errs   []string
+	}{
+		{module: "overrides: {ifDescr: {regex_extracts: {Speed: [{regex: '(\\d+)G'}]}, regex_extract_fallbacks: {Speed: '-1'}}}"},
+		{module: "overrides: {ifDescr: {regex_extracts: {Speed: [{regex: '(\\d+)G'}]}}}"},
+		{
+			module: "strict_extracts: true\noverrides: {ifDescr: {regex_extracts: {Speed: [{regex: '(\\d+)G'}]}}}",
+			errs:   []string{"Override of 'ifDescr' has no fallback for regex extract 'Speed', which strict_extracts requires"},
+		},
+		{
+			module: "overrides: {ifDescr: {regex_extracts: {Speed: [{regex: '(\\d+)G'}]}, regex_extract_fallbacks: {Speed: unknown, Duplex: '0'}}}",
+			errs: []string{
+				"Override of 'ifDescr' has fallback 'unknown' for regex extract 'Speed', which isn't a number",
+				"Override of 'ifDescr' has a fallback for regex extract 'Duplex', which it doesn't have",
+			},
+		},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{}
+		if err := yaml.UnmarshalStrict([]byte("walk: [ifDescr]\n"+c.module), cfg); err != nil {
+			t.Fatal(err)
+		}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if len(c.errs) != 0 {
+			if err == nil {
+				t.Errorf("%s: expected errors", c.module)
+				continue
+			}
+			for _, e := range c.errs {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("%s: got error %q, want it to contain %q", c.module, err, e)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.module, err)
+		}
+		fallbacks := result.Module.Metrics[0].RegexpExtractFallbacks
+		if strings.Contains(c.module, "fallbacks") && fallbacks["Speed"] != "-1" {
+			t.Errorf("%s: got fallbacks %v", c.module, fallbacks)
+		}
+	}
+}
+
+func TestNumericOidLookups(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		lookup    *Lookup
+		labelname string
+		oid       string
+		typ       string
+		err       string
+	}{
+		// OIDs in the MIBs are the same as their names.
+		{lookup: &Lookup{OldIndex: ".1.3.6.1.2.1.2.2.1.1", NewIndex: "1.3.6.1.2.1.2.2.1.2"}, labelname: "ifDescr", oid: "1.3.6.1.2.1.2.2.1.2", typ: "DisplayString"},
+		// Others are made up with the type given.
+		{lookup: &Lookup{OldIndex: "ifIndex", NewIndex: ".1.3.6.1.4.1.9999.1.2", Type: "DisplayString"}, labelname: "oid_1_3_6_1_4_1_9999_1_2", oid: "1.3.6.1.4.1.9999.1.2", typ: "DisplayString"},
+		{lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "1.3.6.1.4.1.9999.1.2", Type: "gauge"}, labelname: "oid_1_3_6_1_4_1_9999_1_2", oid: "1.3.6.1.4.1.9999.1.2", typ: "gauge"},
+		{
+			lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "1.3.6.1.4.1.9999.1.2"},
+			err:    "Cannot find oid '1.3.6.1.4.1.9999.1.2' to look up in the MIBs, so the lookup needs a type such as DisplayString or gauge",
+		},
+		{
+			lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "1.3.6.1.4.1.9999.1.2", Type: "EnumAsInfo"},
+			err:    "Unknown type 'EnumAsInfo' for lookup to '1.3.6.1.4.1.9999.1.2', must be one of ",
+		},
+		{
+			lookup: &Lookup{OldIndex: "ifIndex", NewIndex: "ifDescr", Type: "gauge"},
+			err:    "Lookup to 'ifDescr' has a type, which is only for numeric OIDs that aren't in the MIBs",
+		},
+	}
+	for _, c := range cases {
+		cfg := &ModuleConfig{Walk: []string{"ifInOctets"}, Lookups: []*Lookup{c.lookup}}
+		result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%+v: got error %v, want %q", c.lookup, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%+v: %s", c.lookup, err)
+		}
+		m := result.Module
+		lookup := m.Metrics[0].Lookups[0]
+		if lookup.Labelname != c.labelname || lookup.Oid != c.oid || lookup.Type != c.typ {
+			t.Errorf("%+v: got lookup %+v", c.lookup, lookup)
+		}
+		if !reflect.DeepEqual(m.Walk, sortOids([]string{"1.3.6.1.2.1.2.2.1.10", c.oid})) {
+			t.Errorf("%+v: got walk %v", c.lookup, m.Walk)
+		}
+	}
+}
+
+func TestOverlappingWalks(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	// The lookup's table is walked directly, with and without a leading dot,
+	// as well as by the lookup itself.
+	cfg := &ModuleConfig{
+		Walk:             []string{"interfaces", "ifTable", "1.3.6.1.4.1.9999.1.2", ".1.3.6.1.4.1.9999.1.2"},
+		AllowUnknownOids: true,
+		Lookups:          []*Lookup{{OldIndex: "ifIndex", NewIndex: "1.3.6.1.4.1.9999.1.2", Type: "DisplayString"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]int{}
+	for _, m := range result.Module.Metrics {
+		seen[m.Oid]++
+	}
+	for oid, count := range seen {
+		if count != 1 {
+			t.Errorf("got %d metrics for %s, want 1", count, oid)
+		}
+	}
+	if seen["1.3.6.1.4.1.9999.1.2"] != 1 || seen["1.3.6.1.2.1.2.2.1.10"] != 1 {
+		t.Errorf("missing metrics, got %v", seen)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		cfg      *ModuleConfig
+		metrics  []string
+		walk     []string
+		warnings int
+	}{
+		{
+			cfg:     &ModuleConfig{Walk: []string{"interfaces"}, Exclude: []string{"ifTable"}},
+			metrics: []string{"ifNumber"},
+			walk:    []string{"1.3.6.1.2.1.2.1"},
+		},
+		{
+			// The rest of the table is walked column by column.
+			cfg:     &ModuleConfig{Walk: []string{"ifTable"}, Exclude: []string{"ifPhysAddress", "ifInOctets"}},
+			metrics: []string{"ifDescr", "ifIndex", "ifType"},
+			walk:    []string{"1.3.6.1.2.1.2.2.1.1", "1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.3"},
+		},
+		{
+			// The lookup still needs the excluded column.
+			cfg: &ModuleConfig{
+				Walk:    []string{"ifInOctets"},
+				Exclude: []string{"ifDescr"},
+				Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr"}},
+			},
+			metrics:  []string{"ifInOctets"},
+			walk:     []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+			warnings: 1,
+		},
+	}
+	for _, c := range cases {
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%v: %s", c.cfg.Exclude, err)
+		}
+		metrics := []string{}
+		for _, m := range result.Module.Metrics {
+			metrics = append(metrics, m.Name)
+		}
+		sort.Strings(metrics)
+		if !reflect.DeepEqual(metrics, c.metrics) {
+			t.Errorf("%v: got metrics %v, want %v", c.cfg.Exclude, metrics, c.metrics)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, c.walk) {
+			t.Errorf("%v: got walk %v, want %v", c.cfg.Exclude, result.Module.Walk, c.walk)
+		}
+		warnings := 0
+		for _, w := range result.Warnings {
+			if w.Category == warnExcludedWalked {
+				warnings++
+			}
+		}
+		if warnings != c.warnings {
+			t.Errorf("%v: got warnings %v, want %d", c.cfg.Exclude, result.Warnings, c.warnings)
+		}
+	}
+
+	cfg := &ModuleConfig{Walk: []string{"interfaces"}, Exclude: []string{"ifFoo"}}
+	if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "Cannot find oid 'ifFoo' to exclude") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestAutoInterfaceLookups(t *testing.T) {
+	cases := []struct {
+		fixture string
+		cfg     *ModuleConfig
+		lookups []string
+		walk    []string
+	}{
+		{
+			// Only ifDescr is in the MIBs.
+			fixture: "iftable.json",
+			cfg:     &ModuleConfig{Walk: []string{"ifInOctets"}, AutoInterfaceLookups: true},
+			lookups: []string{"ifDescr"},
+			walk:    []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		},
+		{
+			// Only ifName is in the MIBs.
+			fixture: "augments.json",
+			cfg:     &ModuleConfig{Walk: []string{"ifHCInOctets"}, AutoInterfaceLookups: true},
+			lookups: []string{"ifName"},
+			walk:    []string{"1.3.6.1.2.1.31.1.1.1.1", "1.3.6.1.2.1.31.1.1.1.6"},
+		},
+		{
+			// Lookups already declared aren't added again.
+			fixture: "iftable.json",
+			cfg: &ModuleConfig{
+				Walk:                 []string{"ifInOctets"},
+				Lookups:              []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr", KeepSourceIndexes: true}},
+				AutoInterfaceLookups: true,
+			},
+			lookups: []string{"ifDescr"},
+			walk:    []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		},
+		{
+			// Nothing indexed by ifIndex.
+			fixture: "iftable.json",
+			cfg:     &ModuleConfig{Walk: []string{"ifNumber"}, AutoInterfaceLookups: true},
+			lookups: []string{},
+			walk:    []string{"1.3.6.1.2.1.2.1"},
+		},
+	}
+	for _, c := range cases {
+		node := loadFixture(t, c.fixture)
+		nameToNode, _ := prepareTree(node, generatorOptions{})
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%v: %s", c.cfg.Walk, err)
+		}
+		m := result.Module.Metrics[0]
+		lookups := []string{}
+		for _, l := range m.Lookups {
+			lookups = append(lookups, l.Labelname)
+		}
+		if !reflect.DeepEqual(lookups, c.lookups) {
+			t.Errorf("%v: got lookups %v, want %v", c.cfg.Walk, lookups, c.lookups)
+		}
+		if len(m.Indexes) != 0 && m.Indexes[0].Labelname != "ifIndex" {
+			t.Errorf("%v: got index %s, want ifIndex", c.cfg.Walk, m.Indexes[0].Labelname)
+		}
+		if !reflect.DeepEqual(result.Module.Walk, c.walk) {
+			t.Errorf("%v: got walk %v, want %v", c.cfg.Walk, result.Module.Walk, c.walk)
+		}
+		if len(result.Warnings) != 0 {
+			t.Errorf("%v: got warnings %v", c.cfg.Walk, result.Warnings)
+		}
+		if len(c.cfg.Lookups) > 1 {
+			t.Errorf("%v: config was changed", c.cfg.Walk)
+		}
+	}
+}
+
+func TestSnakeCaseNames(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk:      []string{"ifInOctets", "ifType"},
+		SnakeCase: true,
+		Lookups:   []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr", KeepSourceIndexes: true}},
+		// Overrides use the names from the MIBs.
+		Overrides: map[string]MetricOverrides{
+			"ifInOctets": {RegexpExtracts: map[string][]RegexpExtract{"Parsed": {{Regex: "(.*)", Value: "$1"}}}},
+			"ifType":     {Name: "interfaceType"},
+		},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]*config.Metric{}
+	for _, m := range result.Module.Metrics {
+		metrics[m.Name] = m
+	}
+	m, ok := metrics["if_in_octets"]
+	if !ok {
+		t.Fatalf("no if_in_octets metric, got %v", metrics)
+	}
+	if m.Indexes[0].Labelname != "if_index" {
+		t.Errorf("got index %s, want if_index", m.Indexes[0].Labelname)
+	}
+	if l := m.Lookups[0]; l.Labelname != "if_descr" || !reflect.DeepEqual(l.Labels, []string{"if_index"}) {
+		t.Errorf("got lookup %+v", l)
+	}
+	if _, ok := m.RegexpExtracts["_parsed"]; !ok {
+		t.Errorf("got regex extracts %v, want _parsed", m.RegexpExtracts)
+	}
+	// Renamed metrics keep the name given, but not their labels.
+	if m, ok := metrics["interfaceType"]; !ok || m.Indexes[0].Labelname != "if_index" {
+		t.Errorf("no interfaceType metric indexed by if_index, got %v", metrics)
+	}
+}
+
+func TestRegexpOverrides(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg, err := parseConfig([]byte(`
+modules:
+  a:
+    walk: [ifTable]
+    overrides:
+      '~^ifIn':
+        help: First
+      '~^1\.3\.6\.1\.2\.1\.2\.2\.1\.[23]$':
+        ignore: true
+      '~Octets$':
+        help: Second
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Run several times, as map order varies.
+	for i := 0; i < 10; i++ {
+		result, err := generateConfigModule(context.Background(), cfg.Modules["a"], node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, m := range result.Module.Metrics {
+			names = append(names, m.Name)
+			// The later override wins.
+			if m.Name == "ifInOctets" && m.Help != "Second - 1.3.6.1.2.1.2.2.1.10" {
+				t.Errorf("got help %q", m.Help)
+			}
+		}
+		sort.Strings(names)
+		if want := []string{"ifInOctets", "ifIndex", "ifPhysAddress"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("got metrics %v, want %v", names, want)
+		}
+		warnings := 0
+		for _, w := range result.Warnings {
+			if w.Category == warnOverlappingOverrides {
+				warnings++
+			}
+		}
+		if warnings != 1 {
+			t.Errorf("got warnings %v, want 1 about overlapping overrides", result.Warnings)
+		}
+	}
+
+	// A regex that matches nothing is only warned about.
+	unmatched := &ModuleConfig{Walk: []string{"ifTable"}, Overrides: map[string]MetricOverrides{"~^ifOut": {Type: "gauge"}}}
+	result, err := generateConfigModule(context.Background(), unmatched, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnUnusedOverride || !strings.Contains(result.Warnings[0].Message, "Regex override ~^ifOut doesn't match") {
+		t.Errorf("got warnings %v, want one about ~^ifOut matching nothing", result.Warnings)
+	}
+	if len(result.Module.Metrics) == 0 {
+		t.Error("got no metrics")
+	}
+
+	bad := &ModuleConfig{Walk: []string{"ifTable"}, Overrides: map[string]MetricOverrides{"~(": {Type: "gauge"}}}
+	if _, err := generateConfigModule(context.Background(), bad, node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "Invalid regex in override '~('") {
+		t.Errorf("got error %v, want one about the invalid regex", err)
+	}
+	cfg.Modules["a"].Overrides["~^ifIn"] = MetricOverrides{Name: "foo"}
+	if _, err := generateConfigModule(context.Background(), cfg.Modules["a"], node, nameToNode, generatorOptions{}); err == nil || !strings.Contains(err.Error(), "matches by regex, so can't set a name") {
+		t.Errorf("got error %v", err)
+	}
+}
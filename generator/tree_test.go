@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/prometheus/snmp_exporter/config"
 	yaml "gopkg.in/yaml.v2"
@@ -14,10 +20,10 @@ func TestTreePrepare(t *testing.T) {
 		in  *Node
 		out *Node
 	}{
-		// Descriptions trimmed.
+		// Description whitespace normalised.
 		{
 			in:  &Node{Oid: "1", Description: "A long   sentance.      Even more detail!"},
-			out: &Node{Oid: "1", Description: "A long sentance"},
+			out: &Node{Oid: "1", Description: "A long sentance. Even more detail!"},
 		},
 		// Indexes copied down.
 		{
@@ -87,6 +93,28 @@ func TestTreePrepare(t *testing.T) {
 			in:  &Node{Oid: "1", Label: "ascii", TextualConvention: "DisplayString"},
 			out: &Node{Oid: "1", Label: "ascii", TextualConvention: "DisplayString", Type: "DisplayString"},
 		},
+		// A single SIZE is a fixed size.
+		{
+			in:  &Node{Oid: "1", Label: "fixed", Type: "OCTETSTR", Ranges: []Range{{Low: 6, High: 6}}},
+			out: &Node{Oid: "1", Label: "fixed", Type: "OCTETSTR", Ranges: []Range{{Low: 6, High: 6}}, FixedSize: 6},
+		},
+		{
+			in:  &Node{Oid: "1", Label: "varying", Type: "OCTETSTR", Ranges: []Range{{Low: 0, High: 32}}},
+			out: &Node{Oid: "1", Label: "varying", Type: "OCTETSTR", Ranges: []Range{{Low: 0, High: 32}}},
+		},
+		{
+			in:  &Node{Oid: "1", Label: "either", Type: "OCTETSTR", Ranges: []Range{{Low: 4, High: 4}, {Low: 16, High: 16}}},
+			out: &Node{Oid: "1", Label: "either", Type: "OCTETSTR", Ranges: []Range{{Low: 4, High: 4}, {Low: 16, High: 16}}},
+		},
+		// MAC addresses identified only by their TC.
+		{
+			in:  &Node{Oid: "1", Label: "mac", Type: "OCTETSTR", TextualConvention: "MacAddress"},
+			out: &Node{Oid: "1", Label: "mac", Type: "PhysAddress48", TextualConvention: "MacAddress"},
+		},
+		{
+			in:  &Node{Oid: "1", Label: "mac", Type: "OCTETSTR", TextualConvention: "MacAddr"},
+			out: &Node{Oid: "1", Label: "mac", Type: "PhysAddress48", TextualConvention: "MacAddr"},
+		},
 		// UTF-8 string.
 		{
 			in:  &Node{Oid: "1", Label: "utf8", Hint: "255t"},
@@ -101,7 +129,7 @@ func TestTreePrepare(t *testing.T) {
 			}
 		})
 
-		_ = prepareTree(c.in)
+		prepareTree(c.in, generatorOptions{})
 
 		if !reflect.DeepEqual(c.in, c.out) {
 			t.Errorf("prepareTree: difference in case %d", i)
@@ -118,7 +146,7 @@ func TestTreePrepare(t *testing.T) {
 
 func TestGenerateConfigModule(t *testing.T) {
 	var regexpFooBar config.Regexp
-	regexpFooBar.Regexp, _ = regexp.Compile(".*")
+	regexpFooBar.Regexp, _ = regexp.Compile("^(?:.*)$")
 
 	strMetrics := make(map[string][]config.RegexpExtract)
 	strMetrics["Status"] = []config.RegexpExtract{
@@ -130,7 +158,9 @@ func TestGenerateConfigModule(t *testing.T) {
 
 	overrides := make(map[string]MetricOverrides)
 	metricOverrides := MetricOverrides{
-		RegexpExtracts: strMetrics,
+		RegexpExtracts: map[string][]RegexpExtract{
+			"Status": {{Regex: ".*", Value: "5"}},
+		},
 	}
 	overrides["root"] = metricOverrides
 
@@ -659,6 +689,7 @@ func TestGenerateConfigModule(t *testing.T) {
 								Labelname: "octetDesc",
 								Type:      "OctetString",
 								Oid:       "1.1.1.2",
+								Indexes:   []*config.Index{{Labelname: "octetIndex", Type: "gauge"}},
 							},
 						},
 					},
@@ -706,6 +737,7 @@ func TestGenerateConfigModule(t *testing.T) {
 								Labelname: "octetDesc",
 								Type:      "OctetString",
 								Oid:       "1.1.1.2",
+								Indexes:   []*config.Index{{Labelname: "octetIndex", Type: "gauge"}},
 							},
 						},
 					},
@@ -776,6 +808,7 @@ func TestGenerateConfigModule(t *testing.T) {
 								Labelname: "octet_Desc",
 								Type:      "OctetString",
 								Oid:       "1.1.1.2",
+								Indexes:   []*config.Index{{Labelname: "octet_Index", Type: "gauge"}},
 							},
 						},
 					},
@@ -794,8 +827,12 @@ func TestGenerateConfigModule(t *testing.T) {
 			}
 		}
 
-		nameToNode := prepareTree(c.node)
-		got := generateConfigModule(c.cfg, c.node, nameToNode)
+		nameToNode, _ := prepareTree(c.node, generatorOptions{})
+		result, err := generateConfigModule(context.Background(), c.cfg, c.node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("Error generating config in case %d: %s", i, err)
+		}
+		got := result.Module
 		if !reflect.DeepEqual(got, c.out) {
 			t.Errorf("GenerateConfigModule: difference in case %d", i)
 			out, _ := yaml.Marshal(got)
@@ -805,3 +842,1717 @@ func TestGenerateConfigModule(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateModule(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "table",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableIndex", Type: "OBJID"},
+							{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableDesc", Type: "OCTETSTR"},
+							{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+						}}}},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER"},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "counter-thing", Type: "COUNTER"},
+			{Oid: "1.5", Label: "stringTable",
+				Children: []*Node{
+					{Oid: "1.5.1", Label: "stringEntry", Indexes: []string{"tableName"},
+						Children: []*Node{
+							{Oid: "1.5.1.1", Access: "ACCESS_NOACCESS", Label: "tableName", Type: "OCTETSTR"},
+							{Oid: "1.5.1.2", Access: "ACCESS_READONLY", Label: "stringCount", Type: "INTEGER"},
+							{Oid: "1.5.1.3", Access: "ACCESS_READONLY", Label: "stringDesc", Type: "OCTETSTR"},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		cfg  *ModuleConfig
+		errs []string
+	}{
+		// Valid config.
+		{
+			cfg: &ModuleConfig{
+				Walk:      []string{"scalar", "1.3"},
+				Overrides: map[string]MetricOverrides{"scalar": {}, "counter_thing": {}},
+			},
+			errs: []string{},
+		},
+		// All problems are reported, not just the first.
+		{
+			cfg: &ModuleConfig{
+				Walk: []string{"scalar", "missing", "1.4", "table"},
+				Lookups: []*Lookup{
+					{OldIndex: "tableIndex", NewIndex: "tableDesc"},
+					{OldIndex: "tableIndex", NewIndex: "missingDesc"},
+					{OldIndex: "tableIndex", NewIndex: "tableIndex"},
+				},
+				Overrides: map[string]MetricOverrides{"missingOverride": {}},
+			},
+			errs: []string{
+				"Cannot find oid 'missing' to walk",
+				"Cannot find oid '1.4' to walk",
+				"Unknown index 'missingDesc'",
+				"Unknown index type OBJID for tableIndex",
+				"Cannot look up 'tableIndex' to 'tableIndex' as it is not-accessible, try an accessible column of tableEntry such as tableDesc, tableFoo",
+				"Cannot find oid 'missingOverride' to override",
+				"Error, can't handle index type OBJID for node tableDesc",
+				"Error, can't handle index type OBJID for node tableFoo",
+			},
+		},
+		// Not-accessible objects can't be walked or looked up, but their
+		// tables can be walked.
+		{
+			cfg: &ModuleConfig{
+				Walk:    []string{"tableName", "stringTable"},
+				Lookups: []*Lookup{{OldIndex: "tableName", NewIndex: "tableName"}},
+			},
+			errs: []string{
+				"Cannot walk 'tableName' as it is not-accessible, try an accessible column of stringEntry such as stringDesc, stringCount",
+				"Cannot look up 'tableName' to 'tableName' as it is not-accessible, try an accessible column of stringEntry such as stringDesc, stringCount",
+			},
+		},
+	}
+	for i, c := range cases {
+		errs := append(validateModuleConfig(c.cfg, nameToNode), validateModuleIndexes(c.cfg, nameToNode)...)
+		got := []string{}
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, c.errs) {
+			t.Errorf("validateModule: difference in case %d", i)
+			t.Errorf("Got: %q", got)
+			t.Errorf("Wanted: %q", c.errs)
+		}
+	}
+}
+
+func TestGenerationWarnings(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "tableEntry", Indexes: []string{"missingIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+				}},
+			{Oid: "1.2", Label: "augmentingEntry", Augments: "missingEntry"},
+			{Oid: "1.3", Label: "otherEntry", Indexes: []string{"otherIndex"},
+				Children: []*Node{
+					{Oid: "1.3.1", Access: "ACCESS_NOACCESS", Label: "otherIndex", Type: "OBJID"},
+					{Oid: "1.3.2", Access: "ACCESS_READONLY", Label: "otherFoo", Type: "INTEGER"},
+				}},
+			{Oid: "1.4", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER"},
+			{Oid: "1.5", Label: "chainedEntry", Augments: "augmentingEntry"},
+			{Oid: "1.6", Label: "cycleAEntry", Augments: "cycleBEntry"},
+			{Oid: "1.7", Label: "cycleBEntry", Augments: "cycleAEntry"},
+		}}
+	nameToNode, warnings := prepareTree(node, generatorOptions{})
+	expected := []warning{
+		{Oid: "1.2", Label: "augmentingEntry", Category: warnMissingAugment, Message: "Can't find augmenting oid missingEntry for augmentingEntry"},
+		{Oid: "1.5", Label: "chainedEntry", Category: warnMissingAugment, Message: "Can't find augmenting oid missingEntry for augmentingEntry"},
+		{Oid: "1.6", Label: "cycleAEntry", Category: warnAugmentCycle, Message: "Augments of cycleAEntry form a cycle: cycleAEntry -> cycleBEntry -> cycleAEntry"},
+		{Oid: "1.7", Label: "cycleBEntry", Category: warnAugmentCycle, Message: "Augments of cycleBEntry form a cycle: cycleBEntry -> cycleAEntry -> cycleBEntry"},
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("prepareTree warnings: got %+v, want %+v", warnings, expected)
+	}
+
+	cfg := &Config{Modules: map[string]*ModuleConfig{
+		"test": {
+			Walk:    []string{"root"},
+			Lookups: []*Lookup{{OldIndex: "scalar", NewIndex: "scalar"}},
+		},
+	}}
+	results, err := generateModules(context.Background(), cfg, node, nameToNode, 1, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []warning{
+		{Module: "test", Oid: "1.1.1", Label: "tableFoo", Category: warnMissingIndex, Message: "Error, can't find index missingIndex for node tableFoo"},
+		{Module: "test", Oid: "1.3.2", Label: "otherFoo", Category: warnUnsupportedIndexType, Message: "Error, can't handle index type OBJID for node otherFoo"},
+		{Module: "test", Label: "scalar", Category: warnUnknownLookup, Message: "Lookup of scalar to scalar doesn't match the index of any metric"},
+	}
+	if !reflect.DeepEqual(results["test"].Warnings, expected) {
+		t.Errorf("generateModules warnings: got %+v, want %+v", results["test"].Warnings, expected)
+	}
+	if got, want := expected[0].String(), "Module test: Error, can't find index missingIndex for node tableFoo"; got != want {
+		t.Errorf("Warning string: got %q, want %q", got, want)
+	}
+}
+
+func TestSkippedNodes(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_NOACCESS", Label: "tableIndex", Type: "OBJID"},
+					{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+				}},
+			{Oid: "1.2", Label: "otherEntry", Indexes: []string{"missingIndex"},
+				Children: []*Node{
+					{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: "otherFoo", Type: "INTEGER"},
+				}},
+			{Oid: "1.3", Access: "ACCESS_NOTIFY", Label: "notify", Type: "INTEGER"},
+			{Oid: "1.4", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []skippedNode{
+		{Oid: "1.1.1", Label: "tableIndex", Reason: "unsupported type OBJID"},
+		{Oid: "1.1.2", Label: "tableFoo", Reason: "Error, can't handle index type OBJID for node tableFoo"},
+		{Oid: "1.2.1", Label: "otherFoo", Reason: "Error, can't find index missingIndex for node otherFoo"},
+		{Oid: "1.3", Label: "notify", Reason: "inaccessible access level ACCESS_NOTIFY"},
+	}
+	if !reflect.DeepEqual(result.Skipped, expected) {
+		t.Errorf("generateConfigModule skipped: got %+v, want %+v", result.Skipped, expected)
+	}
+	if len(result.Module.Metrics) != 1 || result.Module.Metrics[0].Name != "scalar" {
+		t.Errorf("generateConfigModule: unexpected metrics %+v", result.Module.Metrics)
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "current", Type: "INTEGER", Status: "STATUS_CURRENT"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "deprecated", Type: "INTEGER", Status: "STATUS_DEPRECATED"},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "obsolete", Type: "INTEGER", Status: "STATUS_OBSOLETE"},
+			{Oid: "1.4", Access: "ACCESS_READONLY", Label: "noStatus", Type: "INTEGER"},
+			{Oid: "1.5", Label: "oldTable", Status: "STATUS_DEPRECATED",
+				Children: []*Node{
+					{Oid: "1.5.1", Label: "oldEntry", Indexes: []string{"oldIndex"}, Status: "STATUS_DEPRECATED",
+						Children: []*Node{
+							{Oid: "1.5.1.1", Access: "ACCESS_READONLY", Label: "oldIndex", Type: "INTEGER", Status: "STATUS_DEPRECATED"},
+							{Oid: "1.5.1.2", Access: "ACCESS_READONLY", Label: "oldDesc", Type: "OCTETSTR", Status: "STATUS_DEPRECATED"},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	no := false
+
+	cases := []struct {
+		cfg      *ModuleConfig
+		metrics  []string
+		skipped  int
+		warnings []string
+	}{
+		// Objects walked by name are kept, even if obsolete.
+		{
+			cfg:      &ModuleConfig{Walk: []string{"1.1", "1.2", "1.3", "1.4"}},
+			metrics:  []string{"current", "deprecated", "obsolete", "noStatus"},
+			skipped:  0,
+			warnings: []string{"obsolete"},
+		},
+		// Obsolete objects are ignored by default.
+		{
+			cfg:      &ModuleConfig{Walk: []string{"root"}},
+			metrics:  []string{"current", "deprecated", "noStatus", "oldIndex", "oldDesc"},
+			skipped:  1,
+			warnings: []string{},
+		},
+		{
+			cfg:      &ModuleConfig{Walk: []string{"root"}, IgnoreObsolete: &no},
+			metrics:  []string{"current", "deprecated", "obsolete", "noStatus", "oldIndex", "oldDesc"},
+			skipped:  0,
+			warnings: []string{},
+		},
+		{
+			cfg:      &ModuleConfig{Walk: []string{"root"}, IgnoreDeprecated: true},
+			metrics:  []string{"current", "noStatus"},
+			skipped:  4,
+			warnings: []string{},
+		},
+		// Explicitly walked and looked up objects are kept, with a warning.
+		{
+			cfg: &ModuleConfig{
+				Walk:             []string{"current", "oldTable"},
+				Lookups:          []*Lookup{{OldIndex: "oldIndex", NewIndex: "oldDesc"}},
+				IgnoreDeprecated: true,
+			},
+			metrics:  []string{"current", "oldIndex", "oldDesc"},
+			skipped:  0,
+			warnings: []string{"oldTable", "oldDesc"},
+		},
+	}
+	for i, c := range cases {
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		metrics := []string{}
+		for _, m := range result.Module.Metrics {
+			metrics = append(metrics, m.Name)
+		}
+		if !reflect.DeepEqual(metrics, c.metrics) {
+			t.Errorf("case %d: got metrics %v, want %v", i, metrics, c.metrics)
+		}
+		if result.StatusSkipped != c.skipped {
+			t.Errorf("case %d: got %d skipped due to status, want %d", i, result.StatusSkipped, c.skipped)
+		}
+		warnings := []string{}
+		for _, w := range result.Warnings {
+			if w.Category == warnIgnoredStatus {
+				warnings = append(warnings, w.Label)
+			}
+		}
+		if !reflect.DeepEqual(warnings, c.warnings) {
+			t.Errorf("case %d: got status warnings for %v, want %v", i, warnings, c.warnings)
+		}
+	}
+}
+
+func TestSizeConstraints(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "table",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "entry", Indexes: []string{"id"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "id", Type: "OCTETSTR", Ranges: []Range{{Low: 4, High: 4}}},
+							{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "name", Type: "OCTETSTR", Hint: "255a", Ranges: []Range{{Low: 0, High: 32}}},
+							{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "data", Type: "OCTETSTR"},
+							{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "count", Type: "INTEGER", Ranges: []Range{{Low: 0, High: 100}}},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"table"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"id": 4, "name": 32, "data": 0, "count": 0}
+	for _, m := range result.Module.Metrics {
+		if m.MaxSize != expected[m.Name] {
+			t.Errorf("metric %s: got max size %d, want %d", m.Name, m.MaxSize, expected[m.Name])
+		}
+		if m.Indexes[0].FixedSize != 4 {
+			t.Errorf("metric %s: got index fixed size %d, want 4", m.Name, m.Indexes[0].FixedSize)
+		}
+	}
+}
+
+func TestTimeticksAsSeconds(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS", Units: "centiseconds"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "last-change", Type: "TIMETICKS"},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "counter", Type: "INTEGER", Units: "packets"},
+			{Oid: "1.4", Access: "ACCESS_READONLY", Label: "sysUpTime_seconds", Type: "INTEGER"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	yes, no := true, false
+
+	cases := []struct {
+		cfg    *ModuleConfig
+		names  []string
+		scales []float64
+		err    bool
+	}{
+		// Unchanged by default.
+		{
+			cfg:    &ModuleConfig{Walk: []string{"1.1", "1.2", "1.3"}},
+			names:  []string{"sysUpTime", "last_change", "counter"},
+			scales: []float64{0, 0, 0},
+		},
+		{
+			cfg:    &ModuleConfig{Walk: []string{"1.1", "1.2", "1.3"}, TimeticksAsSeconds: true, AppendUnitSuffix: true},
+			names:  []string{"sysUpTime_seconds", "last_change_seconds", "counter_packets"},
+			scales: []float64{0.01, 0.01, 0},
+		},
+		// Overrides match the name without the suffix.
+		{
+			cfg: &ModuleConfig{
+				Walk:               []string{"1.1", "1.2"},
+				TimeticksAsSeconds: true,
+				Overrides:          map[string]MetricOverrides{"last_change": {TimeticksAsSeconds: &no}},
+			},
+			names:  []string{"sysUpTime_seconds", "last_change"},
+			scales: []float64{0.01, 0},
+		},
+		{
+			cfg: &ModuleConfig{
+				Walk:      []string{"1.1", "1.2"},
+				Overrides: map[string]MetricOverrides{"sysUpTime": {TimeticksAsSeconds: &yes}},
+			},
+			names:  []string{"sysUpTime_seconds", "last_change"},
+			scales: []float64{0.01, 0},
+		},
+		// Only TIMETICKS can be converted.
+		{
+			cfg: &ModuleConfig{
+				Walk:      []string{"1.3"},
+				Overrides: map[string]MetricOverrides{"counter": {TimeticksAsSeconds: &yes}},
+			},
+			err: true,
+		},
+		// The suffixed name collides with another object.
+		{
+			cfg: &ModuleConfig{Walk: []string{"1.1", "1.4"}, TimeticksAsSeconds: true},
+			err: true,
+		},
+	}
+	for i, c := range cases {
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if c.err {
+			if err == nil {
+				t.Errorf("case %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		names, scales := []string{}, []float64{}
+		for _, m := range result.Module.Metrics {
+			names = append(names, m.Name)
+			scales = append(scales, m.Scale)
+		}
+		if !reflect.DeepEqual(names, c.names) || !reflect.DeepEqual(scales, c.scales) {
+			t.Errorf("case %d: got names %v with scales %v, want %v with %v", i, names, scales, c.names, c.scales)
+		}
+	}
+}
+
+func TestEnumValuesInHelp(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "ifOperStatus", Type: "INTEGER", Description: "The current state.",
+				EnumValues: map[int]string{3: "testing", 1: "up", 2: "down"}},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "plain", Type: "INTEGER", Description: "A number."},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "flags", Type: "BITSTRING", Description: "Some bits.",
+				EnumValues: map[int]string{0: "a", 1: "b"}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	off := false
+
+	cases := []struct {
+		cfg  *ModuleConfig
+		help map[string]string
+	}{
+		{
+			cfg: &ModuleConfig{Walk: []string{"root"}},
+			help: map[string]string{
+				"ifOperStatus": "The current state. - 1.1: 1=up 2=down 3=testing",
+				"plain":        "A number. - 1.2",
+				"flags":        "Some bits. - 1.3",
+			},
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"root"}, MaxEnumValuesInHelp: 2},
+			help: map[string]string{
+				"ifOperStatus": "The current state. - 1.1: 1=up 2=down ...",
+				"plain":        "A number. - 1.2",
+				"flags":        "Some bits. - 1.3",
+			},
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"root"}, EnumValuesInHelp: &off},
+			help: map[string]string{
+				"ifOperStatus": "The current state. - 1.1",
+				"plain":        "A number. - 1.2",
+				"flags":        "Some bits. - 1.3",
+			},
+		},
+	}
+	for i, c := range cases {
+		result, err := generateConfigModule(context.Background(), c.cfg, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		help := map[string]string{}
+		for _, m := range result.Module.Metrics {
+			help[m.Name] = m.Help
+		}
+		if !reflect.DeepEqual(help, c.help) {
+			t.Errorf("case %d: got help %q, want %q", i, help, c.help)
+		}
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "foo-bar", Type: "INTEGER"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "foo.bar", Type: "INTEGER"},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "foo_bar_2", Type: "INTEGER"},
+			{Oid: "1.4", Label: "table",
+				Children: []*Node{
+					{Oid: "1.4.1", Label: "entry", Indexes: []string{"a-b", "a.b"},
+						Children: []*Node{
+							{Oid: "1.4.1.1", Access: "ACCESS_NOACCESS", Label: "a-b", Type: "INTEGER"},
+							{Oid: "1.4.1.2", Access: "ACCESS_NOACCESS", Label: "a.b", Type: "INTEGER"},
+							{Oid: "1.4.1.3", Access: "ACCESS_READONLY", Label: "value", Type: "INTEGER"},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{Walk: []string{"1.1", "1.2", "1.3", "value"}}
+
+	_, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err == nil {
+		t.Fatal("expected error for names that collide after sanitization")
+	}
+	for _, want := range []string{"foo_bar is used by both 1.1 and 1.2", "1.4.1.3"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{allowCollisions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, m := range result.Module.Metrics {
+		names = append(names, m.Name)
+	}
+	// The suffixed name is taken by foo_bar_2, so foo.bar becomes foo_bar_3.
+	if want := []string{"foo_bar", "foo_bar_3", "foo_bar_2", "value"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got metric names %v, want %v", names, want)
+	}
+	labels := []string{}
+	for _, i := range result.Module.Metrics[3].Indexes {
+		labels = append(labels, i.Labelname)
+	}
+	// Index labels are sanitized too.
+	if want := []string{"a_b", "a_b_2"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got index labels %v, want %v", labels, want)
+	}
+	collisions := 0
+	for _, w := range result.Warnings {
+		if w.Category == warnNameCollision {
+			collisions++
+		}
+	}
+	if collisions != 2 {
+		t.Errorf("got %d collision warnings, want 2: %+v", collisions, result.Warnings)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "ifHCInOctets", want: "ifHCInOctets"},
+		{name: "foo-bar.baz", want: "foo_bar_baz"},
+		{name: "foo--bar", want: "foo_bar"},
+		{name: "foo__bar", want: "foo_bar"},
+		{name: "_foo_", want: "_foo_"},
+		{name: "802dot1dStpPort", want: "_802dot1dStpPort"},
+		{name: "802dot1dStpPort", prefix: "ieee", want: "ieee802dot1dStpPort"},
+		{name: "-802", want: "_802"},
+		{name: "température", want: "temp_rature"},
+		{name: "温度", want: "_"},
+		{name: "٣value", want: "_value"},
+		{name: "---", want: "_"},
+		{name: "", want: ""},
+	}
+	for _, c := range cases {
+		if got := sanitizeName(c.name, c.prefix); got != c.want {
+			t.Errorf("sanitizeName(%q, %q): got %q, want %q", c.name, c.prefix, got, c.want)
+		}
+		if got := sanitizeName(c.want, c.prefix); got != c.want {
+			t.Errorf("sanitizeName(%q, %q) isn't idempotent: got %q", c.want, c.prefix, got)
+		}
+	}
+}
+
+func TestSanitizedNames(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "8021xCount", Type: "INTEGER"},
+			{Oid: "1.2", Label: "table",
+				Children: []*Node{
+					{Oid: "1.2.1", Label: "entry", Indexes: []string{"802-index"},
+						Children: []*Node{
+							{Oid: "1.2.1.1", Access: "ACCESS_NOACCESS", Label: "802-index", Type: "INTEGER"},
+							{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "802dot1Name", Type: "DisplayString"},
+							{Oid: "1.2.1.3", Access: "ACCESS_READONLY", Label: "value", Type: "INTEGER"},
+						}}}},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "--", Type: "INTEGER"},
+			{Oid: "1.4", Label: "badTable",
+				Children: []*Node{
+					{Oid: "1.4.1", Label: "badEntry", Indexes: []string{"..."},
+						Children: []*Node{
+							{Oid: "1.4.1.1", Access: "ACCESS_NOACCESS", Label: "...", Type: "INTEGER"},
+							{Oid: "1.4.1.2", Access: "ACCESS_READONLY", Label: "badValue", Type: "INTEGER"},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cfg := &ModuleConfig{
+		Walk:    []string{"8021xCount", "value"},
+		Lookups: []*Lookup{{OldIndex: "802-index", NewIndex: "802dot1Name", KeepSourceIndexes: true}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := result.Module.Metrics
+	if len(metrics) != 2 || metrics[0].Name != "_8021xCount" || metrics[1].Name != "value" {
+		t.Fatalf("Unexpected metrics %+v", metrics)
+	}
+	if got := metrics[1].Indexes[0].Labelname; got != "_802_index" {
+		t.Errorf("got index label %q, want _802_index", got)
+	}
+	lookup := metrics[1].Lookups[0]
+	if lookup.Labelname != "_802dot1Name" || !reflect.DeepEqual(lookup.Labels, []string{"_802_index"}) || lookup.Indexes[0].Labelname != "_802_index" {
+		t.Errorf("Unexpected lookup %+v", lookup)
+	}
+
+	// Overrides match the names from the MIBs, before the digit prefix.
+	cfg = &ModuleConfig{
+		Walk:        []string{"8021xCount", "value"},
+		Overrides:   map[string]MetricOverrides{"8021xCount": {Type: "counter"}},
+		DigitPrefix: "ieee",
+	}
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = result.Module.Metrics
+	if metrics[0].Name != "ieee8021xCount" || metrics[0].Type != "counter" || metrics[1].Indexes[0].Labelname != "ieee802_index" {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	// A module prefix means the name no longer starts with a digit.
+	cfg = &ModuleConfig{Walk: []string{"8021xCount"}, Prefix: "dot1x"}
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Module.Metrics[0].Name; got != "dot1x_8021xCount" {
+		t.Errorf("got metric name %q, want dot1x_8021xCount", got)
+	}
+	// The prefix is already a valid metric name, so its colons are kept.
+	cfg = &ModuleConfig{Walk: []string{"8021xCount"}, Prefix: "acme:dev"}
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Module.Metrics[0].Name; got != "acme:dev_8021xCount" {
+		t.Errorf("got metric name %q, want acme:dev_8021xCount", got)
+	}
+
+	errs := map[string]*ModuleConfig{
+		"Cannot make a metric name from '--' (1.3)":                         {Walk: []string{"1.3"}},
+		"Cannot make a label name from '...' for metric badValue (1.4.1.2)": {Walk: []string{"badValue"}},
+		"Invalid digit_prefix '8'":                                          {Walk: []string{"1.1"}, DigitPrefix: "8"},
+	}
+	for want, cfg := range errs {
+		_, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got error %v, want %q", cfg.Walk, err, want)
+		}
+	}
+
+	// A metric without a valid name can be given one, or ignored.
+	for _, overrides := range []map[string]MetricOverrides{
+		{"1.3": {Name: "dashes"}},
+		{"1.3": {Ignore: true}},
+	} {
+		cfg := &ModuleConfig{Walk: []string{"1.3"}, Overrides: overrides}
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err != nil {
+			t.Errorf("%+v: %s", overrides, err)
+		}
+	}
+}
+
+func TestQualifiedNames(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "OCTETSTR", Module: "SNMPv2-MIB"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "OCTETSTR", Module: "VENDOR-MIB"},
+			{Oid: "1.3", Label: "table", Module: "VENDOR-MIB",
+				Children: []*Node{
+					{Oid: "1.3.1", Label: "entry", Indexes: []string{"index"}, Module: "VENDOR-MIB",
+						Children: []*Node{
+							{Oid: "1.3.1.1", Access: "ACCESS_READONLY", Label: "index", Type: "INTEGER", Module: "VENDOR-MIB"},
+							{Oid: "1.3.1.2", Access: "ACCESS_READONLY", Label: "name", Type: "OCTETSTR", Module: "VENDOR-MIB"},
+							{Oid: "1.3.1.3", Access: "ACCESS_READONLY", Label: "status", Type: "INTEGER", Module: "VENDOR-MIB",
+								EnumValues: map[int]string{1: "up", 2: "down"}},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	if n := nameToNode["SNMPv2-MIB::sysDescr"]; n == nil || n.Oid != "1.1" {
+		t.Fatalf("SNMPv2-MIB::sysDescr resolved to %+v", n)
+	}
+
+	cfg := &ModuleConfig{
+		Walk:      []string{"SNMPv2-MIB::sysDescr", "VENDOR-MIB::status"},
+		Lookups:   []*Lookup{{OldIndex: "VENDOR-MIB::index", NewIndex: "VENDOR-MIB::name"}},
+		Overrides: map[string]MetricOverrides{"VENDOR-MIB::status": {Type: "EnumAsInfo"}},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("unexpected warnings %+v", result.Warnings)
+	}
+	if len(result.Module.Metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(result.Module.Metrics))
+	}
+	if m := result.Module.Metrics[0]; m.Oid != "1.1" {
+		t.Errorf("got sysDescr with oid %s, want 1.1", m.Oid)
+	}
+	if m := result.Module.Metrics[1]; m.Type != "EnumAsInfo" || len(m.Lookups) != 1 || m.Lookups[0].Oid != "1.3.1.2" {
+		t.Errorf("qualified override or lookup not applied: %+v", m)
+	}
+
+	// Bare names defined by more than one MIB are warned about.
+	result, err = generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"sysDescr"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Category != warnAmbiguousName {
+		t.Fatalf("got warnings %+v, want one ambiguous name warning", result.Warnings)
+	}
+	want := "Name sysDescr is defined by more than one MIB module (SNMPv2-MIB::sysDescr, VENDOR-MIB::sysDescr), using VENDOR-MIB::sysDescr with oid 1.2. Use MODULE::name to choose"
+	if result.Warnings[0].Message != want {
+		t.Errorf("got warning %q, want %q", result.Warnings[0].Message, want)
+	}
+}
+
+func TestNameConflicts(t *testing.T) {
+	newTree := func() *Node {
+		return &Node{Oid: "1", Label: "root",
+			Children: []*Node{
+				{Oid: "1.1", Label: "sysDescr", Module: "SNMPv2-MIB"},
+				{Oid: "1.2", Label: "sysDescr", Module: "VENDOR-MIB"},
+				{Oid: "1.3", Label: "sysDescr"},
+				{Oid: "1.4", Label: "unique", Module: "VENDOR-MIB"},
+			}}
+	}
+	cases := []struct {
+		mode     string
+		oid      string
+		warnings []string
+	}{
+		{
+			mode: nameConflictLast,
+			oid:  "1.3",
+			warnings: []string{
+				"Name sysDescr is defined with oid 1.3 by an unknown MIB and oid 1.1 by SNMPv2-MIB, using oid 1.3",
+				"Name sysDescr is defined with oid 1.3 by an unknown MIB and oid 1.2 by VENDOR-MIB, using oid 1.3",
+			},
+		},
+		{
+			mode: nameConflictFirst,
+			oid:  "1.1",
+			warnings: []string{
+				"Name sysDescr is defined with oid 1.1 by SNMPv2-MIB and oid 1.2 by VENDOR-MIB, using oid 1.1",
+				"Name sysDescr is defined with oid 1.1 by SNMPv2-MIB and oid 1.3 by an unknown MIB, using oid 1.1",
+			},
+		},
+	}
+	for _, c := range cases {
+		nameToNode, warnings := prepareTree(newTree(), generatorOptions{onNameConflict: c.mode})
+		if got := nameToNode["sysDescr"].Oid; got != c.oid {
+			t.Errorf("%s: sysDescr got oid %s, want %s", c.mode, got, c.oid)
+		}
+		// Qualified names are unaffected.
+		if got := nameToNode["VENDOR-MIB::sysDescr"].Oid; got != "1.2" {
+			t.Errorf("%s: VENDOR-MIB::sysDescr got oid %s, want 1.2", c.mode, got)
+		}
+		got := []string{}
+		for _, w := range warnings {
+			if w.Category == warnNameConflict {
+				got = append(got, w.Message)
+			}
+		}
+		if !reflect.DeepEqual(got, c.warnings) {
+			t.Errorf("%s: got warnings %q, want %q", c.mode, got, c.warnings)
+		}
+	}
+}
+
+func TestWalkNodeWithParent(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "a",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "a1"},
+					{Oid: "1.1.2", Label: "a2"},
+				}},
+			{Oid: "1.2", Label: "b"},
+		}}
+	got := []string{}
+	walkNodeWithParent(node, func(parent, n *Node) {
+		p := "<nil>"
+		if parent != nil {
+			p = parent.Label
+		}
+		got = append(got, p+">"+n.Label)
+	})
+	expected := []string{"<nil>>root", "root>a", "a>a1", "a>a2", "root>b"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got %v, want %v", got, expected)
+	}
+}
+
+func TestWalkNodeDeepChain(t *testing.T) {
+	const depth = 100000
+	root := &Node{Oid: "1", Label: "root", Indexes: []string{"root"}}
+	n := root
+	for i := 0; i < depth; i++ {
+		c := &Node{Oid: fmt.Sprintf("1.%d", i), Label: fmt.Sprintf("child%d", i)}
+		n.Children = []*Node{c}
+		n = c
+	}
+
+	count := 0
+	walkNode(root, func(n *Node) {
+		count++
+	})
+	if count != depth+1 {
+		t.Errorf("Walked %d nodes, want %d", count, depth+1)
+	}
+	// Indexes are propagated all the way down.
+	prepareTree(root, generatorOptions{})
+	if !reflect.DeepEqual(n.Indexes, []string{"root"}) {
+		t.Errorf("Deepest node has indexes %v, want [root]", n.Indexes)
+	}
+}
+
+func BenchmarkWalkNodeWide(b *testing.B) {
+	root := &Node{Oid: "1", Label: "root"}
+	for i := 0; i < 1000; i++ {
+		c := &Node{Oid: fmt.Sprintf("1.%d", i)}
+		for j := 0; j < 100; j++ {
+			c.Children = append(c.Children, &Node{Oid: fmt.Sprintf("1.%d.%d", i, j)})
+		}
+		root.Children = append(root.Children, c)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		walkNode(root, func(n *Node) {
+			count++
+		})
+	}
+}
+
+func TestMetricHelp(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER",
+				Description: "This object.  The number   of things\n seen. Resets on reboot."},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		mode string
+		help string
+	}{
+		{mode: "", help: "This object - 1.1"},
+		{mode: helpFirstSentence, help: "This object - 1.1"},
+		{mode: helpFull, help: "This object. The number of things seen. Resets on reboot. - 1.1"},
+		{mode: helpNone, help: "1.1"},
+	}
+	for _, c := range cases {
+		result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}, Help: c.mode}, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := result.Module.Metrics[0].Help; got != c.help {
+			t.Errorf("Help mode %q: got %q, want %q", c.mode, got, c.help)
+		}
+	}
+
+	if _, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}, Help: "all"}, node, nameToNode, generatorOptions{}); err == nil {
+		t.Error("Expected error for unknown help mode")
+	}
+}
+
+func TestCleanDescription(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{in: "Plain text.", out: "Plain text."},
+		{in: "Tabs\tand\vvertical\ftabs\r\nand  newlines.", out: "Tabs and vertical tabs and newlines."},
+		{in: "Bell\x07 and NUL\x00 and escape\x1b.", out: "Bell and NUL and escape."},
+		// Latin-1 rather than UTF-8.
+		{in: "Temp in \xb0C, caf\xe9.", out: "Temp in \u00b0C, caf\u00e9."},
+		// Valid UTF-8 is kept.
+		{in: "Temp in \xc2\xb0C, caf\xc3\xa9.", out: "Temp in \u00b0C, caf\u00e9."},
+		// C1 controls aren't valid Latin-1 text.
+		{in: "Stray \x85\x9f bytes.", out: "Stray \ufffd\ufffd bytes."},
+		// Exotic whitespace, a line separator and a byte order mark.
+		{in: "\xef\xbb\xbfNon\xc2\xa0breaking\xe2\x80\xa8space.", out: "Non breaking space."},
+		{in: "  \t\x00 ", out: ""},
+	}
+	for _, c := range cases {
+		got := cleanDescription(c.in)
+		if got != c.out {
+			t.Errorf("cleanDescription(%q): got %q, want %q", c.in, got, c.out)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("cleanDescription(%q): got invalid UTF-8 %q", c.in, got)
+		}
+		// The help must survive a round trip through YAML.
+		out, err := yaml.Marshal(&config.Metric{Name: "m", Help: got})
+		if err != nil {
+			t.Fatalf("cleanDescription(%q): %s", c.in, err)
+		}
+		m := &config.Metric{}
+		if err := yaml.Unmarshal(out, m); err != nil {
+			t.Errorf("cleanDescription(%q): round trip failed: %s\n%s", c.in, err, out)
+		} else if m.Help != got {
+			t.Errorf("cleanDescription(%q): round trip got %q, want %q", c.in, m.Help, got)
+		}
+	}
+}
+
+func TestFirstSentence(t *testing.T) {
+	long := strings.Repeat("word ", 50)
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{
+			// IF-MIB ifInOctets.
+			in:  "The total number of octets received on the interface, including framing characters. Discontinuities in the value of this counter can occur at re-initialization of the management system, and at other times as indicated by the value of ifCounterDiscontinuityTime.",
+			out: "The total number of octets received on the interface, including framing characters",
+		},
+		{
+			// IF-MIB ifIndex.
+			in:  "A unique value, greater than zero, for each interface. It is recommended that values are assigned contiguously starting from 1.",
+			out: "A unique value, greater than zero, for each interface",
+		},
+		{
+			// Single sentence keeps its full stop, as before.
+			in:  "The textual name of the interface.",
+			out: "The textual name of the interface.",
+		},
+		{
+			in:  "The no. of packets received. Counts all packets, including errors.",
+			out: "The no. of packets received",
+		},
+		{
+			in:  "e.g. foo. More detail.",
+			out: "e.g. foo",
+		},
+		{
+			// IP-MIB ipAddressPrefixOrigin, which uses i.e. mid-sentence.
+			in:  "The origin of this prefix, i.e. how it was learned. Values are described in the textual convention.",
+			out: "The origin of this prefix, i.e. how it was learned",
+		},
+		{
+			in:  "Fans, power supplies, etc. are reported here. Other components are not.",
+			out: "Fans, power supplies, etc. are reported here",
+		},
+		{
+			in:  "Inbound vs. outbound traffic ratio. In percent.",
+			out: "Inbound vs. outbound traffic ratio",
+		},
+		{
+			// HOST-RESOURCES-MIB hrStorageSize style parenthetical.
+			in:  "The size of the storage (in units of hrStorageAllocationUnits. See below). This object is writable.",
+			out: "The size of the storage (in units of hrStorageAllocationUnits. See below)",
+		},
+		{
+			// Unbalanced closing parentheses don't break later sentences.
+			in:  "Status) of the fan. Other text.",
+			out: "Status) of the fan",
+		},
+		{
+			// Decimal points and dotted OIDs aren't sentence boundaries.
+			in:  "Version 1.2 of the 1.3.6.1.4 subtree",
+			out: "Version 1.2 of the 1.3.6.1.4 subtree",
+		},
+		{
+			// No sentence boundary, cut at a word.
+			in:  long,
+			out: long[:strings.LastIndex(long[:maxSentenceLength], " ")] + "...",
+		},
+		{
+			// No sentence boundary or spaces, cut at a character.
+			in:  strings.Repeat("é", 150),
+			out: strings.Repeat("é", 100) + "...",
+		},
+		{
+			in:  "",
+			out: "",
+		},
+	}
+	for _, c := range cases {
+		if got := firstSentence(c.in); got != c.out {
+			t.Errorf("firstSentence(%q): got %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestMetricHelpMaxLength(t *testing.T) {
+	n := &Node{Oid: "1.2.3", Description: strings.Repeat("é", 50)}
+	cases := []struct {
+		max int
+		out string
+	}{
+		{max: 0, out: strings.Repeat("é", 50) + " - 1.2.3"},
+		{max: 58, out: strings.Repeat("é", 50) + " - 1.2.3"},
+		// Cut by characters rather than bytes, keeping the OID.
+		{max: 57, out: strings.Repeat("é", 46) + "... - 1.2.3"},
+		{max: 20, out: strings.Repeat("é", 9) + "... - 1.2.3"},
+		// Too short for any of the description.
+		{max: 10, out: "1.2.3"},
+	}
+	for _, c := range cases {
+		got := metricHelp(n, helpFull, c.max, false)
+		if got != c.out {
+			t.Errorf("%d: got %q, want %q", c.max, got, c.out)
+		}
+		if c.max != 0 && utf8.RuneCountInString(got) > c.max {
+			t.Errorf("%d: got %d characters", c.max, utf8.RuneCountInString(got))
+		}
+	}
+}
+
+func TestMIBInHelp(t *testing.T) {
+	n := &Node{Oid: "1.2.3", Module: "IF-MIB", Description: "The interface's description. More."}
+	if got, want := metricHelp(n, helpFirstSentence, 0, true), "The interface's description [IF-MIB] - 1.2.3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := metricHelp(n, helpFirstSentence, 30, true), "The interf... [IF-MIB] - 1.2.3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := metricHelp(n, helpFirstSentence, 0, false), "The interface's description - 1.2.3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "pingRtt", Type: "GAUGE", Units: "milliseconds", Description: "Round trip time."},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "inOctets", Type: "COUNTER", Units: "octets", Description: "Octets received."},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "cpu-load", Type: "GAUGE", Units: "1/100 percent", Description: "CPU load."},
+			{Oid: "1.4", Access: "ACCESS_READONLY", Label: "things", Type: "GAUGE", Description: "Things."},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		suffix bool
+		names  []string
+	}{
+		{suffix: false, names: []string{"pingRtt", "inOctets", "cpu_load", "things"}},
+		{suffix: true, names: []string{"pingRtt_milliseconds", "inOctets", "cpu_load_1_100_percent", "things"}},
+	}
+	for _, c := range cases {
+		result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}, AppendUnitSuffix: c.suffix}, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, m := range result.Module.Metrics {
+			names = append(names, m.Name)
+		}
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("append_unit_suffix %v: got names %v, want %v", c.suffix, names, c.names)
+		}
+		if got, want := result.Module.Metrics[0].Help, "Round trip time. (unit: milliseconds) - 1.1"; got != want {
+			t.Errorf("Got help %q, want %q", got, want)
+		}
+		if got, want := result.Module.Metrics[3].Help, "Things. - 1.4"; got != want {
+			t.Errorf("Got help %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOpaqueTypes(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "floatValue", Type: "OPAQUE", TextualConvention: "Float"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "doubleValue", Type: "OPAQUE", TextualConvention: "Double"},
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "opaqueValue", Type: "OPAQUE"},
+			{Oid: "1.4", Access: "ACCESS_NOTIFY", Label: "notifyValue", Type: "OPAQUE"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	for label, typ := range map[string]string{"floatValue": "FLOAT", "doubleValue": "DOUBLE", "opaqueValue": "OPAQUE"} {
+		if got := nameToNode[label].Type; got != typ {
+			t.Errorf("%s: got type %s, want %s", label, got, typ)
+		}
+	}
+
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, m := range result.Module.Metrics {
+		types[m.Name] = m.Type
+	}
+	if expected := map[string]string{"floatValue": "Float", "doubleValue": "Double"}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("Got metrics %v, want %v", types, expected)
+	}
+	expected := []warning{
+		{Oid: "1.3", Label: "opaqueValue", Category: warnUnknownOpaque, Message: `Can't handle Opaque node opaqueValue with textual convention "", only Float and Double are supported`},
+	}
+	if !reflect.DeepEqual(result.Warnings, expected) {
+		t.Errorf("Got warnings %+v, want %+v", result.Warnings, expected)
+	}
+}
+
+func TestBits(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "capabilities", Type: "BITSTRING",
+				EnumValues: map[int]string{0: "fullDuplex", 1: "halfDuplex", 2: "autoNeg"}},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "unnamedBits", Type: "BITSTRING"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Module.Metrics
+	if m[0].Type != "Bits" || !reflect.DeepEqual(m[0].EnumValues, node.Children[0].EnumValues) {
+		t.Errorf("capabilities: got type %s and bits %v", m[0].Type, m[0].EnumValues)
+	}
+	if m[1].Type != "OctetString" || m[1].EnumValues != nil {
+		t.Errorf("unnamedBits: got type %s and bits %v", m[1].Type, m[1].EnumValues)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Unexpected warnings %+v", result.Warnings)
+	}
+
+	// Too many bits falls back to OctetString.
+	result, err = generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}, MaxBits: 2}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := result.Module.Metrics[0]; m.Type != "OctetString" || m.EnumValues != nil {
+		t.Errorf("capabilities with max_bits 2: got type %s and bits %v", m.Type, m.EnumValues)
+	}
+	expected := []warning{
+		{Oid: "1.1", Label: "capabilities", Category: warnTooManyBits, Message: "BITS node capabilities has 3 named bits which is more than max_bits of 2, using OctetString"},
+	}
+	if !reflect.DeepEqual(result.Warnings, expected) {
+		t.Errorf("Got warnings %+v, want %+v", result.Warnings, expected)
+	}
+}
+
+func TestInetAddressIndexes(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ipAddressTable",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ipAddressEntry", Indexes: []string{"ipAddressAddrType", "ipAddressAddr"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "ipAddressAddrType", Type: "INTEGER", TextualConvention: "InetAddressType"},
+							{Oid: "1.1.1.2", Access: "ACCESS_NOACCESS", Label: "ipAddressAddr", Type: "OCTETSTR", TextualConvention: "InetAddress"},
+							{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "ipAddressIfIndex", Type: "INTEGER"},
+						}}}},
+			{Oid: "1.2", Label: "peerTable",
+				Children: []*Node{
+					{Oid: "1.2.1", Label: "peerEntry", Indexes: []string{"peerAddr"},
+						Children: []*Node{
+							{Oid: "1.2.1.1", Access: "ACCESS_NOACCESS", Label: "peerAddr", Type: "OCTETSTR", TextualConvention: "InetAddress"},
+							{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "peerState", Type: "INTEGER"},
+							{Oid: "1.2.1.3", Access: "ACCESS_READONLY", Label: "peerUptime", Type: "INTEGER"},
+						}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"root"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexes := map[string][]config.Index{}
+	for _, m := range result.Module.Metrics {
+		for _, i := range m.Indexes {
+			indexes[m.Name] = append(indexes[m.Name], *i)
+		}
+	}
+	expected := []config.Index{{Labelname: "ipAddressAddrType", Type: "InetAddressType"}, {Labelname: "ipAddressAddr", Type: "TypedInetAddress"}}
+	if !reflect.DeepEqual(indexes["ipAddressIfIndex"], expected) {
+		t.Errorf("ipAddressIfIndex: got indexes %+v, want %+v", indexes["ipAddressIfIndex"], expected)
+	}
+	expected = []config.Index{{Labelname: "peerAddr", Type: "OctetString"}}
+	if !reflect.DeepEqual(indexes["peerState"], expected) {
+		t.Errorf("peerState: got indexes %+v, want %+v", indexes["peerState"], expected)
+	}
+
+	// One warning for the unpaired table, not each column.
+	expectedWarnings := []warning{
+		{Oid: "1.2.1.1", Label: "peerAddr", Category: warnUnpairedInetAddress, Message: "Index peerAddr of table peerTable is an InetAddress without a preceding InetAddressType index, so its type is unknown"},
+	}
+	if !reflect.DeepEqual(result.Warnings, expectedWarnings) {
+		t.Errorf("Got warnings %+v, want %+v", result.Warnings, expectedWarnings)
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "peerEntry", Indexes: []string{"peerAddr"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_NOACCESS", Label: "peerAddr", Type: "OCTETSTR", TextualConvention: "InetAddressIPv6"},
+					{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "peerNextHop", Type: "OCTETSTR", TextualConvention: "Ipv6Address"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: []string{"peerNextHop"}}, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*config.Metric{
+		{
+			Name:    "peerNextHop",
+			Oid:     "1.1.2",
+			Type:    "InetAddressIPv6",
+			Help:    " - 1.1.2",
+			Indexes: []*config.Index{{Labelname: "peerAddr", Type: "InetAddressIPv6", FixedSize: 16}},
+			Lookups: []*config.Lookup{},
+		},
+	}
+	if !reflect.DeepEqual(result.Module.Metrics, expected) {
+		t.Errorf("Got metrics %+v, want %+v", result.Module.Metrics, expected)
+	}
+}
+
+func TestMIBModuleWalks(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "interfaces", Module: "IF-MIB",
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifNumber", Type: "INTEGER", Module: "IF-MIB"},
+				}},
+			{Oid: "1.2", Label: "system", Module: "SNMPv2-MIB",
+				Children: []*Node{
+					{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "OCTETSTR", Module: "SNMPv2-MIB"},
+					// Nothing to walk.
+					{Oid: "1.2.2", Label: "linkDown", Module: "IF-MIB"},
+					{Oid: "1.2.3", Access: "ACCESS_READONLY", Label: "ifStackLastChange", Type: "TIMETICKS", Module: "IF-MIB"},
+				}},
+			// An object with the same name as a MIB module.
+			{Oid: "1.3", Access: "ACCESS_READONLY", Label: "SNMPv2-MIB", Type: "INTEGER", Module: "OTHER-MIB"},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		walk     []string
+		oids     []string
+		warnings int
+		err      string
+	}{
+		{walk: []string{"IF-MIB"}, oids: []string{"1.1.1", "1.2.3"}},
+		// interfaces is reported as already walked.
+		{walk: []string{"module:IF-MIB", "interfaces"}, oids: []string{"1.1.1", "1.2.3"}, warnings: 1},
+		{walk: []string{"SNMPv2-MIB"}, oids: []string{"1.3"}, warnings: 1},
+		// Objects of other MIB modules under those of the MIB module are walked too.
+		{walk: []string{"module:SNMPv2-MIB"}, oids: []string{"1.2.1", "1.2.3"}},
+		{walk: []string{"module:NO-SUCH-MIB"}, err: "Cannot find MIB module 'NO-SUCH-MIB' with objects to walk"},
+		{walk: []string{"NO-SUCH-MIB"}, err: "Cannot find oid 'NO-SUCH-MIB' to walk"},
+	}
+	for _, c := range cases {
+		result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: c.walk}, node, nameToNode, generatorOptions{})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%v: got error %v, want %q", c.walk, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", c.walk, err)
+		}
+		oids := []string{}
+		for _, m := range result.Module.Metrics {
+			oids = append(oids, m.Oid)
+		}
+		if !reflect.DeepEqual(oids, c.oids) {
+			t.Errorf("%v: got metrics for %v, want %v", c.walk, oids, c.oids)
+		}
+		if len(result.Warnings) != c.warnings {
+			t.Errorf("%v: got warnings %v, want %d", c.walk, result.Warnings, c.warnings)
+		}
+	}
+}
+
+func TestRedundantWalkWarnings(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "interfaces", Module: "IF-MIB",
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifNumber", Type: "INTEGER", Module: "IF-MIB"},
+					{Oid: "1.1.2", Label: "ifTable", Module: "IF-MIB",
+						Children: []*Node{
+							{Oid: "1.1.2.1", Label: "ifEntry", Module: "IF-MIB", Indexes: []string{"ifIndex"},
+								Children: []*Node{
+									{Oid: "1.1.2.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER", Module: "IF-MIB"},
+								}},
+						}},
+				}},
+			{Oid: "1.2", Label: "system", Module: "SNMPv2-MIB",
+				Children: []*Node{
+					{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "OCTETSTR", Module: "SNMPv2-MIB"},
+				}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		walk     []string
+		warnings []string
+	}{
+		{walk: []string{"ifTable", "system"}},
+		{walk: []string{"ifEntry", "ifTable"}, warnings: []string{
+			"redundant-walk: ifEntry is already walked as part of ifTable, ignoring it",
+		}},
+		{walk: []string{"interfaces", "ifEntry", "ifTable"}, warnings: []string{
+			"redundant-walk: ifEntry is already walked as part of interfaces, ignoring it",
+			"redundant-walk: ifTable is already walked as part of interfaces, ignoring it",
+		}},
+		{walk: []string{"ifTable", "system", "ifTable"}, warnings: []string{
+			"duplicate-walk: ifTable is listed more than once in walk",
+		}},
+		{walk: []string{"ifTable", "IF-MIB::ifTable", "1.1.2"}, warnings: []string{
+			"duplicate-walk: ifTable and IF-MIB::ifTable in walk are the same object, ignoring IF-MIB::ifTable",
+			"duplicate-walk: ifTable and 1.1.2 in walk are the same object, ignoring 1.1.2",
+		}},
+		{walk: []string{"ifTable", "module:IF-MIB"}, warnings: []string{
+			"redundant-walk: ifTable is already walked as part of module:IF-MIB, ignoring it",
+		}},
+		{walk: []string{"interfaces", "module:IF-MIB"}, warnings: []string{
+			"redundant-walk: interfaces is already walked as part of module:IF-MIB, ignoring it",
+		}},
+		{walk: []string{"module:IF-MIB", "module:IF-MIB"}, warnings: []string{
+			"duplicate-walk: module:IF-MIB is listed more than once in walk",
+		}},
+	}
+	for _, c := range cases {
+		result, err := generateConfigModule(context.Background(), &ModuleConfig{Walk: c.walk}, node, nameToNode, generatorOptions{})
+		if err != nil {
+			t.Fatalf("%v: %s", c.walk, err)
+		}
+		got := []string{}
+		for _, w := range result.Warnings {
+			got = append(got, w.Category+": "+w.Message)
+		}
+		if len(c.warnings) == 0 {
+			c.warnings = []string{}
+		}
+		if !reflect.DeepEqual(got, c.warnings) {
+			t.Errorf("%v: got warnings %q, want %q", c.walk, got, c.warnings)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"ifHCInOctets":   "if_hc_in_octets",
+		"ipAddrTable":    "ip_addr_table",
+		"IPAddr":         "ip_addr",
+		"ipv6IfIndex":    "ipv6_if_index",
+		"sysUpTime":      "sys_up_time",
+		"cpu_load":       "cpu_load",
+		"hrSWRunPerfCPU": "hr_sw_run_perf_cpu",
+		"ifIndex":        "if_index",
+		"":               "",
+	}
+	for in, out := range cases {
+		if got := snakeCase(in); got != out {
+			t.Errorf("snakeCase(%q): got %q, want %q", in, got, out)
+		}
+	}
+}
+
+func TestOidLess(t *testing.T) {
+	oids := []string{"1.10", "1.2.1", "1", "1.3.6.1.4.1.9.9", "1.2", "1.3.6.1.4.1.10", "1.10.1"}
+	want := []string{"1", "1.2", "1.2.1", "1.3.6.1.4.1.9.9", "1.3.6.1.4.1.10", "1.10", "1.10.1"}
+	if got := sortOids(oids); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// prepareTree as it was before it was collapsed into fewer walks, to check
+// that doing so didn't change the result.
+func prepareTreeSeparateWalks(nodes *Node, opts generatorOptions) (map[string]*Node, []warning) {
+	warnings := []warning{}
+	nameToNode := map[string]*Node{}
+	conflicts := map[string][]*Node{}
+	walkNode(nodes, func(n *Node) {
+		nameToNode[n.Oid] = n
+		if n.Module != "" {
+			nameToNode[qualifiedName(n)] = n
+		}
+		if existing, ok := nameToNode[n.Label]; ok && existing.Oid != n.Oid {
+			if len(conflicts[n.Label]) == 0 {
+				conflicts[n.Label] = []*Node{existing}
+			}
+			conflicts[n.Label] = append(conflicts[n.Label], n)
+			if opts.onNameConflict == nameConflictFirst {
+				return
+			}
+		}
+		nameToNode[n.Label] = n
+	})
+	warnings = append(warnings, nameConflictWarnings(conflicts, nameToNode)...)
+	walkNode(nodes, func(n *Node) {
+		n.Description = cleanDescription(n.Description)
+		n.Units = cleanDescription(n.Units)
+	})
+	walkNode(nodes, func(n *Node) {
+		indexes := []string{}
+		for _, i := range n.Indexes {
+			if i == "INTEGER" {
+				indexes = append(indexes, n.Label)
+			} else {
+				indexes = append(indexes, i)
+			}
+		}
+		n.Indexes = indexes
+	})
+	walkNodeWithParent(nodes, func(parent, n *Node) {
+		if n.Augments != "" {
+			entry, w := augmentedEntry(n, nameToNode)
+			if w == nil {
+				n.Indexes = entry.Indexes
+				n.ImpliedIndex = entry.ImpliedIndex
+			} else {
+				warnings = append(warnings, *w)
+			}
+		}
+		if parent != nil && len(parent.Indexes) != 0 {
+			n.Indexes = parent.Indexes
+			n.ImpliedIndex = parent.ImpliedIndex
+		}
+	})
+	displayStringRe := regexp.MustCompile(`\d+[at]`)
+	walkNode(nodes, func(n *Node) {
+		if n.Type == "OCTETSTR" && n.FixedSize == 0 && len(n.Ranges) == 1 && n.Ranges[0].Low == n.Ranges[0].High {
+			n.FixedSize = n.Ranges[0].Low
+		}
+		if classifyDisplayHint(n.Hint) != hintUnknown {
+			if t := displayHintType(n, classifyDisplayHint(n.Hint)); t != "" {
+				n.Type = t
+			}
+		} else {
+			switch n.Hint {
+			case "1x:":
+				n.Type = "PhysAddress48"
+			}
+			if displayStringRe.MatchString(n.Hint) {
+				n.Type = "DisplayString"
+			}
+		}
+		if n.TextualConvention == "DisplayString" {
+			n.Type = "DisplayString"
+		}
+		switch n.TextualConvention {
+		case "MacAddress", "MacAddr", "MACAddress", "MacAddressType":
+			if n.Type == "OCTETSTR" {
+				n.Type = "PhysAddress48"
+			}
+		}
+		switch n.TextualConvention {
+		case "InetAddressIPv6", "Ipv6Address":
+			n.Type = "InetAddressIPv6"
+			n.FixedSize = 16
+		}
+		if n.Type == "OPAQUE" {
+			switch n.TextualConvention {
+			case "Float":
+				n.Type = "FLOAT"
+			case "Double":
+				n.Type = "DOUBLE"
+			}
+		}
+		if n.TextualConvention == "TruthValue" && n.Type == "INTEGER" {
+			n.Type = "TruthValue"
+		}
+	})
+	return nameToNode, warnings
+}
+
+// A tree of many MIB modules, each with a scalar and tables exercising what
+// prepareTree fixes up: INTEGER indexes, augments of entries later in the
+// tree, chains and cycles of augments, display hints, textual conventions,
+// messy descriptions and names defined by more than one module.
+func largeTree(modules int) *Node {
+	root := &Node{Oid: "1", Label: "iso"}
+	types := []struct {
+		typ, hint, tc string
+		ranges        []Range
+	}{
+		{"OCTETSTR", "255a", "DisplayString", nil},
+		{"OCTETSTR", "1x:", "MacAddress", nil},
+		{"OCTETSTR", "", "MacAddr", nil},
+		{"OCTETSTR", "2d-1d-1d,1d:1d:1d.1d,1a1d:1d", "DateAndTime", nil},
+		{"OCTETSTR", "1d.1d.1d.1d", "", nil},
+		{"OCTETSTR", "2x:", "InetAddressIPv6", nil},
+		{"OCTETSTR", "", "", []Range{{Low: 6, High: 6}}},
+		{"OCTETSTR", "30t", "", nil},
+		{"OPAQUE", "", "Float", nil},
+		{"OPAQUE", "", "Double", nil},
+		{"INTEGER", "", "TruthValue", nil},
+		{"INTEGER", "d-2", "", nil},
+		{"COUNTER64", "", "", nil},
+	}
+	for m := 0; m < modules; m++ {
+		module := fmt.Sprintf("MODULE%d-MIB", m)
+		mod := &Node{Oid: fmt.Sprintf("1.%d", m), Label: fmt.Sprintf("module%d", m), Module: module}
+		root.Children = append(root.Children, mod)
+		// Every module defines sysDescr, so names conflict.
+		mod.Children = append(mod.Children, &Node{Oid: mod.Oid + ".1", Label: "sysDescr", Module: module,
+			Type: "OCTETSTR", TextualConvention: "DisplayString", Access: "ACCESS_READONLY",
+			Description: "A   textual\n description.  ", Units: " seconds "})
+		for tbl := 0; tbl < 10; tbl++ {
+			label := fmt.Sprintf("m%dt%d", m, tbl)
+			table := &Node{Oid: fmt.Sprintf("%s.%d", mod.Oid, tbl+2), Label: label + "Table", Module: module}
+			entry := &Node{Oid: table.Oid + ".1", Label: label + "Entry", Module: module}
+			switch tbl % 5 {
+			case 0:
+				entry.Indexes = []string{label + "Index"}
+			case 1:
+				entry.Indexes = []string{"INTEGER"}
+			case 2:
+				// Augments an entry later in the tree.
+				entry.Augments = fmt.Sprintf("m%dt%dEntry", m, tbl+2)
+			case 3:
+				// Augments an entry that augments another.
+				entry.Augments = fmt.Sprintf("m%dt%dEntry", m, tbl-1)
+			case 4:
+				if m%3 == 0 {
+					entry.Augments = label + "Entry"
+				} else if m%3 == 1 {
+					entry.Augments = "missingEntry"
+				} else {
+					entry.Indexes = []string{label + "Index", label + "Name"}
+					entry.ImpliedIndex = true
+				}
+			}
+			table.Children = []*Node{entry}
+			for c, typ := range types {
+				entry.Children = append(entry.Children, &Node{
+					Oid: fmt.Sprintf("%s.%d", entry.Oid, c+1), Label: fmt.Sprintf("%sColumn%d", label, c), Module: module,
+					Type: typ.typ, Hint: typ.hint, TextualConvention: typ.tc, Ranges: typ.ranges,
+					Access: "ACCESS_READONLY", Description: fmt.Sprintf("Column %d.\tMore  detail.", c),
+				})
+			}
+			mod.Children = append(mod.Children, table)
+		}
+	}
+	return root
+}
+
+// Check that two trees have the same shape and every node the same fields.
+func compareTrees(t *testing.T, got, want *Node) {
+	t.Helper()
+	gotNodes, wantNodes := []*Node{}, []*Node{}
+	walkNode(got, func(n *Node) { gotNodes = append(gotNodes, n) })
+	walkNode(want, func(n *Node) { wantNodes = append(wantNodes, n) })
+	if len(gotNodes) != len(wantNodes) {
+		t.Fatalf("Got %d nodes, want %d", len(gotNodes), len(wantNodes))
+	}
+	differences := 0
+	for i := range gotNodes {
+		g, w := reflect.ValueOf(*gotNodes[i]), reflect.ValueOf(*wantNodes[i])
+		for f := 0; f < g.NumField(); f++ {
+			name := g.Type().Field(f).Name
+			if name == "Children" {
+				if len(gotNodes[i].Children) != len(wantNodes[i].Children) {
+					t.Errorf("%s: got %d children, want %d", wantNodes[i].Oid, len(gotNodes[i].Children), len(wantNodes[i].Children))
+				}
+				continue
+			}
+			if !reflect.DeepEqual(g.Field(f).Interface(), w.Field(f).Interface()) {
+				t.Errorf("%s: got %s %#v, want %#v", wantNodes[i].Oid, name, g.Field(f).Interface(), w.Field(f).Interface())
+				differences++
+			}
+		}
+		if differences > 10 {
+			t.Fatal("Too many differences")
+		}
+	}
+}
+
+func TestPrepareTreeUnchanged(t *testing.T) {
+	trees := map[string]func() *Node{
+		"large": func() *Node { return largeTree(100) },
+	}
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		name := filepath.Base(fixture)
+		trees[name] = func() *Node { return loadFixture(t, name) }
+	}
+	for name, tree := range trees {
+		for _, mode := range []string{nameConflictFirst, nameConflictLast} {
+			opts := generatorOptions{onNameConflict: mode}
+			got, want := tree(), tree()
+			gotNames, gotWarnings := prepareTree(got, opts)
+			wantNames, wantWarnings := prepareTreeSeparateWalks(want, opts)
+			compareTrees(t, got, want)
+			if len(gotNames) != len(wantNames) {
+				t.Errorf("%s %s: got %d names, want %d", name, mode, len(gotNames), len(wantNames))
+			}
+			for k, n := range wantNames {
+				if gotNames[k] == nil || gotNames[k].Oid != n.Oid {
+					t.Errorf("%s %s: name %s isn't oid %s", name, mode, k, n.Oid)
+				}
+			}
+			if !reflect.DeepEqual(gotWarnings, wantWarnings) {
+				t.Errorf("%s %s: got warnings %v, want %v", name, mode, gotWarnings, wantWarnings)
+			}
+		}
+	}
+}
+
+func BenchmarkPrepareTree(b *testing.B) {
+	for _, bench := range []struct {
+		name        string
+		prepareTree func(*Node, generatorOptions) (map[string]*Node, []warning)
+	}{
+		{"separate-walks", prepareTreeSeparateWalks},
+		{"current", prepareTree},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tree := largeTree(100)
+				// Don't count collecting the previous tree.
+				runtime.GC()
+				b.StartTimer()
+				bench.prepareTree(tree, generatorOptions{})
+			}
+		})
+	}
+}
+
+func TestOverridesAndLookupsByName(t *testing.T) {
+	column := func(oid, label, typ, module string) *Node {
+		return &Node{Oid: oid, Access: "ACCESS_READONLY", Label: label, Type: typ, Module: module}
+	}
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "aTable", Module: "A-MIB",
+				Children: []*Node{{Oid: "1.1.1", Label: "aEntry", Module: "A-MIB", Indexes: []string{"aIndex"},
+					Children: []*Node{
+						column("1.1.1.1", "aIndex", "INTEGER", "A-MIB"),
+						column("1.1.1.2", "aName", "OCTETSTR", "A-MIB"),
+						column("1.1.1.3", "aValue", "COUNTER", "A-MIB"),
+						column("1.1.1.4", "shared", "GAUGE", "A-MIB"),
+					}}}},
+			{Oid: "1.2", Label: "bTable", Module: "B-MIB",
+				Children: []*Node{{Oid: "1.2.1", Label: "bEntry", Module: "B-MIB", Indexes: []string{"aIndex", "bIndex"},
+					Children: []*Node{
+						column("1.2.1.1", "bIndex", "INTEGER", "B-MIB"),
+						column("1.2.1.2", "bValue", "GAUGE", "B-MIB"),
+						column("1.2.1.3", "shared", "GAUGE", "B-MIB"),
+					}}}},
+			{Oid: "1.3", Label: "nameTable", Module: "B-MIB",
+				Children: []*Node{{Oid: "1.3.1", Label: "nameEntry", Module: "B-MIB", Indexes: []string{"aIndex", "bIndex"},
+					Children: []*Node{
+						column("1.3.1.1", "nameDescr", "OCTETSTR", "B-MIB"),
+					}}}},
+		}}
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	cfg := &ModuleConfig{
+		Walk: []string{"aTable", "bTable"},
+		Lookups: []*Lookup{
+			{OldIndexes: []string{"aIndex", "bIndex"}, NewIndex: "nameDescr"},
+			{OldIndex: "aIndex", NewIndex: "aName", KeepSourceIndexes: true},
+		},
+		Overrides: map[string]MetricOverrides{
+			// Both metrics with the name.
+			"shared": {Help: "Shared."},
+			// Only the metric with the OID of the qualified name.
+			"B-MIB::shared": {Help: "Shared by B.", Name: "b_shared"},
+			"1.1.1.3":       {Help: "A value."},
+			// Everything under the OID.
+			"bEntry": {Ignore: true},
+			"~^a":    {Help: "Starts with a."},
+		},
+		overrideOrder: []string{"~^a"},
+	}
+	result, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, m := range result.Module.Metrics {
+		lookups := []string{}
+		for _, l := range m.Lookups {
+			lookups = append(lookups, l.Labelname+"<"+strings.Join(l.Labels, ","))
+		}
+		got[m.Oid] = fmt.Sprintf("%s %q %v", m.Name, strings.TrimSuffix(m.Help, " - "+m.Oid), lookups)
+	}
+	expected := map[string]string{
+		"1.1.1.1": `aIndex "Starts with a." [aName<aIndex]`,
+		"1.1.1.2": `aName "Starts with a." [aName<aIndex]`,
+		"1.1.1.3": `aValue "Starts with a." [aName<aIndex]`,
+		"1.1.1.4": `shared "Shared." [aName<aIndex]`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got metrics %v, want %v", got, expected)
+	}
+
+	// Without the ignore, the lookup of two indexes applies to the metrics
+	// with both, and overrides by name and OID both apply.
+	delete(cfg.Overrides, "bEntry")
+	result, err = generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range result.Module.Metrics {
+		if !strings.HasPrefix(m.Oid, "1.2.") {
+			continue
+		}
+		if len(m.Lookups) != 2 || m.Lookups[0].Labelname != "nameDescr" || !reflect.DeepEqual(m.Lookups[0].Labels, []string{"aIndex", "bIndex"}) {
+			t.Errorf("%s: unexpected lookups %+v", m.Name, m.Lookups)
+		}
+		// Named overrides apply in sorted order, so the help is from the
+		// unqualified one.
+		if m.Oid == "1.2.1.3" && (m.Name != "b_shared" || m.Help != "Shared. - 1.2.1.3") {
+			t.Errorf("got %s with help %q, want b_shared with the unqualified override's help", m.Name, m.Help)
+		}
+	}
+}
+
+// A module config walking a table of 10 columns in each of many tables,
+// with a lookup and overrides for many of them.
+func manyMetricsModule(tables int) (*ModuleConfig, *Node) {
+	root := &Node{Oid: "1", Label: "root"}
+	cfg := &ModuleConfig{Walk: []string{"1"}, Overrides: map[string]MetricOverrides{}}
+	for i := 0; i < tables; i++ {
+		entry := &Node{Oid: fmt.Sprintf("1.%d.1", i), Label: fmt.Sprintf("t%dEntry", i), Indexes: []string{"sharedIndex"}}
+		for c := 1; c <= 10; c++ {
+			label := fmt.Sprintf("t%dColumn%d", i, c)
+			entry.Children = append(entry.Children, &Node{Oid: fmt.Sprintf("%s.%d", entry.Oid, c), Label: label,
+				Access: "ACCESS_READONLY", Type: "GAUGE"})
+			switch {
+			case c == 1 && i%4 == 0:
+				cfg.Overrides[fmt.Sprintf("t%dEntry", i)] = MetricOverrides{Ignore: true}
+			case c == 2:
+				cfg.Overrides[label] = MetricOverrides{Help: "Overridden."}
+			}
+		}
+		root.Children = append(root.Children, &Node{Oid: fmt.Sprintf("1.%d", i), Label: fmt.Sprintf("t%dTable", i),
+			Children: []*Node{entry}})
+	}
+	root.Children = append(root.Children, &Node{Oid: "2", Label: "namesTable",
+		Children: []*Node{{Oid: "2.1", Label: "namesEntry", Indexes: []string{"sharedIndex"},
+			Children: []*Node{
+				{Oid: "2.1.1", Label: "sharedIndex", Access: "ACCESS_NOACCESS", Type: "INTEGER"},
+				{Oid: "2.1.2", Label: "sharedName", Access: "ACCESS_READONLY", Type: "OCTETSTR", Hint: "255a"},
+			}}}})
+	cfg.Lookups = []*Lookup{{OldIndex: "sharedIndex", NewIndex: "sharedName"}}
+	return cfg, root
+}
+
+func BenchmarkGenerateConfigModuleManyMetrics(b *testing.B) {
+	cfg, node := manyMetricsModule(1000)
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := generateConfigModule(context.Background(), cfg, node, nameToNode, generatorOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
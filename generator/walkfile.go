@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+)
+
+var (
+	walkLineRE   = regexp.MustCompile(`^(\S+) = (.*)$`)
+	walkTypeRE   = regexp.MustCompile(`^([A-Z][\w -]*):(?: |$)`)
+	walkHexRE    = regexp.MustCompile(`^(?:[0-9A-Fa-f]{2} ?)+$`)
+	walkEnumRE   = regexp.MustCompile(`^[A-Za-z][\w-]*\((-?\d+)\)$`)
+	walkTicksRE  = regexp.MustCompile(`^\((\d+)\)`)
+	walkNumberRE = regexp.MustCompile(`^-?\d+`)
+)
+
+// ParseWalkFile reads the output of snmpwalk or snmpbulkwalk into PDUs by
+// oid, as gosnmp would return them. Oids can be numeric as with -On, start
+// with iso, or be names from the MIBs in nameToNode such as
+// IF-MIB::ifDescr.1 or ifDescr.1 with numeric indexes. Quoted strings and
+// Hex-STRINGs may continue onto following lines. Values without a type, as
+// with -OQ, are strings.
+func ParseWalkFile(r io.Reader, nameToNode map[string]*Node) (map[string]gosnmp.SnmpPDU, error) {
+	type entry struct {
+		line  int
+		oid   string
+		value string
+	}
+	entries := []*entry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		var last *entry
+		if len(entries) != 0 {
+			last = entries[len(entries)-1]
+		}
+		match := walkLineRE.FindStringSubmatch(text)
+		switch {
+		case last != nil && inQuotedString(last.value):
+			last.value += "\n" + text
+		case match != nil:
+			entries = append(entries, &entry{line: line, oid: match[1], value: match[2]})
+		case strings.TrimSpace(text) == "":
+		case last != nil && strings.HasPrefix(last.value, "Hex-STRING: ") && walkHexRE.MatchString(strings.TrimSpace(text)):
+			last.value += "\n" + text
+		default:
+			return nil, fmt.Errorf("Line %d isn't snmpwalk output: %q", line, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	pdus := map[string]gosnmp.SnmpPDU{}
+	for _, e := range entries {
+		oid, err := walkFileOid(e.oid, nameToNode)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", e.line, err)
+		}
+		pdu, ok, err := walkFileValue(e.value)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", e.line, err)
+		}
+		if !ok {
+			continue
+		}
+		pdu.Name = "." + oid
+		pdus[oid] = pdu
+	}
+	return pdus, nil
+}
+
+// Whether a value is a quoted string that hasn't been closed yet.
+func inQuotedString(value string) bool {
+	i := strings.Index(value, "\"")
+	if i == -1 || (i != 0 && !strings.HasPrefix(value, "STRING: ")) {
+		return false
+	}
+	escaped, open := false, false
+	for _, c := range value[i:] {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			open = !open
+		}
+	}
+	return open
+}
+
+// Convert an oid as snmpwalk prints it to a numeric oid without a leading
+// period.
+func walkFileOid(s string, nameToNode map[string]*Node) (string, error) {
+	s = strings.TrimPrefix(s, ".")
+	if strings.HasPrefix(s, "iso.") {
+		s = "1" + s[len("iso"):]
+	}
+	if isNumericOid(s) {
+		return s, nil
+	}
+	name, index := s, ""
+	start := 0
+	if i := strings.Index(s, "::"); i != -1 {
+		start = i + 2
+	}
+	if i := strings.Index(s[start:], "."); i != -1 {
+		name, index = s[:start+i], s[start+i:]
+	}
+	if index != "" && !isNumericOid(index[1:]) {
+		return "", fmt.Errorf("Can't convert the index of %s to an oid, use snmpwalk -On for numeric oids", s)
+	}
+	if nameToNode == nil {
+		return "", fmt.Errorf("Can't look up %s without the MIBs, use snmpwalk -On for numeric oids", s)
+	}
+	n, ok := nameToNode[name]
+	if !ok {
+		return "", fmt.Errorf("Can't find %s in the MIBs", name)
+	}
+	return n.Oid + index, nil
+}
+
+// Whether a string is a numeric oid, without a leading period.
+func isNumericOid(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, arc := range strings.Split(s, ".") {
+		if _, err := strconv.ParseUint(arc, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert a value as snmpwalk prints it, such as "INTEGER: up(1)", to a PDU.
+// Returns false for the end of the MIB view, which isn't a value.
+func walkFileValue(s string) (gosnmp.SnmpPDU, bool, error) {
+	pdu := gosnmp.SnmpPDU{}
+	switch {
+	case strings.HasPrefix(s, "No Such Object"):
+		pdu.Type = gosnmp.NoSuchObject
+		return pdu, true, nil
+	case strings.HasPrefix(s, "No Such Instance"):
+		pdu.Type = gosnmp.NoSuchInstance
+		return pdu, true, nil
+	case strings.HasPrefix(s, "No more variables"):
+		return pdu, false, nil
+	case s == "NULL":
+		pdu.Type = gosnmp.Null
+		return pdu, true, nil
+	}
+	typ, value := "", s
+	if match := walkTypeRE.FindStringSubmatch(s); match != nil {
+		typ, value = match[1], s[len(match[0]):]
+	}
+
+	var err error
+	switch typ {
+	case "INTEGER":
+		pdu.Type = gosnmp.Integer
+		var i int64
+		i, err = walkFileNumber(value)
+		pdu.Value = int(i)
+	case "Counter32", "Gauge32", "Unsigned32", "UInteger32":
+		pdu.Type = gosnmp.Gauge32
+		if typ == "Counter32" {
+			pdu.Type = gosnmp.Counter32
+		}
+		var i int64
+		i, err = walkFileNumber(value)
+		pdu.Value = uint(i)
+	case "Counter64":
+		pdu.Type = gosnmp.Counter64
+		i, ok := new(big.Int).SetString(strings.Fields(value + " ")[0], 10)
+		if !ok {
+			err = fmt.Errorf("invalid number %q", value)
+		} else {
+			pdu.Value = i.Uint64()
+		}
+	case "Timeticks":
+		pdu.Type = gosnmp.TimeTicks
+		match := walkTicksRE.FindStringSubmatch(value)
+		var i int64
+		if match != nil {
+			i, err = strconv.ParseInt(match[1], 10, 64)
+		} else {
+			i, err = walkFileNumber(value)
+		}
+		pdu.Value = uint32(i)
+	case "STRING", "":
+		pdu.Type = gosnmp.OctetString
+		if strings.HasPrefix(value, "\"") {
+			value, err = strconv.Unquote(strings.Replace(value, "\n", `\n`, -1))
+		}
+		pdu.Value = []byte(value)
+	case "Hex-STRING", "BITS", "Network Address":
+		pdu.Type = gosnmp.OctetString
+		if typ == "BITS" {
+			// The hex is followed by the names of the bits that are set.
+			fields := strings.Fields(value)
+			value = ""
+			for _, f := range fields {
+				if strings.Contains(f, "(") {
+					break
+				}
+				value += f
+			}
+		}
+		pdu.Value, err = hex.DecodeString(strings.NewReplacer(" ", "", "\n", "", ":", "").Replace(value))
+		if typ == "Network Address" {
+			pdu.Type = gosnmp.IPAddress
+			pdu.Value = walkFileIP(pdu.Value.([]byte))
+		}
+	case "IpAddress":
+		pdu.Type = gosnmp.IPAddress
+		pdu.Value = value
+	case "OID":
+		pdu.Type = gosnmp.ObjectIdentifier
+		pdu.Value = value
+	default:
+		return pdu, false, fmt.Errorf("Unknown type %s", typ)
+	}
+	if err != nil {
+		return pdu, false, fmt.Errorf("Can't parse %s %q: %s", typ, value, err)
+	}
+	return pdu, true, nil
+}
+
+// Parse a number as snmpwalk prints it, possibly as an enum such as up(1) or
+// followed by units.
+func walkFileNumber(s string) (int64, error) {
+	if match := walkEnumRE.FindStringSubmatch(s); match != nil {
+		s = match[1]
+	}
+	number := walkNumberRE.FindString(s)
+	if number == "" {
+		return 0, fmt.Errorf("not a number")
+	}
+	return strconv.ParseInt(number, 10, 64)
+}
+
+// Format 4 bytes as an IPv4 address, as gosnmp does.
+func walkFileIP(b []byte) string {
+	parts := make([]string, len(b))
+	for i, o := range b {
+		parts[i] = strconv.Itoa(int(o))
+	}
+	return strings.Join(parts, ".")
+}
+
+// An snmpClient answering from values captured by snmpwalk, as the device
+// would have.
+type capturedAgent struct {
+	pdus map[string]gosnmp.SnmpPDU
+	sent int
+}
+
+func (a *capturedAgent) walk(oid string) ([]gosnmp.SnmpPDU, error) {
+	a.sent++
+	oids := []string{}
+	for o, pdu := range a.pdus {
+		if (o == oid || strings.HasPrefix(o, oid+".")) && pdu.Type != gosnmp.NoSuchObject && pdu.Type != gosnmp.NoSuchInstance {
+			oids = append(oids, o)
+		}
+	}
+	pdus := make([]gosnmp.SnmpPDU, 0, len(oids))
+	for _, o := range sortOids(oids) {
+		pdus = append(pdus, a.pdus[o])
+	}
+	return pdus, nil
+}
+
+func (a *capturedAgent) get(oids []string) ([]gosnmp.SnmpPDU, error) {
+	a.sent++
+	pdus := make([]gosnmp.SnmpPDU, 0, len(oids))
+	for _, o := range oids {
+		pdu, ok := a.pdus[o]
+		if !ok {
+			pdu = gosnmp.SnmpPDU{Name: "." + o, Type: gosnmp.NoSuchObject}
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus, nil
+}
+
+func (a *capturedAgent) requests() int {
+	return a.sent
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestParseWalkFile(t *testing.T) {
+	node := loadFixture(t, "iftable.json")
+	walkNode(node, func(n *Node) {
+		n.Module = "IF-MIB"
+	})
+	nameToNode, _ := prepareTree(node, generatorOptions{})
+
+	cases := []struct {
+		name     string
+		walk     string
+		expected map[string]gosnmp.SnmpPDU
+	}{
+		{
+			name: "numeric",
+			walk: `.1.3.6.1.2.1.2.2.1.1.1 = INTEGER: 1
+.1.3.6.1.2.1.2.2.1.2.1 = STRING: "lo"
+.1.3.6.1.2.1.2.2.1.3.1 = INTEGER: softwareLoopback(24)
+.1.3.6.1.2.1.2.2.1.5.1 = Gauge32: 10000000
+.1.3.6.1.2.1.2.2.1.6.1 = ""
+.1.3.6.1.2.1.2.2.1.9.1 = Timeticks: (1234) 0:00:12.34
+.1.3.6.1.2.1.2.2.1.10.1 = Counter32: 42
+.1.3.6.1.2.1.31.1.1.1.6.1 = Counter64: 18446744073709551615
+`,
+			expected: map[string]gosnmp.SnmpPDU{
+				"1.3.6.1.2.1.2.2.1.1.1":    {Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: gosnmp.Integer, Value: 1},
+				"1.3.6.1.2.1.2.2.1.2.1":    {Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("lo")},
+				"1.3.6.1.2.1.2.2.1.3.1":    {Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: gosnmp.Integer, Value: 24},
+				"1.3.6.1.2.1.2.2.1.5.1":    {Name: ".1.3.6.1.2.1.2.2.1.5.1", Type: gosnmp.Gauge32, Value: uint(10000000)},
+				"1.3.6.1.2.1.2.2.1.6.1":    {Name: ".1.3.6.1.2.1.2.2.1.6.1", Type: gosnmp.OctetString, Value: []byte{}},
+				"1.3.6.1.2.1.2.2.1.9.1":    {Name: ".1.3.6.1.2.1.2.2.1.9.1", Type: gosnmp.TimeTicks, Value: uint32(1234)},
+				"1.3.6.1.2.1.2.2.1.10.1":   {Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(42)},
+				"1.3.6.1.2.1.31.1.1.1.6.1": {Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(18446744073709551615)},
+			},
+		},
+		{
+			name: "symbolic",
+			walk: `IF-MIB::ifIndex.2 = INTEGER: 2
+IF-MIB::ifDescr.2 = STRING: eth0
+ifType.2 = INTEGER: 6
+IF-MIB::ifPhysAddress.2 = STRING: 52:54:0:12:34:56
+iso.3.6.1.2.1.2.2.1.7.2 = INTEGER: up(1)
+`,
+			expected: map[string]gosnmp.SnmpPDU{
+				"1.3.6.1.2.1.2.2.1.1.2": {Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: gosnmp.Integer, Value: 2},
+				"1.3.6.1.2.1.2.2.1.2.2": {Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("eth0")},
+				"1.3.6.1.2.1.2.2.1.3.2": {Name: ".1.3.6.1.2.1.2.2.1.3.2", Type: gosnmp.Integer, Value: 6},
+				"1.3.6.1.2.1.2.2.1.6.2": {Name: ".1.3.6.1.2.1.2.2.1.6.2", Type: gosnmp.OctetString, Value: []byte("52:54:0:12:34:56")},
+				"1.3.6.1.2.1.2.2.1.7.2": {Name: ".1.3.6.1.2.1.2.2.1.7.2", Type: gosnmp.Integer, Value: 1},
+			},
+		},
+		{
+			name: "multiline",
+			walk: `.1.3.6.1.2.1.1.1.0 = STRING: "Cisco IOS Software, Version 15.2
+Technical Support: http://www.cisco.com/techsupport
+Copyright (c) 1986-2016 by Cisco Systems, Inc."
+.1.3.6.1.2.1.2.2.1.6.3 = Hex-STRING: 00 1B 21 3A 4F 5E
+.1.3.6.1.4.1.9.9.1.0 = Hex-STRING: 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F
+10 11
+.1.3.6.1.2.1.4.20.1.1.10.0.0.1 = IpAddress: 10.0.0.1
+.1.3.6.1.2.1.1.2.0 = OID: .1.3.6.1.4.1.8072.3.2.10
+.1.3.6.1.2.1.1.9.0 = No Such Object available on this agent at this OID
+.1.3.6.1.2.1.1.10.0 = No Such Instance currently exists at this OID
+.1.3.6.1.2.1.1.11.0 = No more variables left in this MIB View (It is past the end of the MIB tree)
+`,
+			expected: map[string]gosnmp.SnmpPDU{
+				"1.3.6.1.2.1.1.1.0":             {Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Cisco IOS Software, Version 15.2\nTechnical Support: http://www.cisco.com/techsupport\nCopyright (c) 1986-2016 by Cisco Systems, Inc.")},
+				"1.3.6.1.2.1.2.2.1.6.3":         {Name: ".1.3.6.1.2.1.2.2.1.6.3", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1b, 0x21, 0x3a, 0x4f, 0x5e}},
+				"1.3.6.1.4.1.9.9.1.0":           {Name: ".1.3.6.1.4.1.9.9.1.0", Type: gosnmp.OctetString, Value: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}},
+				"1.3.6.1.2.1.4.20.1.1.10.0.0.1": {Name: ".1.3.6.1.2.1.4.20.1.1.10.0.0.1", Type: gosnmp.IPAddress, Value: "10.0.0.1"},
+				"1.3.6.1.2.1.1.2.0":             {Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"},
+				"1.3.6.1.2.1.1.9.0":             {Name: ".1.3.6.1.2.1.1.9.0", Type: gosnmp.NoSuchObject},
+				"1.3.6.1.2.1.1.10.0":            {Name: ".1.3.6.1.2.1.1.10.0", Type: gosnmp.NoSuchInstance},
+			},
+		},
+		{
+			name: "untyped",
+			walk: `.1.3.6.1.2.1.1.5.0 = "router1"
+.1.3.6.1.2.1.1.6.0 = server room
+`,
+			expected: map[string]gosnmp.SnmpPDU{
+				"1.3.6.1.2.1.1.5.0": {Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("router1")},
+				"1.3.6.1.2.1.1.6.0": {Name: ".1.3.6.1.2.1.1.6.0", Type: gosnmp.OctetString, Value: []byte("server room")},
+			},
+		},
+	}
+	for _, c := range cases {
+		got, err := ParseWalkFile(strings.NewReader(c.walk), nameToNode)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.expected)
+		}
+	}
+
+	errors := []struct {
+		walk string
+		err  string
+	}{
+		{walk: "IF-MIB::ifDescr.2 = STRING: eth0\n", err: "without the MIBs"},
+		{walk: "IF-MIB::ifFoo.2 = STRING: eth0\n", err: "Can't find IF-MIB::ifFoo"},
+		{walk: `IP-MIB::ipAddressIfIndex.ipv4."10.0.0.1" = INTEGER: 2` + "\n", err: "use snmpwalk -On"},
+		{walk: ".1.3.6.1.2.1.1.3.0 = Float: 1.5\n", err: "Line 1: Unknown type Float"},
+		{walk: ".1.3.6.1.2.1.1.3.0 = INTEGER: 1\nTimeout: No Response from 192.0.2.1\n", err: "Line 2 isn't snmpwalk output"},
+	}
+	for i, e := range errors {
+		lookup := nameToNode
+		if i == 0 {
+			lookup = nil
+		}
+		if _, err := ParseWalkFile(strings.NewReader(e.walk), lookup); err == nil || !strings.Contains(err.Error(), e.err) {
+			t.Errorf("Parsing %q: got error %v, want %q", e.walk, err, e.err)
+		}
+	}
+}
+
+func TestCheckIndexes(t *testing.T) {
+	cases := []struct {
+		indexes []*config.Index
+		oids    []int
+		err     string
+	}{
+		{indexes: []*config.Index{{Labelname: "a", Type: "gauge"}}, oids: []int{1}},
+		{indexes: []*config.Index{{Labelname: "a", Type: "gauge"}}, oids: []int{1, 2}, err: "1 oids are left over"},
+		{indexes: []*config.Index{{Labelname: "a", Type: "DisplayString"}}, oids: []int{2, 65, 66}},
+		{indexes: []*config.Index{{Labelname: "a", Type: "DisplayString"}}, oids: []int{3, 65, 66}, err: "index a needs 4 oids, but only 3 are left"},
+		{indexes: []*config.Index{{Labelname: "a", Type: "DisplayString", Implied: true}}, oids: []int{65, 66}},
+		{indexes: []*config.Index{{Labelname: "a", Type: "OctetString", FixedSize: 2}, {Labelname: "b", Type: "IpAddr"}}, oids: []int{1, 2, 10, 0, 0, 1}},
+		{indexes: []*config.Index{{Labelname: "a", Type: "PhysAddress48"}}, oids: []int{1, 2, 3}, err: "index a needs 6 oids"},
+		{indexes: []*config.Index{{Labelname: "a", Type: "InetAddress"}}, oids: []int{1, 4, 10, 0, 0, 1}},
+		{indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "a", Type: "TypedInetAddress"}}, oids: []int{1, 4, 10, 0, 0, 1}},
+		{indexes: []*config.Index{{Labelname: "a", Type: "TypedInetAddress"}}, oids: []int{4, 10, 0, 0, 1}, err: "doesn't follow an InetAddressType"},
+	}
+	for _, c := range cases {
+		err := checkIndexes(c.indexes, c.oids)
+		if (c.err == "" && err != nil) || (c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err))) {
+			t.Errorf("checkIndexes(%v): got error %v, want %q", c.oids, err, c.err)
+		}
+	}
+}
+
+func TestTestWalkFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "snmp.yml")
+	content := `if_mib:
+  walk: [1.3.6.1.2.1.2.2.1.2, 1.3.6.1.2.1.2.2.1.3]
+  metrics:
+  - name: ifDescr
+    oid: 1.3.6.1.2.1.2.2.1.2
+    type: DisplayString
+    indexes:
+    - labelname: ifIndex
+      type: gauge
+  - name: ifType
+    oid: 1.3.6.1.2.1.2.2.1.3
+    type: gauge
+    indexes:
+    - labelname: ifIndex
+      type: gauge
+`
+	walkPath := filepath.Join(dir, "walk.txt")
+	walk := `.1.3.6.1.2.1.2.2.1.2.1 = STRING: "lo"
+.1.3.6.1.2.1.2.2.1.2.2 = STRING: "eth0"
+.1.3.6.1.2.1.2.2.1.2.3.1 = STRING: "eth0.1"
+`
+	for path, content := range map[string]string{configPath: content, walkPath: walk} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		minCoverage float64
+		ok          bool
+	}{{50, true}, {60, false}} {
+		buf := &bytes.Buffer{}
+		ok, err := testTarget(buf, configPath, "if_mib", "", walkPath, nil, testParams{}, c.minCoverage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != c.ok {
+			t.Errorf("With min coverage %g: got %v, want %v", c.minCoverage, ok, c.ok)
+		}
+		for _, line := range []string{
+			"Module if_mib: 1 of 2 metrics returned values (50.0%)\n",
+			"  bad      ifDescr 1.3.6.1.2.1.2.2.1.2: 1 of 3 values have indexes that can't be decoded, such as 3.1: 1 oids are left over after the indexes\n",
+			"  missing  ifType 1.3.6.1.2.1.2.2.1.3: no values\n",
+			"  1.3.6.1.2.1.2.2.1: 3 rows\n",
+		} {
+			if !strings.Contains(buf.String(), line) {
+				t.Errorf("Report is missing %q:\n%s", line, buf)
+			}
+		}
+	}
+
+	if _, err := testTarget(&bytes.Buffer{}, configPath, "missing", "", walkPath, nil, testParams{}, 0); err == nil || !strings.Contains(err.Error(), "which has: if_mib") {
+		t.Errorf("Unexpected error for a missing module: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+)
+
+// Call regenerate whenever the generator config or a file it includes
+// changes, polling them every interval, until stop is closed. Errors are
+// logged, and regenerate should leave the previous output untouched on them.
+func watchConfig(configPath string, interval time.Duration, stop <-chan struct{}, regenerate func() error) {
+	var previous []byte
+	for {
+		content, err := configSnapshot(configPath)
+		if err != nil {
+			log.Errorf("Error reading yml config %s: %s", configPath, err)
+		} else if previous == nil || !bytes.Equal(content, previous) {
+			previous = content
+			start := time.Now()
+			if err := regenerate(); err != nil {
+				log.Errorf("Error regenerating config, leaving previous output in place: %s", err)
+			} else {
+				log.Infof("Regenerated config in %s", time.Since(start))
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// The contents of a generator config and the files it includes, so that
+// adding, removing or changing a fragment is noticed. A config that can't be
+// parsed is returned alone, leaving it to loading the config to report why.
+func configSnapshot(configPath string) ([]byte, error) {
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return content, nil
+	}
+	files, err := includedFiles(configPath, cfg.Include)
+	if err != nil {
+		return content, nil
+	}
+	snapshot := bytes.NewBuffer(content)
+	for _, file := range files {
+		fragment, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(snapshot, "\n# %s\n", file)
+		snapshot.Write(fragment)
+	}
+	return snapshot.Bytes(), nil
+}